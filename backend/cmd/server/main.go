@@ -0,0 +1,228 @@
+// Command server runs the Secure File Vault API.
+//
+// It refuses to start when the configuration is invalid or the database, blob stores or TLS
+// files are unusable. On SIGINT or SIGTERM it stops accepting connections, lets in-flight
+// requests (uploads and downloads included) finish within the shutdown timeout, then stops
+// the background jobs at a checkpoint.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"file-vault/backend/internal/api"
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/database/migrations"
+	"file-vault/backend/internal/health"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/openapi"
+	"file-vault/backend/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// readHeaderTimeout bounds how long a client may take to send request headers. Bodies have
+// no deadline, since uploads and downloads of large files legitimately take a long time.
+const readHeaderTimeout = 10 * time.Second
+
+const (
+	// probeTimeout bounds each readiness probe of a dependency.
+	probeTimeout = 5 * time.Second
+	// probeCacheFor is how long a readiness report is reused before dependencies are probed again.
+	probeCacheFor = 2 * time.Second
+)
+
+// traceFlushTimeout bounds exporting the spans still buffered when the server stops.
+const traceFlushTimeout = 5 * time.Second
+
+func main() {
+	// A missing .env is fine: the environment may already be populated
+	_ = godotenv.Load()
+
+	cfg, _, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+	logger := logging.New(cfg.Log, os.Stderr)
+	// Route the log package, used by dependencies, through the same handler
+	slog.SetDefault(logger)
+	logger.Info("Starting server", "version", health.BuildInfo().Version, "config", cfg.Summary())
+
+	if err := run(cfg, logger); err != nil {
+		fatal(err)
+	}
+}
+
+func run(cfg *config.Config, logger *slog.Logger) error {
+	tlsConfig, err := loadTLS(cfg.Server)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Database.AutoMigrate {
+		applied, err := migrations.Migrate(context.Background(), cfg.Database.URL.Value())
+		if err != nil {
+			return fmt.Errorf("migrate database: %w", err)
+		}
+		for _, migration := range applied {
+			logger.Info("Applied schema migration", "version", migration.Version, "name", migration.Name)
+		}
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return fmt.Errorf("initialize clients: %w", err)
+	}
+	if err := clients.Ping(); err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, health.BuildInfo().Version)
+	if err != nil {
+		return err
+	}
+	defer flushTraces(shutdownTracing)
+
+	// Requests are logged by LoggerMiddleware; gin's own route listing would bypass the logger
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+	router.Use(tracing.Middleware(), api.LoggerMiddleware(logger), api.RecoveryMiddleware())
+	if err := router.SetTrustedProxies(config.SplitList(cfg.Server.TrustedProxies)); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	router.Use(api.MetricsMiddleware(), api.CORSMiddleware(config.SplitList(cfg.Server.CORSOrigins)))
+	checker := health.NewChecker(probeTimeout, probeCacheFor, health.Dependencies(clients, cfg.SMTP)...)
+	jobManager := api.SetupRoutes(router, clients, cfg, checker)
+
+	// A route missing from the API document, or documented but gone, is a bug: refuse to start
+	if err := openapi.CheckRoutes(openapi.Spec(), router.Routes()); err != nil {
+		jobManager.Shutdown()
+		return err
+	}
+
+	// Listen before announcing readiness so a taken port fails startup
+	listener, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		jobManager.Shutdown()
+		return fmt.Errorf("listen on %s: %w", cfg.Server.Addr, err)
+	}
+	server := &http.Server{
+		Handler:           router,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			logger.Info("Serving HTTPS", "addr", listener.Addr().String())
+			serveErr <- server.ServeTLS(listener, "", "")
+		} else {
+			logger.Info("Serving HTTP", "addr", listener.Addr().String())
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		jobManager.Shutdown()
+		clients.Close()
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Fail readiness on connections that stay open, so load balancers stop routing here
+	checker.SetDraining()
+	logger.Info("Shutting down, waiting for in-flight requests", "timeout", cfg.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Error draining requests, closing remaining connections", "error", err)
+		server.Close()
+	}
+
+	// Jobs stop at their next row and checkpoint, so they resume on the next start
+	jobManager.Shutdown()
+
+	// Give queued replica copies what is left of the timeout; the repair job catches up on the rest
+	closed := make(chan struct{})
+	go func() {
+		clients.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-shutdownCtx.Done():
+		logger.Warn("Shutdown timeout reached with replica copies still queued")
+	}
+	logger.Info("Server stopped")
+	return nil
+}
+
+// fatal logs err and exits.
+func fatal(err error) {
+	slog.Error("Server failed", "error", err)
+	os.Exit(1)
+}
+
+// flushTraces exports the spans still buffered, giving up after traceFlushTimeout so an
+// unreachable collector does not hold up the exit.
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Warn("Error flushing traces", "error", err)
+	}
+}
+
+// loadTLS builds the TLS configuration, or returns nil to serve plain HTTP. With a client CA
+// every client must present a certificate that chains to it.
+func loadTLS(server config.ServerConfig) (*tls.Config, error) {
+	if server.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(server.TLSCertFile, server.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if server.ClientCAFile != "" {
+		pem, err := os.ReadFile(server.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA %s contains no PEM certificates", server.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
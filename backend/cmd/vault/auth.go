@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"file-vault/backend/pkg/client"
+
+	"golang.org/x/term"
+)
+
+const defaultServer = "http://localhost:8080"
+
+func (c *cli) login(ctx context.Context, args []string) error {
+	previous, _ := c.load()
+	server := defaultServer
+	if previous != nil {
+		server = previous.Server
+	}
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	fs.StringVar(&server, "server", server, "server root URL")
+	email := fs.String("email", "", "account email; prompted for if not given")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of standard input")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *email == "" {
+		if *passwordStdin {
+			return errors.New("login: -password-stdin needs -email")
+		}
+		fmt.Fprint(os.Stderr, "Email: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read email: %w", err)
+		}
+		*email = strings.TrimSpace(line)
+	}
+	password, err := readPassword(stdin, *passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	api := &client.Client{BaseURL: strings.TrimRight(server, "/")}
+	session, err := api.Login(ctx, *email, password)
+	if err != nil {
+		return err
+	}
+	creds := &credentials{Server: api.BaseURL, UserID: session.UserID, Email: session.Email, Username: session.Username}
+	if err := c.save(creds); err != nil {
+		return fmt.Errorf("store credentials: %w", err)
+	}
+	fmt.Printf("Signed in to %s as %s\n", creds.Server, creds.Username)
+	return nil
+}
+
+// readPassword reads the password from the first line of stdin, or prompts for it without
+// echoing when stdin is a terminal.
+func readPassword(stdin *bufio.Reader, fromStdin bool) (string, error) {
+	if fromStdin {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("login: standard input is not a terminal; use -password-stdin")
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return string(password), nil
+}
+
+func (c *cli) logout(args []string) error {
+	if len(args) > 0 {
+		return errors.New("logout: takes no arguments")
+	}
+	removed, err := c.forget()
+	if err != nil {
+		return err
+	}
+	if !removed {
+		fmt.Println("Not signed in")
+		return nil
+	}
+	fmt.Println("Signed out")
+	return nil
+}
+
+func (c *cli) whoami(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("whoami: takes no arguments")
+	}
+	creds, err := c.load()
+	if err != nil {
+		return err
+	}
+	api := &client.Client{BaseURL: creds.Server, UserID: creds.UserID}
+	stats, err := api.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := newTable()
+	fmt.Fprintf(w, "Server:\t%s\n", creds.Server)
+	fmt.Fprintf(w, "User ID:\t%s\n", creds.UserID)
+	if creds.Username != "" {
+		fmt.Fprintf(w, "User:\t%s <%s>\n", creds.Username, creds.Email)
+	}
+	fmt.Fprintf(w, "Used:\t%s of %s\n", formatBytes(stats.DeduplicatedUsage), formatBytes(stats.StorageQuota))
+	fmt.Fprintf(w, "Saved by deduplication:\t%s (%s)\n", formatBytes(stats.SavingsBytes), stats.SavingsPercentage)
+	return w.Flush()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"file-vault/backend/pkg/client"
+)
+
+// credentials identify the signed-in user. The vault authenticates requests by user ID, so
+// the file is as sensitive as a password and is kept readable by its owner only. The
+// password itself is never stored.
+type credentials struct {
+	Server   string `json:"server"`
+	UserID   string `json:"user_id"`
+	Email    string `json:"email,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// cli holds the state shared by the commands.
+type cli struct {
+	configPath string
+}
+
+// path returns the credentials file, in the user's config directory unless -config or
+// VAULT_CLIENT_CONFIG names another.
+func (c *cli) path() (string, error) {
+	if c.configPath != "" {
+		return c.configPath, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locate config directory: %w (use -config)", err)
+	}
+	return filepath.Join(dir, "file-vault", "credentials.json"), nil
+}
+
+// load reads the stored credentials, overridden by VAULT_SERVER and VAULT_USER_ID.
+func (c *cli) load() (*credentials, error) {
+	creds := &credentials{}
+	path, err := c.path()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := readPrivate(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, creds); err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+	if server := os.Getenv("VAULT_SERVER"); server != "" {
+		creds.Server = server
+	}
+	if userID := os.Getenv("VAULT_USER_ID"); userID != "" {
+		creds.UserID = userID
+	}
+	if creds.Server == "" || creds.UserID == "" {
+		return nil, errors.New(`not signed in; run "vault login" first`)
+	}
+	return creds, nil
+}
+
+// client returns an API client acting as the signed-in user.
+func (c *cli) client() (*client.Client, error) {
+	creds, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return &client.Client{BaseURL: creds.Server, UserID: creds.UserID}, nil
+}
+
+// save writes creds to the credentials file, replacing it atomically.
+func (c *cli) save(creds *credentials) error {
+	path, err := c.path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp already makes the file 0600; Chmod covers an unusual umask on the directory
+	if err := tmp.Chmod(0o600); err != nil && runtime.GOOS != "windows" {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// forget deletes the credentials file. It reports whether there was one.
+func (c *cli) forget() (bool, error) {
+	path, err := c.path()
+	if err != nil {
+		return false, err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readPrivate reads a credentials file, refusing one that other users can read.
+func readPrivate(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users (mode %s); run chmod 600 %s",
+			path, info.Mode().Perm(), shellQuote(path))
+	}
+	return os.ReadFile(path)
+}
+
+func shellQuote(s string) string {
+	if !strings.ContainsAny(s, " '\"$\\") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/pkg/client"
+
+	"github.com/google/uuid"
+)
+
+// partSuffix marks a download in progress; get and sync resume from it.
+const partSuffix = ".vault-part"
+
+// remoteFile is a file of the caller's. Name, Size and Hash may be unknown for a file named
+// by ID.
+type remoteFile struct {
+	ID               string
+	Name             string
+	Size             int64
+	Hash             string
+	ClientEncryption string
+}
+
+func fromSearchResult(file models.FileSearchResult) remoteFile {
+	return remoteFile{
+		ID:               file.FileID,
+		Name:             file.Filename,
+		Size:             file.Size,
+		Hash:             file.HashSHA256,
+		ClientEncryption: file.ClientEncryption,
+	}
+}
+
+// resolve finds the file ref names: a file ID, or the exact name of one of the caller's files.
+func resolve(ctx context.Context, api *client.Client, ref string) (remoteFile, error) {
+	if _, err := uuid.Parse(ref); err == nil {
+		return remoteFile{ID: ref}, nil
+	}
+	matches, err := filesNamed(ctx, api, ref)
+	if err != nil {
+		return remoteFile{}, err
+	}
+	switch len(matches) {
+	case 0:
+		return remoteFile{}, fmt.Errorf("no file named %q", ref)
+	case 1:
+		return matches[0], nil
+	}
+	ids := make([]string, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	return remoteFile{}, fmt.Errorf("%d files are named %q; name one by ID: %s", len(matches), ref, strings.Join(ids, ", "))
+}
+
+// filesNamed returns the caller's files called exactly name, newest first.
+func filesNamed(ctx context.Context, api *client.Client, name string) ([]remoteFile, error) {
+	var matches []remoteFile
+	for file, err := range api.SearchAll(ctx, client.SearchQuery{Filename: name}) {
+		if err != nil {
+			return nil, err
+		}
+		if file.Filename == name {
+			matches = append(matches, fromSearchResult(file))
+		}
+	}
+	return matches, nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *cli) put(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	quiet := fs.Bool("q", false, "do not show progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("put: expected the files to upload")
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		if err := putFile(ctx, api, path, *quiet); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "vault: put %s: %v\n", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("put: %d of %d files failed", failed, fs.NArg())
+	}
+	return nil
+}
+
+// putFile uploads the file at path under its base name, unless a file of that name with the
+// same content is already stored, which makes re-running an interrupted put cheap.
+func putFile(ctx context.Context, api *client.Client, path string, quiet bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("not a regular file (use sync for directories)")
+	}
+	name := filepath.Base(path)
+	hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	existing, err := filesNamed(ctx, api, name)
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		if file.Hash == hash {
+			fmt.Printf("%s is already stored as %s\n", name, file.ID)
+			return nil
+		}
+	}
+
+	file, err := upload(ctx, api, path, name, info.Size(), quiet)
+	if err != nil {
+		return err
+	}
+	if file.Filename != name {
+		fmt.Printf("Uploaded %s as %s (renamed to %s, as a different %s exists)\n", name, file.FileID, file.Filename, name)
+	} else {
+		fmt.Printf("Uploaded %s as %s\n", name, file.FileID)
+	}
+	return nil
+}
+
+// upload sends the file at path as name, drawing progress as it goes.
+func upload(ctx context.Context, api *client.Client, path, name string, size int64, quiet bool) (*models.UserFile, error) {
+	if size == 0 {
+		return nil, errors.New("empty files cannot be uploaded")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := newProgress(name, 0, size, quiet)
+	file, err := api.Upload(ctx, name, &progressFile{File: f, progress: p})
+	p.finish()
+	return file, err
+}
+
+// progressFile reports reads of a file to a progress line. The client reads an upload once to
+// hash it and again to send it, seeking back in between, so progress follows the position.
+type progressFile struct {
+	*os.File
+	progress *progress
+}
+
+func (f *progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.progress.Write(b[:n])
+	return n, err
+}
+
+func (f *progressFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.progress.done = pos
+	}
+	return pos, err
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	output := fs.String("o", "", `destination path, or "-" for standard output (default: the file's name)`)
+	force := fs.Bool("f", false, "overwrite an existing destination")
+	quiet := fs.Bool("q", false, "do not show progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("get: expected one file ID or name")
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	file, err := resolve(ctx, api, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if file.Name == "" {
+		// Named by ID: the download headers carry the name and size
+		d, err := api.Open(ctx, file.ID, 0)
+		if err != nil {
+			return err
+		}
+		d.Close()
+		file.Name, file.Size, file.ClientEncryption = d.Filename, d.Size, d.ClientEncryption
+	}
+	if file.ClientEncryption != "" {
+		fmt.Fprintf(os.Stderr, "vault: %s is end-to-end encrypted; the download is ciphertext\n", file.Name)
+	}
+
+	if *output == "-" {
+		_, err := api.Download(ctx, file.ID, 0, os.Stdout)
+		return err
+	}
+	dest := *output
+	if dest == "" {
+		dest = filepath.Base(file.Name)
+	} else if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = filepath.Join(dest, filepath.Base(file.Name))
+	}
+	if _, err := os.Stat(dest); err == nil && !*force {
+		return fmt.Errorf("%s already exists; use -f to overwrite it", dest)
+	}
+	if err := download(ctx, api, file, dest, *quiet); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %s to %s\n", file.Name, dest)
+	return nil
+}
+
+// download copies file to dest through a partial file next to it, continuing from whatever
+// an earlier, interrupted download left there. The content is checked against the stored
+// hash, when known, before dest is replaced.
+func download(ctx context.Context, api *client.Client, file remoteFile, dest string, quiet bool) error {
+	part := dest + partSuffix
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	offset := info.Size()
+	if file.Size > 0 && offset > file.Size {
+		// Left over from different content; start again
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return err
+		}
+		offset = 0
+	}
+
+	p := newProgress(file.Name, offset, file.Size, quiet)
+	_, err = api.Download(ctx, file.ID, offset, io.MultiWriter(f, p))
+	p.finish()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if offset > 0 || ctx.Err() != nil {
+			return fmt.Errorf("%w (run the command again to resume)", err)
+		}
+		return err
+	}
+
+	if file.Hash != "" {
+		hash, err := hashFile(part)
+		if err != nil {
+			return err
+		}
+		if hash != file.Hash {
+			os.Remove(part)
+			return fmt.Errorf("downloaded %s does not match its SHA-256; the partial file was removed", file.Name)
+		}
+	}
+	return os.Rename(part, dest)
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "maximum number of files to show")
+	offset := fs.Int("offset", 0, "number of newer files to skip")
+	asJSON := jsonFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("ls: takes no arguments; use search to filter")
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	files, err := api.ListFiles(ctx, client.Page{Limit: *limit, Offset: *offset})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(files)
+	}
+	if err := printFiles(files); err != nil {
+		return err
+	}
+	if len(files) == *limit {
+		fmt.Printf("(showing %d; use -offset %d for more)\n", *limit, *offset+*limit)
+	}
+	return nil
+}
+
+func printFiles(files []models.FileSearchResult) error {
+	w := newTable()
+	fmt.Fprintln(w, "FILE ID\tNAME\tSIZE\tTYPE\tCREATED")
+	for _, file := range files {
+		mimeType := file.MimeType
+		if file.ClientEncryption != "" {
+			mimeType = file.ClientEncryption
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", file.FileID, file.Filename, formatBytes(file.Size), mimeType,
+			file.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func (c *cli) search(ctx context.Context, args []string) error {
+	var text string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		text, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	mimeType := fs.String("type", "", "only files of this MIME type")
+	minSize := fs.String("min-size", "", "only files at least this large, e.g. 100MiB")
+	maxSize := fs.String("max-size", "", "only files at most this large")
+	since := fs.String("since", "", "only files uploaded on or after this date (YYYY-MM-DD or RFC 3339)")
+	until := fs.String("until", "", "only files uploaded on or before this date")
+	limit := fs.Int("limit", 0, "maximum number of files to show, 0 for all")
+	asJSON := jsonFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || (fs.NArg() == 1 && text != "") {
+		return errors.New("search: expected at most one search text")
+	}
+	if fs.NArg() == 1 {
+		text = fs.Arg(0)
+	}
+
+	q := client.SearchQuery{Filename: text, MimeType: *mimeType}
+	var err error
+	if *minSize != "" {
+		if q.MinSize, err = parseBytes(*minSize); err != nil {
+			return err
+		}
+	}
+	if *maxSize != "" {
+		if q.MaxSize, err = parseBytes(*maxSize); err != nil {
+			return err
+		}
+	}
+	if q.Since, err = parseDate(*since, false); err != nil {
+		return err
+	}
+	if q.Until, err = parseDate(*until, true); err != nil {
+		return err
+	}
+	if *limit > 0 {
+		q.Limit = min(*limit, 1000)
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	files := []models.FileSearchResult{}
+	for file, err := range api.SearchAll(ctx, q) {
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		if len(files) == *limit {
+			break
+		}
+	}
+	if *asJSON {
+		return printJSON(files)
+	}
+	return printFiles(files)
+}
+
+// parseDate parses a date or RFC 3339 time. A date alone means the start of that local day,
+// or its end if endOfDay is set.
+func parseDate(raw string, endOfDay bool) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC 3339)", raw)
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+func (c *cli) remove(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("rm: expected the files to delete")
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	for _, ref := range args {
+		file, err := resolve(ctx, api, ref)
+		if err != nil {
+			return err
+		}
+		if err := api.Delete(ctx, file.ID); err != nil {
+			return fmt.Errorf("delete %s: %w", ref, err)
+		}
+		fmt.Printf("Deleted %s\n", ref)
+	}
+	return nil
+}
+
+func (c *cli) share(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	off := fs.Bool("off", false, "make the file private again, revoking its link")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("share: expected one file ID or name")
+	}
+	creds, err := c.load()
+	if err != nil {
+		return err
+	}
+	api := &client.Client{BaseURL: creds.Server, UserID: creds.UserID}
+	file, err := resolve(ctx, api, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Toggling always issues a new token, so look for a live link before touching it
+	shares, err := api.PublicShares(ctx)
+	if err != nil {
+		return err
+	}
+	var token string
+	for _, share := range shares {
+		if share.FileID == file.ID {
+			token = share.ShareToken
+		}
+	}
+
+	switch {
+	case *off && token == "":
+		fmt.Printf("%s is not shared\n", fs.Arg(0))
+		return nil
+	case !*off && token != "":
+		printShareLink(creds.Server, token)
+		return nil
+	}
+	state, err := api.ToggleShare(ctx, file.ID)
+	if err != nil {
+		return err
+	}
+	if *off {
+		fmt.Printf("%s is no longer shared\n", fs.Arg(0))
+		return nil
+	}
+	if !state.IsPublic {
+		return errors.New("share: the file was shared concurrently and is now private; run the command again")
+	}
+	printShareLink(creds.Server, state.ShareToken)
+	return nil
+}
+
+func printShareLink(server, token string) {
+	fmt.Printf("Token:    %s\n", token)
+	fmt.Printf("Download: %s/share/%s/download\n", strings.TrimRight(server, "/"), token)
+}
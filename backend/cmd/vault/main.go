@@ -0,0 +1,104 @@
+// Command vault is the end-user client of a Secure File Vault server: it uploads, downloads,
+// lists, searches, shares and deletes the caller's files, and keeps a local directory in sync
+// with them. It talks to the server's HTTP API through pkg/client.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"file-vault/backend/pkg/client"
+)
+
+const usage = `Usage: vault [-config file] <command> [arguments]
+
+"vault login" stores the credentials in file-vault/credentials.json under the user config
+directory (e.g. ~/.config), or in VAULT_CLIENT_CONFIG or -config if set, readable only by
+you. VAULT_SERVER and VAULT_USER_ID override the stored server and user for scripts.
+
+Commands:
+  login [-server url] [-email e] [-password-stdin]
+                                Sign in and store the credentials
+  logout                        Forget the stored credentials
+  whoami                        Show the signed-in user, quota and usage
+  put [-q] <path>...            Upload files, skipping those already uploaded unchanged
+  get [-o path] [-q] <file>     Download a file, resuming a partial earlier download
+  ls [-limit n] [-offset n]     List files, newest first
+  rm <file>...                  Delete files
+  search [text] [-type mime] [-min-size size] [-max-size size] [-since date] [-until date]
+                                List files whose name contains text and match the flags
+  share [-off] <file>           Make a file public and print its link, or private again
+  sync [-dry-run] [-no-upload] [-no-download] <dir>
+                                Upload new and changed files in dir and download missing ones
+
+<file> is a file ID or the exact name of one of your files. ls and search accept -json.
+`
+
+func main() {
+	fs := flag.NewFlagSet("vault", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	configPath := fs.String("config", os.Getenv("VAULT_CLIENT_CONFIG"), "credentials file")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
+	args := fs.Args()
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Interrupting cancels the request in flight; partial downloads are kept for resuming
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cli := &cli{configPath: *configPath}
+	var err error
+	switch args[0] {
+	case "help":
+		fmt.Print(usage)
+		return
+	case "login":
+		err = cli.login(ctx, args[1:])
+	case "logout":
+		err = cli.logout(args[1:])
+	case "whoami":
+		err = cli.whoami(ctx, args[1:])
+	case "put":
+		err = cli.put(ctx, args[1:])
+	case "get":
+		err = cli.get(ctx, args[1:])
+	case "ls":
+		err = cli.list(ctx, args[1:])
+	case "rm":
+		err = cli.remove(ctx, args[1:])
+	case "search":
+		err = cli.search(ctx, args[1:])
+	case "share":
+		err = cli.share(ctx, args[1:])
+	case "sync":
+		err = cli.sync(ctx, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "vault: unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && err == error(apiErr) && apiErr.Code != "" {
+		// Show what the server said rather than the status line
+		err = fmt.Errorf("%s (%s)", apiErr.Detail, apiErr.Code)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vault: %v\n", err)
+		os.Exit(1)
+	}
+}
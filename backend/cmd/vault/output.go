@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// jsonFlag adds the -json flag of commands that print a table by default.
+func jsonFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "print JSON instead of a table")
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+var byteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+// formatBytes renders n in binary units, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", value, byteUnits[unit])
+}
+
+// parseBytes parses a byte count with an optional binary unit, e.g. 500MiB or 10GiB.
+func parseBytes(raw string) (int64, error) {
+	s := strings.TrimSpace(raw)
+	multiplier := int64(1)
+	for i := len(byteUnits) - 1; i > 0; i-- {
+		if strings.HasSuffix(strings.ToLower(s), strings.ToLower(byteUnits[i])) {
+			s = strings.TrimSpace(s[:len(s)-len(byteUnits[i])])
+			multiplier = int64(1) << (10 * i)
+			break
+		}
+	}
+	s = strings.TrimSuffix(strings.TrimSpace(s), "B")
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q (use bytes or a unit such as 500MiB or 10GiB)", raw)
+	}
+	return int64(value * float64(multiplier)), nil
+}
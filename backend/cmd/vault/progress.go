@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressInterval is how often the progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progress counts the bytes of a transfer and redraws a status line on standard error as
+// they pass. It draws nothing when quiet or when standard error is not a terminal.
+type progress struct {
+	name    string
+	done    int64
+	total   int64
+	resumed int64 // Bytes done before this run, left out of the rate
+	started time.Time
+	drawn   time.Time
+	silent  bool
+}
+
+func newProgress(name string, done, total int64, quiet bool) *progress {
+	return &progress{
+		name:    name,
+		done:    done,
+		total:   total,
+		resumed: done,
+		started: time.Now(),
+		silent:  quiet || !term.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if now := time.Now(); now.Sub(p.drawn) >= progressInterval {
+		p.drawn = now
+		p.draw()
+	}
+	return len(b), nil
+}
+
+// reader returns r counting into p.
+func (p *progress) reader(r io.Reader) io.Reader {
+	return io.TeeReader(r, p)
+}
+
+func (p *progress) draw() {
+	if p.silent {
+		return
+	}
+	line := fmt.Sprintf("%s  %s", p.name, formatBytes(p.done))
+	if p.total > 0 {
+		line += fmt.Sprintf(" / %s  %3d%%", formatBytes(p.total), p.done*100/p.total)
+	}
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		line += fmt.Sprintf("  %s/s", formatBytes(int64(float64(p.done-p.resumed)/elapsed)))
+	}
+	// Clear the rest of the previous, possibly longer, line
+	fmt.Fprintf(os.Stderr, "\r%s\033[K", line)
+}
+
+// finish draws the final state and ends the status line.
+func (p *progress) finish() {
+	if p.silent {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
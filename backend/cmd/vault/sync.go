@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"file-vault/backend/pkg/client"
+)
+
+// syncAction is what sync does with one file name.
+type syncAction int
+
+const (
+	syncUnchanged syncAction = iota
+	syncUpload               // Only local
+	syncUpdate               // Local content differs from the stored file
+	syncDownload             // Only stored
+)
+
+// syncItem is one file name present locally, remotely or both.
+type syncItem struct {
+	name   string
+	action syncAction
+	local  string       // Path in the directory
+	size   int64        // Local size
+	remote []remoteFile // Stored files of this name, newest first
+}
+
+// sync makes dir and the caller's files agree: local files the vault lacks, or holds with
+// other content, are uploaded, and stored files missing locally are downloaded. Local
+// changes win, and nothing is deleted on either side except a stored file replaced by an
+// update. The vault has no folders, so only the top level of dir takes part.
+func (c *cli) sync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only show what would be transferred")
+	noUpload := fs.Bool("no-upload", false, "do not upload new or changed local files")
+	noDownload := fs.Bool("no-download", false, "do not download files missing locally")
+	quiet := fs.Bool("q", false, "do not show progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("sync: expected one directory")
+	}
+	dir := fs.Arg(0)
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	items, err := planSync(ctx, api, dir)
+	if err != nil {
+		return err
+	}
+
+	var uploaded, updated, downloaded, unchanged, failed int
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		switch item.action {
+		case syncUnchanged:
+			unchanged++
+			continue
+		case syncUpload, syncUpdate:
+			if *noUpload {
+				continue
+			}
+			verb := "upload"
+			if item.action == syncUpdate {
+				verb = "update"
+			}
+			fmt.Printf("%-8s  %s\n", verb, item.name)
+			if *dryRun {
+				break
+			}
+			if err = pushItem(ctx, api, item, *quiet); err == nil {
+				if item.action == syncUpdate {
+					updated++
+				} else {
+					uploaded++
+				}
+			}
+		case syncDownload:
+			if *noDownload {
+				continue
+			}
+			file := item.remote[0]
+			if file.ClientEncryption != "" {
+				fmt.Printf("skip      %s (end-to-end encrypted)\n", item.name)
+				continue
+			}
+			fmt.Printf("%-8s  %s\n", "download", item.name)
+			if *dryRun {
+				break
+			}
+			if err = download(ctx, api, file, filepath.Join(dir, item.name), *quiet); err == nil {
+				downloaded++
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "vault: sync %s: %v\n", item.name, err)
+			failed++
+		}
+	}
+
+	if *dryRun {
+		return nil
+	}
+	fmt.Printf("%d uploaded, %d updated, %d downloaded, %d unchanged\n", uploaded, updated, downloaded, unchanged)
+	if failed > 0 {
+		return fmt.Errorf("sync: %d files failed", failed)
+	}
+	return nil
+}
+
+// planSync pairs the regular files at the top of dir with the caller's stored files by name
+// and compares their SHA-256 hashes.
+func planSync(ctx context.Context, api *client.Client, dir string) ([]syncItem, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*syncItem{}
+	skippedDirs := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, partSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		// Follow symlinks to what they point at
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			skippedDirs++
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Size() == 0 {
+			fmt.Fprintf(os.Stderr, "vault: skipping empty file %s\n", name)
+			continue
+		}
+		byName[name] = &syncItem{name: name, action: syncUpload, local: path, size: info.Size()}
+	}
+	if skippedDirs > 0 {
+		fmt.Fprintf(os.Stderr, "vault: skipping %d subdirectories; sync covers the top level only\n", skippedDirs)
+	}
+
+	for file, err := range api.SearchAll(ctx, client.SearchQuery{}) {
+		if err != nil {
+			return nil, err
+		}
+		if !safeName(file.Filename) {
+			fmt.Fprintf(os.Stderr, "vault: skipping stored file %s with an unusable name\n", file.FileID)
+			continue
+		}
+		item := byName[file.Filename]
+		if item == nil {
+			item = &syncItem{name: file.Filename, action: syncDownload}
+			byName[file.Filename] = item
+		}
+		item.remote = append(item.remote, fromSearchResult(file))
+	}
+
+	items := make([]syncItem, 0, len(byName))
+	for _, item := range byName {
+		if item.local != "" && len(item.remote) > 0 {
+			hash, err := hashFile(item.local)
+			if err != nil {
+				return nil, err
+			}
+			item.action = syncUpdate
+			for _, file := range item.remote {
+				if file.Hash == hash {
+					item.action = syncUnchanged
+				}
+			}
+		}
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].name < items[j].name })
+	return items, nil
+}
+
+// pushItem uploads a local file. An update uploads the new version first, under a prefixed
+// name while the old one still holds the name, and only then deletes the stored files it
+// replaces and takes over their name. If the upload fails the stored file is left as it was.
+func pushItem(ctx context.Context, api *client.Client, item syncItem, quiet bool) error {
+	file, err := upload(ctx, api, item.local, item.name, item.size, quiet)
+	if err != nil {
+		return err
+	}
+	if item.action == syncUpdate {
+		for _, old := range item.remote {
+			if err := api.Delete(ctx, old.ID); err != nil {
+				return fmt.Errorf("replace stored copy (new version kept as %s): %w", file.Filename, err)
+			}
+		}
+		if file.Filename != item.name {
+			if file, err = api.Rename(ctx, file.FileID, item.name); err != nil {
+				return fmt.Errorf("rename new version (kept as %s): %w", file.Filename, err)
+			}
+		}
+	}
+	if file.Filename != item.name {
+		fmt.Fprintf(os.Stderr, "vault: %s was stored as %s\n", item.name, file.Filename)
+	}
+	return nil
+}
+
+// safeName reports whether a stored filename can be written into the sync directory as is.
+func safeName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name &&
+		!strings.ContainsAny(name, `/\`) && !strings.HasSuffix(name, partSuffix)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+)
+
+func runStorage(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("storage: missing subcommand (list, migrate, cleanup, status)")
+	}
+	switch args[0] {
+	case "list":
+		return storageList(cfg)
+	case "migrate":
+		return storageMigrate(cfg, args[1:])
+	case "cleanup":
+		return storageCleanup(cfg, args[1:])
+	case "status":
+		return jobStatus(cfg, args[1:], jobs.KindBackendMigration, jobs.KindBackendCleanup)
+	default:
+		return fmt.Errorf("storage: unknown subcommand %q", args[0])
+	}
+}
+
+func storageList(cfg *config.Config) error {
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	content := clients.ContentStore()
+	names := content.BackendNames()
+	usage, err := jobs.BackendUsage(clients.Postgrest, names)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tACTIVE\tHOT BLOBS")
+	for _, name := range append([]string{""}, names...) {
+		label := name
+		if label == "" {
+			label = "(default)"
+		}
+		fmt.Fprintf(w, "%s\t%t\t%d\n", label, name == content.ActiveBackend(), usage[name])
+	}
+	return w.Flush()
+}
+
+// backendFlags parses the -to flag shared by migrate and cleanup, defaulting to ACTIVE_BACKEND.
+func backendFlags(cfg *config.Config, name string, args []string, rate *float64) (string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	target := fs.String("to", cfg.Storage.ActiveBackend, "backend from STORAGE_BACKENDS the content moves to")
+	if rate != nil {
+		fs.Float64Var(rate, "rate", 0, "copy rate limit in MiB per second (0 for unlimited)")
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if *target == "" {
+		return "", fmt.Errorf("%s: set ACTIVE_BACKEND or pass -to", name)
+	}
+	return *target, nil
+}
+
+func storageMigrate(cfg *config.Config, args []string) error {
+	var rate float64
+	target, err := backendFlags(cfg, "storage migrate", args, &rate)
+	if err != nil {
+		return err
+	}
+	if rate < 0 {
+		return errors.New("storage migrate: -rate must not be negative")
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	manager := jobs.NewManager(clients.Postgrest)
+	manager.Register(jobs.KindBackendMigration, jobs.BackendMigration(clients.Postgrest, clients.ContentStore()))
+
+	job, err := resumeOrCreate(manager, jobs.KindBackendMigration, jobs.BackendMigrationParams{
+		Target:         target,
+		BytesPerSecond: int64(rate * (1 << 20)),
+	}, "vaultctl storage status")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrating content to backend %s (job %s, %d blobs)\n", target, job.JobID, job.Total)
+	return runJob(manager, job)
+}
+
+func storageCleanup(cfg *config.Config, args []string) error {
+	target, err := backendFlags(cfg, "storage cleanup", args, nil)
+	if err != nil {
+		return err
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	remaining, err := jobs.NotOnBackend(clients.Postgrest, target)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d blobs are not on %s yet; run `vaultctl storage migrate -to %s` first", remaining, target, target)
+	}
+	manager := jobs.NewManager(clients.Postgrest)
+	manager.Register(jobs.KindBackendCleanup, jobs.BackendCleanup(clients.Postgrest, clients.ContentStore()))
+
+	job, err := resumeOrCreate(manager, jobs.KindBackendCleanup, jobs.BackendMigrationParams{Target: target}, "vaultctl storage status")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removing migrated content from the other backends (job %s, %d blobs)\n", job.JobID, job.Total)
+	return runJob(manager, job)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/storage"
+)
+
+func runBlobs(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("blobs: missing subcommand (migrate, scrub, repair, heal, tier, gc, status)")
+	}
+	switch args[0] {
+	case "migrate":
+		return blobsSweep(cfg, jobs.KindBlobLayout, "Moving blobs to content-addressed keys")
+	case "scrub":
+		return blobsSweep(cfg, jobs.KindScrub, "Verifying blobs")
+	case "repair":
+		return blobsSweep(cfg, jobs.KindReplicaRepair, "Repairing blob replicas")
+	case "heal":
+		return blobsSweep(cfg, jobs.KindHeal, "Healing erasure-coded blobs")
+	case "tier":
+		return blobsTier(cfg)
+	case "gc":
+		return blobsGC(cfg, args[1:])
+	case "status":
+		return blobsStatus(cfg, args[1:])
+	default:
+		return fmt.Errorf("blobs: unknown subcommand %q", args[0])
+	}
+}
+
+// blobsSweep runs (or resumes) a parameterless blob maintenance job in the foreground.
+func blobsSweep(cfg *config.Config, kind, title string) error {
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	content := clients.ContentStore()
+	manager := jobs.NewManager(clients.Postgrest)
+	manager.Register(jobs.KindBlobLayout, jobs.BlobLayout(clients.Postgrest, content))
+	manager.Register(jobs.KindScrub, jobs.Scrub(content))
+	manager.Register(jobs.KindReplicaRepair, jobs.ReplicaRepair(clients.Blobs))
+	manager.Register(jobs.KindHeal, jobs.Heal(content))
+
+	job, err := resumeOrCreate(manager, kind, nil, "vaultctl blobs status")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (job %s, %d blobs)\n", title, job.JobID, job.Total)
+	return runJob(manager, job)
+}
+
+// blobsTier moves content not read within COLD_AFTER_DAYS to the cold store in the foreground.
+func blobsTier(cfg *config.Config) error {
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	if clients.Tiering == nil {
+		return errors.New("blobs tier: COLD_STORE is not set")
+	}
+	manager := jobs.NewManager(clients.Postgrest)
+	manager.Register(jobs.KindTiering, jobs.Tiering(clients.Postgrest, clients.ContentStore()))
+
+	job, err := resumeOrCreate(manager, jobs.KindTiering, jobs.NewTieringParams(clients.Tiering), "vaultctl blobs status")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Moving content unread for %s to cold storage (job %s, %d blobs)\n", clients.Tiering.ColdAfter, job.JobID, job.Total)
+	return runJob(manager, job)
+}
+
+func blobsGC(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("blobs gc", flag.ContinueOnError)
+	grace := fs.Duration("grace", 24*time.Hour, "keep unreferenced blobs younger than this")
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without deleting it")
+	class := fs.String("class", storage.ClassHot, "storage class whose store to collect (hot or cold)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *class == storage.ClassCold && clients.Tiering == nil {
+		return errors.New("blobs gc: no cold store is configured")
+	}
+	report, err := jobs.CollectGarbage(ctx, clients.Postgrest, clients.ContentStore(), *class, *grace, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "Deleted"
+	deleted := report.Deleted
+	if *dryRun {
+		verb = "Would delete"
+		deleted = report.Superseded + report.Unknown
+	}
+	fmt.Printf("Scanned %d blobs: %d referenced, %d too recent to collect\n", report.Scanned, report.Referenced, report.Recent)
+	fmt.Printf("%s %d orphaned blobs (%d superseded copies, %d of unknown content), %d bytes\n",
+		verb, deleted, report.Superseded, report.Unknown, report.Bytes)
+	return nil
+}
+
+func blobsStatus(cfg *config.Config, args []string) error {
+	return jobStatus(cfg, args, jobs.KindBlobLayout, jobs.KindScrub, jobs.KindReplicaRepair, jobs.KindHeal, jobs.KindTiering)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+func runFiles(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("files: missing subcommand (list, search)")
+	}
+	switch args[0] {
+	case "list":
+		return filesList(cfg, "", args[1:])
+	case "search":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return errors.New("files search: expected the text to search for before the flags")
+		}
+		return filesList(cfg, args[1], args[2:])
+	default:
+		return fmt.Errorf("files: unknown subcommand %q", args[0])
+	}
+}
+
+// filesList lists files across all users, newest first, optionally only those whose name
+// contains query.
+func filesList(cfg *config.Config, query string, args []string) error {
+	fs := flag.NewFlagSet("files list", flag.ContinueOnError)
+	owner := fs.String("owner", "", "only files of this user ID, username or email")
+	mimeType := fs.String("mime", "", "only files of this MIME type")
+	minSize := fs.String("min-size", "", "only files at least this large, e.g. 100MiB")
+	deleted := fs.Bool("deleted", false, "include deleted files")
+	limit := fs.Int("limit", 100, "maximum number of files to show")
+	asJSON := jsonFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+
+	embed := "file_contents(size,mime_type,client_encryption)"
+	if *mimeType != "" || *minSize != "" {
+		embed = "file_contents!inner(size,mime_type,client_encryption)"
+	}
+	filter := clients.Postgrest.From("files").
+		Select("file_id,owner_id,filename,is_deleted,created_at,"+embed+",users(username)", "", false).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(*limit, "")
+	if query != "" {
+		filter = filter.Ilike("filename", "%"+query+"%")
+	}
+	if *owner != "" {
+		ownerID, err := resolveUserID(clients.Postgrest, *owner)
+		if err != nil {
+			return err
+		}
+		filter = filter.Eq("owner_id", ownerID)
+	}
+	if *mimeType != "" {
+		filter = filter.Eq("file_contents.mime_type", *mimeType)
+	}
+	if *minSize != "" {
+		bytes, err := parseBytes(*minSize)
+		if err != nil {
+			return err
+		}
+		filter = filter.Gte("file_contents.size", fmt.Sprint(bytes))
+	}
+	if !*deleted {
+		filter = filter.Eq("is_deleted", "false")
+	}
+
+	var files []models.FileSearchResult
+	if _, err := filter.ExecuteTo(&files); err != nil {
+		return fmt.Errorf("list files: %w", err)
+	}
+
+	if *asJSON {
+		return printJSON(files)
+	}
+	w := newTable()
+	fmt.Fprintln(w, "FILE ID\tOWNER\tFILENAME\tSIZE\tTYPE\tDELETED\tCREATED")
+	for _, file := range files {
+		ownerName := file.OwnerID
+		if file.User != nil {
+			ownerName = file.User.Username
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", file.FileID, ownerName, file.Filename, formatBytes(file.Size),
+			file.MimeType, file.IsDeleted, file.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(files) == *limit {
+		fmt.Printf("(showing the newest %d; raise -limit for more)\n", *limit)
+	}
+	return nil
+}
+
+// resolveUserID returns the ID of the user ref names.
+func resolveUserID(db *postgrest.Client, ref string) (string, error) {
+	column, value := userRef(ref)
+	if column == "user_id" {
+		return value, nil
+	}
+	var users []models.User
+	if _, err := db.From("users").Select("user_id", "", false).Eq(column, value).ExecuteTo(&users); err != nil {
+		return "", fmt.Errorf("look up user %s: %w", ref, err)
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("user %s not found", ref)
+	}
+	return users[0].UserID, nil
+}
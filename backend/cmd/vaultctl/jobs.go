@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/models"
+)
+
+// resumeOrCreate picks up an interrupted job of kind if there is one, otherwise starts a new one.
+// statusCommand is suggested when a job of that kind is already running elsewhere.
+func resumeOrCreate(manager *jobs.Manager, kind string, params interface{}, statusCommand string) (*models.Job, error) {
+	stale, err := manager.Stale()
+	if err != nil {
+		return nil, err
+	}
+	for i := range stale {
+		if stale[i].Kind != kind {
+			continue
+		}
+		job := &stale[i]
+		if err := manager.Claim(job); err == nil {
+			fmt.Printf("Resuming interrupted job %s at %d/%d\n", job.JobID, job.Processed, job.Total)
+			return job, nil
+		}
+	}
+
+	job, err := manager.Create(kind, params)
+	if errors.Is(err, jobs.ErrAlreadyRunning) {
+		return nil, fmt.Errorf("%w; follow it with `%s`", err, statusCommand)
+	}
+	return job, err
+}
+
+// runJob runs job in the foreground, printing progress. Ctrl-C stops it at the next row and
+// leaves it resumable.
+func runJob(manager *jobs.Manager, job *models.Job) error {
+	manager.OnCheckpoint = printProgress
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := manager.Run(ctx, job); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("\nInterrupted; run the command again to resume.")
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("\nDone: %d processed, %d failed\n", job.Processed, job.Failed)
+	if job.LastError != "" {
+		fmt.Printf("Last error: %s\n", job.LastError)
+	}
+	return nil
+}
+
+// jobStatus prints the job named in args, or the latest jobs of each kind.
+func jobStatus(cfg *config.Config, args []string, kinds ...string) error {
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	manager := jobs.NewManager(clients.Postgrest)
+
+	var list []models.Job
+	if len(args) > 0 {
+		job, err := manager.Get(args[0])
+		if err != nil {
+			return fmt.Errorf("job %s not found: %w", args[0], err)
+		}
+		list = []models.Job{*job}
+	} else {
+		for _, kind := range kinds {
+			latest, err := manager.List(kind, 10)
+			if err != nil {
+				return err
+			}
+			list = append(list, latest...)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB ID\tKIND\tSTATUS\tPROGRESS\tFAILED\tSTARTED\tLAST ERROR")
+	for _, job := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%d\t%s\t%s\n", job.JobID, job.Kind, job.Status, job.Processed, job.Total, job.Failed,
+			job.CreatedAt.Format("2006-01-02 15:04"), job.LastError)
+	}
+	return w.Flush()
+}
+
+func printProgress(job models.Job) {
+	fmt.Printf("\r%d/%d processed, %d failed", job.Processed, job.Total, job.Failed)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/keys"
+)
+
+func runKeys(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("keys: missing subcommand (list, add, rotate, status, retire)")
+	}
+	switch args[0] {
+	case "list":
+		return keysList(cfg)
+	case "add":
+		return keysAdd(cfg, args[1:])
+	case "rotate":
+		return keysRotate(cfg, args[1:])
+	case "status":
+		return keysStatus(cfg, args[1:])
+	case "retire":
+		return keysRetire(cfg, args[1:])
+	default:
+		return fmt.Errorf("keys: unknown subcommand %q", args[0])
+	}
+}
+
+func keysList(cfg *config.Config) error {
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	keyIDs := clients.Keys.KeyIDs()
+	usage, err := jobs.KeyUsage(clients.Postgrest, keyIDs)
+	if err != nil {
+		return err
+	}
+	accessKeys, err := jobs.AccessKeyUsage(clients.Postgrest, keyIDs)
+	if err != nil {
+		return err
+	}
+
+	current := clients.Keys.CurrentKeyID()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY ID\tCURRENT\tBLOBS\tACCESS KEYS\tRETIRABLE")
+	for _, id := range keyIDs {
+		retirable := id != current && usage[id] == 0 && accessKeys[id] == 0
+		fmt.Fprintf(w, "%s\t%t\t%d\t%d\t%t\n", id, id == current, usage[id], accessKeys[id], retirable)
+	}
+	fmt.Fprintf(w, "(unencrypted)\t\t%d\t\t\n", usage[""])
+	return w.Flush()
+}
+
+func keysAdd(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("keys add", flag.ContinueOnError)
+	path := fs.String("file", cfg.Keys.MasterKeyFile, "master key file to add the new version to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("keys add: set MASTER_KEY_FILE or pass -file")
+	}
+
+	// When moving from a single MASTER_KEY to a key file, carry the existing key over
+	var seed *keys.LocalProvider
+	if encoded := cfg.Keys.MasterKey.Value(); encoded != "" {
+		existing, err := keys.FromKey(cfg.Keys.MasterKeyID, encoded)
+		if err != nil {
+			return err
+		}
+		seed = existing
+	}
+
+	id, err := keys.AddKeyVersion(*path, seed)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added master key %s to %s and made it current.\n", id, *path)
+	fmt.Println("Restart the server with MASTER_KEY_FILE pointing at this file, then run `vaultctl keys rotate`.")
+	return nil
+}
+
+func keysRotate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	reencrypt := fs.Bool("reencrypt", false, "re-encrypt every blob with a fresh data key instead of only re-wrapping")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	rewrapped, err := jobs.RewrapAccessKeys(context.Background(), clients.Postgrest, clients.Keys)
+	if err != nil {
+		return err
+	}
+	if rewrapped > 0 {
+		fmt.Printf("Re-wrapped %d S3 access keys under master key %s\n", rewrapped, clients.Keys.CurrentKeyID())
+	}
+
+	content := clients.ContentStore()
+	manager := jobs.NewManager(clients.Postgrest)
+	manager.Register(jobs.KindKeyRotation, jobs.KeyRotation(clients.Postgrest, content))
+
+	job, err := resumeOrCreate(manager, jobs.KindKeyRotation, jobs.KeyRotationParams{
+		TargetKeyID: clients.Keys.CurrentKeyID(),
+		Reencrypt:   *reencrypt,
+	}, "vaultctl keys status")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotating to master key %s (job %s, %d blobs)\n", clients.Keys.CurrentKeyID(), job.JobID, job.Total)
+	return runJob(manager, job)
+}
+
+func keysStatus(cfg *config.Config, args []string) error {
+	return jobStatus(cfg, args, jobs.KindKeyRotation)
+}
+
+func keysRetire(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("keys retire: expected exactly one key ID")
+	}
+	id := args[0]
+	path := cfg.Keys.MasterKeyFile
+	if path == "" {
+		return errors.New("keys retire: MASTER_KEY_FILE is not set")
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	usage, err := jobs.KeyUsage(clients.Postgrest, []string{id})
+	if err != nil {
+		return err
+	}
+	if usage[id] > 0 {
+		return fmt.Errorf("master key %s still wraps %d blobs; run `vaultctl keys rotate` first", id, usage[id])
+	}
+	accessKeys, err := jobs.AccessKeyUsage(clients.Postgrest, []string{id})
+	if err != nil {
+		return err
+	}
+	if accessKeys[id] > 0 {
+		return fmt.Errorf("master key %s still wraps %d S3 access keys; run `vaultctl keys rotate` first", id, accessKeys[id])
+	}
+
+	if err := keys.RetireKeyVersion(path, id); err != nil {
+		return err
+	}
+	fmt.Printf("Retired master key %s.\n", id)
+	return nil
+}
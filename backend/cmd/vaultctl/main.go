@@ -0,0 +1,110 @@
+// Command vaultctl administers a Secure File Vault deployment from the command line.
+// It talks to the same database and blob store as the server, using the same configuration.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"file-vault/backend/internal/config"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: vaultctl [-config file.yaml] [setting flags] <command> [arguments]
+
+Settings are read from the config file (or VAULT_CONFIG), then the environment and .env,
+then setting flags such as -supabase-bucket; run "vaultctl -h" for the flag list.
+
+Commands:
+  keys list                     List master key versions and the blobs wrapped under each
+  keys add [-file path]         Generate a new master key version and make it current
+  keys rotate [-reencrypt]      Re-wrap (or re-encrypt) every blob under the current master key
+  keys status [job-id]          Show key rotation progress
+  keys retire <key-id>          Remove a master key version no blob references any more
+  blobs migrate                 Move blobs stored under random keys to content-addressed keys
+  blobs scrub                   Read every blob back and verify it against its content hash
+  blobs repair                  Copy blobs to replicas that are missing them
+  blobs heal                    Rebuild lost or corrupt shards on an erasure-coded store
+  blobs tier                    Move content unread for COLD_AFTER_DAYS to the cold store
+  blobs gc [-grace d] [-dry-run] [-class hot|cold]
+                                Delete blobs no file_contents row references
+  blobs status [job-id]         Show blob maintenance job progress
+  storage list                  List storage backends and how much content each holds
+  storage migrate [-to name] [-rate MiB/s]
+                                Copy, verify and switch every blob to another backend
+  storage cleanup [-to name]    Delete migrated blobs from the backends they left
+  storage status [job-id]       Show storage migration progress
+  migrate up [-to version]      Apply pending schema migrations (needs DATABASE_URL)
+  migrate down [-steps n | -to version]
+                                Roll back the newest schema migrations (one by default)
+  migrate status                Show which schema migrations are applied
+  users list [-q text] [-admins]
+                                List users, optionally matching a username or email
+  users create -username u -email e [-password p] [-admin]
+                                Create a verified user; a password is generated if none is given
+  users promote|demote <user>   Grant or revoke administrator rights
+  users set <user> [-rate-limit n] [-quota size]
+                                Set a user's requests per second and storage quota (e.g. 10GiB)
+  files list [-owner user] [-mime type] [-min-size size] [-deleted] [-limit n]
+                                List files across all users, newest first
+  files search <text> [flags]   List files whose name contains text, with the files list flags
+  stats [-top n]                Show deduplication and compression savings and the most shared content
+
+<user> is a user ID, username or email address. users list, files, stats and migrate status accept -json.
+`
+
+func main() {
+	// A missing .env is fine: the environment may already be populated
+	_ = godotenv.Load()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "help":
+		fmt.Print(usage)
+		return
+	}
+
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vaultctl: %v\n", err)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "keys":
+		err = runKeys(cfg, args[1:])
+	case "blobs":
+		err = runBlobs(cfg, args[1:])
+	case "storage":
+		err = runStorage(cfg, args[1:])
+	case "migrate":
+		err = runMigrate(cfg, args[1:])
+	case "users":
+		err = runUsers(cfg, args[1:])
+	case "files":
+		err = runFiles(cfg, args[1:])
+	case "stats":
+		err = runStats(cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "vaultctl: unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vaultctl: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database/migrations"
+)
+
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate: missing subcommand (up, down, status)")
+	}
+	if cfg.Database.URL == "" {
+		return errors.New("migrate: set DATABASE_URL to the PostgreSQL connection URL")
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp(cfg, args[1:])
+	case "down":
+		return migrateDown(cfg, args[1:])
+	case "status":
+		return migrateStatus(cfg, args[1:])
+	default:
+		return fmt.Errorf("migrate: unknown subcommand %q", args[0])
+	}
+}
+
+func migrateUp(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+	to := fs.Int("to", 0, "version to migrate up to (default latest)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	m, err := migrations.Connect(ctx, cfg.Database.URL.Value())
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+
+	applied, err := m.Up(ctx, *to)
+	for _, migration := range applied {
+		fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("Schema is up to date")
+	}
+	return nil
+}
+
+func migrateDown(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	to := fs.Int("to", -1, "version to roll back to; 0 drops the whole schema")
+	steps := fs.Int("steps", 1, "number of versions to roll back when -to is not given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	m, err := migrations.Connect(ctx, cfg.Database.URL.Value())
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+
+	target := *to
+	if target < 0 {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		var appliedVersions []int
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				appliedVersions = append(appliedVersions, status.Version)
+			}
+		}
+		if *steps < 1 || len(appliedVersions) == 0 {
+			fmt.Println("Nothing to roll back")
+			return nil
+		}
+		if *steps >= len(appliedVersions) {
+			target = 0
+		} else {
+			target = appliedVersions[len(appliedVersions)-*steps-1]
+		}
+	}
+
+	rolledBack, err := m.Down(ctx, target)
+	for _, migration := range rolledBack {
+		fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+	}
+	return err
+}
+
+func migrateStatus(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate status", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	m, err := migrations.Connect(ctx, cfg.Database.URL.Value())
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		type row struct {
+			Version   int        `json:"version"`
+			Name      string     `json:"name"`
+			AppliedAt *time.Time `json:"applied_at"`
+		}
+		rows := make([]row, len(statuses))
+		for i, status := range statuses {
+			rows[i] = row{Version: status.Version, Name: status.Name, AppliedAt: status.AppliedAt}
+		}
+		return printJSON(rows)
+	}
+
+	w := newTable()
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// dedupStats summarizes how much storage deduplication and compression save across all users.
+type dedupStats struct {
+	Files            int64           `json:"files"`
+	UniqueContents   int             `json:"unique_contents"`
+	ReferencedBytes  int64           `json:"referenced_bytes"` // What storage would take without deduplication
+	UniqueBytes      int64           `json:"unique_bytes"`
+	PhysicalBytes    int64           `json:"physical_bytes"`
+	DedupSavedBytes  int64           `json:"dedup_saved_bytes"`
+	CompressionSaved int64           `json:"compression_saved_bytes"`
+	DedupRatio       float64         `json:"dedup_ratio"`
+	MostShared       []sharedContent `json:"most_shared"`
+}
+
+// sharedContent is stored content referenced by more than one file.
+type sharedContent struct {
+	ContentID      string `json:"content_id"`
+	Size           int64  `json:"size"`
+	MimeType       string `json:"mime_type"`
+	ReferenceCount int    `json:"reference_count"`
+	DedupScope     string `json:"dedup_scope,omitempty"`
+}
+
+func runStats(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	top := fs.Int("top", 10, "number of most shared contents to list")
+	asJSON := jsonFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	clients, err := database.InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	db := clients.Postgrest
+
+	var contents []models.FileContent
+	if _, err := db.From("file_contents").Select("size,stored_size,reference_count", "", false).ExecuteTo(&contents); err != nil {
+		return fmt.Errorf("read file contents: %w", err)
+	}
+	_, files, err := db.From("files").Select("file_id", "exact", true).Eq("is_deleted", "false").Execute()
+	if err != nil {
+		return fmt.Errorf("count files: %w", err)
+	}
+
+	stats := dedupStats{Files: files, UniqueContents: len(contents)}
+	for _, content := range contents {
+		stats.UniqueBytes += content.Size
+		stats.PhysicalBytes += content.PhysicalSize()
+		stats.ReferencedBytes += content.Size * int64(content.ReferenceCount)
+	}
+	stats.DedupSavedBytes = stats.ReferencedBytes - stats.UniqueBytes
+	stats.CompressionSaved = stats.UniqueBytes - stats.PhysicalBytes
+	if stats.UniqueBytes > 0 {
+		stats.DedupRatio = float64(stats.ReferencedBytes) / float64(stats.UniqueBytes)
+	}
+
+	stats.MostShared = []sharedContent{}
+	if *top > 0 {
+		_, err = db.From("file_contents").
+			Select("content_id,size,mime_type,reference_count,dedup_scope", "", false).
+			Gt("reference_count", "1").
+			Order("reference_count", &postgrest.OrderOpts{Ascending: false}).
+			Limit(*top, "").
+			ExecuteTo(&stats.MostShared)
+		if err != nil {
+			return fmt.Errorf("read most shared contents: %w", err)
+		}
+	}
+
+	if *asJSON {
+		return printJSON(stats)
+	}
+	w := newTable()
+	fmt.Fprintf(w, "Files\t%d\n", stats.Files)
+	fmt.Fprintf(w, "Unique contents\t%d\n", stats.UniqueContents)
+	fmt.Fprintf(w, "Referenced size\t%s\n", formatBytes(stats.ReferencedBytes))
+	fmt.Fprintf(w, "Unique size\t%s\n", formatBytes(stats.UniqueBytes))
+	fmt.Fprintf(w, "Stored size\t%s\n", formatBytes(stats.PhysicalBytes))
+	fmt.Fprintf(w, "Saved by dedup\t%s (%.2fx)\n", formatBytes(stats.DedupSavedBytes), stats.DedupRatio)
+	fmt.Fprintf(w, "Saved by compression\t%s\n", formatBytes(stats.CompressionSaved))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(stats.MostShared) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w = newTable()
+	fmt.Fprintln(w, "CONTENT ID\tREFERENCES\tSIZE\tSAVED\tTYPE")
+	for _, content := range stats.MostShared {
+		saved := content.Size * int64(content.ReferenceCount-1)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", content.ContentID, content.ReferenceCount, formatBytes(content.Size),
+			formatBytes(saved), content.MimeType)
+	}
+	return w.Flush()
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"net/http"
 
+	"file-vault/backend/internal/apierror"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,7 +21,7 @@ func (h *Handler) UploadFileHandler(c *gin.Context) {
 
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file was received."})
+		apierror.Respond(c, apierror.BadRequest("No file was received."))
 		return
 	}
 
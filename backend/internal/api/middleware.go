@@ -1,11 +1,21 @@
 package api
 
 import (
+	"file-vault/backend/internal/apierror"
 	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/handlers"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/metrics"
 	"file-vault/backend/internal/models"
-	"log"
+	"file-vault/backend/internal/s3"
+	"file-vault/backend/internal/tracing"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/time/rate"
@@ -55,6 +65,7 @@ func (u *UserRateLimiter) GetLimiter(userID string) *rate.Limiter {
 // RateLimitMiddleware is a Gin middleware for per-user rate limiting.
 func RateLimitMiddleware(limiter *UserRateLimiter, clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		userID := c.Query("owner_id")
 		if userID == "" {
 			c.Next()
@@ -82,7 +93,8 @@ func RateLimitMiddleware(limiter *UserRateLimiter, clients *database.AppClients)
 
 		// Now, check if the request is allowed.
 		if !userLimiter.Allow() {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			metrics.RateLimited.Inc()
+			apierror.Respond(c, apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests"))
 			return
 		}
 
@@ -90,9 +102,112 @@ func RateLimitMiddleware(limiter *UserRateLimiter, clients *database.AppClients)
 	}
 }
 
+// LoggerMiddleware gives every request an ID, echoed in the X-Request-ID response header, and
+// a logger tagged with it and the trace ID that handlers get through logging.FromContext. Each
+// request is logged once it completes; probe and scrape requests only at debug level.
+func LoggerMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := logging.NewRequestID(c.GetHeader(logging.RequestIDHeader))
+		c.Header(logging.RequestIDHeader, requestID)
+
+		ctx := logging.WithRequestID(c.Request.Context(), requestID)
+		requestLogger := logger.With("request_id", requestID)
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			requestLogger = requestLogger.With("trace_id", traceID)
+		}
+		c.Request = c.Request.WithContext(logging.NewContext(ctx, requestLogger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case c.FullPath() == "/healthz" || c.FullPath() == "/readyz" || c.FullPath() == "/metrics":
+			level = slog.LevelDebug
+		}
+		// The query string is left out: it can carry user IDs and share tokens
+		requestLogger.Log(c.Request.Context(), level, "Request completed",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// RecoveryMiddleware answers 500 when a handler panics, logging the panic and stack trace with
+// the request's logger instead of gin's plain-text writer.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		logging.FromContext(c.Request.Context()).Error("Panic handling request", "stack", string(debug.Stack()))
+		apierror.Respond(c, apierror.Internal("Internal server error", fmt.Errorf("panic: %v", recovered)))
+	})
+}
+
+// MetricsMiddleware records the count and latency of every request, labelled with the route
+// template (e.g. /api/v1/files/:id) so IDs in paths do not explode the label set.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// CORSMiddleware lets browsers on the given origins call the API. Listed origins may send
+// credentials; "*" allows any other origin, but only without them.
+// Preflight requests are answered here, before rate limiting and authentication.
+func CORSMiddleware(origins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		switch {
+		case allowed[origin]:
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Credentials", "true")
+		case allowed["*"]:
+			// Never with credentials: any site could then act as the signed-in user
+			header.Set("Access-Control-Allow-Origin", "*")
+		default:
+			c.Next()
+			return
+		}
+		header.Set("Access-Control-Expose-Headers", "Content-Disposition, Content-Length, X-Request-ID")
+		if c.Request.Method == http.MethodOptions {
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-User-ID, X-Request-ID, Traceparent")
+			header.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
 // AuthMiddleware checks if a user is authenticated.
 func AuthMiddleware(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		// In a real app, you would parse a JWT token from the Authorization header.
 		// For this exercise, we'll assume the user ID is passed in a custom header for simplicity.
 		userID := c.GetHeader("X-User-ID")
@@ -103,20 +218,16 @@ func AuthMiddleware(clients *database.AppClients) gin.HandlerFunc {
 		}
 
 		if userID == "" {
-			log.Printf("AuthMiddleware: User ID missing from header and query parameter.")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required: User ID missing"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "Authentication required: User ID missing"))
 			return
 		}
 
-		log.Printf("AuthMiddleware: Attempting to authenticate user with ID: %s", userID)
 		var user models.User
 		_, err := clients.Postgrest.From("users").Select("*", "", false).Single().Eq("user_id", userID).ExecuteTo(&user)
 		if err != nil {
-			log.Printf("AuthMiddleware: Failed to fetch user %s from database: %v", userID, err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID or user not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusUnauthorized, apierror.CodeUnauthenticated, "Invalid user ID or user not found"))
 			return
 		}
-		log.Printf("AuthMiddleware: User %s authenticated successfully.", userID)
 
 		// Set the user ID and user model in the context for subsequent handlers
 		c.Set("userID", userID)
@@ -125,24 +236,86 @@ func AuthMiddleware(clients *database.AppClients) gin.HandlerFunc {
 	}
 }
 
+// TokenAuthMiddleware authenticates requests with a personal token sent as the password of
+// HTTP Basic credentials, for clients such as WebDAV mounts that cannot send anything else.
+func TokenAuthMiddleware(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		username, token, ok := c.Request.BasicAuth()
+		if !ok {
+			// Prompts file managers to ask for credentials
+			c.Header("WWW-Authenticate", `Basic realm="File Vault", charset="UTF-8"`)
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "Authentication required: sign in with your username and a personal token"))
+			return
+		}
+
+		user, err := handlers.AuthenticateToken(c.Request.Context(), clients, username, token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="File Vault", charset="UTF-8"`)
+			apierror.Respond(c, apierror.Wrap(err, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid username or personal token"))
+			return
+		}
+
+		c.Set("userID", user.UserID)
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// S3AuthMiddleware authenticates requests to the S3 gateway by their AWS Signature Version 4
+// signature, made with one of the user's access keys. Failures are answered with S3 error
+// documents, which S3 clients understand, rather than problem documents.
+func S3AuthMiddleware(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		ctx := c.Request.Context()
+		c.Header("X-Amz-Request-Id", logging.RequestID(ctx))
+
+		var key models.AccessKey
+		auth, err := s3.Verify(c.Request, func(accessKeyID string) (string, error) {
+			found, secret, err := handlers.AccessKeySecret(ctx, clients, accessKeyID)
+			key = found
+			return secret, err
+		})
+		if err != nil {
+			handlers.RespondS3(c, err)
+			return
+		}
+
+		var user models.User
+		_, err = clients.Postgrest.From("users").Select("*", "", false).Single().Eq("user_id", key.UserID).ExecuteTo(&user)
+		if err != nil {
+			handlers.RespondS3(c, s3.ErrInvalidAccessKeyID)
+			return
+		}
+		handlers.RecordAccessKeyUse(ctx, clients, key)
+
+		c.Set("userID", user.UserID)
+		c.Set("user", user)
+		c.Set("s3Auth", auth)
+		c.Next()
+	}
+}
+
 // AdminAuthMiddleware checks if a user has admin privileges by querying the database.
 func AdminAuthMiddleware(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		userID := c.Query("user_id") // In a real app, get this from JWT/session
 		if userID == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User ID is required"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "User ID is required"))
 			return
 		}
 
 		var user models.User
 		_, err := clients.Postgrest.From("users").Select("*", "", false).Single().Eq("user_id", userID).ExecuteTo(&user)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "User not found"))
 			return
 		}
 
 		if !user.IsAdmin {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "Admin privileges required"))
 			return
 		}
 
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func corsResponse(origins []string, method, origin string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(CORSMiddleware(origins))
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/ping", nil)
+	req.Header.Set("Origin", origin)
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSListedOriginGetsCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := corsResponse([]string{"https://app.example.com", "*"}, http.MethodGet, "https://app.example.com")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Fatalf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+}
+
+func TestCORSWildcardNeverAllowsCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		rec := corsResponse([]string{"*"}, method, "https://evil.example")
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Fatalf("%s: Access-Control-Allow-Origin = %q, want *", method, got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Fatalf("%s: Access-Control-Allow-Credentials = %q, want none", method, got)
+		}
+	}
+}
+
+func TestCORSUnlistedOriginIsNotAllowed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := corsResponse([]string{"https://app.example.com"}, http.MethodGet, "https://evil.example")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
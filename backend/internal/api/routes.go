@@ -1,28 +1,62 @@
 package api
 
 import (
+	"log/slog"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/config"
 	"file-vault/backend/internal/database" // Import database package for AppClients
+	"file-vault/backend/internal/dedup"
 	"file-vault/backend/internal/handlers"
+	"file-vault/backend/internal/health"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/openapi"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
 	// Import postgrest-go
 )
 
-// SetupRoutes configures the API routes
-func SetupRoutes(router *gin.Engine, clients *database.AppClients) { // Accept AppClients
-	// Initialize the rate limiter: 2 requests per second with a burst of 4.
-	limiter := NewUserRateLimiter(rate.Limit(2), 4)
+// SetupRoutes configures the API routes and returns the background job manager, which the
+// caller shuts down when the server stops.
+func SetupRoutes(router *gin.Engine, clients *database.AppClients, cfg *config.Config, checker *health.Checker) *jobs.Manager { // Accept AppClients
+	// Initialize the default per-user rate limiter
+	limiter := NewUserRateLimiter(rate.Limit(cfg.RateLimit.RequestsPerSecond), cfg.RateLimit.Burst)
+
+	// Background jobs resume where they left off if the server restarted mid-run
+	jobManager := setupJobs(clients)
+
+	// Outstanding proof-of-ownership challenges for uploads that skip sending bytes
+	challenges := dedup.NewChallengeStore()
+
+	// Requests are checked against the API document before they reach a handler. Middleware
+	// only applies to routes registered after it, so this comes first.
+	spec := openapi.Spec()
+	router.Use(openapi.ValidateRequests(spec))
+	router.GET("/openapi.json", openapi.Handler(spec))
+	router.GET("/docs/*filepath", openapi.Docs())
+
+	// Probes for the orchestrator, outside rate limiting
+	router.GET("/healthz", handlers.Healthz())
+	router.GET("/readyz", handlers.Readyz(checker))
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Unknown paths get a problem document like every other error
+	router.NoRoute(func(c *gin.Context) {
+		apierror.Respond(c, apierror.NotFound("No route matches "+c.Request.Method+" "+c.Request.URL.Path))
+	})
 
 	// Group routes under /api/v1
 	v1 := router.Group("/api/v1")
 	v1.Use(RateLimitMiddleware(limiter, clients)) // Apply the rate limiting middleware to all v1 routes
 	{
 		// User routes
-		v1.POST("/register", handlers.RegisterUser(clients.Postgrest)) // Pass Postgrest client
-		v1.POST("/login", handlers.LoginUser(clients.Postgrest))       // Pass Postgrest client
-		v1.POST("/verify-otp", handlers.VerifyOTP(clients.Postgrest))
-		v1.POST("/resend-otp", handlers.ResendOTP(clients.Postgrest))
+		v1.POST("/register", handlers.RegisterUser(clients, cfg))
+		v1.POST("/login", handlers.LoginUser(clients))
+		v1.POST("/verify-otp", handlers.VerifyOTP(clients))
+		v1.POST("/resend-otp", handlers.ResendOTP(clients, cfg))
 
 		// Authenticated user routes
 		user := v1.Group("/user")
@@ -30,24 +64,44 @@ func SetupRoutes(router *gin.Engine, clients *database.AppClients) { // Accept A
 		{
 			user.GET("/quota", handlers.GetUserQuota(clients))
 			user.POST("/password", handlers.UpdatePassword(clients))
+			user.PATCH("/files/:id", handlers.RenameFile(clients))
 			user.POST("/files/:id/share", handlers.ShareFile(clients))
+
+			// End-to-end encryption: public keys and per-recipient wrapped file keys
+			user.PUT("/public-key", handlers.SetPublicKey(clients))
+			user.GET("/public-keys/:id", handlers.GetPublicKey(clients))
+			user.GET("/files/:id/key", handlers.GetFileKey(clients))
+			user.POST("/files/:id/keys", handlers.ShareFileKey(clients))
+			user.GET("/shared-with-me", handlers.ListFilesSharedWithMe(clients))
+
+			// Personal tokens, the passwords of WebDAV mounts
+			user.POST("/tokens", handlers.CreatePersonalToken(clients))
+			user.GET("/tokens", handlers.ListPersonalTokens(clients))
+			user.DELETE("/tokens/:id", handlers.RevokePersonalToken(clients))
+
+			// Access keys, which sign requests to the S3 gateway
+			user.POST("/access-keys", handlers.CreateAccessKey(clients))
+			user.GET("/access-keys", handlers.ListAccessKeys(clients))
+			user.DELETE("/access-keys/:id", handlers.RevokeAccessKey(clients))
 		}
 		// Publicly shared files route (no authentication required)
 		v1.GET("/user/shared-publicly", handlers.ListPubliclySharedFiles(clients))
 
 		// File routes (these should be accessible via v1, not user group)
-		v1.POST("/upload", handlers.UploadFile(clients, "balkanid-file-storage")) // Pass the entire clients object and bucket name
+		v1.POST("/upload", handlers.UploadFile(clients)) // Pass the entire clients object
+		v1.POST("/upload/check", handlers.CheckUpload(clients, challenges))
+		v1.POST("/upload/prove", handlers.ProveUpload(clients, challenges))
 		v1.GET("/files", handlers.ListFiles(clients))
-		v1.GET("/files/:id", handlers.GetFile(clients, "balkanid-file-storage"))
-		v1.DELETE("/files/:id", handlers.DeleteFile(clients, "balkanid-file-storage"))
+		v1.GET("/files/:id", handlers.GetFile(clients))
+		v1.DELETE("/files/:id", handlers.DeleteFile(clients))
 
 		// Search route (accessible via v1)
 		v1.GET("/search", handlers.SearchFiles(clients))
 		v1.GET("/stats", handlers.GetStats(clients))
 
 		// Public sharing routes (no authentication required for GetPublicShare and DownloadPublicShare)
-		router.GET("/share/:token", handlers.GetPublicShare(clients, "balkanid-file-storage"))
-		router.GET("/share/:token/download", handlers.DownloadPublicShare(clients, "balkanid-file-storage"))
+		router.GET("/share/:token", handlers.GetPublicShare(clients))
+		router.GET("/share/:token/download", handlers.DownloadPublicShare(clients))
 
 		// Admin routes
 		admin := v1.Group("/admin")
@@ -55,6 +109,76 @@ func SetupRoutes(router *gin.Engine, clients *database.AppClients) { // Accept A
 		{
 			admin.GET("/files", handlers.AdminListFiles(clients))
 			admin.POST("/config", handlers.UpdateConfig(clients))
+			admin.GET("/stats", handlers.AdminGetStats(clients))
+			admin.GET("/keys", handlers.ListMasterKeys(clients))
+			admin.POST("/keys/rotate", handlers.StartKeyRotation(clients, jobManager))
+			admin.POST("/blobs/migrate", handlers.StartBlobMigration(jobManager))
+			admin.POST("/blobs/scrub", handlers.StartScrub(jobManager))
+			admin.POST("/blobs/heal", handlers.StartHeal(jobManager))
+			admin.POST("/tiering/run", handlers.StartTiering(clients, jobManager))
+			admin.GET("/replicas", handlers.ListReplicas(clients))
+			admin.POST("/replicas/repair", handlers.StartReplicaRepair(jobManager))
+			admin.GET("/storage/backends", handlers.ListBackends(clients))
+			admin.POST("/storage/migrate", handlers.StartStorageMigration(clients, jobManager))
+			admin.POST("/storage/cleanup", handlers.StartStorageCleanup(clients, jobManager))
+			admin.GET("/diagnostics", handlers.Diagnostics(cfg, checker, jobManager))
+			admin.GET("/jobs", handlers.ListJobs(jobManager))
+			admin.GET("/jobs/:id", handlers.GetJob(jobManager))
+			admin.POST("/jobs/:id/cancel", handlers.CancelJob(jobManager))
+		}
+	}
+
+	// WebDAV, for mounting the vault as a network drive. It authenticates with personal
+	// tokens over HTTP Basic and is not rate limited per request: file managers send bursts
+	// of metadata requests whenever a folder is opened.
+	dav := router.Group("/dav")
+	dav.Use(TokenAuthMiddleware(clients))
+	{
+		davHandler := handlers.WebDAV(clients, "/dav")
+		for _, method := range handlers.WebDAVMethods {
+			dav.Handle(method, "/*path", davHandler)
 		}
 	}
+
+	// The S3-compatible gateway, for tools that speak S3. Requests are signed with access
+	// keys; like WebDAV it is not rate limited per request, as clients list and transfer in
+	// parallel. Clients address the service as "/s3/" and buckets below it.
+	gateway := router.Group("/s3")
+	gateway.Use(S3AuthMiddleware(clients))
+	{
+		s3Handler := handlers.S3(clients, "/s3")
+		for _, method := range handlers.S3Methods {
+			gateway.Handle(method, "/*path", s3Handler)
+		}
+	}
+	return jobManager
+}
+
+// setupJobs registers the background job kinds and resumes any job interrupted by a restart.
+func setupJobs(clients *database.AppClients) *jobs.Manager {
+	content := clients.ContentStore()
+
+	manager := jobs.NewManager(clients.Postgrest)
+	manager.Register(jobs.KindKeyRotation, jobs.KeyRotation(clients.Postgrest, content))
+	manager.Register(jobs.KindBlobLayout, jobs.BlobLayout(clients.Postgrest, content))
+	manager.Register(jobs.KindScrub, jobs.Scrub(content))
+	manager.Register(jobs.KindReplicaRepair, jobs.ReplicaRepair(clients.Blobs))
+	manager.Register(jobs.KindTiering, jobs.Tiering(clients.Postgrest, content))
+	manager.Register(jobs.KindHeal, jobs.Heal(content))
+	manager.Register(jobs.KindBackendMigration, jobs.BackendMigration(clients.Postgrest, content))
+	manager.Register(jobs.KindBackendCleanup, jobs.BackendCleanup(clients.Postgrest, content))
+
+	if err := manager.Resume(); err != nil {
+		slog.Error("Error resuming background jobs", "error", err)
+	}
+
+	// Move content nobody has read in a while to the cold store once a day
+	if clients.Tiering != nil {
+		manager.Every(jobs.KindTiering, jobs.NewTieringParams(clients.Tiering), 24*time.Hour)
+	}
+	// Rebuild shards lost to failed or replaced disks, including blobs nobody reads
+	if jobs.CanHeal(content) {
+		manager.Every(jobs.KindHeal, nil, 24*time.Hour)
+	}
+	return manager
 }
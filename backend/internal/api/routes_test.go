@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/health"
+	"file-vault/backend/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// newTestRouter sets up every route against a PostgREST stand-in that answers each query
+// with no rows, which is all route registration and job resumption need.
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(rest.Close)
+
+	clients := &database.AppClients{Postgrest: postgrest.NewClient(rest.URL, "", nil)}
+	cfg := &config.Config{}
+	cfg.RateLimit.RequestsPerSecond = 100
+	cfg.RateLimit.Burst = 100
+
+	router := gin.New()
+	jobManager := SetupRoutes(router, clients, cfg, health.NewChecker(time.Second, time.Second))
+	t.Cleanup(jobManager.Shutdown)
+	return router
+}
+
+func TestRoutesMatchOpenAPIDocument(t *testing.T) {
+	router := newTestRouter(t)
+	if err := openapi.CheckRoutes(openapi.Spec(), router.Routes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateRequestsRejectsMalformedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(openapi.ValidateRequests(openapi.Spec()))
+	router.POST("/api/v1/upload/check", func(c *gin.Context) {
+		t.Error("a request failing validation reached the handler")
+	})
+
+	body := `{"hash_sha256": "not-a-sha256", "size": -1, "filename": "report.pdf"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/check?owner_id=6f1c2a7e-3b4d-4e5f-8a9b-0c1d2e3f4a5b", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400; body %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, apierror.ContentType) {
+		t.Fatalf("Content-Type = %q, want %s", got, apierror.ContentType)
+	}
+	var problem apierror.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	if problem.Code != apierror.CodeInvalidRequest || problem.Status != http.StatusBadRequest {
+		t.Fatalf("problem = %+v, want %s with status 400", problem, apierror.CodeInvalidRequest)
+	}
+	if !strings.Contains(problem.Detail, "hash_sha256") && !strings.Contains(problem.Detail, "size") {
+		t.Fatalf("detail %q does not name the invalid member", problem.Detail)
+	}
+}
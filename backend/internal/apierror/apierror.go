@@ -0,0 +1,124 @@
+// Package apierror defines the errors the API returns. Each has a stable machine-readable code,
+// an HTTP status and a message that is safe to show clients; the internal cause is logged but
+// never sent. Errors are written as RFC 7807 problem documents.
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/storage"
+)
+
+// Code identifies the kind of error. Codes are part of the API: clients branch on them, so
+// existing codes must not be renamed.
+type Code string
+
+// Error codes returned by the API.
+const (
+	CodeInvalidRequest     Code = "INVALID_REQUEST"
+	CodeUnauthenticated    Code = "UNAUTHENTICATED"
+	CodeInvalidCredentials Code = "INVALID_CREDENTIALS"
+	CodeEmailNotVerified   Code = "EMAIL_NOT_VERIFIED"
+	CodeAlreadyVerified    Code = "ALREADY_VERIFIED"
+	CodeInvalidOTP         Code = "INVALID_OTP"
+	CodeOTPExpired         Code = "OTP_EXPIRED"
+	CodeForbidden          Code = "FORBIDDEN"
+	CodeNotOwner           Code = "NOT_OWNER"
+	CodeNotShared          Code = "NOT_SHARED"
+	CodeNotFound           Code = "NOT_FOUND"
+	CodeAlreadyExists      Code = "ALREADY_EXISTS"
+	CodeConflict           Code = "CONFLICT"
+	CodeJobRunning         Code = "JOB_ALREADY_RUNNING"
+	CodeQuotaExceeded      Code = "QUOTA_EXCEEDED"
+	CodeMIMEMismatch       Code = "MIME_MISMATCH"
+	CodeProofFailed        Code = "PROOF_FAILED"
+	CodeContentGone        Code = "CONTENT_GONE"
+	CodeRateLimited        Code = "RATE_LIMITED"
+	CodeNotConfigured      Code = "NOT_CONFIGURED"
+	CodeInternal           Code = "INTERNAL"
+)
+
+// Error is an error the API reports to the client.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string // Shown to the client
+	Err     error  // Internal cause, logged but never sent
+	// Extensions are extra members of the problem document, e.g. the count blocking a request.
+	Extensions map[string]interface{}
+}
+
+// New returns an error with status, code and a message safe to show clients.
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Wrap returns an error with status, code and message, caused by err.
+func Wrap(err error, status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message, Err: err}
+}
+
+// BadRequest reports a malformed or incomplete request.
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+// InvalidBody reports a request body that could not be decoded or bound. The binding error
+// describes the client's input, so it is included in the message.
+func InvalidBody(err error) *Error {
+	return Wrap(err, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body: "+err.Error())
+}
+
+// NotFound reports a missing resource.
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+// Internal reports a server-side failure, described to the client only by message.
+func Internal(message string, err error) *Error {
+	return Wrap(err, http.StatusInternalServerError, CodeInternal, message)
+}
+
+// With adds the extension member key to the problem document.
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]interface{})
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// From maps err to the error reported to the client. Errors of other packages the handlers
+// pass through get their own codes; anything unrecognised is an internal error whose text
+// stays in the logs.
+func From(err error) *Error {
+	var apiErr *Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, jobs.ErrAlreadyRunning):
+		return Wrap(err, http.StatusConflict, CodeJobRunning, "A job of this kind is already running")
+	case errors.Is(err, dedup.ErrChallengeNotFound):
+		return Wrap(err, http.StatusNotFound, CodeNotFound, "Challenge not found or expired")
+	case errors.Is(err, dedup.ErrTooManyChallenges):
+		return Wrap(err, http.StatusTooManyRequests, CodeRateLimited, "Too many outstanding challenges; answer or wait for existing ones to expire")
+	case errors.Is(err, storage.ErrNotFound):
+		return Wrap(err, http.StatusNotFound, CodeNotFound, "Stored content not found")
+	default:
+		return Internal("Internal server error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFrom(t *testing.T) {
+	quota := New(http.StatusForbidden, CodeQuotaExceeded, "Storage quota exceeded")
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+	}{
+		{"API error", quota, http.StatusForbidden, CodeQuotaExceeded},
+		{"wrapped API error", fmt.Errorf("upload: %w", quota), http.StatusForbidden, CodeQuotaExceeded},
+		{"job running", fmt.Errorf("start: %w", jobs.ErrAlreadyRunning), http.StatusConflict, CodeJobRunning},
+		{"challenge gone", dedup.ErrChallengeNotFound, http.StatusNotFound, CodeNotFound},
+		{"too many challenges", dedup.ErrTooManyChallenges, http.StatusTooManyRequests, CodeRateLimited},
+		{"blob missing", storage.ErrNotFound, http.StatusNotFound, CodeNotFound},
+		{"anything else", errors.New("dial tcp 10.0.0.5:5432: connection refused"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := From(tc.err)
+			if got.Status != tc.status || got.Code != tc.code {
+				t.Fatalf("From = %d %s, want %d %s", got.Status, got.Code, tc.status, tc.code)
+			}
+			if !errors.Is(got, tc.err) && !errors.Is(tc.err, got) {
+				t.Fatal("the cause is not kept for logging")
+			}
+		})
+	}
+}
+
+func TestRespondWritesProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/upload", func(c *gin.Context) {
+		cause := errors.New("quota row 42 for postgres://vault:hunter2@db")
+		Respond(c, Wrap(cause, http.StatusForbidden, CodeQuotaExceeded, "Storage quota exceeded").With("quota", 100))
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/upload", nil))
+
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if strings.Contains(rec.Body.String(), "hunter2") || strings.Contains(rec.Body.String(), "quota row") {
+		t.Fatalf("internal cause sent to the client: %s", rec.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":     "urn:file-vault:problem:quota-exceeded",
+		"title":    "Forbidden",
+		"status":   float64(403),
+		"detail":   "Storage quota exceeded",
+		"instance": "/api/v1/upload",
+		"code":     "QUOTA_EXCEEDED",
+		"error":    "Storage quota exceeded",
+		"quota":    float64(100),
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+}
+
+func TestExtensionsDoNotReplaceStandardMembers(t *testing.T) {
+	p := Problem{Status: 409, Code: CodeConflict, Detail: "Conflict", Extensions: map[string]interface{}{"status": 200, "active": 3}}
+	body, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	json.Unmarshal(body, &got)
+	if got["status"] != float64(409) || got["active"] != float64(3) {
+		t.Fatalf("marshalled %s", body)
+	}
+}
@@ -0,0 +1,79 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"file-vault/backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem documents.
+const ContentType = "application/problem+json"
+
+// typePrefix makes a problem type URI from an error code.
+const typePrefix = "urn:file-vault:problem:"
+
+// Problem is the RFC 7807 document every error response carries.
+type Problem struct {
+	Type      string `json:"type" example:"urn:file-vault:problem:quota-exceeded"`
+	Title     string `json:"title" example:"Forbidden"`
+	Status    int    `json:"status" example:"403"`
+	Detail    string `json:"detail" example:"Storage quota exceeded"`
+	Instance  string `json:"instance,omitempty" example:"/api/v1/upload"`
+	Code      Code   `json:"code" example:"QUOTA_EXCEEDED"`
+	RequestID string `json:"request_id,omitempty" example:"0b7c5a4e-2f1d-4c8e-9a57-3f0e6d1b2c4a"`
+	// Error repeats Detail for clients written against the earlier {"error": "..."} bodies.
+	Error string `json:"error" example:"Storage quota exceeded"`
+
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON adds the extension members alongside the standard ones.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	body, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+	members := make(map[string]interface{}, len(p.Extensions))
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// Problem returns the document describing e for the request c is handling.
+func (e *Error) Problem(c *gin.Context) Problem {
+	return Problem{
+		Type:       typePrefix + strings.ReplaceAll(strings.ToLower(string(e.Code)), "_", "-"),
+		Title:      http.StatusText(e.Status),
+		Status:     e.Status,
+		Detail:     e.Message,
+		Instance:   c.Request.URL.Path,
+		Code:       e.Code,
+		RequestID:  logging.RequestID(c.Request.Context()),
+		Error:      e.Message,
+		Extensions: e.Extensions,
+	}
+}
+
+// Respond aborts the request c is handling with err as a problem document. Server errors are
+// logged with their internal cause; client errors only at debug level.
+func Respond(c *gin.Context, err error) {
+	apiErr := From(err)
+	logger := logging.FromContext(c.Request.Context())
+	if apiErr.Status >= http.StatusInternalServerError {
+		logger.Error(apiErr.Message, "code", apiErr.Code, "error", apiErr.Err)
+	} else if apiErr.Err != nil {
+		logger.Debug(apiErr.Message, "code", apiErr.Code, "error", apiErr.Err)
+	}
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(apiErr.Status, apiErr.Problem(c))
+}
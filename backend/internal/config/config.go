@@ -0,0 +1,293 @@
+// Package config loads the server and vaultctl configuration from defaults, an optional YAML
+// file, the environment and command-line flags, in that order of precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/dedup"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete configuration. Each setting can come from the YAML key given by its
+// yaml tags, the environment variable in its env tag, or the flag in its flag tag.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Supabase  SupabaseConfig  `yaml:"supabase"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Keys      KeysConfig      `yaml:"keys"`
+	Dedup     DedupConfig     `yaml:"dedup"`
+	SMTP      SMTPConfig      `yaml:"smtp"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	OTP       OTPConfig       `yaml:"otp"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Log       LogConfig       `yaml:"log"`
+}
+
+// ServerConfig controls how the API server listens and shuts down.
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" env:"SERVER_ADDR" flag:"addr" default:":8080" usage:"Address the API server listens on"`
+	TLSCertFile     string        `yaml:"tls_cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"PEM certificate served over TLS; plain HTTP when unset"`
+	TLSKeyFile      string        `yaml:"tls_key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"PEM private key of the TLS certificate"`
+	ClientCAFile    string        `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca" usage:"PEM CA bundle client certificates must chain to (mutual TLS)"`
+	TrustedProxies  string        `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trusted-proxies" usage:"Comma-separated proxy IPs or CIDRs whose X-Forwarded-For is trusted"`
+	CORSOrigins     string        `yaml:"cors_origins" env:"CORS_ORIGINS" flag:"cors-origins" default:"http://localhost:5173" usage:"Comma-separated origins the frontend is served from; * allows any origin, without credentials"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"30s" usage:"How long in-flight requests may finish after SIGTERM"`
+}
+
+// SupabaseConfig locates the Supabase project holding the database and, by default, the blobs.
+type SupabaseConfig struct {
+	RestURL    string `yaml:"rest_url" env:"SUPABASE_REST_URL" flag:"supabase-rest-url" usage:"PostgREST endpoint of the Supabase project"`
+	StorageURL string `yaml:"storage_url" env:"SUPABASE_STORAGE_URL" flag:"supabase-storage-url" usage:"Storage endpoint of the Supabase project"`
+	Key        Secret `yaml:"key" env:"SUPABASE_KEY" flag:"supabase-key" usage:"Supabase service key"`
+	Bucket     string `yaml:"bucket" env:"SUPABASE_BUCKET" flag:"supabase-bucket" default:"balkanid-file-storage" usage:"Storage bucket blobs are kept in unless blob_store is set"`
+}
+
+// DatabaseConfig is the direct PostgreSQL connection schema migrations run over. The API itself
+// goes through PostgREST.
+type DatabaseConfig struct {
+	URL         Secret `yaml:"url" env:"DATABASE_URL" flag:"database-url" usage:"PostgreSQL connection URL used for schema migrations"`
+	AutoMigrate bool   `yaml:"auto_migrate" env:"AUTO_MIGRATE" flag:"auto-migrate" usage:"Apply pending schema migrations when the server starts"`
+}
+
+// StorageConfig selects the blob stores. Store URLs are those storage.OpenURL accepts.
+type StorageConfig struct {
+	BlobStore       string `yaml:"blob_store" env:"BLOB_STORE" flag:"blob-store" usage:"Blob store URL replacing the Supabase bucket"`
+	Backends        string `yaml:"backends" env:"STORAGE_BACKENDS" flag:"storage-backends" usage:"Named blob stores content can be migrated to, as name=url pairs"`
+	ActiveBackend   string `yaml:"active_backend" env:"ACTIVE_BACKEND" flag:"active-backend" usage:"Backend from storage backends new uploads are written to"`
+	ReplicaStores   string `yaml:"replica_stores" env:"REPLICA_STORES" flag:"replica-stores" usage:"Secondary blob stores every blob is copied to, as name=url pairs"`
+	ColdStore       string `yaml:"cold_store" env:"COLD_STORE" flag:"cold-store" usage:"Blob store URL of the cold storage class"`
+	ColdAfterDays   int    `yaml:"cold_after_days" env:"COLD_AFTER_DAYS" flag:"cold-after-days" default:"30" usage:"Days content may go unread before it moves to cold storage"`
+	RehydrateOnRead bool   `yaml:"rehydrate_on_read" env:"REHYDRATE_ON_READ" flag:"rehydrate-on-read" usage:"Move cold content back to hot storage when it is downloaded"`
+}
+
+// KeysConfig locates the master keys blobs are encrypted under.
+type KeysConfig struct {
+	MasterKey     Secret `yaml:"master_key" env:"MASTER_KEY" flag:"master-key" usage:"Base64-encoded 32-byte master key"`
+	MasterKeyID   string `yaml:"master_key_id" env:"MASTER_KEY_ID" flag:"master-key-id" usage:"Version of master_key (default v1)"`
+	MasterKeyFile string `yaml:"master_key_file" env:"MASTER_KEY_FILE" flag:"master-key-file" usage:"Versioned master key file, used instead of master_key"`
+}
+
+// DedupConfig sets which users' uploads may share stored content.
+type DedupConfig struct {
+	Scope string `yaml:"scope" env:"DEDUP_SCOPE" flag:"dedup-scope" default:"global" usage:"Deduplication scope: global, organization or user"`
+}
+
+// SMTPConfig is the mail server OTP emails are sent through.
+type SMTPConfig struct {
+	Host string `yaml:"host" env:"SMTP_HOST" flag:"smtp-host" usage:"SMTP server host"`
+	Port int    `yaml:"port" env:"SMTP_PORT" flag:"smtp-port" default:"587" usage:"SMTP server port (STARTTLS)"`
+	User string `yaml:"user" env:"SMTP_USER" flag:"smtp-user" usage:"SMTP user, also the sender address"`
+	Pass Secret `yaml:"pass" env:"SMTP_PASS" flag:"smtp-pass" usage:"SMTP password"`
+}
+
+// RateLimitConfig is the default per-user API rate limit; admins can override it per user.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second" env:"RATE_LIMIT_RPS" flag:"rate-limit-rps" default:"2" usage:"Requests per second each user may make"`
+	Burst             int     `yaml:"burst" env:"RATE_LIMIT_BURST" flag:"rate-limit-burst" default:"4" usage:"Requests a user may make at once before the rate applies"`
+}
+
+// OTPConfig controls email verification codes.
+type OTPConfig struct {
+	Lifetime time.Duration `yaml:"lifetime" env:"OTP_LIFETIME" flag:"otp-lifetime" default:"15m" usage:"How long an emailed verification code stays valid"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported. The OTLP endpoint and service
+// name use the standard OpenTelemetry environment variables.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" default:"none" usage:"Span exporter: none, otlp or stdout"`
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"OTLP/HTTP collector URL (default http://localhost:4318)"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" flag:"service-name" default:"file-vault" usage:"Service name spans are reported under"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" default:"1" usage:"Fraction of new traces recorded; requests continuing a trace follow its decision"`
+}
+
+// LogConfig controls the server's log output.
+type LogConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" default:"info" usage:"Lowest level logged: debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" default:"text" usage:"Log line format: text or json"`
+}
+
+// Secret is a configuration value that is never printed.
+type Secret string
+
+// String returns a placeholder instead of the secret.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// Value returns the secret itself.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// MarshalYAML redacts the secret.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// LogValue redacts the secret in structured logs.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// MarshalJSON redacts the secret.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// String renders the configuration as YAML with secrets redacted, for logging at startup.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<config: %v>", err)
+	}
+	return string(out)
+}
+
+// Summary returns the configuration keyed like the YAML file, with secrets redacted.
+func (c *Config) Summary() map[string]interface{} {
+	summary := make(map[string]interface{})
+	out, err := yaml.Marshal(c)
+	if err == nil {
+		err = yaml.Unmarshal(out, &summary)
+	}
+	if err != nil {
+		summary["error"] = err.Error()
+	}
+	return summary
+}
+
+// Validate reports every invalid or missing setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		invalid("server.addr (SERVER_ADDR) must not be empty")
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		invalid("server.tls_cert_file (TLS_CERT_FILE) and server.tls_key_file (TLS_KEY_FILE) must be set together")
+	}
+	if c.Server.ClientCAFile != "" && c.Server.TLSCertFile == "" {
+		invalid("server.client_ca_file (TLS_CLIENT_CA_FILE) requires server.tls_cert_file (TLS_CERT_FILE)")
+	}
+	for _, proxy := range SplitList(c.Server.TrustedProxies) {
+		if !isIPOrCIDR(proxy) {
+			invalid("server.trusted_proxies (TRUSTED_PROXIES) %q is not an IP address or CIDR", proxy)
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive, got %s", c.Server.ShutdownTimeout)
+	}
+
+	if c.Supabase.RestURL == "" {
+		invalid("supabase.rest_url (SUPABASE_REST_URL) is required")
+	} else if !isHTTPURL(c.Supabase.RestURL) {
+		invalid("supabase.rest_url (SUPABASE_REST_URL) %q is not an http(s) URL", c.Supabase.RestURL)
+	}
+	if c.Supabase.Key == "" {
+		invalid("supabase.key (SUPABASE_KEY) is required")
+	}
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL.Value()); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			invalid("database.url (DATABASE_URL) is not a postgres:// URL")
+		}
+	} else if c.Database.AutoMigrate {
+		invalid("database.auto_migrate (AUTO_MIGRATE) requires database.url (DATABASE_URL)")
+	}
+	if c.Storage.BlobStore == "" {
+		if c.Supabase.StorageURL == "" {
+			invalid("supabase.storage_url (SUPABASE_STORAGE_URL) is required unless storage.blob_store (BLOB_STORE) is set")
+		} else if !isHTTPURL(c.Supabase.StorageURL) {
+			invalid("supabase.storage_url (SUPABASE_STORAGE_URL) %q is not an http(s) URL", c.Supabase.StorageURL)
+		}
+		if c.Supabase.Bucket == "" {
+			invalid("supabase.bucket (SUPABASE_BUCKET) must not be empty")
+		}
+	}
+	if c.Storage.ActiveBackend != "" && c.Storage.Backends == "" {
+		invalid("storage.active_backend (ACTIVE_BACKEND) is set but storage.backends (STORAGE_BACKENDS) is empty")
+	}
+	if c.Storage.ColdAfterDays <= 0 {
+		invalid("storage.cold_after_days (COLD_AFTER_DAYS) must be a positive number of days, got %d", c.Storage.ColdAfterDays)
+	}
+
+	if c.Keys.MasterKey == "" && c.Keys.MasterKeyFile == "" {
+		invalid("keys.master_key (MASTER_KEY) or keys.master_key_file (MASTER_KEY_FILE) is required to encrypt blobs at rest")
+	}
+	if _, err := dedup.ParseScope(c.Dedup.Scope); err != nil {
+		invalid("dedup.scope (DEDUP_SCOPE): %v", err)
+	}
+
+	if c.SMTP.Port <= 0 || c.SMTP.Port > 65535 {
+		invalid("smtp.port (SMTP_PORT) must be between 1 and 65535, got %d", c.SMTP.Port)
+	}
+	if c.SMTP.Host != "" && (c.SMTP.User == "" || c.SMTP.Pass == "") {
+		invalid("smtp.user (SMTP_USER) and smtp.pass (SMTP_PASS) are required when smtp.host (SMTP_HOST) is set")
+	}
+	if c.RateLimit.RequestsPerSecond <= 0 {
+		invalid("rate_limit.requests_per_second (RATE_LIMIT_RPS) must be positive, got %g", c.RateLimit.RequestsPerSecond)
+	}
+	if c.RateLimit.Burst < 1 {
+		invalid("rate_limit.burst (RATE_LIMIT_BURST) must be at least 1, got %d", c.RateLimit.Burst)
+	}
+	if c.OTP.Lifetime < time.Minute {
+		invalid("otp.lifetime (OTP_LIFETIME) must be at least 1m, got %s", c.OTP.Lifetime)
+	}
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		invalid("tracing.exporter (TRACING_EXPORTER) must be none, otlp or stdout, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.Endpoint != "" && !isHTTPURL(c.Tracing.Endpoint) {
+		invalid("tracing.endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) %q is not an http(s) URL", c.Tracing.Endpoint)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio (TRACING_SAMPLE_RATIO) must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		invalid("log.format (LOG_FORMAT) must be text or json, got %q", c.Log.Format)
+	}
+	return errors.Join(errs...)
+}
+
+// SplitList splits a comma-separated setting, dropping blanks.
+func SplitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func isIPOrCIDR(raw string) bool {
+	if _, err := netip.ParseAddr(raw); err == nil {
+		return true
+	}
+	_, err := netip.ParsePrefix(raw)
+	return err == nil
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable holding the config file path when -config is not given.
+const FileEnv = "VAULT_CONFIG"
+
+// field is one setting, found by walking the Config struct.
+type field struct {
+	name  string // Dotted YAML path, used in error messages
+	env   string
+	flag  string
+	def   string
+	usage string
+	value reflect.Value
+}
+
+// Load builds the configuration and validates it. Settings are taken from, in increasing order
+// of precedence: the defaults, the YAML file named by -config (or VAULT_CONFIG), the environment,
+// and the flags in args. Parsing stops at the first non-flag argument; the rest are returned.
+func Load(args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	fields := fieldsOf(reflect.ValueOf(cfg).Elem(), "")
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+		if err := set(f.value, f.def); err != nil {
+			return nil, nil, fmt.Errorf("default for %s: %w", f.name, err)
+		}
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(FileEnv), "YAML configuration file")
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		name := f.flag
+		record := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, f.usage, record)
+		} else {
+			fs.Func(name, f.usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *path != "" {
+		if err := loadFile(cfg, *path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields {
+		if raw, ok := os.LookupEnv(f.env); ok && raw != "" {
+			if err := set(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+		if raw, ok := flagValues[f.flag]; ok {
+			if err := set(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.flag, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile overlays the YAML file at path on cfg, rejecting unknown keys so typos are caught.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// fieldsOf lists the settings in the struct v, recursing into nested sections.
+func fieldsOf(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := prefix + sf.Tag.Get("yaml")
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, fieldsOf(v.Field(i), name+".")...)
+			continue
+		}
+		fields = append(fields, field{
+			name:  name,
+			env:   sf.Tag.Get("env"),
+			flag:  sf.Tag.Get("flag"),
+			def:   sf.Tag.Get("default"),
+			usage: sf.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+// set parses raw into v according to its type.
+func set(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
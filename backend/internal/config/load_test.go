@@ -0,0 +1,218 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isolate clears every variable Load reads, then sets the ones a valid configuration needs.
+func isolate(t *testing.T) {
+	t.Helper()
+	t.Setenv(FileEnv, "")
+	for _, f := range fieldsOf(reflect.ValueOf(&Config{}).Elem(), "") {
+		t.Setenv(f.env, "")
+	}
+	t.Setenv("SUPABASE_REST_URL", "https://project.supabase.co/rest/v1")
+	t.Setenv("SUPABASE_STORAGE_URL", "https://project.supabase.co/storage/v1")
+	t.Setenv("SUPABASE_KEY", "service-key-value")
+	t.Setenv("MASTER_KEY", "bWFzdGVyLWtleS12YWx1ZQ==")
+}
+
+func writeYAML(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	isolate(t)
+	path := writeYAML(t, "server:\n  addr: \":9000\"\n  shutdown_timeout: 45s\nrate_limit:\n  burst: 8\n")
+
+	cases := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		addr  string
+		burst int
+	}{
+		{"defaults", nil, nil, ":8080", 4},
+		{"file over defaults", nil, []string{"-config", path}, ":9000", 8},
+		{"file from VAULT_CONFIG", map[string]string{FileEnv: path}, nil, ":9000", 8},
+		{"env over file", map[string]string{"SERVER_ADDR": ":9100"}, []string{"-config", path}, ":9100", 8},
+		{"flag over env", map[string]string{"SERVER_ADDR": ":9100", "RATE_LIMIT_BURST": "16"}, []string{"-config", path, "-addr", ":9200"}, ":9200", 16},
+		{"empty env is unset", map[string]string{"SERVER_ADDR": ""}, []string{"-config", path}, ":9000", 8},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			cfg, _, err := Load(tc.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Server.Addr != tc.addr || cfg.RateLimit.Burst != tc.burst {
+				t.Fatalf("addr %q burst %d, want %q and %d", cfg.Server.Addr, cfg.RateLimit.Burst, tc.addr, tc.burst)
+			}
+		})
+	}
+}
+
+func TestLoadTypesAndArgs(t *testing.T) {
+	isolate(t)
+	t.Setenv("OTP_LIFETIME", "30m")
+	t.Setenv("RATE_LIMIT_RPS", "2.5")
+	cfg, rest, err := Load([]string{"-auto-migrate", "-database-url", "postgres://u:p@db/vault", "keys", "status"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.OTP.Lifetime != 30*time.Minute || cfg.RateLimit.RequestsPerSecond != 2.5 || !cfg.Database.AutoMigrate {
+		t.Fatalf("settings not parsed: %+v %+v %+v", cfg.OTP, cfg.RateLimit, cfg.Database)
+	}
+	if strings.Join(rest, " ") != "keys status" {
+		t.Fatalf("remaining args %q", rest)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		yaml string
+		args []string
+		want string
+	}{
+		{"bad duration in env", map[string]string{"SHUTDOWN_TIMEOUT": "soon"}, "", nil, "SHUTDOWN_TIMEOUT"},
+		{"bad integer in env", map[string]string{"RATE_LIMIT_BURST": "lots"}, "", nil, "RATE_LIMIT_BURST"},
+		{"bad boolean in env", map[string]string{"AUTO_MIGRATE": "maybe"}, "", nil, "AUTO_MIGRATE"},
+		{"bad number in flag", nil, "", []string{"-rate-limit-rps", "fast"}, "-rate-limit-rps"},
+		{"unknown flag", nil, "", []string{"-no-such-flag"}, "no-such-flag"},
+		{"unknown YAML key", nil, "server:\n  adress: \":9000\"\n", nil, "adress"},
+		{"unknown YAML section", nil, "severs: {}\n", nil, "severs"},
+		{"invalid after merging", map[string]string{"LOG_FORMAT": "xml"}, "", nil, "log.format"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			isolate(t)
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			args := tc.args
+			if tc.yaml != "" {
+				args = append([]string{"-config", writeYAML(t, tc.yaml)}, args...)
+			}
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Load = %v, want an error naming %s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	isolate(t)
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_USER", "vault@example.com")
+	t.Setenv("SMTP_PASS", "smtp-password-value")
+	t.Setenv("DATABASE_URL", "postgres://vault:db-password-value@db/vault")
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.SMTP.Pass.Value() != "smtp-password-value" {
+		t.Fatal("Value does not return the secret")
+	}
+
+	asJSON, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := json.Marshal(cfg.Summary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, rendered := range map[string]string{"String": cfg.String(), "MarshalJSON": string(asJSON), "Summary": string(summary)} {
+		for _, secret := range []string{"service-key-value", "bWFzdGVyLWtleS12YWx1ZQ==", "smtp-password-value", "db-password-value"} {
+			if strings.Contains(rendered, secret) {
+				t.Errorf("%s reveals %s", name, secret)
+			}
+		}
+		if !strings.Contains(rendered, "[redacted]") || !strings.Contains(rendered, "vault@example.com") {
+			t.Errorf("%s does not show the redacted configuration: %s", name, rendered)
+		}
+	}
+	if Secret("").String() != "" {
+		t.Error("an unset secret renders as redacted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	isolate(t)
+	valid, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate of a valid configuration: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"empty addr", func(c *Config) { c.Server.Addr = "" }, "server.addr"},
+		{"cert without key", func(c *Config) { c.Server.TLSCertFile = "cert.pem" }, "must be set together"},
+		{"client CA without TLS", func(c *Config) { c.Server.ClientCAFile = "ca.pem" }, "server.client_ca_file"},
+		{"bad trusted proxy", func(c *Config) { c.Server.TrustedProxies = "10.0.0.0/8, proxy.local" }, `"proxy.local"`},
+		{"zero shutdown timeout", func(c *Config) { c.Server.ShutdownTimeout = 0 }, "server.shutdown_timeout"},
+		{"missing REST URL", func(c *Config) { c.Supabase.RestURL = "" }, "supabase.rest_url (SUPABASE_REST_URL) is required"},
+		{"REST URL not http", func(c *Config) { c.Supabase.RestURL = "ftp://x" }, "is not an http(s) URL"},
+		{"missing Supabase key", func(c *Config) { c.Supabase.Key = "" }, "supabase.key"},
+		{"database URL not postgres", func(c *Config) { c.Database.URL = "mysql://db" }, "database.url"},
+		{"auto-migrate without database", func(c *Config) { c.Database.AutoMigrate = true }, "database.auto_migrate"},
+		{"missing storage URL", func(c *Config) { c.Supabase.StorageURL = "" }, "supabase.storage_url"},
+		{"storage URL not http", func(c *Config) { c.Supabase.StorageURL = "bucket" }, "supabase.storage_url"},
+		{"empty bucket", func(c *Config) { c.Supabase.Bucket = "" }, "supabase.bucket"},
+		{"active backend without backends", func(c *Config) { c.Storage.ActiveBackend = "s3" }, "storage.active_backend"},
+		{"zero cold days", func(c *Config) { c.Storage.ColdAfterDays = 0 }, "storage.cold_after_days"},
+		{"no master key", func(c *Config) { c.Keys.MasterKey = "" }, "keys.master_key"},
+		{"bad dedup scope", func(c *Config) { c.Dedup.Scope = "team" }, "dedup.scope"},
+		{"bad SMTP port", func(c *Config) { c.SMTP.Port = 70000 }, "smtp.port"},
+		{"SMTP host without credentials", func(c *Config) { c.SMTP.Host = "smtp.example.com" }, "smtp.user"},
+		{"zero rate", func(c *Config) { c.RateLimit.RequestsPerSecond = 0 }, "rate_limit.requests_per_second"},
+		{"zero burst", func(c *Config) { c.RateLimit.Burst = 0 }, "rate_limit.burst"},
+		{"short OTP lifetime", func(c *Config) { c.OTP.Lifetime = time.Second }, "otp.lifetime"},
+		{"bad exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, "tracing.exporter"},
+		{"bad OTLP endpoint", func(c *Config) { c.Tracing.Endpoint = "collector:4318" }, "tracing.endpoint"},
+		{"bad sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio"},
+		{"bad log level", func(c *Config) { c.Log.Level = "verbose" }, "log.level"},
+		{"bad log format", func(c *Config) { c.Log.Format = "xml" }, "log.format"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := *valid
+			tc.change(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Validate = %v, want an error naming %s", err, tc.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	cfg := *valid
+	cfg.Server.Addr = ""
+	cfg.Log.Format = "xml"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.addr") || !strings.Contains(err.Error(), "log.format") {
+		t.Fatalf("Validate = %v, want both problems", err)
+	}
+}
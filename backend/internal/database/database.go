@@ -1,8 +1,17 @@
 package database
 
 import (
-	"log"
-	"os"
+	"context"
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/keys"
+	"file-vault/backend/internal/metrics"
+	"file-vault/backend/internal/replication"
+	"file-vault/backend/internal/storage"
+	"file-vault/backend/internal/tracing"
+	"fmt"
+	"log/slog"
+	"net/http"
 
 	"github.com/supabase-community/postgrest-go"
 	storage_go "github.com/supabase-community/storage-go"
@@ -12,57 +21,190 @@ import (
 type AppClients struct {
 	Postgrest *postgrest.Client
 	Storage   *storage_go.Client
+	Keys      keys.Provider     // Wraps the per-content data keys blobs are encrypted with
+	Dedup     dedup.Scope       // Which users' uploads may share stored content
+	Blobs     storage.BlobStore // The bucket (or BLOB_STORE) blobs are stored in, replicated when REPLICA_STORES is set
+	Tiering   *storage.Tiering  // Cold storage class, nil when COLD_STORE is unset
+	Backends  *storage.Backends // Named hot stores from STORAGE_BACKENDS, nil when unset
+
+	restURL     string
+	restHeaders map[string]string
+}
+
+// ContentStore returns a ContentStore over the configured blob stores and master keys.
+func (c *AppClients) ContentStore() *storage.ContentStore {
+	content := storage.NewContentStore(c.Blobs, c.Keys)
+	if c.Tiering != nil {
+		content.SetColdStore(c.Tiering.Cold)
+	}
+	if c.Backends != nil {
+		content.SetBackends(c.Backends)
+	}
+	return content
+}
+
+// WithContext returns a copy of c whose PostgREST client attaches ctx to every query, so the
+// queries show up as spans of the request ctx belongs to. Cancelling ctx does not abort them:
+// a handler's writes run to completion even if the client hangs up.
+func (c *AppClients) WithContext(ctx context.Context) *AppClients {
+	if c.restURL == "" {
+		return c
+	}
+	bound := *c
+	bound.Postgrest = newPostgrestClient(context.WithoutCancel(ctx), c.restURL, c.restHeaders)
+	return &bound
+}
+
+// newPostgrestClient returns a PostgREST client whose requests are timed and, when ctx is part
+// of a trace, traced.
+func newPostgrestClient(ctx context.Context, restURL string, headers map[string]string) *postgrest.Client {
+	client := postgrest.NewClient(restURL, "", headers)
+	if client.ClientError == nil {
+		client.Transport.Parent = contextTransport{
+			ctx:    ctx,
+			parent: tracing.PostgrestTransport(metrics.PostgrestTransport(nil)),
+		}
+	}
+	return client
 }
 
-// InitDB initializes and returns a custom struct containing PostgREST and Storage clients
-func InitDB() (*AppClients, error) {
-	supabaseRestURL := os.Getenv("SUPABASE_REST_URL")
-	supabaseStorageURL := os.Getenv("SUPABASE_STORAGE_URL")
-	supabaseKey := os.Getenv("SUPABASE_KEY")
+// contextTransport sends requests with ctx, since postgrest-go builds them without one.
+type contextTransport struct {
+	ctx    context.Context
+	parent http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.parent.RoundTrip(req.WithContext(t.ctx))
+}
 
-	log.Printf("DEBUG: Supabase REST URL: %s", supabaseRestURL)
-	log.Printf("DEBUG: Supabase Storage URL: %s", supabaseStorageURL)
-	log.Printf("DEBUG: Supabase Key (first 10 chars): %s", supabaseKey[:min(10, len(supabaseKey))])
+// Ping checks that the database answers queries with the configured key.
+func (c *AppClients) Ping() error {
+	if _, _, err := c.Postgrest.From("users").Select("user_id", "", false).Limit(1, "").Execute(); err != nil {
+		return fmt.Errorf("query database: %w", err)
+	}
+	return nil
+}
 
-	if supabaseRestURL == "" || supabaseStorageURL == "" || supabaseKey == "" {
-		log.Fatal("SUPABASE_REST_URL, SUPABASE_STORAGE_URL, or SUPABASE_KEY is not set in .env or is empty")
+// Close waits for queued replica copies to finish. It must be called after the last upload.
+func (c *AppClients) Close() {
+	if replicated, ok := c.Blobs.(*replication.Store); ok {
+		replicated.Close()
 	}
+}
+
+// InitDB initializes and returns a custom struct containing PostgREST and Storage clients.
+// cfg must have passed validation.
+func InitDB(cfg *config.Config) (*AppClients, error) {
+	supabaseRestURL := cfg.Supabase.RestURL
+	supabaseStorageURL := cfg.Supabase.StorageURL
+	supabaseKey := cfg.Supabase.Key.Value()
+	bucket := cfg.Supabase.Bucket
+
+	slog.Debug("Connecting to Supabase", "rest_url", supabaseRestURL, "storage_url", supabaseStorageURL)
 
 	// Initialize PostgREST client
-	postgrestClient := postgrest.NewClient(supabaseRestURL, "", map[string]string{
+	restHeaders := map[string]string{
 		"apikey":        supabaseKey,
 		"Authorization": "Bearer " + supabaseKey,
-	})
+	}
+	postgrestClient := newPostgrestClient(context.Background(), supabaseRestURL, restHeaders)
 
 	if postgrestClient.ClientError != nil {
 		return nil, postgrestClient.ClientError
 	}
 
 	// Initialize Storage client
-	log.Printf("DEBUG: Initializing Storage client with URL: %s, Key prefix: %s", supabaseStorageURL, supabaseKey[:min(10, len(supabaseKey))])
 	storageClient := storage_go.NewClient(supabaseStorageURL, supabaseKey, map[string]string{
 		"Authorization": "Bearer " + supabaseKey,
 	})
 
-	// You can optionally test connections here if needed
-	// For example, try to list buckets with storageClient
-	_, err := storageClient.GetBucket("balkanid-file-storage")
-	if err != nil {
-		log.Printf("DEBUG: Bucket 'balkanid-file-storage' not found, attempting to create it...")
-		_, err = storageClient.CreateBucket("balkanid-file-storage", storage_go.BucketOptions{
-			Public: false,
-		})
+	// BLOB_STORE replaces the Supabase bucket, e.g. with erasure-coded local disks on-prem
+	var blobs storage.BlobStore
+	if blobStoreURL := cfg.Storage.BlobStore; blobStoreURL != "" {
+		store, err := storage.OpenURL(blobStoreURL)
 		if err != nil {
-			log.Printf("FATAL: Could not create bucket 'balkanid-file-storage'")
 			return nil, err
 		}
-		log.Printf("DEBUG: Bucket 'balkanid-file-storage' created successfully.")
+		blobs = store
+		slog.Info("Storing blobs in blob store", "url", blobStoreURL)
 	} else {
-		log.Printf("DEBUG: Bucket 'balkanid-file-storage' already exists.")
+		// You can optionally test connections here if needed
+		// For example, try to list buckets with storageClient
+		_, err := storageClient.GetBucket(bucket)
+		if err != nil {
+			slog.Info("Bucket not found, creating it", "bucket", bucket)
+			_, err = storageClient.CreateBucket(bucket, storage_go.BucketOptions{
+				Public: false,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("create bucket %s: %w", bucket, err)
+			}
+		} else {
+			slog.Debug("Bucket exists", "bucket", bucket)
+		}
+		blobs = storage.NewSupabaseStore(storageClient, bucket)
+	}
+	blobs = storage.Instrument("default", blobs)
+
+	// Load the master keys used for envelope encryption of blobs at rest
+	keyProvider, err := keys.Load(cfg.Keys.MasterKeyFile, cfg.Keys.MasterKey.Value(), cfg.Keys.MasterKeyID)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Envelope encryption enabled", "master_key_id", keyProvider.CurrentKeyID())
+
+	dedupScope, err := dedup.ParseScope(cfg.Dedup.Scope)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Deduplication scope", "scope", dedupScope)
+
+	// Secondary copies of every blob, read from when the primary store fails
+	replicas, err := replication.ParseReplicas(cfg.Storage.ReplicaStores)
+	if err != nil {
+		return nil, err
+	}
+	for i := range replicas {
+		replicas[i].Store = storage.Instrument("replica:"+replicas[i].Name, replicas[i].Store)
+	}
+	if len(replicas) > 0 {
+		blobs = replication.NewStore(postgrestClient, blobs, replicas)
+		slog.Info("Replicating blobs", "replicas", len(replicas))
+	}
+
+	tiering, err := storage.OpenTiering(cfg.Storage.ColdStore, cfg.Storage.ColdAfterDays, cfg.Storage.RehydrateOnRead)
+	if err != nil {
+		return nil, err
+	}
+	if tiering != nil {
+		tiering.Cold = storage.Instrument("cold", tiering.Cold)
+		slog.Info("Cold storage enabled", "cold_after", tiering.ColdAfter.String())
+	}
+
+	backends, err := storage.OpenBackends(cfg.Storage.Backends, cfg.Storage.ActiveBackend)
+	if err != nil {
+		return nil, err
+	}
+	if backends != nil {
+		for name, store := range backends.Stores {
+			backends.Stores[name] = storage.Instrument("backend:"+name, store)
+		}
+	}
+	if backends != nil && backends.Active != "" {
+		slog.Info("Writing new blobs to storage backend", "backend", backends.Active)
 	}
 
 	return &AppClients{
 		Postgrest: postgrestClient,
 		Storage:   storageClient,
+		Keys:      keyProvider,
+		Dedup:     dedupScope,
+		Blobs:     blobs,
+		Tiering:   tiering,
+		Backends:  backends,
+
+		restURL:     supabaseRestURL,
+		restHeaders: restHeaders,
 	}, nil
 }
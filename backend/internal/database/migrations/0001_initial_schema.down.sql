@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS public.api_usage;
+DROP TABLE IF EXISTS public.download_logs;
+DROP TABLE IF EXISTS public.shares;
+DROP TABLE IF EXISTS public.file_tags;
+DROP TABLE IF EXISTS public.tags;
+DROP TABLE IF EXISTS public.file_folder_mapping;
+DROP TABLE IF EXISTS public.folders;
+DROP TABLE IF EXISTS public.files;
+DROP TABLE IF EXISTS public.file_contents;
+DROP TABLE IF EXISTS public.users;
@@ -0,0 +1,115 @@
+-- Tables as first deployed. IF NOT EXISTS lets databases created by hand from the README
+-- adopt the migrations: later versions add what they are missing.
+
+CREATE TABLE IF NOT EXISTS public.users (
+  user_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  username character varying NOT NULL UNIQUE,
+  email character varying NOT NULL UNIQUE,
+  password_hash text NOT NULL,
+  is_admin boolean DEFAULT false,
+  storage_quota bigint DEFAULT 10485760,
+  created_at timestamp without time zone DEFAULT now(),
+  first_name character varying,
+  last_name character varying,
+  date_of_birth date,
+  phone_number character varying,
+  last_login timestamp without time zone,
+  email_verified boolean DEFAULT false,
+  phone_verified boolean DEFAULT false,
+  status character varying DEFAULT 'active'::character varying,
+  otp character varying,
+  otp_expires_at timestamp without time zone,
+  rate_limit integer DEFAULT 2,
+  CONSTRAINT users_pkey PRIMARY KEY (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.file_contents (
+  content_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  hash_sha256 character varying NOT NULL,
+  size bigint NOT NULL,
+  mime_type character varying,
+  storage_path text NOT NULL,
+  reference_count integer NOT NULL DEFAULT 0,
+  created_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT file_contents_pkey PRIMARY KEY (content_id),
+  CONSTRAINT file_contents_hash_sha256_key UNIQUE (hash_sha256)
+);
+
+CREATE TABLE IF NOT EXISTS public.files (
+  file_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  owner_id uuid,
+  content_id uuid,
+  filename character varying NOT NULL,
+  is_deleted boolean DEFAULT false,
+  created_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT files_pkey PRIMARY KEY (file_id),
+  CONSTRAINT files_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES public.users(user_id),
+  CONSTRAINT files_content_id_fkey FOREIGN KEY (content_id) REFERENCES public.file_contents(content_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.folders (
+  folder_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  owner_id uuid,
+  parent_id uuid,
+  name character varying NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT folders_pkey PRIMARY KEY (folder_id),
+  CONSTRAINT folders_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES public.users(user_id),
+  CONSTRAINT folders_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES public.folders(folder_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.file_folder_mapping (
+  file_id uuid NOT NULL,
+  folder_id uuid NOT NULL,
+  CONSTRAINT file_folder_mapping_pkey PRIMARY KEY (folder_id, file_id),
+  CONSTRAINT file_folder_mapping_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(file_id),
+  CONSTRAINT file_folder_mapping_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES public.folders(folder_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.tags (
+  tag_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  name character varying NOT NULL UNIQUE,
+  CONSTRAINT tags_pkey PRIMARY KEY (tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.file_tags (
+  file_id uuid NOT NULL,
+  tag_id uuid NOT NULL,
+  CONSTRAINT file_tags_pkey PRIMARY KEY (file_id, tag_id),
+  CONSTRAINT file_tags_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(file_id),
+  CONSTRAINT file_tags_tag_id_fkey FOREIGN KEY (tag_id) REFERENCES public.tags(tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.shares (
+  share_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  file_id uuid,
+  folder_id uuid,
+  is_public boolean DEFAULT false,
+  shared_with uuid,
+  created_at timestamp without time zone DEFAULT now(),
+  share_token text,
+  download_count integer NOT NULL DEFAULT 0,
+  CONSTRAINT shares_pkey PRIMARY KEY (share_id),
+  CONSTRAINT shares_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(file_id),
+  CONSTRAINT shares_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES public.folders(folder_id),
+  CONSTRAINT shares_shared_with_fkey FOREIGN KEY (shared_with) REFERENCES public.users(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.download_logs (
+  log_id bigserial NOT NULL,
+  file_id uuid,
+  downloader_id uuid,
+  downloaded_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT download_logs_pkey PRIMARY KEY (log_id),
+  CONSTRAINT download_logs_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(file_id),
+  CONSTRAINT download_logs_downloader_id_fkey FOREIGN KEY (downloader_id) REFERENCES public.users(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.api_usage (
+  usage_id bigserial NOT NULL,
+  user_id uuid,
+  endpoint character varying NOT NULL,
+  called_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT api_usage_pkey PRIMARY KEY (usage_id),
+  CONSTRAINT api_usage_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE public.file_contents
+  DROP COLUMN IF EXISTS wrapped_key,
+  DROP COLUMN IF EXISTS key_id,
+  DROP COLUMN IF EXISTS encryption,
+  DROP COLUMN IF EXISTS stored_size,
+  DROP COLUMN IF EXISTS codec;
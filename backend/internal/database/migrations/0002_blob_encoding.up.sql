@@ -0,0 +1,7 @@
+-- Compression and envelope encryption of blobs at rest.
+ALTER TABLE public.file_contents
+  ADD COLUMN IF NOT EXISTS codec character varying NOT NULL DEFAULT 'none',
+  ADD COLUMN IF NOT EXISTS stored_size bigint,
+  ADD COLUMN IF NOT EXISTS encryption character varying,
+  ADD COLUMN IF NOT EXISTS key_id character varying,
+  ADD COLUMN IF NOT EXISTS wrapped_key text;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.file_keys;
+ALTER TABLE public.file_contents DROP COLUMN IF EXISTS client_encryption;
+ALTER TABLE public.users DROP COLUMN IF EXISTS public_key;
@@ -0,0 +1,13 @@
+-- Client-side encrypted content and the per-recipient copies of its file keys.
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS public_key text;
+ALTER TABLE public.file_contents ADD COLUMN IF NOT EXISTS client_encryption character varying;
+
+CREATE TABLE IF NOT EXISTS public.file_keys (
+  file_id uuid NOT NULL,
+  recipient_id uuid NOT NULL,
+  wrapped_key text NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT file_keys_pkey PRIMARY KEY (file_id, recipient_id),
+  CONSTRAINT file_keys_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(file_id) ON DELETE CASCADE,
+  CONSTRAINT file_keys_recipient_id_fkey FOREIGN KEY (recipient_id) REFERENCES public.users(user_id)
+);
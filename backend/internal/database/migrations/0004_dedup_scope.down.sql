@@ -0,0 +1,5 @@
+-- Fails if the same content is stored in more than one scope; merge those rows first.
+ALTER TABLE public.file_contents DROP CONSTRAINT IF EXISTS file_contents_hash_scope_key;
+ALTER TABLE public.file_contents ADD CONSTRAINT file_contents_hash_sha256_key UNIQUE (hash_sha256);
+ALTER TABLE public.file_contents DROP COLUMN IF EXISTS dedup_scope;
+ALTER TABLE public.users DROP COLUMN IF EXISTS organization_id;
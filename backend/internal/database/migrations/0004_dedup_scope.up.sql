@@ -0,0 +1,7 @@
+-- Content is shared only within a dedup scope, so a hash is unique per scope rather than globally.
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS organization_id uuid;
+ALTER TABLE public.file_contents ADD COLUMN IF NOT EXISTS dedup_scope character varying NOT NULL DEFAULT 'global';
+
+ALTER TABLE public.file_contents DROP CONSTRAINT IF EXISTS file_contents_hash_sha256_key;
+ALTER TABLE public.file_contents DROP CONSTRAINT IF EXISTS file_contents_hash_scope_key;
+ALTER TABLE public.file_contents ADD CONSTRAINT file_contents_hash_scope_key UNIQUE (hash_sha256, dedup_scope);
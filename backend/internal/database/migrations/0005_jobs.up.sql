@@ -0,0 +1,17 @@
+-- Resumable background jobs over file_contents.
+CREATE TABLE IF NOT EXISTS public.jobs (
+  job_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  kind character varying NOT NULL,
+  status character varying NOT NULL DEFAULT 'running',
+  params jsonb,
+  cursor text,
+  total bigint NOT NULL DEFAULT 0,
+  processed bigint NOT NULL DEFAULT 0,
+  failed bigint NOT NULL DEFAULT 0,
+  last_error text,
+  lease_owner text,
+  heartbeat_at timestamp without time zone,
+  created_at timestamp without time zone DEFAULT now(),
+  finished_at timestamp without time zone,
+  CONSTRAINT jobs_pkey PRIMARY KEY (job_id)
+);
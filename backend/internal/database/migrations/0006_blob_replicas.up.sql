@@ -0,0 +1,11 @@
+-- State of each blob's copy on the secondary stores in REPLICA_STORES.
+CREATE TABLE IF NOT EXISTS public.blob_replicas (
+  content_id uuid NOT NULL,
+  replica character varying NOT NULL,
+  storage_path text NOT NULL,
+  status character varying NOT NULL,
+  last_error text,
+  updated_at timestamp without time zone DEFAULT now(),
+  CONSTRAINT blob_replicas_pkey PRIMARY KEY (content_id, replica),
+  CONSTRAINT blob_replicas_content_id_fkey FOREIGN KEY (content_id) REFERENCES public.file_contents(content_id) ON DELETE CASCADE
+);
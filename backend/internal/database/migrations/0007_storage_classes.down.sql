@@ -0,0 +1,3 @@
+ALTER TABLE public.file_contents
+  DROP COLUMN IF EXISTS last_accessed_at,
+  DROP COLUMN IF EXISTS storage_class;
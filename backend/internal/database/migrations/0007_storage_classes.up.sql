@@ -0,0 +1,4 @@
+-- Hot and cold storage classes, with the last download deciding when content goes cold.
+ALTER TABLE public.file_contents
+  ADD COLUMN IF NOT EXISTS storage_class character varying NOT NULL DEFAULT 'hot',
+  ADD COLUMN IF NOT EXISTS last_accessed_at timestamp without time zone;
@@ -0,0 +1 @@
+ALTER TABLE public.file_contents DROP COLUMN IF EXISTS storage_backend;
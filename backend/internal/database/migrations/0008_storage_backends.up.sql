@@ -0,0 +1,2 @@
+-- The named backend from STORAGE_BACKENDS holding each hot blob; '' is the default store.
+ALTER TABLE public.file_contents ADD COLUMN IF NOT EXISTS storage_backend character varying NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS public.jobs_kind_status_idx;
+DROP INDEX IF EXISTS public.file_keys_recipient_id_idx;
+DROP INDEX IF EXISTS public.file_contents_storage_class_accessed_idx;
+DROP INDEX IF EXISTS public.file_contents_storage_path_idx;
+DROP INDEX IF EXISTS public.shares_shared_with_idx;
+DROP INDEX IF EXISTS public.shares_file_id_idx;
+DROP INDEX IF EXISTS public.shares_share_token_key;
+DROP INDEX IF EXISTS public.files_content_id_idx;
+DROP INDEX IF EXISTS public.files_owner_id_is_deleted_idx;
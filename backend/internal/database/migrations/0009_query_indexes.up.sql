@@ -0,0 +1,22 @@
+-- Indexes behind the queries the handlers and jobs run. file_contents(hash_sha256) lookups
+-- use file_contents_hash_scope_key, whose index leads with the hash.
+
+-- Listing, searching, quota and stats over a user's live files
+CREATE INDEX IF NOT EXISTS files_owner_id_is_deleted_idx ON public.files (owner_id, is_deleted);
+-- Reference checks when content is deleted
+CREATE INDEX IF NOT EXISTS files_content_id_idx ON public.files (content_id);
+
+-- Public share links are looked up by token, and each token must name one share
+CREATE UNIQUE INDEX IF NOT EXISTS shares_share_token_key ON public.shares (share_token) WHERE share_token IS NOT NULL;
+CREATE INDEX IF NOT EXISTS shares_file_id_idx ON public.shares (file_id);
+CREATE INDEX IF NOT EXISTS shares_shared_with_idx ON public.shares (shared_with);
+
+-- Blob maintenance finds rows by blob key, and tiering by class and last access
+CREATE INDEX IF NOT EXISTS file_contents_storage_path_idx ON public.file_contents (storage_path);
+CREATE INDEX IF NOT EXISTS file_contents_storage_class_accessed_idx ON public.file_contents (storage_class, last_accessed_at);
+
+-- Files shared with a user through end-to-end encryption
+CREATE INDEX IF NOT EXISTS file_keys_recipient_id_idx ON public.file_keys (recipient_id);
+
+-- Running and stale jobs of a kind
+CREATE INDEX IF NOT EXISTS jobs_kind_status_idx ON public.jobs (kind, status);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.file_folder_mapping_file_id_idx;
+DROP INDEX IF EXISTS public.folders_owner_id_parent_id_idx;
+DROP TABLE IF EXISTS public.personal_tokens;
@@ -0,0 +1,18 @@
+-- Personal access tokens, which WebDAV clients present through HTTP Basic authentication.
+-- Only the SHA-256 of a token is stored; the token itself is shown once when it is created.
+CREATE TABLE IF NOT EXISTS public.personal_tokens (
+  token_id uuid NOT NULL DEFAULT gen_random_uuid(),
+  user_id uuid NOT NULL,
+  name character varying NOT NULL,
+  token_hash character varying NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  last_used_at timestamp without time zone,
+  CONSTRAINT personal_tokens_pkey PRIMARY KEY (token_id),
+  CONSTRAINT personal_tokens_token_hash_key UNIQUE (token_hash),
+  CONSTRAINT personal_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS personal_tokens_user_id_idx ON public.personal_tokens (user_id);
+
+-- WebDAV browses a user's folder tree and the files in each folder
+CREATE INDEX IF NOT EXISTS folders_owner_id_parent_id_idx ON public.folders (owner_id, parent_id);
+CREATE INDEX IF NOT EXISTS file_folder_mapping_file_id_idx ON public.file_folder_mapping (file_id);
@@ -0,0 +1,3 @@
+ALTER TABLE public.file_contents
+  DROP COLUMN IF EXISTS hash_md5;
+DROP TABLE IF EXISTS public.s3_access_keys;
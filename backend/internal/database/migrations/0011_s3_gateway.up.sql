@@ -0,0 +1,21 @@
+-- Access keys of the S3-compatible gateway. S3 clients sign every request with the secret
+-- rather than sending it, so the server must be able to read it back: it is stored wrapped
+-- under the master key version key_id, like the data keys of file contents.
+CREATE TABLE IF NOT EXISTS public.s3_access_keys (
+  access_key_id character varying NOT NULL,
+  user_id uuid NOT NULL,
+  name character varying NOT NULL,
+  secret_key character varying NOT NULL,
+  key_id character varying NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  last_used_at timestamp without time zone,
+  CONSTRAINT s3_access_keys_pkey PRIMARY KEY (access_key_id),
+  CONSTRAINT s3_access_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS s3_access_keys_user_id_idx ON public.s3_access_keys (user_id);
+CREATE INDEX IF NOT EXISTS s3_access_keys_key_id_idx ON public.s3_access_keys (key_id);
+
+-- S3 clients compare ETags with the MD5 of what they uploaded. Content stored before this
+-- has none until it is uploaded again.
+ALTER TABLE public.file_contents
+  ADD COLUMN IF NOT EXISTS hash_md5 character varying;
@@ -0,0 +1,268 @@
+// Package migrations holds the versioned database schema and applies it over a direct
+// PostgreSQL connection (DATABASE_URL), recording applied versions in schema_migrations.
+//
+// Each version is a pair of files, NNNN_name.up.sql and NNNN_name.down.sql. A version runs in
+// a single transaction together with its schema_migrations row, so it applies fully or not at all.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockID is the advisory lock key held while migrating, so servers starting together take turns.
+const lockID = 0x76617566 // "vauf"
+
+// Migration is one schema version.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Status is a migration and when it was applied; AppliedAt is nil while it is pending.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// All returns the embedded migrations in version order.
+func All() ([]Migration, error) {
+	return load(files)
+}
+
+// load reads the migrations at the root of fsys.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.up.sql or NNNN_name.down.sql", name)
+		}
+		prefix, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s does not start with a positive version number", name)
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	all := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+// Latest returns the highest embedded version.
+func Latest() (int, error) {
+	all, err := All()
+	if err != nil || len(all) == 0 {
+		return 0, err
+	}
+	return all[len(all)-1].Version, nil
+}
+
+// Migrator applies migrations over one database connection.
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+}
+
+// Connect opens a connection to the database at url and makes sure schema_migrations exists.
+func Connect(ctx context.Context, url string) (*Migrator, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+  version bigint NOT NULL,
+  name text NOT NULL,
+  applied_at timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+)`)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return &Migrator{conn: conn, migrations: migrations}, nil
+}
+
+// Close closes the connection.
+func (m *Migrator) Close(ctx context.Context) error {
+	return m.conn.Close(ctx)
+}
+
+// Status lists every embedded migration with the time it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if at, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration up to and including target, or all of them when target
+// is 0, and returns those it applied.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(applied map[int]time.Time) error {
+		for _, migration := range m.migrations {
+			if target > 0 && migration.Version > target {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.apply(ctx, migration, migration.up,
+				`INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the applied migrations above target, newest first, and returns those it
+// rolled back. Down(ctx, 0) empties the schema.
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(applied map[int]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= target {
+				break
+			}
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			err := m.apply(ctx, migration, migration.down,
+				`DELETE FROM public.schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// locked runs fn holding the migration lock, with the versions applied so far.
+func (m *Migrator) locked(ctx context.Context, fn func(applied map[int]time.Time) error) error {
+	if _, err := m.conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("lock schema_migrations: %w", err)
+	}
+	defer m.conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(applied); err != nil {
+		return err
+	}
+	// Supabase's PostgREST caches the schema; have it pick up new tables and columns
+	_, _ = m.conn.Exec(ctx, "NOTIFY pgrst, 'reload schema'")
+	return nil
+}
+
+// apply runs sql and the schema_migrations update in one transaction.
+func (m *Migrator) apply(ctx context.Context, migration Migration, sql, record string, args ...interface{}) error {
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return fmt.Errorf("record migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.conn.Query(ctx, "SELECT version, applied_at FROM public.schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[int(version)] = at
+	}
+	return applied, rows.Err()
+}
+
+// Migrate brings the database at url up to the latest version and returns the migrations it
+// applied, none when the schema was already current.
+func Migrate(ctx context.Context, url string) ([]Migration, error) {
+	if url == "" {
+		return nil, errors.New("DATABASE_URL is required to run migrations")
+	}
+	m, err := Connect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close(ctx)
+	return m.Up(ctx, 0)
+}
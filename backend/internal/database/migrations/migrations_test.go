@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range all {
+		// Versions run 1, 2, 3… so a gap means a file was lost or misnumbered
+		if m.Version != i+1 {
+			t.Fatalf("migration %d_%s follows version %d", m.Version, m.Name, i)
+		}
+		if strings.TrimSpace(m.up) == "" || strings.TrimSpace(m.down) == "" {
+			t.Errorf("migration %04d_%s has an empty file", m.Version, m.Name)
+		}
+	}
+	latest, err := Latest()
+	if err != nil || latest != len(all) {
+		t.Fatalf("Latest = %d, %v, want %d", latest, err, len(all))
+	}
+}
+
+func TestLoadOrdersAndPairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_tokens.up.sql":    {Data: []byte("CREATE TABLE tokens ();")},
+		"0010_tokens.down.sql":  {Data: []byte("DROP TABLE tokens;")},
+		"0002_columns.up.sql":   {Data: []byte("ALTER TABLE files ADD x int;")},
+		"0002_columns.down.sql": {Data: []byte("ALTER TABLE files DROP x;")},
+	}
+	all, err := load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Version != 2 || all[1].Version != 10 || all[1].Name != "tokens" {
+		t.Fatalf("loaded %+v", all)
+	}
+	if all[0].up != "ALTER TABLE files ADD x int;" || all[0].down != "ALTER TABLE files DROP x;" {
+		t.Fatalf("up and down swapped: %+v", all[0])
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	sql := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	cases := map[string]fstest.MapFS{
+		"no direction":    {"0001_init.sql": sql},
+		"bad direction":   {"0001_init.sideways.sql": sql, "0001_init.up.sql": sql},
+		"no version":      {"init.up.sql": sql, "init.down.sql": sql},
+		"zero version":    {"0000_init.up.sql": sql, "0000_init.down.sql": sql},
+		"missing down":    {"0001_init.up.sql": sql},
+		"missing up":      {"0001_init.down.sql": sql},
+		"version reused":  {"0001_init.up.sql": sql, "0001_init.down.sql": sql, "0001_other.up.sql": sql, "0001_other.down.sql": sql},
+		"empty down file": {"0001_init.up.sql": sql, "0001_init.down.sql": {}},
+	}
+	for name, fsys := range cases {
+		if all, err := load(fsys); err == nil {
+			t.Errorf("%s: loaded %+v", name, all)
+		}
+	}
+}
+
+func TestMigrateNeedsURL(t *testing.T) {
+	if _, err := Migrate(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "DATABASE_URL") {
+		t.Fatalf("Migrate without a URL = %v", err)
+	}
+}
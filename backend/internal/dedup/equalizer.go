@@ -0,0 +1,78 @@
+package dedup
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Starting estimates used until real writes have been observed.
+const (
+	defaultOverhead = 50 * time.Millisecond
+	defaultPerByte  = 50 * time.Nanosecond // ~20 MB/s
+	// Writes up to this size are dominated by fixed latency and only update the overhead estimate
+	smallWriteSize = 64 * 1024
+	// Weight of the newest sample in the moving averages
+	ewmaWeight = 0.1
+)
+
+// Equalizer makes a deduplicated upload take about as long as storing a new blob would have,
+// so response timing does not reveal that the content already existed. It learns how long
+// blob writes take from the uploads that really store content.
+type Equalizer struct {
+	mu       sync.Mutex
+	overhead float64 // seconds per write
+	perByte  float64 // seconds per byte
+}
+
+// NewEqualizer returns an Equalizer seeded with conservative write estimates.
+func NewEqualizer() *Equalizer {
+	return &Equalizer{
+		overhead: defaultOverhead.Seconds(),
+		perByte:  defaultPerByte.Seconds(),
+	}
+}
+
+// Observe records that storing size bytes took d.
+func (e *Equalizer) Observe(size int64, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if size <= smallWriteSize {
+		e.overhead += ewmaWeight * (d.Seconds() - e.overhead)
+		return
+	}
+	perByte := (d.Seconds() - e.overhead) / float64(size)
+	if perByte < 0 {
+		perByte = 0
+	}
+	e.perByte += ewmaWeight * (perByte - e.perByte)
+}
+
+// estimate is the expected time to store size bytes.
+func (e *Equalizer) estimate(size int64) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Duration((e.overhead + e.perByte*float64(size)) * float64(time.Second))
+}
+
+// Delay returns how long to hold the response to an upload of size bytes. A hit waits
+// 0.9-1.3x the estimated write time in place of the write it skipped; a miss, which already
+// paid for its write, waits a further 0-0.2x. Both then average 1.1x with overlapping ranges.
+func (e *Equalizer) Delay(size int64, hit bool) time.Duration {
+	est := float64(e.estimate(size))
+	if hit {
+		return time.Duration(est * (0.9 + 0.4*rand.Float64()))
+	}
+	return time.Duration(est * 0.2 * rand.Float64())
+}
+
+// Wait sleeps for Delay(size, hit), returning early if ctx is done.
+func (e *Equalizer) Wait(ctx context.Context, size int64, hit bool) {
+	timer := time.NewTimer(e.Delay(size, hit))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
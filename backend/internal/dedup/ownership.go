@@ -0,0 +1,195 @@
+package dedup
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Proof-of-ownership challenges ask for hashes of a few random byte ranges, which a client
+// can only answer if it holds the whole file rather than just its hash.
+const (
+	challengeRanges   = 8
+	challengeRangeLen = 1024
+	challengeNonceLen = 16
+	challengeTTL      = 5 * time.Minute
+)
+
+// Limits on outstanding challenges. Pre-upload checks are cheap to send, so without them a
+// client could grow the store without bound for the length of a challenge's TTL.
+const (
+	maxChallengesPerOwner = 16
+	maxChallenges         = 10000
+)
+
+var (
+	// ErrChallengeNotFound is returned for unknown, expired or already answered challenges.
+	ErrChallengeNotFound = errors.New("challenge not found or expired")
+	// ErrTooManyChallenges is returned when an owner, or the store as a whole, already has
+	// as many outstanding challenges as it may hold.
+	ErrTooManyChallenges = errors.New("too many outstanding challenges")
+)
+
+// Range is a byte range of the file a challenge asks the client to prove it holds.
+type Range struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// Challenge is issued when a pre-upload check matches existing content. It is answered by
+// Prove and binds the file row that will be created once it is answered correctly.
+type Challenge struct {
+	ID        string    `json:"challenge_id"`
+	Nonce     string    `json:"nonce"` // Hex; prefixed to every range before hashing
+	Ranges    []Range   `json:"ranges"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	OwnerID   string `json:"-"`
+	ContentID string `json:"-"`
+	Filename  string `json:"-"`
+}
+
+// NewChallenge picks challengeRanges non-overlapping ranges of a file of size bytes, one
+// at a random position in each of as many equal segments.
+func NewChallenge(ownerID, contentID, filename string, size int64) (*Challenge, error) {
+	nonce := make([]byte, challengeNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var ranges []Range
+	segments := int64(challengeRanges)
+	if size < segments {
+		segments = 1
+	}
+	segmentLen := size / segments
+	for i := int64(0); i < segments; i++ {
+		start := i * segmentLen
+		if i == segments-1 {
+			segmentLen = size - start // the last segment absorbs the remainder
+		}
+		length := min(int64(challengeRangeLen), segmentLen)
+		offset := start
+		if slack := segmentLen - length; slack > 0 {
+			offset += mrand.Int64N(slack + 1)
+		}
+		ranges = append(ranges, Range{Offset: offset, Length: length})
+	}
+
+	return &Challenge{
+		ID:        uuid.New().String(),
+		Nonce:     hex.EncodeToString(nonce),
+		Ranges:    ranges,
+		ExpiresAt: time.Now().UTC().Add(challengeTTL),
+		OwnerID:   ownerID,
+		ContentID: contentID,
+		Filename:  filename,
+	}, nil
+}
+
+// Prove reads r and returns the hex SHA-256 of nonce||bytes for each range. Ranges must be
+// sorted and non-overlapping, as NewChallenge produces them. Clients answer a challenge with it.
+func Prove(r io.Reader, nonce string, ranges []Range) ([]string, error) {
+	rawNonce, err := hex.DecodeString(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge nonce: %w", err)
+	}
+	proofs := make([]string, 0, len(ranges))
+	var pos int64
+	for _, rg := range ranges {
+		if rg.Offset < pos || rg.Length < 0 {
+			return nil, errors.New("challenge ranges must be sorted and non-overlapping")
+		}
+		if _, err := io.CopyN(io.Discard, r, rg.Offset-pos); err != nil {
+			return nil, fmt.Errorf("skip to offset %d: %w", rg.Offset, err)
+		}
+		h := sha256.New()
+		h.Write(rawNonce)
+		if _, err := io.CopyN(h, r, rg.Length); err != nil {
+			return nil, fmt.Errorf("read range at offset %d: %w", rg.Offset, err)
+		}
+		proofs = append(proofs, hex.EncodeToString(h.Sum(nil)))
+		pos = rg.Offset + rg.Length
+	}
+	return proofs, nil
+}
+
+// Verify reports whether proofs answer the challenge for content read from r.
+func (ch *Challenge) Verify(r io.Reader, proofs []string) (bool, error) {
+	if len(proofs) != len(ch.Ranges) {
+		return false, nil
+	}
+	expected, err := Prove(r, ch.Nonce, ch.Ranges)
+	if err != nil {
+		return false, err
+	}
+	ok := 1
+	for i := range expected {
+		ok &= subtle.ConstantTimeCompare([]byte(expected[i]), []byte(proofs[i]))
+	}
+	return ok == 1, nil
+}
+
+// ChallengeStore holds outstanding challenges in memory until they are answered or expire.
+type ChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]*Challenge
+	perOwner   map[string]int
+}
+
+// NewChallengeStore creates an empty ChallengeStore.
+func NewChallengeStore() *ChallengeStore {
+	return &ChallengeStore{challenges: make(map[string]*Challenge), perOwner: make(map[string]int)}
+}
+
+// Add stores ch, dropping any challenges that have expired. It returns ErrTooManyChallenges
+// instead when ch's owner or the store is already at its limit.
+func (s *ChallengeStore) Add(ch *Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, existing := range s.challenges {
+		if now.After(existing.ExpiresAt) {
+			s.remove(id, existing)
+		}
+	}
+	if s.perOwner[ch.OwnerID] >= maxChallengesPerOwner || len(s.challenges) >= maxChallenges {
+		return ErrTooManyChallenges
+	}
+	s.challenges[ch.ID] = ch
+	s.perOwner[ch.OwnerID]++
+	return nil
+}
+
+// remove deletes the challenge stored under id. s.mu must be held.
+func (s *ChallengeStore) remove(id string, ch *Challenge) {
+	delete(s.challenges, id)
+	if s.perOwner[ch.OwnerID]--; s.perOwner[ch.OwnerID] <= 0 {
+		delete(s.perOwner, ch.OwnerID)
+	}
+}
+
+// Take removes and returns the challenge issued to ownerID under id. Each challenge can
+// be answered once, so a wrong answer means asking for a new one.
+func (s *ChallengeStore) Take(id, ownerID string) (*Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.challenges[id]
+	if !ok || ch.OwnerID != ownerID {
+		return nil, ErrChallengeNotFound
+	}
+	s.remove(id, ch)
+	if time.Now().After(ch.ExpiresAt) {
+		return nil, ErrChallengeNotFound
+	}
+	return ch, nil
+}
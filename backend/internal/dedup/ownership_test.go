@@ -0,0 +1,62 @@
+package dedup
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChallengeStoreCapsOutstandingPerOwner(t *testing.T) {
+	s := NewChallengeStore()
+	for i := 0; i < maxChallengesPerOwner; i++ {
+		ch, err := NewChallenge("alice", "content", "a.txt", 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Add(ch); err != nil {
+			t.Fatalf("challenge %d: %v", i, err)
+		}
+	}
+
+	extra, _ := NewChallenge("alice", "content", "a.txt", 4096)
+	if err := s.Add(extra); !errors.Is(err, ErrTooManyChallenges) {
+		t.Fatalf("Add over the limit = %v, want ErrTooManyChallenges", err)
+	}
+	other, _ := NewChallenge("bob", "content", "b.txt", 4096)
+	if err := s.Add(other); err != nil {
+		t.Fatalf("another owner was limited: %v", err)
+	}
+
+	// Answering a challenge frees its slot
+	var taken string
+	for id, ch := range s.challenges {
+		if ch.OwnerID == "alice" {
+			taken = id
+			break
+		}
+	}
+	if _, err := s.Take(taken, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(extra); err != nil {
+		t.Fatalf("Add after Take: %v", err)
+	}
+}
+
+func TestChallengeStoreSweepsExpired(t *testing.T) {
+	s := NewChallengeStore()
+	for i := 0; i < maxChallengesPerOwner; i++ {
+		ch, _ := NewChallenge("alice", "content", "a.txt", 4096)
+		ch.ExpiresAt = time.Now().Add(-time.Second)
+		s.challenges[ch.ID] = ch
+		s.perOwner[ch.OwnerID]++
+	}
+
+	ch, _ := NewChallenge("alice", "content", "a.txt", 4096)
+	if err := s.Add(ch); err != nil {
+		t.Fatalf("Add with only expired challenges outstanding: %v", err)
+	}
+	if len(s.challenges) != 1 || s.perOwner["alice"] != 1 {
+		t.Fatalf("expired challenges were not swept: %d stored, %d counted", len(s.challenges), s.perOwner["alice"])
+	}
+}
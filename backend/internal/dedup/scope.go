@@ -0,0 +1,43 @@
+// Package dedup decides which uploads may share stored content, and hides from uploaders
+// whether their upload was deduplicated against someone else's.
+package dedup
+
+import "fmt"
+
+// Scope is how widely identical content is shared between users.
+type Scope string
+
+const (
+	// ScopeGlobal deduplicates across every user. Upload timing is equalized so a hit is not observable.
+	ScopeGlobal Scope = "global"
+	// ScopeOrganization deduplicates within an organization; users without one are scoped to themselves.
+	ScopeOrganization Scope = "organization"
+	// ScopeUser only deduplicates a user's own uploads.
+	ScopeUser Scope = "user"
+)
+
+// ParseScope validates a configured scope, defaulting to ScopeGlobal when empty.
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case "":
+		return ScopeGlobal, nil
+	case ScopeGlobal, ScopeOrganization, ScopeUser:
+		return Scope(s), nil
+	}
+	return "", fmt.Errorf("unsupported dedup scope %q (want global, organization or user)", s)
+}
+
+// Key returns the value recorded in file_contents.dedup_scope for content uploaded by userID.
+// Content is only deduplicated against rows with the same key.
+func (s Scope) Key(userID, organizationID string) string {
+	switch s {
+	case ScopeOrganization:
+		if organizationID != "" {
+			return "org:" + organizationID
+		}
+		return "user:" + userID
+	case ScopeUser:
+		return "user:" + userID
+	}
+	return string(ScopeGlobal)
+}
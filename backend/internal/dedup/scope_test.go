@@ -0,0 +1,77 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseScope(t *testing.T) {
+	cases := map[string]Scope{"": ScopeGlobal, "global": ScopeGlobal, "organization": ScopeOrganization, "user": ScopeUser}
+	for in, want := range cases {
+		if got, err := ParseScope(in); err != nil || got != want {
+			t.Errorf("ParseScope(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"team", "Global", "org"} {
+		if _, err := ParseScope(in); err == nil {
+			t.Errorf("ParseScope(%q) succeeded", in)
+		}
+	}
+}
+
+func TestScopeKey(t *testing.T) {
+	cases := []struct {
+		scope Scope
+		org   string
+		want  string
+	}{
+		{ScopeGlobal, "acme", "global"},
+		{ScopeOrganization, "acme", "org:acme"},
+		// Without an organization a user only shares with themselves
+		{ScopeOrganization, "", "user:alice"},
+		{ScopeUser, "acme", "user:alice"},
+	}
+	for _, tc := range cases {
+		if got := tc.scope.Key("alice", tc.org); got != tc.want {
+			t.Errorf("%s.Key(alice, %q) = %q, want %q", tc.scope, tc.org, got, tc.want)
+		}
+	}
+}
+
+func TestEqualizerDelaysOverlap(t *testing.T) {
+	e := NewEqualizer()
+	const size = 10 << 20
+	// Learn a slower store than the seeded estimate
+	for i := 0; i < 200; i++ {
+		e.Observe(1024, 80*time.Millisecond)
+		e.Observe(size, 80*time.Millisecond+size*100*time.Nanosecond)
+	}
+	est := e.estimate(size)
+	if want := 80*time.Millisecond + size*100*time.Nanosecond; est < want*9/10 || est > want*11/10 {
+		t.Fatalf("estimate %v after observing %v writes", est, want)
+	}
+
+	for i := 0; i < 1000; i++ {
+		hit, miss := e.Delay(size, true), e.Delay(size, false)
+		if hit < est*9/10 || hit > est*13/10 {
+			t.Fatalf("hit delay %v outside 0.9-1.3x of %v", hit, est)
+		}
+		// A miss already waited about est for its write
+		if miss < 0 || miss > est*2/10 {
+			t.Fatalf("miss delay %v outside 0-0.2x of %v", miss, est)
+		}
+	}
+}
+
+func TestEqualizerWaitStopsOnCancel(t *testing.T) {
+	e := NewEqualizer()
+	e.Observe(1024, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	e.Wait(ctx, 1024, true)
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("Wait ignored cancellation for %v", waited)
+	}
+}
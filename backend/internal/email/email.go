@@ -1,22 +1,23 @@
 package email
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"time"
 
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/metrics"
+	"file-vault/backend/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/gomail.v2"
 )
 
-// SendOTP sends an OTP to the specified email address using gomail.
-func SendOTP(name, email, otp string) error {
-	// Get email configuration from environment variables
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := 587 // Standard port for SMTP with STARTTLS
-	senderEmail := os.Getenv("SMTP_USER")
-	senderPassword := os.Getenv("SMTP_PASS")
-
-	if smtpHost == "" || senderEmail == "" || senderPassword == "" {
-		return fmt.Errorf("SMTP configuration is missing. Please set SMTP_HOST, SMTP_USER, and SMTP_PASS environment variables")
+// SendOTP sends an OTP that expires after lifetime to the specified email address using gomail.
+// ctx only carries the trace the send is recorded in.
+func SendOTP(ctx context.Context, smtp config.SMTPConfig, lifetime time.Duration, name, email, otp string) error {
+	if smtp.Host == "" || smtp.User == "" || smtp.Pass == "" {
+		return fmt.Errorf("SMTP configuration is missing. Please set SMTP_HOST, SMTP_USER, and SMTP_PASS")
 	}
 
 	userName := name
@@ -25,18 +26,37 @@ func SendOTP(name, email, otp string) error {
 	}
 
 	m := gomail.NewMessage()
-	m.SetHeader("From", senderEmail)
+	m.SetHeader("From", smtp.User)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", "Your OTP for BalkanID File Vault")
-	m.SetBody("text/html", fmt.Sprintf(`Hi %s,<br><br>You requested to verify your email address for the BalkanID File Vault application.<br><br>🔑 Your One-Time Password (OTP) is: <b>%s</b><br>⏳ This code will expire in 15 minutes.<br><br>⚠️ For your security:<br>- Do not share this OTP with anyone.<br>- BalkanID will never ask for your OTP over phone, email, or chat.<br><br>If you did not request this verification, please ignore this email or contact support immediately.<br><br>Thanks,<br>The BalkanID Team`, userName, otp))
+	m.SetBody("text/html", fmt.Sprintf(`Hi %s,<br><br>You requested to verify your email address for the BalkanID File Vault application.<br><br>🔑 Your One-Time Password (OTP) is: <b>%s</b><br>⏳ This code will expire in %s.<br><br>⚠️ For your security:<br>- Do not share this OTP with anyone.<br>- BalkanID will never ask for your OTP over phone, email, or chat.<br><br>If you did not request this verification, please ignore this email or contact support immediately.<br><br>Thanks,<br>The BalkanID Team`, userName, otp, formatLifetime(lifetime)))
 
-	// The port is an int, so we need to convert it from the string if we were getting it from env
-	d := gomail.NewDialer(smtpHost, smtpPort, senderEmail, senderPassword)
+	d := gomail.NewDialer(smtp.Host, smtp.Port, smtp.User, smtp.Pass.Value())
 
 	// Send the email
-	if err := d.DialAndSend(m); err != nil {
+	_, span := tracing.Start(ctx, "smtp send", attribute.String("server.address", smtp.Host))
+	err := d.DialAndSend(m)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.EmailsSent.WithLabelValues("failed").Inc()
 		return fmt.Errorf("could not send email: %w", err)
 	}
+	metrics.EmailsSent.WithLabelValues("sent").Inc()
 
 	return nil
 }
+
+// formatLifetime renders lifetime in words, e.g. "15 minutes" or "1 hour".
+func formatLifetime(lifetime time.Duration) string {
+	if lifetime%time.Hour == 0 {
+		return plural(int(lifetime/time.Hour), "hour")
+	}
+	return plural(int(lifetime.Round(time.Minute)/time.Minute), "minute")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/s3"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessKeyIDPrefix starts every access key ID. AWS uses "AKIA"; a distinct prefix tells
+// the two apart in configuration files.
+const accessKeyIDPrefix = "AKFV"
+
+// secretAlphabet is what secret access keys are made of, as in AWS: letters, digits, + and /.
+const secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// storedAccessKey is an s3_access_keys row with the wrapped secret, which only the gateway reads.
+type storedAccessKey struct {
+	models.AccessKey
+	SecretKey string `json:"secret_key"` // Base64, wrapped under KeyID
+	KeyID     string `json:"key_id"`
+}
+
+// newAccessKey generates an access key ID of 20 characters and a secret of 40, the lengths
+// AWS uses, which some tools check.
+func newAccessKey() (id, secret string, err error) {
+	random := make([]byte, 10+40)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	id = accessKeyIDPrefix + base32.StdEncoding.EncodeToString(random[:10])[:16]
+	var b strings.Builder
+	for _, c := range random[10:] {
+		// 256 is a multiple of 64, so every character is equally likely
+		b.WriteByte(secretAlphabet[c%64])
+	}
+	return id, b.String(), nil
+}
+
+// CreateAccessKey issues an S3 access key for the authenticated user. The secret is returned
+// once; it is stored wrapped under the current master key, since verifying a signature needs it.
+func CreateAccessKey(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var payload struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+		name := strings.TrimSpace(payload.Name)
+		if name == "" || len(name) > 100 {
+			apierror.Respond(c, apierror.BadRequest("name must be between 1 and 100 characters"))
+			return
+		}
+
+		id, secret, err := newAccessKey()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to generate access key", err))
+			return
+		}
+		keyID, wrapped, err := clients.Keys.WrapKey(c.Request.Context(), []byte(secret))
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to protect access key", err))
+			return
+		}
+
+		var created []models.AccessKey
+		_, err = clients.Postgrest.From("s3_access_keys").Insert(map[string]interface{}{
+			"access_key_id": id,
+			"user_id":       c.GetString("userID"),
+			"name":          name,
+			"secret_key":    base64.StdEncoding.EncodeToString(wrapped),
+			"key_id":        keyID,
+		}, false, "", "representation", "").ExecuteTo(&created)
+		if err != nil || len(created) == 0 {
+			apierror.Respond(c, apierror.Internal("Failed to create access key", err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"access_key_id":     created[0].AccessKeyID,
+			"user_id":           created[0].UserID,
+			"name":              created[0].Name,
+			"created_at":        created[0].CreatedAt,
+			"secret_access_key": secret,
+		})
+	}
+}
+
+// ListAccessKeys lists the authenticated user's S3 access keys, without their secrets.
+func ListAccessKeys(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var accessKeys []models.AccessKey
+		_, err := clients.Postgrest.From("s3_access_keys").Select("access_key_id,user_id,name,created_at,last_used_at", "", false).
+			Eq("user_id", c.GetString("userID")).
+			Order("created_at", nil).
+			ExecuteTo(&accessKeys)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to list access keys", err))
+			return
+		}
+		if accessKeys == nil {
+			accessKeys = []models.AccessKey{}
+		}
+
+		c.JSON(http.StatusOK, accessKeys)
+	}
+}
+
+// RevokeAccessKey deletes one of the authenticated user's S3 access keys.
+func RevokeAccessKey(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var deleted []models.AccessKey
+		_, err := clients.Postgrest.From("s3_access_keys").Delete("", "").
+			Eq("access_key_id", c.Param("id")).
+			Eq("user_id", c.GetString("userID")).
+			ExecuteTo(&deleted)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to revoke access key", err))
+			return
+		}
+		if len(deleted) == 0 {
+			apierror.Respond(c, apierror.NotFound("Access key not found"))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// AccessKeySecret returns an access key and its secret, for verifying a request signed with
+// it. Unknown keys are reported as s3.ErrUnknownAccessKey.
+func AccessKeySecret(ctx context.Context, clients *database.AppClients, accessKeyID string) (models.AccessKey, string, error) {
+	var stored storedAccessKey
+	_, err := clients.Postgrest.From("s3_access_keys").Select("*", "", false).Single().Eq("access_key_id", accessKeyID).ExecuteTo(&stored)
+	if err != nil {
+		return models.AccessKey{}, "", s3.ErrUnknownAccessKey
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(stored.SecretKey)
+	if err != nil {
+		return models.AccessKey{}, "", err
+	}
+	secret, err := clients.Keys.UnwrapKey(ctx, stored.KeyID, wrapped)
+	if err != nil {
+		return models.AccessKey{}, "", err
+	}
+	return stored.AccessKey, string(secret), nil
+}
+
+// RecordAccessKeyUse updates the last_used_at of an access key that signed a valid request,
+// at most once an hour.
+func RecordAccessKeyUse(ctx context.Context, clients *database.AppClients, key models.AccessKey) {
+	now := time.Now().UTC()
+	if key.LastUsedAt != nil && now.Sub(key.LastUsedAt.Time) <= tokenUseInterval {
+		return
+	}
+	_, _, err := clients.Postgrest.From("s3_access_keys").Update(map[string]interface{}{"last_used_at": now}, "", "").Eq("access_key_id", key.AccessKeyID).Execute()
+	if err != nil {
+		logging.FromContext(ctx).Error("Error recording access key use", "access_key_id", key.AccessKeyID, "error", err)
+	}
+}
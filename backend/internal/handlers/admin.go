@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"file-vault/backend/internal/apierror"
 	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -15,14 +19,15 @@ import (
 // @Produce  json
 // @Param   config body UpdateConfigRequest true "Configuration"
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Failure 400 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
 // @Router /admin/config [post]
 func UpdateConfig(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		var req UpdateConfigRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, apierror.InvalidBody(err))
 			return
 		}
 
@@ -37,7 +42,7 @@ func UpdateConfig(clients *database.AppClients) gin.HandlerFunc {
 		}
 
 		if len(updateData) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one field (rate_limit or storage_quota) must be provided"})
+			apierror.Respond(c, apierror.BadRequest("at least one field (rate_limit or storage_quota) must be provided"))
 			return
 		}
 
@@ -45,7 +50,7 @@ func UpdateConfig(clients *database.AppClients) gin.HandlerFunc {
 		_, _, err := clients.Postgrest.From("users").Update(updateData, "", "").Eq("user_id", req.UserID).Execute()
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user configuration"})
+			apierror.Respond(c, apierror.Internal("Failed to update user configuration", err))
 			return
 		}
 
@@ -58,3 +63,67 @@ type UpdateConfigRequest struct {
 	RateLimit    *int   `json:"rate_limit,omitempty"`
 	StorageQuota *int64 `json:"storage_quota,omitempty"`
 }
+
+// tierUsage is the share of stored content in one storage class.
+type tierUsage struct {
+	Blobs         int   `json:"blobs"`
+	LogicalBytes  int64 `json:"logical_bytes"`
+	PhysicalBytes int64 `json:"physical_bytes"`
+}
+
+// AdminGetStats godoc
+// @Summary System-wide storage statistics
+// @Description Report logical versus physical bytes across all stored content, including compression savings and usage per storage class
+// @Tags admin
+// @Produce  json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/stats [get]
+func AdminGetStats(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var contents []models.FileContent
+		_, err := clients.Postgrest.From("file_contents").Select("content_id,size,stored_size,codec,reference_count,storage_class", "", false).ExecuteTo(&contents)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to calculate storage statistics", err))
+			return
+		}
+
+		var logicalBytes, physicalBytes, referencedBytes int64
+		compressedBlobs := 0
+		tiers := map[string]*tierUsage{storage.ClassHot: {}, storage.ClassCold: {}}
+		for _, content := range contents {
+			logicalBytes += content.Size
+			physicalBytes += content.PhysicalSize()
+			class := content.StorageClass
+			if class == "" {
+				class = storage.ClassHot
+			}
+			if usage, ok := tiers[class]; ok {
+				usage.Blobs++
+				usage.LogicalBytes += content.Size
+				usage.PhysicalBytes += content.PhysicalSize()
+			}
+			referencedBytes += content.Size * int64(content.ReferenceCount)
+			if content.Codec != "" && content.Codec != storage.CodecNone {
+				compressedBlobs++
+			}
+		}
+
+		var compressionRatio float64
+		if physicalBytes > 0 {
+			compressionRatio = float64(logicalBytes) / float64(physicalBytes)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_blobs":               len(contents),
+			"compressed_blobs":          compressedBlobs,
+			"referenced_bytes":          referencedBytes,
+			"logical_bytes":             logicalBytes,
+			"physical_bytes":            physicalBytes,
+			"compression_savings_bytes": logicalBytes - physicalBytes,
+			"compression_ratio":         fmt.Sprintf("%.2f", compressionRatio),
+			"tiers":                     tiers,
+		})
+	}
+}
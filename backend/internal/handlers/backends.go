@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageMigrationRequest selects the backend a migration or cleanup works towards.
+type StorageMigrationRequest struct {
+	Target         string `json:"target" binding:"required"`
+	BytesPerSecond int64  `json:"bytes_per_second"`
+}
+
+// ListBackends godoc
+// @Summary Storage backends
+// @Description List the configured storage backends, which one new uploads go to, and how much hot content each holds
+// @Tags admin
+// @Produce  json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/storage/backends [get]
+func ListBackends(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		content := clients.ContentStore()
+		names := content.BackendNames()
+		usage, err := jobs.BackendUsage(clients.Postgrest, names)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch storage backends", err))
+			return
+		}
+
+		backends := []gin.H{{"name": "", "default": true, "blobs": usage[""]}}
+		for _, name := range names {
+			backends = append(backends, gin.H{"name": name, "default": false, "blobs": usage[name]})
+		}
+		c.JSON(http.StatusOK, gin.H{"active": content.ActiveBackend(), "backends": backends})
+	}
+}
+
+// StartStorageMigration godoc
+// @Summary Migrate content to another storage backend
+// @Description Start a background job that copies every hot blob to the target backend, verifies the copy against its content hash and switches the row over. Source copies are kept until a cleanup.
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param   migration body StorageMigrationRequest true "Target backend and optional copy rate limit"
+// @Success 202 {object} models.Job
+// @Failure 400 {object} apierror.Problem
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/storage/migrate [post]
+func StartStorageMigration(clients *database.AppClients, manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req StorageMigrationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+		if _, err := clients.ContentStore().Backend(req.Target); err != nil {
+			apierror.Respond(c, apierror.Wrap(err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Storage backend "+strconv.Quote(req.Target)+" is not configured"))
+			return
+		}
+		if req.BytesPerSecond < 0 {
+			apierror.Respond(c, apierror.BadRequest("bytes_per_second must not be negative"))
+			return
+		}
+
+		startBackendJob(c, manager, jobs.KindBackendMigration, jobs.BackendMigrationParams{
+			Target:         req.Target,
+			BytesPerSecond: req.BytesPerSecond,
+		}, "storage migration")
+	}
+}
+
+// StartStorageCleanup godoc
+// @Summary Remove migrated content from the old storage backends
+// @Description Start a background job that deletes every blob already on the target backend from the other backends. Refused while any hot content still lives elsewhere.
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param   cleanup body StorageMigrationRequest true "Backend the content was migrated to"
+// @Success 202 {object} models.Job
+// @Failure 400 {object} apierror.Problem
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/storage/cleanup [post]
+func StartStorageCleanup(clients *database.AppClients, manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var req StorageMigrationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+		if _, err := clients.ContentStore().Backend(req.Target); err != nil {
+			apierror.Respond(c, apierror.Wrap(err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Storage backend "+strconv.Quote(req.Target)+" is not configured"))
+			return
+		}
+		remaining, err := jobs.NotOnBackend(clients.Postgrest, req.Target)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to start storage cleanup", err))
+			return
+		}
+		if remaining > 0 {
+			apierror.Respond(c, apierror.New(http.StatusConflict, apierror.CodeConflict, "Content is still stored on other backends; finish the migration first").With("remaining", remaining))
+			return
+		}
+
+		startBackendJob(c, manager, jobs.KindBackendCleanup, jobs.BackendMigrationParams{Target: req.Target}, "storage cleanup")
+	}
+}
+
+// startBackendJob starts a backend migration or cleanup job, answering 409 while one is running.
+func startBackendJob(c *gin.Context, manager *jobs.Manager, kind string, params jobs.BackendMigrationParams, name string) {
+	job, err := manager.Start(kind, params)
+	if errors.Is(err, jobs.ErrAlreadyRunning) {
+		apierror.Respond(c, apierror.Wrap(err, http.StatusConflict, apierror.CodeJobRunning, "A "+name+" is already running"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to start "+name, err))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/replication"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartBlobMigration godoc
+// @Summary Move blobs to content-addressed keys
+// @Description Start a background job that copies every blob still stored under a random key to a key derived from its content hash
+// @Tags admin
+// @Produce  json
+// @Success 202 {object} models.Job
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/blobs/migrate [post]
+func StartBlobMigration(manager *jobs.Manager) gin.HandlerFunc {
+	return startSweep(manager, jobs.KindBlobLayout, "blob migration")
+}
+
+// StartScrub godoc
+// @Summary Verify stored blobs
+// @Description Start a background job that reads every blob back and checks it against its content hash; failures are counted on the job
+// @Tags admin
+// @Produce  json
+// @Success 202 {object} models.Job
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/blobs/scrub [post]
+func StartScrub(manager *jobs.Manager) gin.HandlerFunc {
+	return startSweep(manager, jobs.KindScrub, "scrub")
+}
+
+// StartHeal godoc
+// @Summary Heal erasure-coded blobs
+// @Description Start a background job that rebuilds the missing or corrupt shards of every blob on an erasure-coded store. It also runs daily.
+// @Tags admin
+// @Produce  json
+// @Success 202 {object} models.Job
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/blobs/heal [post]
+func StartHeal(manager *jobs.Manager) gin.HandlerFunc {
+	return startSweep(manager, jobs.KindHeal, "heal")
+}
+
+// StartTiering godoc
+// @Summary Move unread content to cold storage
+// @Description Start a background job that moves content not downloaded within COLD_AFTER_DAYS to the cold storage class. It also runs daily.
+// @Tags admin
+// @Produce  json
+// @Success 202 {object} models.Job
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/tiering/run [post]
+func StartTiering(clients *database.AppClients, manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if clients.Tiering == nil {
+			apierror.Respond(c, apierror.New(http.StatusConflict, apierror.CodeNotConfigured, "No cold store is configured"))
+			return
+		}
+		job, err := manager.Start(jobs.KindTiering, jobs.NewTieringParams(clients.Tiering))
+		if errors.Is(err, jobs.ErrAlreadyRunning) {
+			apierror.Respond(c, apierror.Wrap(err, http.StatusConflict, apierror.CodeJobRunning, "A tiering run is already in progress"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to start tiering", err))
+			return
+		}
+
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// ListReplicas godoc
+// @Summary Replica status
+// @Description List the configured blob replicas with how many blobs each holds up to date and how many failed to copy
+// @Tags admin
+// @Produce  json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/replicas [get]
+func ListReplicas(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		replicated, ok := clients.Blobs.(*replication.Store)
+		if !ok {
+			c.JSON(http.StatusOK, gin.H{"replicas": []replication.ReplicaStatus{}})
+			return
+		}
+
+		statuses, err := replicated.Status()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch replica status", err))
+			return
+		}
+		_, total, err := clients.Postgrest.From("file_contents").Select("content_id", "exact", true).Execute()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch replica status", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"total_blobs": total, "replicas": statuses})
+	}
+}
+
+// StartReplicaRepair godoc
+// @Summary Repair blob replicas
+// @Description Start a background job that copies every blob to the replicas missing it or holding a stale copy
+// @Tags admin
+// @Produce  json
+// @Success 202 {object} models.Job
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/replicas/repair [post]
+func StartReplicaRepair(manager *jobs.Manager) gin.HandlerFunc {
+	return startSweep(manager, jobs.KindReplicaRepair, "replica repair")
+}
+
+// startSweep starts a parameterless background job of kind, answering 409 while one is running.
+func startSweep(manager *jobs.Manager, kind, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := manager.Start(kind, nil)
+		if errors.Is(err, jobs.ErrAlreadyRunning) {
+			apierror.Respond(c, apierror.Wrap(err, http.StatusConflict, apierror.CodeJobRunning, "A "+name+" is already running"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to start "+name, err))
+			return
+		}
+
+		c.JSON(http.StatusAccepted, job)
+	}
+}
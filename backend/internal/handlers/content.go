@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/metrics"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// maxPageSize caps the limit query parameter of file listings.
+const maxPageSize = 1000
+
+// paginate orders a file listing newest first and applies the limit and offset query
+// parameters. Without either every matching file is returned, as before pagination existed;
+// an offset without a limit returns a page of maxPageSize.
+func paginate(c *gin.Context, filter *postgrest.FilterBuilder) (*postgrest.FilterBuilder, error) {
+	filter = filter.Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Order("file_id", &postgrest.OrderOpts{Ascending: false})
+
+	offset := 0
+	if s := c.Query("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, apierror.BadRequest("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	limit := 0
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return nil, apierror.BadRequest("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		limit = n
+	} else if offset > 0 {
+		limit = maxPageSize
+	}
+	if limit == 0 {
+		return filter, nil
+	}
+	return filter.Range(offset, offset+limit-1, ""), nil
+}
+
+// openContent opens the blob behind fileContent. The tiering job or a rehydration may move
+// the blob to another storage class between loading the row and opening it, deleting the copy
+// the row pointed at; the row is then reloaded and the blob opened where it lives now.
+// fileContent is updated to the row that was opened.
+func openContent(ctx context.Context, clients *database.AppClients, content *storage.ContentStore, fileContent *models.FileContent) (io.ReadCloser, error) {
+	file, err := content.Open(ctx, fileContent)
+	if err == nil {
+		return file, nil
+	}
+	var current models.FileContent
+	if _, fetchErr := clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", fileContent.ContentID).ExecuteTo(&current); fetchErr != nil {
+		return nil, err
+	}
+	if current.StorageClass == fileContent.StorageClass && current.StorageBackend == fileContent.StorageBackend && current.StoragePath == fileContent.StoragePath {
+		return nil, err
+	}
+	*fileContent = current
+	return content.Open(ctx, fileContent)
+}
+
+// sendContent streams the size bytes of file to the client. A single byte range, such as the
+// "bytes=N-" a client resuming an interrupted download sends, is answered with 206 and only
+// that part of the content; the bytes before it are read and discarded, because stored
+// content may be compressed or encrypted and cannot be seeked. Other Range headers are
+// ignored and the whole content is sent.
+func sendContent(c *gin.Context, file io.Reader, size int64, mimeType string, headers map[string]string) {
+	c.Header("Accept-Ranges", "bytes")
+
+	start, end, ok := parseRange(c.GetHeader("Range"), size)
+	if !ok {
+		metrics.DownloadedBytes.Add(float64(size))
+		c.DataFromReader(http.StatusOK, size, mimeType, file, headers)
+		return
+	}
+	if start >= size {
+		c.Header("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		apierror.Respond(c, apierror.New(http.StatusRequestedRangeNotSatisfiable, apierror.CodeInvalidRequest,
+			"Range starts beyond the end of the content").With("size", size))
+		return
+	}
+	if _, err := io.CopyN(io.Discard, file, start); err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to download file", err))
+		return
+	}
+
+	length := end - start + 1
+	c.Header("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(size, 10))
+	metrics.DownloadedBytes.Add(float64(length))
+	c.DataFromReader(http.StatusPartialContent, length, mimeType, io.LimitReader(file, length), headers)
+}
+
+// parseRange parses a Range header naming one byte range of content of the given size,
+// returning its first and last offsets. ok is false for absent, malformed and multi-range
+// headers, which are served as the whole content. A start beyond the content is returned
+// as is for the caller to reject.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// A suffix range: the final bytes of the content
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+func TestOpenContentFollowsMovedBlob(t *testing.T) {
+	hot, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := storage.NewContentStore(hot, nil)
+	content.SetColdStore(cold)
+
+	data := []byte("rehydrated while a download was starting")
+	current := models.FileContent{ContentID: "c1", Size: int64(len(data)), StoragePath: storage.ContentKey(strings.Repeat("ab", 32))}
+	if err := content.Save(context.Background(), &current, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current)
+	}))
+	defer rest.Close()
+	clients := &database.AppClients{Postgrest: postgrest.NewClient(rest.URL, "", nil)}
+
+	// The download loaded the row while the content was still cold
+	stale := current
+	stale.StorageClass = storage.ClassCold
+	file, err := openContent(context.Background(), clients, content, &stale)
+	if err != nil {
+		t.Fatalf("openContent: %v", err)
+	}
+	defer file.Close()
+	got, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %q, want %q", got, data)
+	}
+	if stale.StorageClass != storage.ClassHot {
+		t.Fatalf("row was not refreshed: storage_class %q", stale.StorageClass)
+	}
+}
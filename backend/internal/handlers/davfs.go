@@ -0,0 +1,855 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/metrics"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+	"golang.org/x/net/webdav"
+)
+
+// davFS presents one user's folders and files as a webdav.FileSystem. Folders come from the
+// 'folders' table and a file sits in the folders it has file_folder_mapping rows for, or at
+// the top level without any. Writes go through the same dedup, quota and reference counting
+// as uploads and deletes over the API. The S3 gateway maps object keys onto it as well.
+//
+// A davFS serves a single request: it caches what it has looked up until it changes something.
+type davFS struct {
+	clients *database.AppClients
+	user    models.User
+	uploads *uploadStore
+	content *storage.ContentStore
+	// declaredType is the Content-Type of a PUT, checked against the content it carries
+	declaredType string
+	// body is the body of a PUT, which tells whether it arrived whole; nil for writes that
+	// do not come from a WebDAV request body
+	body *davBody
+	// failure is the API error behind the last operation that failed, reported to the client
+	// in place of webdav's generic status
+	failure *apierror.Error
+
+	folders []models.Folder        // All of the user's folders, loaded on first use
+	listed  map[string][]davRecord // Files by folder ID ("" for the top level) once listed
+}
+
+// davRecord is a live file as WebDAV sees it.
+type davRecord struct {
+	FileID    string                     `json:"file_id"`
+	ContentID string                     `json:"content_id"`
+	Filename  string                     `json:"filename"`
+	CreatedAt models.CustomTime          `json:"created_at"`
+	Content   models.FileContentSummary  `json:"file_contents"`
+	Folders   []models.FileFolderMapping `json:"file_folder_mapping"`
+}
+
+// davInfo describes the top level, a folder or a file. It implements webdav.ContentTyper and
+// webdav.ETager so listings do not open the stored content.
+type davInfo struct {
+	name     string
+	folder   *models.Folder // Nil for the top level and files
+	file     *davRecord     // Nil for folders
+	parentID string         // Folder holding the file or folder, "" for the top level
+	modTime  time.Time
+}
+
+func (i *davInfo) Name() string { return i.name }
+func (i *davInfo) IsDir() bool  { return i.file == nil }
+func (i *davInfo) Sys() any     { return nil }
+
+func (i *davInfo) Size() int64 {
+	if i.file == nil {
+		return 0
+	}
+	return i.file.Content.Size
+}
+
+func (i *davInfo) Mode() os.FileMode {
+	if i.IsDir() {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i *davInfo) ModTime() time.Time {
+	switch {
+	case i.file != nil:
+		return i.file.CreatedAt.Time
+	case i.folder != nil:
+		return i.folder.CreatedAt.Time
+	}
+	return i.modTime
+}
+
+// ContentType returns the MIME type recorded when the content was stored.
+func (i *davInfo) ContentType(ctx context.Context) (string, error) {
+	if i.file == nil || i.file.Content.MimeType == "" {
+		return "application/octet-stream", nil
+	}
+	return i.file.Content.MimeType, nil
+}
+
+// ETag derives the entity tag from the content hash, so it changes exactly when the bytes do.
+func (i *davInfo) ETag(ctx context.Context) (string, error) {
+	if i.file == nil || i.file.Content.HashSHA256 == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + i.file.Content.HashSHA256 + `"`, nil
+}
+
+// fail records err for the response if it is an API error, and returns it.
+func (fs *davFS) fail(err error) error {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		fs.failure = apiErr
+	}
+	return err
+}
+
+// changed drops what fs has cached after it modified folders or files.
+func (fs *davFS) changed() {
+	fs.folders = nil
+	fs.listed = nil
+}
+
+// loadFolders fetches the user's folders once per request; paths are resolved in memory.
+func (fs *davFS) loadFolders() error {
+	if fs.folders != nil {
+		return nil
+	}
+	var folders []models.Folder
+	_, err := fs.clients.Postgrest.From("folders").Select("*", "", false).Eq("owner_id", fs.user.UserID).ExecuteTo(&folders)
+	if err != nil {
+		return fs.fail(apierror.Internal("Failed to list folders", err))
+	}
+	if folders == nil {
+		folders = []models.Folder{}
+	}
+	fs.folders = folders
+	return nil
+}
+
+// subfolder returns the folder called name directly inside parentID ("" for the top level).
+func (fs *davFS) subfolder(parentID, name string) *models.Folder {
+	for i := range fs.folders {
+		folder := &fs.folders[i]
+		if folder.Name == name && folderParent(*folder) == parentID {
+			return folder
+		}
+	}
+	return nil
+}
+
+func folderParent(folder models.Folder) string {
+	if folder.ParentID == nil {
+		return ""
+	}
+	return *folder.ParentID
+}
+
+// davSelect is what WebDAV reads of a file; the mapping is embedded to place it in folders.
+const davSelect = "file_id,content_id,filename,created_at,file_contents(size,mime_type,hash_sha256,hash_md5,client_encryption),"
+
+// files returns the live files in folderID, newest first, optionally only those called name.
+// End-to-end encrypted files are left out: only the clients holding their keys can read them.
+func (fs *davFS) files(folderID, name string) ([]davRecord, error) {
+	if rows, ok := fs.listed[folderID]; ok && name != "" {
+		var named []davRecord
+		for _, row := range rows {
+			if row.Filename == name {
+				named = append(named, row)
+			}
+		}
+		return named, nil
+	}
+
+	columns := davSelect + "file_folder_mapping(file_id,folder_id)"
+	if folderID != "" {
+		columns = davSelect + "file_folder_mapping!inner(file_id,folder_id)"
+	}
+	query := fs.clients.Postgrest.From("files").Select(columns, "", false).
+		Eq("owner_id", fs.user.UserID).
+		Eq("is_deleted", "false")
+	if folderID != "" {
+		query = query.Eq("file_folder_mapping.folder_id", folderID)
+	}
+	if name != "" {
+		query = query.Eq("filename", name)
+	}
+	var rows []davRecord
+	_, err := query.Order("created_at", &postgrest.OrderOpts{Ascending: false}).ExecuteTo(&rows)
+	if err != nil {
+		return nil, fs.fail(apierror.Internal("Failed to list files", err))
+	}
+
+	files := rows[:0]
+	for _, row := range rows {
+		// Top-level files are those in no folder
+		if row.Content.ClientEncryption != "" || (folderID == "" && len(row.Folders) > 0) {
+			continue
+		}
+		files = append(files, row)
+	}
+	if name == "" {
+		if fs.listed == nil {
+			fs.listed = make(map[string][]davRecord)
+		}
+		fs.listed[folderID] = files
+	}
+	return files, nil
+}
+
+// file returns the newest live file called name in folderID, or nil.
+func (fs *davFS) file(folderID, name string) (*davRecord, error) {
+	files, err := fs.files(folderID, name)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	return &files[0], nil
+}
+
+// splitPath cleans a WebDAV path into its segments; the top level has none.
+func splitPath(name string) []string {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return nil
+	}
+	return strings.Split(clean[1:], "/")
+}
+
+// resolve looks up name. A folder shadows a file of the same name beside it.
+func (fs *davFS) resolve(name string) (*davInfo, error) {
+	segments := splitPath(name)
+	if len(segments) == 0 {
+		return &davInfo{name: "/", modTime: fs.user.CreatedAt.Time}, nil
+	}
+	parentID, err := fs.resolveDir(segments[:len(segments)-1])
+	if err != nil {
+		return nil, err
+	}
+	base := segments[len(segments)-1]
+	if folder := fs.subfolder(parentID, base); folder != nil {
+		return &davInfo{name: base, folder: folder, parentID: parentID}, nil
+	}
+	file, err := fs.file(parentID, base)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, os.ErrNotExist
+	}
+	return &davInfo{name: base, file: file, parentID: parentID}, nil
+}
+
+// resolveDir returns the ID of the folder the segments lead to, "" for the top level.
+func (fs *davFS) resolveDir(segments []string) (string, error) {
+	if err := fs.loadFolders(); err != nil {
+		return "", err
+	}
+	folderID := ""
+	for _, segment := range segments {
+		folder := fs.subfolder(folderID, segment)
+		if folder == nil {
+			return "", os.ErrNotExist
+		}
+		folderID = folder.FolderID
+	}
+	return folderID, nil
+}
+
+// resolveParent returns the folder that would hold name and the name within it.
+func (fs *davFS) resolveParent(name string) (string, string, error) {
+	segments := splitPath(name)
+	if len(segments) == 0 {
+		return "", "", os.ErrInvalid
+	}
+	parentID, err := fs.resolveDir(segments[:len(segments)-1])
+	return parentID, segments[len(segments)-1], err
+}
+
+// Stat implements webdav.FileSystem.
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.resolve(name)
+}
+
+// Mkdir implements webdav.FileSystem by creating a folder.
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	parentID, base, err := fs.resolveParent(name)
+	if err != nil {
+		if errors.Is(err, os.ErrInvalid) {
+			return os.ErrExist // The top level
+		}
+		return err
+	}
+	if _, err := fs.resolve(name); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	folder := map[string]interface{}{"owner_id": fs.user.UserID, "name": base, "parent_id": nil}
+	if parentID != "" {
+		folder["parent_id"] = parentID
+	}
+	_, _, err = fs.clients.Postgrest.From("folders").Insert(folder, false, "", "", "").Execute()
+	if err != nil {
+		return fs.fail(apierror.Internal("Failed to create folder", err))
+	}
+	fs.changed()
+	return nil
+}
+
+// OpenFile implements webdav.FileSystem. Files opened for writing are stored when closed.
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	info, err := fs.resolve(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return &davDir{fs: fs, info: info}, nil
+		}
+		return &davReader{fs: fs, ctx: ctx, info: info}, nil
+	}
+
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a folder")}
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+		return nil, err
+	case errors.Is(err, os.ErrNotExist):
+		// A new file, whose folder must exist
+		parentID, base, err := fs.resolveParent(name)
+		if err != nil {
+			return nil, err
+		}
+		info = &davInfo{name: base, file: &davRecord{Filename: base, CreatedAt: models.CustomTime{Time: time.Now()}}, parentID: parentID}
+	case err != nil:
+		return nil, err
+	}
+
+	spool, err := os.CreateTemp("", "file-vault-dav-*")
+	if err != nil {
+		return nil, fs.fail(apierror.Internal("Failed to buffer upload", err))
+	}
+	return &davWriter{fs: fs, ctx: ctx, info: info, existing: info.file.FileID != "", spool: spool}, nil
+}
+
+// RemoveAll implements webdav.FileSystem. Deleting a file goes through the same soft delete
+// and reference counting as the API; deleting a folder deletes everything in it.
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	info, err := fs.resolve(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fs.changed()
+	switch {
+	case info.file != nil:
+		return fs.removeFile(ctx, *info.file, info.parentID)
+	case info.folder != nil:
+		return fs.removeFolder(ctx, *info.folder)
+	}
+	return os.ErrPermission // The top level
+}
+
+// removeFile takes file out of folderID, deleting it unless it is placed in other folders too.
+func (fs *davFS) removeFile(ctx context.Context, file davRecord, folderID string) error {
+	if folderID != "" {
+		_, _, err := fs.clients.Postgrest.From("file_folder_mapping").Delete("", "").
+			Eq("file_id", file.FileID).
+			Eq("folder_id", folderID).
+			Execute()
+		if err != nil {
+			return fs.fail(apierror.Internal("Failed to delete file", err))
+		}
+		var remaining []models.FileFolderMapping
+		_, err = fs.clients.Postgrest.From("file_folder_mapping").Select("file_id,folder_id", "", false).Eq("file_id", file.FileID).ExecuteTo(&remaining)
+		if err != nil {
+			return fs.fail(apierror.Internal("Failed to delete file", err))
+		}
+		if len(remaining) > 0 {
+			return nil
+		}
+	}
+	return fs.fail(deleteUserFile(ctx, fs.clients, fs.content, models.UserFile{FileID: file.FileID, ContentID: file.ContentID}))
+}
+
+func (fs *davFS) removeFolder(ctx context.Context, folder models.Folder) error {
+	if err := fs.loadFolders(); err != nil {
+		return err
+	}
+	var subfolders []models.Folder
+	for _, child := range fs.folders {
+		if folderParent(child) == folder.FolderID {
+			subfolders = append(subfolders, child)
+		}
+	}
+	for _, child := range subfolders {
+		if err := fs.removeFolder(ctx, child); err != nil {
+			return err
+		}
+	}
+	files, err := fs.files(folder.FolderID, "")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := fs.removeFile(ctx, file, folder.FolderID); err != nil {
+			return err
+		}
+	}
+
+	// Files WebDAV does not show, such as end-to-end encrypted ones, move to the top level
+	_, _, err = fs.clients.Postgrest.From("file_folder_mapping").Delete("", "").Eq("folder_id", folder.FolderID).Execute()
+	if err == nil {
+		_, _, err = fs.clients.Postgrest.From("shares").Delete("", "").Eq("folder_id", folder.FolderID).Execute()
+	}
+	if err == nil {
+		_, _, err = fs.clients.Postgrest.From("folders").Delete("", "").Eq("folder_id", folder.FolderID).Execute()
+	}
+	if err != nil {
+		return fs.fail(apierror.Internal("Failed to delete folder", err))
+	}
+	return nil
+}
+
+// Rename implements webdav.FileSystem for MOVE. webdav has already removed an existing
+// destination the client allowed to be overwritten.
+func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	info, err := fs.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	parentID, base, err := fs.resolveParent(newName)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.resolve(newName); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	defer fs.changed()
+
+	switch {
+	case info.file != nil:
+		return fs.moveFile(*info.file, info.parentID, parentID, base)
+	case info.folder != nil:
+		// A folder cannot move into itself or anything below it
+		for id := parentID; id != ""; {
+			if id == info.folder.FolderID {
+				return os.ErrInvalid
+			}
+			next := ""
+			for _, folder := range fs.folders {
+				if folder.FolderID == id {
+					next = folderParent(folder)
+				}
+			}
+			id = next
+		}
+		update := map[string]interface{}{"name": base, "parent_id": nil}
+		if parentID != "" {
+			update["parent_id"] = parentID
+		}
+		_, _, err := fs.clients.Postgrest.From("folders").Update(update, "", "").Eq("folder_id", info.folder.FolderID).Execute()
+		if err != nil {
+			return fs.fail(apierror.Internal("Failed to move folder", err))
+		}
+		return nil
+	}
+	return os.ErrPermission // The top level
+}
+
+// moveFile renames file and moves it from one folder to another.
+func (fs *davFS) moveFile(file davRecord, from, to, name string) error {
+	if name != file.Filename {
+		_, _, err := fs.clients.Postgrest.From("files").Update(map[string]interface{}{"filename": name}, "", "").Eq("file_id", file.FileID).Execute()
+		if err != nil {
+			return fs.fail(apierror.Internal("Failed to rename file", err))
+		}
+	}
+	if from == to {
+		return nil
+	}
+	if from != "" {
+		_, _, err := fs.clients.Postgrest.From("file_folder_mapping").Delete("", "").
+			Eq("file_id", file.FileID).
+			Eq("folder_id", from).
+			Execute()
+		if err != nil {
+			return fs.fail(apierror.Internal("Failed to move file", err))
+		}
+	}
+	if to != "" {
+		_, _, err := fs.clients.Postgrest.From("file_folder_mapping").Insert(models.FileFolderMapping{FileID: file.FileID, FolderID: to}, false, "", "", "").Execute()
+		if err != nil {
+			return fs.fail(apierror.Internal("Failed to move file", err))
+		}
+	}
+	return nil
+}
+
+// davDir is an open folder, which can only be listed.
+type davDir struct {
+	fs      *davFS
+	info    *davInfo
+	entries []os.FileInfo
+	loaded  bool
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		entries, err := d.fs.list(d.info)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// list returns the folders and files directly inside dir, one file per name.
+func (fs *davFS) list(dir *davInfo) ([]os.FileInfo, error) {
+	folderID := ""
+	if dir.folder != nil {
+		folderID = dir.folder.FolderID
+	}
+	if err := fs.loadFolders(); err != nil {
+		return nil, err
+	}
+	files, err := fs.files(folderID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var entries []os.FileInfo
+	for i := range fs.folders {
+		folder := &fs.folders[i]
+		if folderParent(*folder) == folderID && !seen[folder.Name] {
+			seen[folder.Name] = true
+			entries = append(entries, &davInfo{name: folder.Name, folder: folder, parentID: folderID})
+		}
+	}
+	for i := range files {
+		// Files are newest first, so the one a path resolves to is listed
+		if file := &files[i]; !seen[file.Filename] {
+			seen[file.Filename] = true
+			entries = append(entries, &davInfo{name: file.Filename, file: file, parentID: folderID})
+		}
+	}
+	return entries, nil
+}
+
+func (d *davDir) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (d *davDir) Write([]byte) (int, error)      { return 0, os.ErrInvalid }
+func (d *davDir) Seek(int64, int) (int64, error) { return 0, nil }
+func (d *davDir) Stat() (os.FileInfo, error)     { return d.info, nil }
+func (d *davDir) Close() error                   { return nil }
+
+// davReader reads a stored file. The content is opened on the first read and again when a
+// read follows a seek, which http.ServeContent does to answer range requests.
+type davReader struct {
+	fs      *davFS
+	ctx     context.Context
+	info    *davInfo
+	content *models.FileContent
+	body    io.ReadCloser
+	offset  int64 // Position of body
+	pos     int64 // Position of the next read
+}
+
+func (r *davReader) Read(p []byte) (int, error) {
+	if r.pos >= r.info.Size() {
+		return 0, io.EOF
+	}
+	if r.body == nil || r.offset != r.pos {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.pos = r.offset
+	metrics.DownloadedBytes.Add(float64(n))
+	return n, err
+}
+
+// open opens the content positioned at r.pos.
+func (r *davReader) open() error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.content == nil {
+		var fileContent models.FileContent
+		_, err := r.fs.clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", r.info.file.ContentID).ExecuteTo(&fileContent)
+		if err != nil {
+			return r.fs.fail(apierror.Internal("File content not found", err))
+		}
+		r.content = &fileContent
+		recordAccess(r.ctx, r.fs.clients, r.fs.content, fileContent)
+	}
+	body, err := openContent(r.ctx, r.fs.clients, r.fs.content, r.content)
+	if err != nil {
+		return r.fs.fail(apierror.Internal("Failed to download file", err))
+	}
+	if _, err := io.CopyN(io.Discard, body, r.pos); err != nil {
+		body.Close()
+		return r.fs.fail(apierror.Internal("Failed to download file", err))
+	}
+	r.body, r.offset = body, r.pos
+	return nil
+}
+
+func (r *davReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.info.Size()
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *davReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+func (r *davReader) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (r *davReader) Write([]byte) (int, error)          { return 0, os.ErrInvalid }
+func (r *davReader) Stat() (os.FileInfo, error)         { return r.info, nil }
+
+// davWriter buffers a file being written in a temporary file and stores it when closed, as
+// the hash and size have to be known before deduplication and the quota check.
+type davWriter struct {
+	fs       *davFS
+	ctx      context.Context
+	info     *davInfo
+	existing bool // Overwriting info.file rather than creating it
+	spool    *os.File
+	err      error // First write error; what was written is then discarded on Close
+}
+
+func (w *davWriter) Write(p []byte) (int, error) {
+	n, err := w.spool.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *davWriter) Stat() (os.FileInfo, error) {
+	// Close fills in the stored file on this same info, which webdav reads the ETag from
+	if !w.existing {
+		if stat, err := w.spool.Stat(); err == nil {
+			w.info.file.Content.Size = stat.Size()
+		}
+	}
+	return w.info, nil
+}
+
+func (w *davWriter) Read([]byte) (int, error)           { return 0, os.ErrInvalid }
+func (w *davWriter) Seek(int64, int) (int64, error)     { return 0, os.ErrInvalid }
+func (w *davWriter) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+
+func (w *davWriter) Close() error {
+	defer os.Remove(w.spool.Name())
+	defer w.spool.Close()
+	fs := w.fs
+
+	if w.err != nil {
+		return fs.fail(apierror.Internal("Failed to buffer upload", w.err))
+	}
+	stat, err := w.spool.Stat()
+	if err != nil {
+		return fs.fail(apierror.Internal("Failed to buffer upload", err))
+	}
+	size := stat.Size()
+	// webdav closes the file even when copying the body failed. A partial upload must not
+	// replace the file it was meant to overwrite, so it is dropped unstored.
+	if err := fs.body.incomplete(size); err != nil {
+		return fs.fail(apierror.Wrap(err, http.StatusBadRequest, apierror.CodeInvalidRequest, "The upload ended before all of its content arrived"))
+	}
+	mimeType, err := w.mimeType()
+	if err != nil {
+		return fs.fail(err)
+	}
+
+	var replacing int64
+	if w.existing {
+		replacing = w.info.file.Content.Size
+	}
+	fileContent, err := fs.uploads.store(w.ctx, fs.clients, fs.user.UserID, w.spool, size, mimeType, "", replacing)
+	if err != nil {
+		return fs.fail(err)
+	}
+	file := w.info.file
+	if w.existing {
+		err = w.replace(fileContent)
+	} else {
+		err = w.create(fileContent)
+	}
+	if err != nil {
+		// The new content is not referenced after all
+		if releaseErr := releaseContent(w.ctx, fs.clients, fs.content, fileContent.ContentID); releaseErr != nil {
+			logging.FromContext(w.ctx).Error("Error releasing content of a failed WebDAV upload", "content_id", fileContent.ContentID, "error", releaseErr)
+		}
+		return fs.fail(err)
+	}
+	fs.changed()
+
+	file.ContentID = fileContent.ContentID
+	file.Content = models.FileContentSummary{Size: size, MimeType: mimeType, HashSHA256: fileContent.HashSHA256, HashMD5: fileContent.HashMD5}
+	return nil
+}
+
+// davBody is the body of a PUT. It keeps the count of bytes read and the first read error,
+// which webdav reports to the client but not to the file being written.
+type davBody struct {
+	io.ReadCloser
+	length int64 // Content-Length, -1 when unknown
+	n      int64
+	err    error
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// incomplete returns why a file of size bytes written from b is not the whole body, or nil
+// if it is. A nil b has nothing to check against.
+func (b *davBody) incomplete(size int64) error {
+	switch {
+	case b == nil:
+		return nil
+	case b.err != nil:
+		return b.err
+	case b.length >= 0 && size != b.length:
+		return fmt.Errorf("received %d of %d bytes", size, b.length)
+	case size != b.n:
+		return fmt.Errorf("stored %d of %d bytes received", size, b.n)
+	}
+	return nil
+}
+
+// abort discards what was written without storing it.
+func (w *davWriter) abort() {
+	w.spool.Close()
+	os.Remove(w.spool.Name())
+}
+
+// mimeType sniffs the buffered content. A type the client declared must match it, as for
+// uploads over the API; clients that declare none or application/octet-stream get the
+// sniffed type.
+func (w *davWriter) mimeType() (string, error) {
+	buffer := make([]byte, 512)
+	n, err := w.spool.ReadAt(buffer, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", apierror.Internal("Failed to read file for MIME type detection", err)
+	}
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return "", apierror.Internal("Failed to read file", err)
+	}
+	detected := http.DetectContentType(buffer[:n])
+
+	declared := w.fs.declaredType
+	if declared != "" {
+		if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType == "application/octet-stream" {
+			declared = ""
+		}
+	}
+	if declared == "" {
+		return detected, nil
+	}
+	return declared, matchMimeType(declared, detected)
+}
+
+// create adds the 'files' row of a new file, in its folder unless it is at the top level.
+func (w *davWriter) create(fileContent models.FileContent) error {
+	fs := w.fs
+	var created []models.UserFile
+	_, err := fs.clients.Postgrest.From("files").Insert(models.UserFile{
+		OwnerID:   fs.user.UserID,
+		ContentID: fileContent.ContentID,
+		Filename:  w.info.name,
+		CreatedAt: models.CustomTime{Time: time.Now()},
+	}, false, "", "representation", "").ExecuteTo(&created)
+	if err != nil || len(created) == 0 {
+		return apierror.Internal("Failed to create file entry", err)
+	}
+	w.info.file.FileID = created[0].FileID
+	w.info.file.CreatedAt = created[0].CreatedAt
+
+	if w.info.parentID != "" {
+		mapping := models.FileFolderMapping{FileID: created[0].FileID, FolderID: w.info.parentID}
+		if _, _, err := fs.clients.Postgrest.From("file_folder_mapping").Insert(mapping, false, "", "", "").Execute(); err != nil {
+			// Leave no file behind at the top level instead
+			fs.clients.Postgrest.From("files").Delete("", "").Eq("file_id", created[0].FileID).Execute()
+			return apierror.Internal("Failed to place file in folder", err)
+		}
+	}
+	return nil
+}
+
+// replace points an existing file at new content and releases the content it had. The
+// creation time is moved on as well, as it is all clients have to tell a changed file by.
+func (w *davWriter) replace(fileContent models.FileContent) error {
+	fs := w.fs
+	previous := w.info.file.ContentID
+	now := time.Now().UTC()
+	_, _, err := fs.clients.Postgrest.From("files").Update(map[string]interface{}{
+		"content_id": fileContent.ContentID,
+		"created_at": now,
+	}, "", "").Eq("file_id", w.info.file.FileID).Execute()
+	if err != nil {
+		return apierror.Internal("Failed to update file", err)
+	}
+	w.info.file.CreatedAt = models.CustomTime{Time: now}
+
+	if err := releaseContent(w.ctx, fs.clients, fs.content, previous); err != nil {
+		logging.FromContext(w.ctx).Error("Error releasing replaced content", "content_id", previous, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// putWriter returns a writer overwriting an existing file as a WebDAV PUT of body would.
+// fs has no upload store, so a writer that tried to store what it received would panic.
+func putWriter(t *testing.T, body io.Reader, length int64) (*davFS, *davWriter) {
+	t.Helper()
+	spool, err := os.CreateTemp(t.TempDir(), "spool-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &davFS{body: &davBody{ReadCloser: io.NopCloser(body), length: length}}
+	info := &davInfo{name: "report.txt", file: &davRecord{FileID: "file-1", Filename: "report.txt"}}
+	return fs, &davWriter{fs: fs, ctx: context.Background(), info: info, existing: true, spool: spool}
+}
+
+func TestDavWriterDropsUploadCutOffMidBody(t *testing.T) {
+	body := io.MultiReader(strings.NewReader("the first half"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	fs, w := putWriter(t, body, 28)
+
+	// What webdav's PUT handler does: copy the body, then close the file whatever happened
+	if _, err := io.Copy(w, fs.body); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("copy error = %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Close stored a truncated upload")
+	}
+	if fs.failure == nil || fs.failure.Status != http.StatusBadRequest {
+		t.Fatalf("failure = %+v, want a 400", fs.failure)
+	}
+	if _, err := os.Stat(w.spool.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("spool file was not removed: %v", err)
+	}
+}
+
+func TestDavWriterDropsUploadShorterThanContentLength(t *testing.T) {
+	fs, w := putWriter(t, strings.NewReader("short"), 1024)
+	if _, err := io.Copy(w, fs.body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Close stored an upload shorter than its Content-Length")
+	}
+}
+
+func TestDavWriterDropsUploadAfterWriteError(t *testing.T) {
+	fs, w := putWriter(t, strings.NewReader("payload"), 7)
+	w.spool.Close() // Every write now fails
+	if _, err := io.Copy(w, fs.body); err == nil {
+		t.Fatal("copy into a closed spool succeeded")
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Close stored an upload whose spooling failed")
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// UploadCheckRequest describes a file the client wants to upload without sending its bytes.
+type UploadCheckRequest struct {
+	HashSHA256 string `json:"hash_sha256" binding:"required"`
+	Size       int64  `json:"size"`
+	Filename   string `json:"filename" binding:"required"`
+}
+
+// UploadProofRequest answers a proof-of-ownership challenge.
+type UploadProofRequest struct {
+	ChallengeID string   `json:"challenge_id" binding:"required"`
+	Proofs      []string `json:"proofs" binding:"required"`
+	WrappedKey  string   `json:"wrapped_key"` // Required when the existing content is end-to-end encrypted
+}
+
+// CheckUpload lets a client skip uploading content the server already holds. When the hash
+// matches content in the uploader's dedup scope, a proof-of-ownership challenge is returned
+// for ProveUpload; otherwise the client falls back to a normal upload.
+func CheckUpload(clients *database.AppClients, challenges *dedup.ChallengeStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		ownerID := c.Query("owner_id")
+		if ownerID == "" {
+			apierror.Respond(c, apierror.BadRequest("Owner ID is required"))
+			return
+		}
+
+		var req UploadCheckRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+		if !sha256HexPattern.MatchString(req.HashSHA256) || req.Size < 0 {
+			apierror.Respond(c, apierror.BadRequest("hash_sha256 must be a lowercase hex SHA-256 and size non-negative"))
+			return
+		}
+
+		user, err := checkQuota(clients, ownerID, req.Size)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		fileContent, err := findDedupCandidate(clients, ownerID, user.OrganizationID, req.HashSHA256)
+		if err != nil || fileContent.Size != req.Size {
+			c.JSON(http.StatusOK, gin.H{"exists": false})
+			return
+		}
+
+		challenge, err := dedup.NewChallenge(ownerID, fileContent.ContentID, req.Filename, fileContent.Size)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to create challenge", err))
+			return
+		}
+		if err := challenges.Add(challenge); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"exists": true, "challenge": challenge})
+	}
+}
+
+// findDedupCandidate looks up content a pre-upload check may deduplicate against: content
+// with the hash in the uploader's dedup scope, whoever stored it. In global scope that is
+// anyone's content; only a client holding the whole file can answer the challenge that
+// stands between the match and a new reference to it.
+func findDedupCandidate(clients *database.AppClients, ownerID, organizationID, hash string) (models.FileContent, error) {
+	var fileContent models.FileContent
+	_, err := clients.Postgrest.From("file_contents").Select("*", "", false).Single().
+		Eq("hash_sha256", hash).
+		Eq("dedup_scope", clients.Dedup.Key(ownerID, organizationID)).
+		ExecuteTo(&fileContent)
+	return fileContent, err
+}
+
+// ProveUpload verifies the answer to a CheckUpload challenge against the stored content and,
+// if it is correct, creates the file row pointing at the existing content without any upload.
+func ProveUpload(clients *database.AppClients, challenges *dedup.ChallengeStore) gin.HandlerFunc {
+	content := clients.ContentStore()
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		ownerID := c.Query("owner_id")
+		if ownerID == "" {
+			apierror.Respond(c, apierror.BadRequest("Owner ID is required"))
+			return
+		}
+
+		var req UploadProofRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+
+		challenge, err := challenges.Take(req.ChallengeID, ownerID)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var fileContent models.FileContent
+		_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", challenge.ContentID).ExecuteTo(&fileContent)
+		if err != nil {
+			// The last reference was deleted since the challenge was issued
+			apierror.Respond(c, apierror.Wrap(err, http.StatusConflict, apierror.CodeContentGone, "Content is no longer stored; upload the file instead"))
+			return
+		}
+		if fileContent.ClientEncryption != "" && req.WrappedKey == "" {
+			apierror.Respond(c, apierror.BadRequest("wrapped_key is required for end-to-end encrypted content"))
+			return
+		}
+
+		// Usage may have changed since the check
+		if _, err := checkQuota(clients, ownerID, fileContent.Size); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		blob, err := openContent(c.Request.Context(), clients, content, &fileContent)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to verify proof", err))
+			return
+		}
+		ok, err := challenge.Verify(blob, req.Proofs)
+		blob.Close()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to verify proof", err))
+			return
+		}
+		if !ok {
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeProofFailed, "Proof of ownership failed"))
+			return
+		}
+
+		if err := addContentReference(clients, &fileContent); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		newFile, err := createUserFile(clients, ownerID, fileContent.ContentID, challenge.Filename)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if fileContent.ClientEncryption != "" {
+			if err := storeFileKey(clients, newFile.FileID, ownerID, req.WrappedKey); err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+		}
+
+		// The bytes were never sent, so this saved the whole upload
+		recordDedup(true, fileContent.Size)
+		c.JSON(http.StatusOK, newFile)
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+func TestCheckUploadMatchesContentInScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hash := strings.Repeat("ab", 32)
+	cases := []struct {
+		scope dedup.Scope
+		key   string
+	}{
+		// Content stored by another user: the challenge decides whether alice gets to use it
+		{dedup.ScopeGlobal, "global"},
+		{dedup.ScopeOrganization, "org:acme"},
+		{dedup.ScopeUser, "user:alice"},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.scope), func(t *testing.T) {
+			var lookedUp string
+			rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				query := r.URL.Query()
+				switch {
+				case r.URL.Path == "/users":
+					json.NewEncoder(w).Encode(map[string]interface{}{"storage_quota": 1 << 30, "organization_id": "acme"})
+				case r.URL.Path == "/file_contents" && query.Get("hash_sha256") != "":
+					if query.Get("hash_sha256") != "eq."+hash || query.Has("owner_id") {
+						t.Errorf("unexpected candidate lookup %s", r.URL.RawQuery)
+					}
+					lookedUp = query.Get("dedup_scope")
+					json.NewEncoder(w).Encode(models.FileContent{ContentID: "stored-by-bob", HashSHA256: hash, Size: 4096, DedupScope: tc.key})
+				default:
+					w.Write([]byte(`[]`))
+				}
+			}))
+			defer rest.Close()
+			clients := &database.AppClients{Postgrest: postgrest.NewClient(rest.URL, "", nil), Dedup: tc.scope}
+			challenges := dedup.NewChallengeStore()
+
+			router := gin.New()
+			router.POST("/upload/check", CheckUpload(clients, challenges))
+			rec := httptest.NewRecorder()
+			body := `{"hash_sha256":"` + hash + `","size":4096,"filename":"report.pdf"}`
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/upload/check?owner_id=alice", strings.NewReader(body)))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if lookedUp != "eq."+tc.key {
+				t.Fatalf("looked up dedup_scope %q, want eq.%s", lookedUp, tc.key)
+			}
+			var resp struct {
+				Exists    bool             `json:"exists"`
+				Challenge *dedup.Challenge `json:"challenge"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !resp.Exists || resp.Challenge == nil || len(resp.Challenge.Ranges) == 0 {
+				t.Fatalf("no challenge for matching content: %s", rec.Body)
+			}
+			challenge, err := challenges.Take(resp.Challenge.ID, "alice")
+			if err != nil {
+				t.Fatalf("challenge not stored for alice: %v", err)
+			}
+			if challenge.ContentID != "stored-by-bob" || challenge.Filename != "report.pdf" {
+				t.Fatalf("challenge binds %q/%q", challenge.ContentID, challenge.Filename)
+			}
+		})
+	}
+}
+
+func TestCheckUploadSizeMismatchIsMiss(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/users":
+			w.Write([]byte(`{"storage_quota": 1073741824}`))
+		case r.URL.Query().Get("hash_sha256") != "":
+			json.NewEncoder(w).Encode(models.FileContent{ContentID: "c1", Size: 10})
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer rest.Close()
+	clients := &database.AppClients{Postgrest: postgrest.NewClient(rest.URL, "", nil), Dedup: dedup.ScopeGlobal}
+
+	router := gin.New()
+	router.POST("/upload/check", CheckUpload(clients, dedup.NewChallengeStore()))
+	rec := httptest.NewRecorder()
+	body := `{"hash_sha256":"` + strings.Repeat("cd", 32) + `","size":11,"filename":"a.txt"}`
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/upload/check?owner_id=alice", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"exists":false}` {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// x25519KeySize is the length of the public keys clients register for end-to-end sharing.
+const x25519KeySize = 32
+
+// SetPublicKey stores the authenticated user's X25519 public key, which other users wrap
+// file keys for when sharing end-to-end encrypted files with them.
+func SetPublicKey(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var payload struct {
+			PublicKey string `json:"public_key" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(payload.PublicKey)
+		if err != nil || len(raw) != x25519KeySize {
+			apierror.Respond(c, apierror.BadRequest("public_key must be a base64-encoded 32-byte X25519 key"))
+			return
+		}
+
+		userID := c.GetString("userID")
+		_, _, err = clients.Postgrest.From("users").Update(map[string]interface{}{"public_key": payload.PublicKey}, "", "").Eq("user_id", userID).Execute()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to store public key", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Public key updated successfully"})
+	}
+}
+
+// GetPublicKey returns another user's public key so the caller can wrap a file key for them.
+func GetPublicKey(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var user models.User
+		_, err := clients.Postgrest.From("users").Select("user_id,username,public_key", "", false).Single().Eq("user_id", c.Param("id")).ExecuteTo(&user)
+		if err != nil {
+			apierror.Respond(c, apierror.NotFound("User not found"))
+			return
+		}
+		if user.PublicKey == "" {
+			apierror.Respond(c, apierror.NotFound("User has not registered a public key"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":    user.UserID,
+			"username":   user.Username,
+			"public_key": user.PublicKey,
+		})
+	}
+}
+
+// GetFileKey returns the file key of an end-to-end encrypted file, wrapped for the authenticated user.
+func GetFileKey(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var fileKey models.FileKey
+		_, err := clients.Postgrest.From("file_keys").Select("*", "", false).Single().
+			Eq("file_id", c.Param("id")).
+			Eq("recipient_id", c.GetString("userID")).
+			ExecuteTo(&fileKey)
+		if err != nil {
+			apierror.Respond(c, apierror.NotFound("No file key has been shared with you for this file"))
+			return
+		}
+
+		c.JSON(http.StatusOK, fileKey)
+	}
+}
+
+// ShareFileKey shares an end-to-end encrypted file with another user. The owner's client unwraps
+// the file key and re-wraps it for the recipient's public key; the server only stores the result.
+func ShareFileKey(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		fileID := c.Param("id")
+		var payload struct {
+			RecipientID string `json:"recipient_id" binding:"required"`
+			WrappedKey  string `json:"wrapped_key" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+
+		// Verify the user owns the file
+		var userFile models.UserFile
+		_, err := clients.Postgrest.From("files").Select("owner_id", "", false).Single().Eq("file_id", fileID).ExecuteTo(&userFile)
+		if err != nil {
+			apierror.Respond(c, apierror.NotFound("File not found or not owned by user"))
+			return
+		}
+		if userFile.OwnerID != c.GetString("userID") {
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotOwner, "You do not have permission to share this file"))
+			return
+		}
+
+		var recipient models.User
+		_, err = clients.Postgrest.From("users").Select("user_id", "", false).Single().Eq("user_id", payload.RecipientID).ExecuteTo(&recipient)
+		if err != nil {
+			apierror.Respond(c, apierror.NotFound("Recipient not found"))
+			return
+		}
+
+		fileKey := models.FileKey{
+			FileID:      fileID,
+			RecipientID: payload.RecipientID,
+			WrappedKey:  payload.WrappedKey,
+			CreatedAt:   models.CustomTime{Time: time.Now()},
+		}
+		_, _, err = clients.Postgrest.From("file_keys").Insert(fileKey, true, "file_id,recipient_id", "", "").Execute()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to share file key", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "File shared successfully"})
+	}
+}
+
+// ListFilesSharedWithMe lists end-to-end encrypted files other users have shared with the authenticated user.
+func ListFilesSharedWithMe(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		userID := c.GetString("userID")
+
+		var shared []struct {
+			WrappedKey string                  `json:"wrapped_key"`
+			File       models.FileSearchResult `json:"files"`
+		}
+		_, err := clients.Postgrest.From("file_keys").
+			Select("wrapped_key,files!inner(file_id,owner_id,filename,is_deleted,created_at,file_contents(size,mime_type,client_encryption),users(username))", "", false).
+			Eq("recipient_id", userID).
+			Neq("files.owner_id", userID).
+			Eq("files.is_deleted", "false").
+			ExecuteTo(&shared)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to list shared files", err))
+			return
+		}
+
+		files := make([]models.FileSearchResult, 0, len(shared))
+		for _, s := range shared {
+			files = append(files, s.File)
+		}
+		c.JSON(http.StatusOK, files)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/database"
+	"file-vault/backend/pkg/e2e"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+func TestSetPublicKeyStoresClientIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id, err := e2e.NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored map[string]string
+	rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/users" || r.URL.Query().Get("user_id") != "eq.alice" {
+			t.Errorf("unexpected PostgREST call %s %s", r.Method, r.URL)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &stored); err != nil {
+			t.Errorf("decode update: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer rest.Close()
+	clients := &database.AppClients{Postgrest: postgrest.NewClient(rest.URL, "", nil)}
+
+	rec := servePublicKey(clients, `{"public_key":"`+id.PublicKey()+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if stored["public_key"] != id.PublicKey() {
+		t.Fatalf("stored public key %q, want %q", stored["public_key"], id.PublicKey())
+	}
+}
+
+func TestSetPublicKeyRejectsMalformedKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		rec := servePublicKey(&database.AppClients{}, `{"public_key":"`+key+`"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("key %q: status = %d, want 400", key, rec.Code)
+		}
+	}
+}
+
+func servePublicKey(clients *database.AppClients, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.PUT("/user/public-key", func(c *gin.Context) { c.Set("userID", "alice") }, SetPublicKey(clients))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/user/public-key", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	return rec
+}
@@ -1,217 +1,365 @@
 package handlers
 
 import (
+	"context"
+	"crypto/md5"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
+	"file-vault/backend/internal/apierror"
 	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/metrics"
 	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // UploadFile handles the core logic for file uploads and deduplication.
-func UploadFile(clients *database.AppClients, bucketName string) gin.HandlerFunc {
+func UploadFile(clients *database.AppClients) gin.HandlerFunc {
+	uploads := newUploadStore(clients)
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		file, header, err := c.Request.FormFile("file")
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusBadRequest, apierror.CodeInvalidRequest, "File is required"))
 			return
 		}
 		defer file.Close()
 
-		// 1. Validate MIME type
-		buffer := make([]byte, 512)
-		_, err = file.Read(buffer)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file for MIME type detection"})
+		// 1. Validate MIME type. End-to-end encrypted uploads are ciphertext the server cannot
+		// inspect, so they skip sniffing and are stored opaque with the wrapped file key.
+		mimeType := header.Header.Get("Content-Type")
+		clientEncryption := c.PostForm("e2e")
+		wrappedKey := c.PostForm("wrapped_key")
+		if clientEncryption != "" {
+			if !models.IsClientEncryption(clientEncryption) {
+				apierror.Respond(c, apierror.BadRequest(fmt.Sprintf("Unsupported e2e mode: %s", clientEncryption)))
+				return
+			}
+			if wrappedKey == "" {
+				apierror.Respond(c, apierror.BadRequest("wrapped_key is required for end-to-end encrypted uploads"))
+				return
+			}
+			mimeType = "application/octet-stream"
+		} else if err := validateMimeType(file, header); err != nil {
+			apierror.Respond(c, err)
 			return
 		}
-		file.Seek(0, 0) // Reset file reader
-
-		detectedMimeType := http.DetectContentType(buffer)
-		declaredMimeTypeHeader := header.Header.Get("Content-Type")
 
-		if declaredMimeTypeHeader == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "MIME type for the file part is not declared in Content-Type header"})
+		ownerID := c.Query("owner_id")
+		if ownerID == "" {
+			apierror.Respond(c, apierror.BadRequest("Owner ID is required"))
 			return
 		}
 
-		// Parse the media types to ignore parameters like charset and ensure a clean comparison
-		parsedDeclaredMimeType, _, err := mime.ParseMediaType(declaredMimeTypeHeader)
+		// 2. Store the content, or reference identical content already stored
+		fileContent, err := uploads.store(c.Request.Context(), clients, ownerID, file, header.Size, mimeType, clientEncryption, 0)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid Content-Type header format: %s", declaredMimeTypeHeader)})
+			apierror.Respond(c, err)
 			return
 		}
 
-		parsedDetectedMimeType, _, err := mime.ParseMediaType(detectedMimeType)
+		// 3. Create the logical file entry in the 'files' table
+		newFile, err := createUserFile(clients, ownerID, fileContent.ContentID, header.Filename)
 		if err != nil {
-			// This is unlikely to fail for http.DetectContentType output, but handle defensively
-			log.Printf("Could not parse detected MIME type: %s", detectedMimeType)
-			parsedDetectedMimeType = detectedMimeType // Fallback to raw value
-		}
-
-		if parsedDetectedMimeType != parsedDeclaredMimeType {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("MIME type mismatch: declared '%s', detected '%s'", parsedDeclaredMimeType, parsedDetectedMimeType)})
+			apierror.Respond(c, err)
 			return
 		}
 
-		// 2. Calculate SHA256 hash
-		hash := sha256.New()
-		if _, err := io.Copy(hash, file); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate file hash"})
-			return
+		// 4. Keep the owner's wrapped file key for end-to-end encrypted uploads
+		if clientEncryption != "" {
+			if err := storeFileKey(clients, newFile.FileID, ownerID, wrappedKey); err != nil {
+				apierror.Respond(c, err)
+				return
+			}
 		}
-		hashString := fmt.Sprintf("%x", hash.Sum(nil))
-		file.Seek(0, 0) // Reset file reader
 
-		ownerID := c.Query("owner_id")
-		if ownerID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Owner ID is required"})
-			return
-		}
+		c.JSON(http.StatusOK, newFile)
+	}
+}
 
-		// Check storage quota
-		var user models.User
-		_, err = clients.Postgrest.From("users").Select("storage_quota", "", false).Single().Eq("user_id", ownerID).ExecuteTo(&user)
-		if err != nil {
-			log.Printf("Error fetching user for quota check: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
+// uploadStore saves uploaded content, sharing what is already stored within the uploader's
+// dedup scope. UploadFile and the WebDAV share store files through it.
+type uploadStore struct {
+	content *storage.ContentStore
+	// Global dedup would otherwise let uploaders time whether someone else stored the same file
+	equalizer *dedup.Equalizer
+}
 
-		var userFiles []models.UserFile
-		_, err = clients.Postgrest.From("files").Select("content_id", "", false).Eq("owner_id", ownerID).Eq("is_deleted", "false").ExecuteTo(&userFiles)
-		if err != nil {
-			log.Printf("Error fetching user files for quota check: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user files"})
-			return
-		}
+func newUploadStore(clients *database.AppClients) *uploadStore {
+	uploads := &uploadStore{content: clients.ContentStore()}
+	if clients.Dedup == dedup.ScopeGlobal {
+		uploads.equalizer = dedup.NewEqualizer()
+	}
+	return uploads
+}
 
-		var allFileContents []models.FileContent
-		_, err = clients.Postgrest.From("file_contents").Select("content_id,size", "", false).ExecuteTo(&allFileContents)
-		if err != nil {
-			log.Printf("Error fetching all file contents for quota check: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate storage usage"})
-			return
-		}
+// store adds size bytes of src to ownerID's storage, after checking their quota, and returns
+// the file_contents row the new file is to reference: identical content already stored in the
+// owner's dedup scope, whose reference count it increments, or a new blob with a count of 1.
+// replacing is the size of content the new file supersedes, which is not counted twice.
+func (u *uploadStore) store(ctx context.Context, clients *database.AppClients, ownerID string, src io.ReadSeeker, size int64, mimeType, clientEncryption string, replacing int64) (models.FileContent, error) {
+	// The MD5 is only kept for S3 clients, which expect it as the ETag
+	hash, md5Hash := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(hash, md5Hash), src); err != nil {
+		return models.FileContent{}, apierror.Internal("Failed to calculate file hash", err)
+	}
+	hashString := fmt.Sprintf("%x", hash.Sum(nil))
+	md5String := fmt.Sprintf("%x", md5Hash.Sum(nil))
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return models.FileContent{}, apierror.Internal("Failed to read file", err)
+	}
 
-		contentSizes := make(map[string]int64)
-		for _, content := range allFileContents {
-			contentSizes[content.ContentID] = content.Size
-		}
+	user, err := checkQuota(clients, ownerID, size-replacing)
+	if err != nil {
+		return models.FileContent{}, err
+	}
 
-		var storageUsed int64
-		for _, file := range userFiles {
-			storageUsed += contentSizes[file.ContentID]
+	// Check if file content already exists within the uploader's dedup scope
+	scopeKey := clients.Dedup.Key(ownerID, user.OrganizationID)
+	var fileContent models.FileContent
+	_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("hash_sha256", hashString).Eq("dedup_scope", scopeKey).ExecuteTo(&fileContent)
+	hit := err == nil
+
+	if !hit {
+		// --- NEW FILE CONTENT ---
+		// Upload the physical file
+		fileContent = models.FileContent{
+			ContentID:      uuid.New().String(),
+			HashSHA256:     hashString,
+			HashMD5:        md5String,
+			Size:           size,
+			MimeType:       mimeType,
+			StoragePath:    storage.ContentKey(hashString),
+			ReferenceCount: 1, // Start with 1 since we are creating the first reference
+			CreatedAt:      models.CustomTime{Time: time.Now()},
+			// Convergent ciphertext is deterministic, so identical files still deduplicate by hash
+			ClientEncryption: clientEncryption,
+			DedupScope:       scopeKey,
+		}
+		// Save compresses and encrypts the blob, recording the codec and wrapped data key on fileContent
+		saveStart := time.Now()
+		if err := u.content.Save(ctx, &fileContent, src); err != nil {
+			return models.FileContent{}, apierror.Internal("Failed to upload file", err)
+		}
+		if u.equalizer != nil {
+			u.equalizer.Observe(size, time.Since(saveStart))
+		}
+
+		// Create a new file_contents entry with reference_count = 1
+		_, _, dbErr := clients.Postgrest.From("file_contents").Insert(fileContent, false, "", "", "").Execute()
+		if dbErr != nil {
+			return models.FileContent{}, apierror.Internal("Failed to create file content entry", dbErr)
+		}
+	} else {
+		// --- DUPLICATE FILE CONTENT ---
+		// Increment the reference_count for the existing content
+		if err := addContentReference(clients, &fileContent); err != nil {
+			return models.FileContent{}, err
+		}
+		if fileContent.HashMD5 == "" {
+			// Content stored before MD5s were recorded
+			_, _, err := clients.Postgrest.From("file_contents").Update(map[string]interface{}{"hash_md5": md5String}, "", "").Eq("content_id", fileContent.ContentID).Execute()
+			if err != nil {
+				logging.FromContext(ctx).Error("Error recording content MD5", "content_id", fileContent.ContentID, "error", err)
+			}
+			fileContent.HashMD5 = md5String
 		}
+	}
+	if u.equalizer != nil {
+		// Hold hits for as long as the skipped write would have taken, and jitter misses to match
+		u.equalizer.Wait(ctx, size, hit)
+	}
 
-		if storageUsed+header.Size > user.StorageQuota {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Storage quota exceeded"})
-			return
-		}
+	metrics.UploadedBytes.Add(float64(size))
+	recordDedup(hit, size)
+	return fileContent, nil
+}
 
-		// 2. Check if file content already exists
-		var fileContent models.FileContent
-		_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("hash_sha256", hashString).ExecuteTo(&fileContent)
+// recordDedup counts an upload of size bytes that did (hit) or did not find its content already stored.
+func recordDedup(hit bool, size int64) {
+	if hit {
+		metrics.DedupLookups.WithLabelValues("hit").Inc()
+		metrics.DedupSavedBytes.Add(float64(size))
+	} else {
+		metrics.DedupLookups.WithLabelValues("miss").Inc()
+	}
+}
 
-		if err != nil {
-			// --- NEW FILE CONTENT ---
-			// 3a. Upload the physical file
-			storagePath := uuid.New().String()
-			_, uploadErr := clients.Storage.UploadFile(bucketName, "uploads/"+storagePath, file)
-			if uploadErr != nil {
-				log.Printf("Error uploading file to storage: %v", uploadErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
-				return
-			}
+// checkQuota fetches the uploader and verifies that size more bytes fit in their storage quota.
+func checkQuota(clients *database.AppClients, ownerID string, size int64) (models.User, error) {
+	var user models.User
+	_, err := clients.Postgrest.From("users").Select("storage_quota,organization_id", "", false).Single().Eq("user_id", ownerID).ExecuteTo(&user)
+	if err != nil {
+		return user, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "User not found")
+	}
 
-			// 3b. Create a new file_contents entry with reference_count = 1
-			fileContent = models.FileContent{
-				ContentID:      uuid.New().String(),
-				HashSHA256:     hashString,
-				Size:           header.Size,
-				MimeType:       header.Header.Get("Content-Type"),
-				StoragePath:    storagePath,
-				ReferenceCount: 1, // Start with 1 since we are creating the first reference
-				CreatedAt:      models.CustomTime{Time: time.Now()},
-			}
-			_, _, dbErr := clients.Postgrest.From("file_contents").Insert(fileContent, false, "", "", "").Execute()
-			if dbErr != nil {
-				log.Printf("Error creating file content entry: %v", dbErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file content entry"})
-				return
-			}
-		} else {
-			// --- DUPLICATE FILE CONTENT ---
-			// 3c. Increment the reference_count for the existing content
-			newRefCount := fileContent.ReferenceCount + 1
-			_, _, updateErr := clients.Postgrest.From("file_contents").Update(map[string]interface{}{"reference_count": newRefCount}, "", "").Eq("content_id", fileContent.ContentID).Execute()
-			if updateErr != nil {
-				log.Printf("Error incrementing reference count: %v", updateErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to increment reference count"})
-				return
-			}
-		}
+	var userFiles []models.UserFile
+	_, err = clients.Postgrest.From("files").Select("content_id", "", false).Eq("owner_id", ownerID).Eq("is_deleted", "false").ExecuteTo(&userFiles)
+	if err != nil {
+		return user, apierror.Internal("Failed to fetch user files", err)
+	}
 
-		// 4. Create the logical file entry in the 'files' table
-		finalFilename := header.Filename
-		var existingUserFiles []models.UserFile
-		_, err = clients.Postgrest.From("files").Select("*", "", false).Eq("owner_id", ownerID).Eq("filename", finalFilename).ExecuteTo(&existingUserFiles)
-		if err != nil {
-			log.Printf("Error checking for existing filename: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for existing filename"})
-			return
-		}
+	var allFileContents []models.FileContent
+	_, err = clients.Postgrest.From("file_contents").Select("content_id,size", "", false).ExecuteTo(&allFileContents)
+	if err != nil {
+		return user, apierror.Internal("Failed to calculate storage usage", err)
+	}
 
-		if len(existingUserFiles) > 0 {
-			finalFilename = fmt.Sprintf("%s-%s", uuid.New().String()[:8], header.Filename)
-		}
+	contentSizes := make(map[string]int64)
+	for _, content := range allFileContents {
+		contentSizes[content.ContentID] = content.Size
+	}
 
-		newFile := models.UserFile{
-			FileID:    uuid.New().String(),
-			OwnerID:   ownerID,
-			ContentID: fileContent.ContentID,
-			Filename:  finalFilename,
-			CreatedAt: models.CustomTime{Time: time.Now()},
-		}
-		_, _, err = clients.Postgrest.From("files").Insert(newFile, false, "", "", "").Execute()
-		if err != nil {
-			log.Printf("Error creating file entry: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file entry"})
-			return
-		}
+	var storageUsed int64
+	for _, file := range userFiles {
+		storageUsed += contentSizes[file.ContentID]
+	}
 
-		c.JSON(http.StatusOK, newFile)
+	if storageUsed+size > user.StorageQuota {
+		metrics.QuotaRejections.Inc()
+		return user, apierror.New(http.StatusForbidden, apierror.CodeQuotaExceeded, "Storage quota exceeded").
+			With("storage_quota", user.StorageQuota).
+			With("storage_used", storageUsed)
+	}
+	return user, nil
+}
+
+// addContentReference increments the reference count of existing content a new file points at.
+func addContentReference(clients *database.AppClients, fileContent *models.FileContent) error {
+	newRefCount := fileContent.ReferenceCount + 1
+	_, _, err := clients.Postgrest.From("file_contents").Update(map[string]interface{}{"reference_count": newRefCount}, "", "").Eq("content_id", fileContent.ContentID).Execute()
+	if err != nil {
+		return apierror.Internal("Failed to increment reference count", err)
+	}
+	fileContent.ReferenceCount = newRefCount
+	return nil
+}
+
+// createUserFile inserts the 'files' row for an upload, prefixing the filename when the
+// owner already has a file with that name. Deleted files do not hold on to their names.
+func createUserFile(clients *database.AppClients, ownerID, contentID, filename string) (models.UserFile, error) {
+	finalFilename := filename
+	var existingUserFiles []models.UserFile
+	_, err := clients.Postgrest.From("files").Select("file_id", "", false).Eq("owner_id", ownerID).Eq("filename", finalFilename).Eq("is_deleted", "false").ExecuteTo(&existingUserFiles)
+	if err != nil {
+		return models.UserFile{}, apierror.Internal("Failed to check for existing filename", err)
+	}
+
+	if len(existingUserFiles) > 0 {
+		finalFilename = fmt.Sprintf("%s-%s", uuid.New().String()[:8], filename)
+	}
+
+	newFile := models.UserFile{
+		FileID:    uuid.New().String(),
+		OwnerID:   ownerID,
+		ContentID: contentID,
+		Filename:  finalFilename,
+		CreatedAt: models.CustomTime{Time: time.Now()},
+	}
+	_, _, err = clients.Postgrest.From("files").Insert(newFile, false, "", "", "").Execute()
+	if err != nil {
+		return models.UserFile{}, apierror.Internal("Failed to create file entry", err)
+	}
+	return newFile, nil
+}
+
+// storeFileKey records the owner's wrapped key for an end-to-end encrypted file.
+func storeFileKey(clients *database.AppClients, fileID, ownerID, wrappedKey string) error {
+	fileKey := models.FileKey{
+		FileID:      fileID,
+		RecipientID: ownerID,
+		WrappedKey:  wrappedKey,
+		CreatedAt:   models.CustomTime{Time: time.Now()},
+	}
+	_, _, err := clients.Postgrest.From("file_keys").Insert(fileKey, false, "", "", "").Execute()
+	if err != nil {
+		return apierror.Internal("Failed to store file key", err)
 	}
+	return nil
+}
+
+// validateMimeType checks the sniffed content type of file against the Content-Type declared
+// for the file part.
+func validateMimeType(file multipart.File, header *multipart.FileHeader) error {
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return apierror.Internal("Failed to read file for MIME type detection", err)
+	}
+	file.Seek(0, 0) // Reset file reader
+
+	declaredMimeTypeHeader := header.Header.Get("Content-Type")
+	if declaredMimeTypeHeader == "" {
+		return apierror.BadRequest("MIME type for the file part is not declared in Content-Type header")
+	}
+	// Only the bytes read count: padding a short file would make it look binary
+	return matchMimeType(declaredMimeTypeHeader, http.DetectContentType(buffer[:n]))
+}
+
+// matchMimeType checks a declared Content-Type against the one detected from the content,
+// ignoring parameters such as charset.
+func matchMimeType(declaredMimeTypeHeader, detectedMimeType string) error {
+	// Parse the media types to ignore parameters like charset and ensure a clean comparison
+	parsedDeclaredMimeType, _, err := mime.ParseMediaType(declaredMimeTypeHeader)
+	if err != nil {
+		return apierror.BadRequest(fmt.Sprintf("Invalid Content-Type header format: %s", declaredMimeTypeHeader))
+	}
+
+	parsedDetectedMimeType, _, err := mime.ParseMediaType(detectedMimeType)
+	if err != nil {
+		// This is unlikely to fail for http.DetectContentType output, but handle defensively
+		slog.Warn("Could not parse detected MIME type", "mime_type", detectedMimeType)
+		parsedDetectedMimeType = detectedMimeType // Fallback to raw value
+	}
+
+	if parsedDetectedMimeType != parsedDeclaredMimeType {
+		return apierror.New(http.StatusBadRequest, apierror.CodeMIMEMismatch, fmt.Sprintf("MIME type mismatch: declared '%s', detected '%s'", parsedDeclaredMimeType, parsedDetectedMimeType)).
+			With("declared", parsedDeclaredMimeType).
+			With("detected", parsedDetectedMimeType)
+	}
+
+	return nil
 }
 
 // ListFiles retrieves all non-deleted files for a user.
 func ListFiles(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		// In a real app, ownerID would come from a JWT token or session.
 		ownerID := c.Query("owner_id")
 		if ownerID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Owner ID is required"})
+			apierror.Respond(c, apierror.BadRequest("Owner ID is required"))
 			return
 		}
 
-		var filesWithContent []models.FileSearchResult
-		_, err := clients.Postgrest.From("files").
-			Select("file_id,filename,is_deleted,created_at,file_contents(size,mime_type)", "", false). // Select specific fields
+		filter, err := paginate(c, clients.Postgrest.From("files").
+			Select("file_id,filename,is_deleted,created_at,file_contents(size,mime_type,client_encryption,hash_sha256)", "", false). // Select specific fields
 			Eq("owner_id", ownerID).
-			Eq("is_deleted", "false").
-			ExecuteTo(&filesWithContent)
+			Eq("is_deleted", "false"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var filesWithContent []models.FileSearchResult
+		_, err = filter.ExecuteTo(&filesWithContent)
 		if err != nil {
-			log.Printf("Error listing files: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files"})
+			apierror.Respond(c, apierror.Internal("Failed to list files", err))
 			return
 		}
 
@@ -222,15 +370,16 @@ func ListFiles(clients *database.AppClients) gin.HandlerFunc {
 // ShareFile toggles the public status of a file and returns a share token.
 func ShareFile(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		fileID := c.Param("id")
 		if fileID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
+			apierror.Respond(c, apierror.BadRequest("File ID is required"))
 			return
 		}
 
 		ownerID := c.GetString("userID") // Get ownerID from context (set by AuthMiddleware)
 		if ownerID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "Unauthorized"))
 			return
 		}
 
@@ -238,12 +387,11 @@ func ShareFile(clients *database.AppClients) gin.HandlerFunc {
 		var userFile models.UserFile
 		_, err := clients.Postgrest.From("files").Select("owner_id", "", false).Single().Eq("file_id", fileID).ExecuteTo(&userFile)
 		if err != nil {
-			log.Printf("Error fetching file for share: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "File not found or not owned by user"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "File not found or not owned by user"))
 			return
 		}
 		if userFile.OwnerID != ownerID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to share this file"})
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotOwner, "You do not have permission to share this file"))
 			return
 		}
 
@@ -255,7 +403,7 @@ func ShareFile(clients *database.AppClients) gin.HandlerFunc {
 			// The postgrest-go library returns an error if Single() finds no rows.
 			// We assume if err is not nil, it means no record was found, or a real DB error.
 			// For simplicity, we'll treat any error here as "no existing share found" for creation.
-			log.Printf("No existing share found for file %s, or an error occurred: %v. Attempting to create new share.", fileID, err)
+			logger(c).Debug("No existing share found, creating one", "file_id", fileID, "error", err)
 
 			// No share record exists, create one.
 			share = models.Share{
@@ -267,8 +415,7 @@ func ShareFile(clients *database.AppClients) gin.HandlerFunc {
 			}
 			_, _, dbErr := clients.Postgrest.From("shares").Insert(share, false, "", "", "").Execute()
 			if dbErr != nil {
-				log.Printf("Error creating share entry: %v", dbErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share entry"})
+				apierror.Respond(c, apierror.Internal("Failed to create share entry", dbErr))
 				return
 			}
 		} else { // Share record exists (err is nil)
@@ -277,8 +424,7 @@ func ShareFile(clients *database.AppClients) gin.HandlerFunc {
 			share.ShareToken = uuid.New().String() // Generate new token on toggle
 			_, _, updateErr := clients.Postgrest.From("shares").Update(map[string]interface{}{"is_public": share.IsPublic, "share_token": share.ShareToken}, "", "").Eq("share_id", share.ShareID).Execute()
 			if updateErr != nil {
-				log.Printf("Error updating share status: %v", updateErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update share status"})
+				apierror.Respond(c, apierror.Internal("Failed to update share status", updateErr))
 				return
 			}
 		}
@@ -291,24 +437,24 @@ func ShareFile(clients *database.AppClients) gin.HandlerFunc {
 }
 
 // GetPublicShare retrieves a publicly shared file by its share token.
-func GetPublicShare(clients *database.AppClients, bucketName string) gin.HandlerFunc {
+func GetPublicShare(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		shareToken := c.Param("token")
 		if shareToken == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Share token is required"})
+			apierror.Respond(c, apierror.BadRequest("Share token is required"))
 			return
 		}
 
 		var share models.Share
 		_, err := clients.Postgrest.From("shares").Select("*", "", false).Single().Eq("share_token", shareToken).ExecuteTo(&share)
 		if err != nil {
-			log.Printf("Error fetching share by token: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "Public share not found or invalid token"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "Public share not found or invalid token"))
 			return
 		}
 
 		if !share.IsPublic {
-			c.JSON(http.StatusForbidden, gin.H{"error": "This file is not publicly shared"})
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotShared, "This file is not publicly shared"))
 			return
 		}
 
@@ -316,23 +462,21 @@ func GetPublicShare(clients *database.AppClients, bucketName string) gin.Handler
 		newCount := share.DownloadCount + 1
 		_, _, updateErr := clients.Postgrest.From("shares").Update(map[string]interface{}{"download_count": newCount}, "", "").Eq("share_id", share.ShareID).Execute()
 		if updateErr != nil {
-			log.Printf("Error incrementing download count for public share: %v", updateErr)
+			logger(c).Error("Error incrementing download count for public share", "error", updateErr)
 			// Don't block the download, just log the error
 		}
 
 		var userFile models.UserFile
 		_, err = clients.Postgrest.From("files").Select("file_id,filename,content_id,owner_id", "", false).Single().Eq("file_id", share.FileID).ExecuteTo(&userFile)
 		if err != nil {
-			log.Printf("Error fetching file metadata for public share: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "File associated with share not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "File associated with share not found"))
 			return
 		}
 
 		var fileContent models.FileContent
 		_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", userFile.ContentID).ExecuteTo(&fileContent)
 		if err != nil {
-			log.Printf("Error fetching file content metadata for public share: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "File content not found"})
+			apierror.Respond(c, apierror.Internal("File content not found", err))
 			return
 		}
 
@@ -340,7 +484,7 @@ func GetPublicShare(clients *database.AppClients, bucketName string) gin.Handler
 		var owner models.User
 		_, err = clients.Postgrest.From("users").Select("username", "", false).Single().Eq("user_id", userFile.OwnerID).ExecuteTo(&owner)
 		if err != nil {
-			log.Printf("Error fetching owner username for public share: %v", err)
+			logger(c).Error("Error fetching owner username for public share", "error", err)
 			// Proceed without owner name if not found
 		}
 
@@ -357,24 +501,25 @@ func GetPublicShare(clients *database.AppClients, bucketName string) gin.Handler
 }
 
 // DownloadPublicShare handles downloading a publicly shared file.
-func DownloadPublicShare(clients *database.AppClients, bucketName string) gin.HandlerFunc {
+func DownloadPublicShare(clients *database.AppClients) gin.HandlerFunc {
+	content := clients.ContentStore()
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		shareToken := c.Param("token")
 		if shareToken == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Share token is required"})
+			apierror.Respond(c, apierror.BadRequest("Share token is required"))
 			return
 		}
 
 		var share models.Share
 		_, err := clients.Postgrest.From("shares").Select("*", "", false).Single().Eq("share_token", shareToken).ExecuteTo(&share)
 		if err != nil {
-			log.Printf("Error fetching share by token for download: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "Public share not found or invalid token"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "Public share not found or invalid token"))
 			return
 		}
 
 		if !share.IsPublic {
-			c.JSON(http.StatusForbidden, gin.H{"error": "This file is not publicly shared"})
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotShared, "This file is not publicly shared"))
 			return
 		}
 
@@ -382,42 +527,42 @@ func DownloadPublicShare(clients *database.AppClients, bucketName string) gin.Ha
 		newCount := share.DownloadCount + 1
 		_, _, updateErr := clients.Postgrest.From("shares").Update(map[string]interface{}{"download_count": newCount}, "", "").Eq("share_id", share.ShareID).Execute()
 		if updateErr != nil {
-			log.Printf("Error incrementing download count for public share: %v", updateErr)
+			logger(c).Error("Error incrementing download count for public share", "error", updateErr)
 			// Don't block the download, just log the error
 		}
 
 		var userFile models.UserFile
 		_, err = clients.Postgrest.From("files").Select("file_id,filename,content_id", "", false).Single().Eq("file_id", share.FileID).ExecuteTo(&userFile)
 		if err != nil {
-			log.Printf("Error fetching file metadata for public share download: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "File associated with share not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "File associated with share not found"))
 			return
 		}
 
 		var fileContent models.FileContent
 		_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", userFile.ContentID).ExecuteTo(&fileContent)
 		if err != nil {
-			log.Printf("Error fetching file content metadata for public share download: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "File content not found"})
+			apierror.Respond(c, apierror.Internal("File content not found", err))
 			return
 		}
 
-		file, err := clients.Storage.DownloadFile(bucketName, "uploads/"+fileContent.StoragePath)
+		file, err := openContent(c.Request.Context(), clients, content, &fileContent)
 		if err != nil {
-			log.Printf("Error downloading file from storage for public share: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
+			apierror.Respond(c, apierror.Internal("Failed to download file", err))
 			return
 		}
+		defer file.Close()
+		recordAccess(c.Request.Context(), clients, content, fileContent)
 
-		c.Header("Content-Disposition", "attachment; filename="+userFile.Filename)
-		c.Header("Content-Length", strconv.FormatInt(fileContent.Size, 10))
-		c.Data(http.StatusOK, fileContent.MimeType, file)
+		sendContent(c, file, fileContent.Size, fileContent.MimeType, map[string]string{
+			"Content-Disposition": "attachment; filename=" + userFile.Filename,
+		})
 	}
 }
 
 // ListPubliclySharedFiles lists all files publicly shared by the authenticated user.
 func ListPubliclySharedFiles(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		var sharedFiles []models.PubliclySharedFileResponse
 
 		_, err := clients.Postgrest.From("shares").
@@ -426,8 +571,7 @@ func ListPubliclySharedFiles(clients *database.AppClients) gin.HandlerFunc {
 			ExecuteTo(&sharedFiles)
 
 		if err != nil {
-			log.Printf("Error listing publicly shared files: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list publicly shared files"})
+			apierror.Respond(c, apierror.Internal("Failed to list publicly shared files", err))
 			return
 		}
 
@@ -454,9 +598,10 @@ func ListPubliclySharedFiles(clients *database.AppClients) gin.HandlerFunc {
 // GetStats calculates and returns user-specific storage statistics.
 func GetStats(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		userID := c.Query("user_id")
 		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+			apierror.Respond(c, apierror.BadRequest("User ID is required"))
 			return
 		}
 
@@ -464,8 +609,7 @@ func GetStats(clients *database.AppClients) gin.HandlerFunc {
 		var user models.User
 		_, err := clients.Postgrest.From("users").Select("storage_quota", "", false).Single().Eq("user_id", userID).ExecuteTo(&user)
 		if err != nil {
-			log.Printf("Error fetching user for stats: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "User not found"))
 			return
 		}
 
@@ -473,11 +617,12 @@ func GetStats(clients *database.AppClients) gin.HandlerFunc {
 		var userFilesWithContent []models.FileStatsResult
 		_, err = clients.Postgrest.From("files").Select("file_contents(*)", "", false).Eq("owner_id", userID).Eq("is_deleted", "false").ExecuteTo(&userFilesWithContent)
 		if err != nil {
-			log.Printf("Error fetching user files for stats: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user files"})
+			apierror.Respond(c, apierror.Internal("Failed to fetch user files", err))
 			return
 		}
 
+		// Savings only count duplicates among the caller's own files, so they never move
+		// because another user stored the same content
 		var originalSize int64
 		deduplicatedSizeMap := make(map[string]int64)
 		for _, file := range userFilesWithContent {
@@ -509,6 +654,7 @@ func GetStats(clients *database.AppClients) gin.HandlerFunc {
 // AdminListFiles allows admins to view all files and user details.
 func AdminListFiles(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		// In a real app, you'd have middleware to check if the user is an admin.
 		// For this exercise, we'll assume the check has passed.
 
@@ -517,8 +663,7 @@ func AdminListFiles(clients *database.AppClients) gin.HandlerFunc {
 			Select("file_id,owner_id,filename,is_deleted,created_at,file_contents(size,mime_type),users(username)", "", false).
 			ExecuteTo(&allFiles)
 		if err != nil {
-			log.Printf("Error listing all files for admin: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files"})
+			apierror.Respond(c, apierror.Internal("Failed to list files", err))
 			return
 		}
 
@@ -527,24 +672,25 @@ func AdminListFiles(clients *database.AppClients) gin.HandlerFunc {
 }
 
 // GetFile handles downloading a specific file.
-func GetFile(clients *database.AppClients, bucketName string) gin.HandlerFunc {
+func GetFile(clients *database.AppClients) gin.HandlerFunc {
+	content := clients.ContentStore()
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		fileID := c.Param("id")
 		if fileID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
+			apierror.Respond(c, apierror.BadRequest("File ID is required"))
 			return
 		}
 
 		var userFile models.UserFile
 		_, err := clients.Postgrest.From("files").Select("*", "", false).Single().Eq("file_id", fileID).ExecuteTo(&userFile)
 		if err != nil {
-			log.Printf("Error fetching file metadata: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "File not found"))
 			return
 		}
 
 		if userFile.IsDeleted {
-			c.JSON(http.StatusNotFound, gin.H{"error": "File has been deleted"})
+			apierror.Respond(c, apierror.NotFound("File has been deleted"))
 			return
 		}
 
@@ -555,7 +701,7 @@ func GetFile(clients *database.AppClients, bucketName string) gin.HandlerFunc {
 			newCount := share.DownloadCount + 1
 			_, _, updateErr := clients.Postgrest.From("shares").Update(map[string]interface{}{"download_count": newCount}, "", "").Eq("share_id", share.ShareID).Execute()
 			if updateErr != nil {
-				log.Printf("Error incrementing download count: %v", updateErr)
+				logger(c).Error("Error incrementing download count", "error", updateErr)
 				// Don't block the download, just log the error
 			}
 		}
@@ -563,111 +709,220 @@ func GetFile(clients *database.AppClients, bucketName string) gin.HandlerFunc {
 		var fileContent models.FileContent
 		_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", userFile.ContentID).ExecuteTo(&fileContent)
 		if err != nil {
-			log.Printf("Error fetching file content metadata: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "File content not found"})
+			apierror.Respond(c, apierror.Internal("File content not found", err))
 			return
 		}
 
-		file, err := clients.Storage.DownloadFile(bucketName, "uploads/"+fileContent.StoragePath)
+		file, err := openContent(c.Request.Context(), clients, content, &fileContent)
 		if err != nil {
-			log.Printf("Error downloading file from storage: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
+			apierror.Respond(c, apierror.Internal("Failed to download file", err))
 			return
 		}
+		defer file.Close()
+		recordAccess(c.Request.Context(), clients, content, fileContent)
 
-		c.Header("Content-Disposition", "attachment; filename="+userFile.Filename)
-		c.Header("Content-Length", strconv.FormatInt(fileContent.Size, 10)) // Add Content-Length header
-		c.Data(http.StatusOK, fileContent.MimeType, file)
+		// Stream the logical bytes, resuming at a requested offset; Content-Length is the uncompressed size
+		extraHeaders := map[string]string{
+			"Content-Disposition": "attachment; filename=" + userFile.Filename,
+		}
+		if fileContent.ClientEncryption != "" {
+			// Tell the client it must fetch its wrapped file key to decrypt the body
+			extraHeaders["X-Client-Encryption"] = fileContent.ClientEncryption
+		}
+		sendContent(c, file, fileContent.Size, fileContent.MimeType, extraHeaders)
 	}
 }
 
+// accessRecordInterval limits how often downloads rewrite last_accessed_at for the same content.
+const accessRecordInterval = time.Hour
+
+// rehydrating holds the IDs of cold content being moved back to hot storage by this process.
+var rehydrating sync.Map
+
+// recordAccess notes that fileContent was downloaded, which keeps it in the hot storage class.
+// Cold content is moved back to hot storage in the background when rehydration is enabled.
+func recordAccess(ctx context.Context, clients *database.AppClients, content *storage.ContentStore, fileContent models.FileContent) {
+	now := time.Now().UTC()
+	if fileContent.LastAccessedAt == nil || now.Sub(fileContent.LastAccessedAt.Time) > accessRecordInterval {
+		_, _, err := clients.Postgrest.From("file_contents").Update(map[string]interface{}{"last_accessed_at": now}, "", "").Eq("content_id", fileContent.ContentID).Execute()
+		if err != nil {
+			logging.FromContext(ctx).Error("Error recording content access", "content_id", fileContent.ContentID, "error", err)
+		}
+	}
+
+	if fileContent.StorageClass != storage.ClassCold || clients.Tiering == nil || !clients.Tiering.Rehydrate {
+		return
+	}
+	// One rehydration per content at a time; a download that loaded the row before the move
+	// finishes reopens the blob through openContent once the cold copy is gone
+	if _, busy := rehydrating.LoadOrStore(fileContent.ContentID, true); busy {
+		return
+	}
+	go func() {
+		defer rehydrating.Delete(fileContent.ContentID)
+		// Another process may have rehydrated the content since the row was loaded
+		var current models.FileContent
+		_, err := clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", fileContent.ContentID).ExecuteTo(&current)
+		if err != nil || current.StorageClass != storage.ClassCold || current.StoragePath != fileContent.StoragePath {
+			return
+		}
+		fileContent = current
+		if err := jobs.MoveToClass(context.Background(), clients.Postgrest, content, &fileContent, storage.ClassHot); err != nil {
+			logging.FromContext(ctx).Error("Error rehydrating content", "content_id", fileContent.ContentID, "error", err)
+		}
+	}()
+}
+
 // DeleteFile handles the soft delete and reference count logic.
-func DeleteFile(clients *database.AppClients, bucketName string) gin.HandlerFunc {
+func DeleteFile(clients *database.AppClients) gin.HandlerFunc {
+	content := clients.ContentStore()
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		fileID := c.Param("id")
 		if fileID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
+			apierror.Respond(c, apierror.BadRequest("File ID is required"))
 			return
 		}
 
 		// 1. Verify ownership and soft delete the file
 		userID := c.Query("user_id") // In a real app, get this from JWT/session
 		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+			apierror.Respond(c, apierror.BadRequest("User ID is required"))
 			return
 		}
 
 		var userFile models.UserFile
 		_, err := clients.Postgrest.From("files").Select("*", "", false).Single().Eq("file_id", fileID).ExecuteTo(&userFile)
 		if err != nil {
-			log.Printf("Error finding file to delete: %v", err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "File not found"))
 			return
 		}
 
 		if userFile.OwnerID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to delete this file"})
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotOwner, "You do not have permission to delete this file"))
+			return
+		}
+
+		if err := deleteUserFile(c.Request.Context(), clients, content, userFile); err != nil {
+			apierror.Respond(c, err)
 			return
 		}
 
-		_, _, err = clients.Postgrest.From("files").Update(map[string]interface{}{"is_deleted": true}, "", "").Eq("file_id", fileID).Execute()
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RenameFileRequest names the file a rename gives it.
+type RenameFileRequest struct {
+	Filename string `json:"filename" binding:"required"`
+}
+
+// RenameFile changes the name of a file the caller owns. Unlike an upload, which prefixes a
+// name already in use, a rename to a taken name is refused.
+func RenameFile(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		fileID := c.Param("id")
+		userID := c.GetString("userID") // Set by AuthMiddleware
+		var payload RenameFileRequest
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+
+		var userFile models.UserFile
+		_, err := clients.Postgrest.From("files").Select("*", "", false).Single().Eq("file_id", fileID).Eq("is_deleted", "false").ExecuteTo(&userFile)
 		if err != nil {
-			log.Printf("Error soft deleting file: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "File not found"))
+			return
+		}
+		if userFile.OwnerID != userID {
+			apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotOwner, "You do not have permission to rename this file"))
+			return
+		}
+		if payload.Filename == userFile.Filename {
+			c.JSON(http.StatusOK, userFile)
 			return
 		}
 
-		// 2. Decrement reference count
-		var fileContent models.FileContent
-		_, err = clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", userFile.ContentID).ExecuteTo(&fileContent)
+		var existing []models.UserFile
+		_, err = clients.Postgrest.From("files").Select("file_id", "", false).Eq("owner_id", userID).Eq("filename", payload.Filename).Eq("is_deleted", "false").ExecuteTo(&existing)
 		if err != nil {
-			log.Printf("Error fetching file content for reference count decrement: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file references"})
+			apierror.Respond(c, apierror.Internal("Failed to check for existing filename", err))
+			return
+		}
+		if len(existing) > 0 {
+			apierror.Respond(c, apierror.New(http.StatusConflict, apierror.CodeAlreadyExists, "You already have a file with this name"))
 			return
 		}
 
-		newRefCount := fileContent.ReferenceCount - 1
-		_, _, err = clients.Postgrest.From("file_contents").Update(map[string]interface{}{"reference_count": newRefCount}, "", "").Eq("content_id", userFile.ContentID).Execute()
+		_, _, err = clients.Postgrest.From("files").Update(map[string]interface{}{"filename": payload.Filename}, "", "").Eq("file_id", fileID).Execute()
 		if err != nil {
-			log.Printf("Error decrementing reference count: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file references"})
+			apierror.Respond(c, apierror.Internal("Failed to rename file", err))
 			return
 		}
+		userFile.Filename = payload.Filename
+		c.JSON(http.StatusOK, userFile)
+	}
+}
 
-		// 3. Cleanup if reference count is 0
-		if newRefCount == 0 {
-			log.Printf("Reference count is 0 for content_id %s. Deleting physical file.", userFile.ContentID)
-			// 3a. Delete from storage
-			_, err = clients.Storage.RemoveFile(bucketName, []string{"uploads/" + fileContent.StoragePath})
-			if err != nil {
-				log.Printf("Error deleting physical file from storage: %v", err)
-				// Don't block, but log it. The file becomes an orphan.
-			}
+// deleteUserFile soft deletes userFile and releases its reference to the stored content.
+func deleteUserFile(ctx context.Context, clients *database.AppClients, content *storage.ContentStore, userFile models.UserFile) error {
+	_, _, err := clients.Postgrest.From("files").Update(map[string]interface{}{"is_deleted": true}, "", "").Eq("file_id", userFile.FileID).Execute()
+	if err != nil {
+		return apierror.Internal("Failed to delete file", err)
+	}
+	return releaseContent(ctx, clients, content, userFile.ContentID)
+}
 
-			// 3b. Delete the file_contents record
-			_, _, err = clients.Postgrest.From("file_contents").Delete("", "").Eq("content_id", fileContent.ContentID).Execute()
-			if err != nil {
-				log.Printf("Error deleting file_contents record: %v", err)
-				// Don't block, but log it.
-			}
+// releaseContent decrements the reference count of stored content, deleting the blob and its
+// file_contents row once nothing references it.
+func releaseContent(ctx context.Context, clients *database.AppClients, content *storage.ContentStore, contentID string) error {
+	var fileContent models.FileContent
+	_, err := clients.Postgrest.From("file_contents").Select("*", "", false).Single().Eq("content_id", contentID).ExecuteTo(&fileContent)
+	if err != nil {
+		return apierror.Internal("Failed to update file references", err)
+	}
+
+	newRefCount := fileContent.ReferenceCount - 1
+	_, _, err = clients.Postgrest.From("file_contents").Update(map[string]interface{}{"reference_count": newRefCount}, "", "").Eq("content_id", contentID).Execute()
+	if err != nil {
+		return apierror.Internal("Failed to update file references", err)
+	}
+
+	// Cleanup if reference count is 0
+	if newRefCount == 0 {
+		log := logging.FromContext(ctx)
+		log.Info("Deleting content with no references left", "content_id", contentID)
+		// Delete from storage
+		if err := content.Delete(ctx, &fileContent); err != nil {
+			log.Error("Error deleting physical file from storage", "error", err)
+			// Don't block, but log it. The file becomes an orphan.
 		}
 
-		c.Status(http.StatusNoContent)
+		// Delete the file_contents record
+		_, _, err = clients.Postgrest.From("file_contents").Delete("", "").Eq("content_id", fileContent.ContentID).Execute()
+		if err != nil {
+			log.Error("Error deleting file_contents record", "error", err)
+			// Don't block, but log it.
+		}
 	}
+	return nil
 }
 
 // SearchFiles allows users to find files based on various criteria.
 // SearchFiles allows users to find files based on various criteria.
 func SearchFiles(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		ownerID := c.Query("owner_id")
 		if ownerID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Owner ID is required"})
+			apierror.Respond(c, apierror.BadRequest("Owner ID is required"))
 			return
 		}
 
 		// Build the query using FilterBuilder
-		selectQuery := "file_id,filename,is_deleted,created_at,file_contents(size,mime_type)"
+		selectQuery := "file_id,filename,is_deleted,created_at,file_contents(size,mime_type,client_encryption,hash_sha256)"
 
 		// Determine if an inner join is needed for file_contents based on filters
 		needsFileContentsJoin := false
@@ -676,7 +931,7 @@ func SearchFiles(clients *database.AppClients) gin.HandlerFunc {
 		}
 
 		if needsFileContentsJoin {
-			selectQuery = "file_id,filename,is_deleted,created_at,file_contents!inner(size,mime_type)"
+			selectQuery = "file_id,filename,is_deleted,created_at,file_contents!inner(size,mime_type,client_encryption,hash_sha256)"
 		}
 
 		filter := clients.Postgrest.From("files").
@@ -714,15 +969,20 @@ func SearchFiles(clients *database.AppClients) gin.HandlerFunc {
 			filter = filter.Lte("created_at", endDate)
 		}
 
+		filter, err := paginate(c, filter)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
 		var searchResults []models.FileSearchResult
-		_, err := filter.ExecuteTo(&searchResults)
+		_, err = filter.ExecuteTo(&searchResults)
 		if err != nil {
-			log.Printf("Error searching files: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files"})
+			apierror.Respond(c, apierror.Internal("Failed to search files", err))
 			return
 		}
 
-		log.Printf("Search results from DB: %+v", searchResults)
+		logger(c).Debug("Searched files", "results", len(searchResults))
 		c.JSON(http.StatusOK, searchResults)
 	}
 }
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+func TestRenameFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stored := models.UserFile{FileID: "f1", OwnerID: "alice", ContentID: "c1", Filename: "draft.txt"}
+	cases := []struct {
+		name     string
+		caller   string
+		filename string
+		found    bool
+		taken    bool
+		status   int
+		renamed  bool
+	}{
+		{"owner renames", "alice", "final.txt", true, false, http.StatusOK, true},
+		{"same name", "alice", "draft.txt", true, false, http.StatusOK, false},
+		{"name taken", "alice", "final.txt", true, true, http.StatusConflict, false},
+		{"not the owner", "mallory", "final.txt", true, false, http.StatusForbidden, false},
+		{"no such file", "alice", "final.txt", false, false, http.StatusNotFound, false},
+		{"empty name", "alice", "", true, false, http.StatusBadRequest, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var update map[string]interface{}
+			rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				query := r.URL.Query()
+				switch {
+				case r.Method == http.MethodPatch:
+					if query.Get("file_id") != "eq.f1" {
+						t.Errorf("update of %s", r.URL.RawQuery)
+					}
+					body, _ := io.ReadAll(r.Body)
+					json.Unmarshal(body, &update)
+					w.WriteHeader(http.StatusNoContent)
+				case query.Has("filename"):
+					// The name check only looks at the caller's own files
+					if query.Get("owner_id") != "eq."+tc.caller || query.Get("filename") != "eq."+tc.filename {
+						t.Errorf("name check %s", r.URL.RawQuery)
+					}
+					if tc.taken {
+						w.Write([]byte(`[{"file_id":"f2"}]`))
+						return
+					}
+					w.Write([]byte(`[]`))
+				case !tc.found:
+					w.WriteHeader(http.StatusNotAcceptable)
+					w.Write([]byte(`{"code":"PGRST116","message":"JSON object requested, multiple (or no) rows returned"}`))
+				default:
+					json.NewEncoder(w).Encode(stored)
+				}
+			}))
+			defer rest.Close()
+			clients := &database.AppClients{Postgrest: postgrest.NewClient(rest.URL, "", nil)}
+
+			router := gin.New()
+			router.PATCH("/user/files/:id", func(c *gin.Context) { c.Set("userID", tc.caller) }, RenameFile(clients))
+			rec := httptest.NewRecorder()
+			body := `{"filename":"` + tc.filename + `"}`
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/user/files/f1", strings.NewReader(body)))
+
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tc.status, rec.Body)
+			}
+			if renamed := update != nil; renamed != tc.renamed {
+				t.Fatalf("file row updated: %v, want %v", renamed, tc.renamed)
+			}
+			if tc.renamed {
+				var file models.UserFile
+				json.Unmarshal(rec.Body.Bytes(), &file)
+				if update["filename"] != tc.filename || file.Filename != tc.filename {
+					t.Fatalf("update %v, response %+v", update, file)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/health"
+	"file-vault/backend/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz godoc
+// @Summary Liveness probe
+// @Description Report that the process is up and serving requests. Dependencies are not probed, so a database outage does not get the server restarted.
+// @Tags health
+// @Produce  json
+// @Success 200 {object} map[string]interface{}
+// @Router /healthz [get]
+func Healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Readyz godoc
+// @Summary Readiness probe
+// @Description Probe the metadata store, the blob store (write, read and delete of a canary object) and the mail server, reporting each with its latency. Fails with 503 when a dependency is down or the server is shutting down.
+// @Tags health
+// @Produce  json
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report
+// @Router /readyz [get]
+func Readyz(checker *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checker.Check(c.Request.Context())
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+}
+
+// Diagnostics godoc
+// @Summary Server diagnostics
+// @Description Report the build, uptime, runtime statistics, configuration (secrets redacted), dependency health and background jobs of the server handling the request
+// @Tags admin
+// @Produce  json
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/diagnostics [get]
+func Diagnostics(cfg *config.Config, checker *health.Checker, manager *jobs.Manager) gin.HandlerFunc {
+	started := time.Now()
+	return func(c *gin.Context) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		recent, err := manager.List("", 20)
+		jobState := gin.H{
+			"owner":        manager.Owner(),
+			"running_here": manager.Running(),
+			"recent":       recent,
+		}
+		if err != nil {
+			logger(c).Error("Error listing jobs for diagnostics", "error", err)
+			jobState["error"] = "Failed to list jobs"
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"build":          health.BuildInfo(),
+			"started_at":     started,
+			"uptime_seconds": int64(time.Since(started).Seconds()),
+			"runtime": gin.H{
+				"goroutines":       runtime.NumGoroutine(),
+				"gomaxprocs":       runtime.GOMAXPROCS(0),
+				"heap_alloc_bytes": mem.HeapAlloc,
+				"sys_bytes":        mem.Sys,
+				"num_gc":           mem.NumGC,
+			},
+			"config":       cfg.Summary(),
+			"dependencies": checker.Check(c.Request.Context()),
+			"jobs":         jobState,
+		})
+	}
+}
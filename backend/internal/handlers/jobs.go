@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListJobs godoc
+// @Summary List background jobs
+// @Description List the most recent background jobs and their progress, optionally filtered by kind
+// @Tags admin
+// @Produce  json
+// @Param   kind query string false "Job kind, e.g. key_rotation"
+// @Param   limit query int false "Maximum number of jobs (default 20)"
+// @Success 200 {array} models.Job
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/jobs [get]
+func ListJobs(manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 20
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		list, err := manager.List(c.Query("kind"), limit)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to list jobs", err))
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// GetJob godoc
+// @Summary Get a background job
+// @Description Report the status and progress of a background job
+// @Tags admin
+// @Produce  json
+// @Param   id path string true "Job ID"
+// @Success 200 {object} models.Job
+// @Failure 404 {object} apierror.Problem
+// @Router /admin/jobs/{id} [get]
+func GetJob(manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := manager.Get(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.Wrap(err, http.StatusNotFound, apierror.CodeNotFound, "Job not found"))
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// CancelJob godoc
+// @Summary Cancel a background job
+// @Description Stop a running job at its next checkpoint
+// @Tags admin
+// @Produce  json
+// @Param   id path string true "Job ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/jobs/{id}/cancel [post]
+func CancelJob(manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := manager.Cancel(c.Param("id")); err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to cancel job", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Job cancelled"})
+	}
+}
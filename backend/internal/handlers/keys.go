@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListMasterKeys godoc
+// @Summary List master key versions
+// @Description List the master key versions the server holds and how many blobs and S3 access keys each still wraps
+// @Tags admin
+// @Produce  json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/keys [get]
+func ListMasterKeys(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		keyIDs := clients.Keys.KeyIDs()
+		usage, err := jobs.KeyUsage(clients.Postgrest, keyIDs)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to count master key usage", err))
+			return
+		}
+		accessKeys, err := jobs.AccessKeyUsage(clients.Postgrest, keyIDs)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to count master key usage", err))
+			return
+		}
+
+		current := clients.Keys.CurrentKeyID()
+		versions := make([]gin.H, 0, len(keyIDs))
+		for _, id := range keyIDs {
+			versions = append(versions, gin.H{
+				"key_id":      id,
+				"current":     id == current,
+				"blobs":       usage[id],
+				"access_keys": accessKeys[id],
+				"retirable":   id != current && usage[id] == 0 && accessKeys[id] == 0,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"current_key_id":    current,
+			"versions":          versions,
+			"unencrypted_blobs": usage[""],
+		})
+	}
+}
+
+// KeyRotationRequest chooses whether a key rotation also re-encrypts blobs under fresh data keys.
+type KeyRotationRequest struct {
+	Reencrypt bool `json:"reencrypt"`
+}
+
+// StartKeyRotation godoc
+// @Summary Rotate to the current master key
+// @Description Re-wrap the S3 access key secrets, then start a background job that re-wraps every data key under the current master key, optionally re-encrypting blobs with fresh data keys
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param   rotation body KeyRotationRequest false "Rotation options"
+// @Success 202 {object} models.Job
+// @Failure 409 {object} apierror.Problem
+// @Failure 500 {object} apierror.Problem
+// @Router /admin/keys/rotate [post]
+func StartKeyRotation(clients *database.AppClients, manager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var req KeyRotationRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierror.Respond(c, apierror.InvalidBody(err))
+				return
+			}
+		}
+
+		if _, err := jobs.RewrapAccessKeys(c.Request.Context(), clients.Postgrest, clients.Keys); err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to re-wrap access keys", err))
+			return
+		}
+		job, err := manager.Start(jobs.KindKeyRotation, jobs.KeyRotationParams{
+			TargetKeyID: clients.Keys.CurrentKeyID(),
+			Reencrypt:   req.Reencrypt,
+		})
+		if errors.Is(err, jobs.ErrAlreadyRunning) {
+			apierror.Respond(c, apierror.Wrap(err, http.StatusConflict, apierror.CodeJobRunning, "A key rotation is already running"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to start key rotation", err))
+			return
+		}
+
+		c.JSON(http.StatusAccepted, job)
+	}
+}
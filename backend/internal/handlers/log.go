@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"file-vault/backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logger returns the logger of the request c is handling, tagged with its request and trace IDs.
+func logger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
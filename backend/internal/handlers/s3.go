@@ -0,0 +1,650 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/s3"
+	"file-vault/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// s3Bucket is the one bucket each user has, holding all of their files.
+const s3Bucket = "vault"
+
+// s3MaxKeys is the most keys one listing returns, as in S3.
+const s3MaxKeys = 1000
+
+// s3MaxKeyLength is the longest object key S3 accepts, in bytes.
+const s3MaxKeyLength = 1024
+
+// emptyMD5 is the ETag of an empty object, which folders are to S3 clients.
+const emptyMD5 = "d41d8cd98f00b204e9800998ecf8427e"
+
+// S3Methods are the HTTP methods the S3 gateway is routed for.
+var S3Methods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete}
+
+// s3Gateway is what the S3 handler shares between requests.
+type s3Gateway struct {
+	prefix  string
+	uploads *uploadStore
+	content *storage.ContentStore
+	parts   string // Directory holding the parts of multipart uploads in progress
+}
+
+// S3 serves the authenticated user's files to S3 clients under prefix, path-style. Each user
+// has one bucket, "vault", and an object's key is the folder path and name of a file, as
+// WebDAV shows it: "a/b/c.txt" is c.txt in folder b inside folder a. Objects are written
+// through the same dedup, quota and reference counting as uploads over the API. It must run
+// after S3AuthMiddleware.
+func S3(clients *database.AppClients, prefix string) gin.HandlerFunc {
+	gateway := &s3Gateway{
+		prefix:  prefix,
+		uploads: newUploadStore(clients),
+		content: clients.ContentStore(),
+		parts:   filepath.Join(os.TempDir(), "file-vault-s3"),
+	}
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		fs := &davFS{clients: clients, user: c.MustGet("user").(models.User), uploads: gateway.uploads, content: gateway.content}
+		if err := gateway.serve(c, fs); err != nil {
+			RespondS3(c, err)
+		}
+	}
+}
+
+// serve dispatches a request on its path and sub-resource query parameters.
+func (g *s3Gateway) serve(c *gin.Context, fs *davFS) error {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, g.prefix), "/"), "/")
+	query := c.Request.URL.Query()
+	method := c.Request.Method
+	switch {
+	case bucket == "" && method == http.MethodGet:
+		return g.listBuckets(c, fs)
+	case bucket == "":
+		return s3.ErrMethodNotAllowed
+	case bucket != s3Bucket:
+		return s3.ErrNoSuchBucket
+	case key == "":
+		return g.serveBucket(c, fs, query)
+	}
+
+	name, folder, err := objectPath(key)
+	if err != nil {
+		return err
+	}
+	for _, subresource := range objectSubresources {
+		if query.Has(subresource) {
+			return s3.ErrNotImplemented
+		}
+	}
+	uploadID := query.Get("uploadId")
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		if query.Has("uploadId") {
+			return g.listParts(c, fs, key, uploadID)
+		}
+		return g.getObject(c, fs, name, folder)
+	case http.MethodPut:
+		if query.Has("uploadId") {
+			return g.uploadPart(c, fs, key, uploadID)
+		}
+		if c.GetHeader("X-Amz-Copy-Source") != "" {
+			return s3.ErrNotImplemented.WithMessage("CopyObject is not supported")
+		}
+		return g.putObject(c, fs, name, folder)
+	case http.MethodPost:
+		if query.Has("uploads") {
+			return g.createMultipartUpload(c, fs, key, folder)
+		}
+		if query.Has("uploadId") {
+			return g.completeMultipartUpload(c, fs, key, name, uploadID)
+		}
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			return g.abortMultipartUpload(c, fs, key, uploadID)
+		}
+		return g.deleteObject(c, fs, name, folder)
+	}
+	return s3.ErrNotImplemented
+}
+
+// bucketSubresources are bucket configuration queries the gateway has nothing to answer with.
+var bucketSubresources = []string{
+	"acl", "cors", "delete", "encryption", "lifecycle", "logging", "notification", "object-lock",
+	"policy", "replication", "tagging", "uploads", "versioning", "versions", "website",
+}
+
+// objectSubresources are object queries that would otherwise be taken for reads, writes or
+// deletes of the object itself.
+var objectSubresources = []string{
+	"acl", "attributes", "legal-hold", "restore", "retention", "select", "tagging", "torrent",
+}
+
+// serveBucket handles requests on the bucket itself.
+func (g *s3Gateway) serveBucket(c *gin.Context, fs *davFS, query url.Values) error {
+	for _, subresource := range bucketSubresources {
+		if query.Has(subresource) {
+			return s3.ErrNotImplemented
+		}
+	}
+	switch c.Request.Method {
+	case http.MethodHead:
+		c.Status(http.StatusOK)
+		return nil
+	case http.MethodGet:
+		if query.Has("location") {
+			s3.WriteXML(c.Writer, http.StatusOK, &s3.LocationConstraint{})
+			return nil
+		}
+		return g.listObjects(c, fs, query)
+	}
+	return s3.ErrNotImplemented
+}
+
+// objectPath checks an object key and returns the davFS path it names. A key ending in "/"
+// names a folder, as the empty marker objects S3 tools create for folders.
+func objectPath(key string) (name string, folder bool, err error) {
+	if len(key) > s3MaxKeyLength {
+		return "", false, s3.ErrInvalidArgument.WithMessage("The object key is too long")
+	}
+	folder = strings.HasSuffix(key, "/")
+	trimmed := strings.TrimSuffix(key, "/")
+	for _, segment := range strings.Split(trimmed, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", false, s3.ErrInvalidArgument.WithMessage(`Object keys cannot have empty, "." or ".." path segments`)
+		}
+	}
+	return "/" + trimmed, folder, nil
+}
+
+// objectETag is the entity tag S3 clients expect: the MD5 of the content, or its SHA-256 for
+// content stored before MD5s were recorded.
+func objectETag(file *davRecord) string {
+	if file.Content.HashMD5 != "" {
+		return `"` + file.Content.HashMD5 + `"`
+	}
+	return `"` + file.Content.HashSHA256 + `"`
+}
+
+func (g *s3Gateway) listBuckets(c *gin.Context, fs *davFS) error {
+	s3.WriteXML(c.Writer, http.StatusOK, &s3.ListAllMyBucketsResult{
+		Owner:   s3.Owner{ID: fs.user.UserID, DisplayName: fs.user.Username},
+		Buckets: []s3.Bucket{{Name: s3Bucket, CreationDate: fs.user.CreatedAt.Time.UTC()}},
+	})
+	return nil
+}
+
+// listObjects answers ListObjectsV2 (list-type=2) and the original ListObjects.
+func (g *s3Gateway) listObjects(c *gin.Context, fs *davFS, query url.Values) error {
+	v2 := query.Get("list-type") == "2"
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := s3MaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return s3.ErrInvalidArgument.WithMessage("max-keys must be a non-negative integer")
+		}
+		maxKeys = min(n, s3MaxKeys)
+	}
+	result := &s3.ListBucketResult{Name: s3Bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys}
+
+	// Listing resumes after this key or common prefix
+	after := query.Get("marker")
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		after = result.StartAfter
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return s3.ErrInvalidArgument.WithMessage("The continuation token is not valid")
+			}
+			result.ContinuationToken = token
+			after = string(decoded)
+		}
+	} else {
+		result.Marker = after
+	}
+
+	objects, err := listAllObjects(fs)
+	if err != nil {
+		return err
+	}
+	last, count := "", 0
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, prefix) {
+			continue
+		}
+		// Keys with the delimiter after the prefix roll up into one common prefix
+		entry, rolledUp := object.Key, false
+		if delimiter != "" {
+			if i := strings.Index(object.Key[len(prefix):], delimiter); i >= 0 {
+				entry, rolledUp = object.Key[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if entry <= after || (rolledUp && entry == last) {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = maxKeys > 0
+			break
+		}
+		if rolledUp {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3.CommonPrefix{Prefix: entry})
+		} else {
+			result.Contents = append(result.Contents, object)
+		}
+		count++
+		last = entry
+	}
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else if delimiter != "" {
+			result.NextMarker = last
+		}
+	}
+	if v2 {
+		result.KeyCount = &count
+	}
+
+	// Clients ask for keys URL-encoded so that any byte survives XML
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.Delimiter = url.QueryEscape(result.Delimiter)
+		result.StartAfter = url.QueryEscape(result.StartAfter)
+		result.Marker = url.QueryEscape(result.Marker)
+		result.NextMarker = url.QueryEscape(result.NextMarker)
+		for i := range result.Contents {
+			result.Contents[i].Key = url.QueryEscape(result.Contents[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i].Prefix = url.QueryEscape(result.CommonPrefixes[i].Prefix)
+		}
+	}
+	s3.WriteXML(c.Writer, http.StatusOK, result)
+	return nil
+}
+
+// listAllObjects returns every object of the user sorted by key: the newest live file of
+// each folder path and name, and empty folders as marker objects. Files a folder of the same
+// name shadows are left out, as WebDAV does.
+func listAllObjects(fs *davFS) ([]s3.Object, error) {
+	if err := fs.loadFolders(); err != nil {
+		return nil, err
+	}
+	var rows []davRecord
+	_, err := fs.clients.Postgrest.From("files").Select(davSelect+"file_folder_mapping(file_id,folder_id)", "", false).
+		Eq("owner_id", fs.user.UserID).
+		Eq("is_deleted", "false").
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&rows)
+	if err != nil {
+		return nil, apierror.Internal("Failed to list files", err)
+	}
+
+	paths := folderPaths(fs.folders)
+	seen := make(map[string]bool)
+	occupied := make(map[string]bool) // Folder IDs with something listed in them
+	var objects []s3.Object
+	add := func(folderID string, file *davRecord) {
+		dir, ok := paths[folderID]
+		key := dir + file.Filename
+		if !ok || seen[key] || fs.subfolder(folderID, file.Filename) != nil {
+			return
+		}
+		seen[key] = true
+		occupied[folderID] = true
+		objects = append(objects, s3.Object{
+			Key:          key,
+			LastModified: file.CreatedAt.Time.UTC().Truncate(time.Millisecond),
+			ETag:         objectETag(file),
+			Size:         file.Content.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	for i := range rows {
+		file := &rows[i]
+		if file.Content.ClientEncryption != "" {
+			continue
+		}
+		if len(file.Folders) == 0 {
+			add("", file)
+		}
+		for _, mapping := range file.Folders {
+			add(mapping.FolderID, file)
+		}
+	}
+
+	for _, folder := range fs.folders {
+		occupied[folderParent(folder)] = true
+	}
+	for _, folder := range fs.folders {
+		if dir, ok := paths[folder.FolderID]; ok && !occupied[folder.FolderID] && !seen[dir] {
+			seen[dir] = true
+			objects = append(objects, s3.Object{Key: dir, LastModified: folder.CreatedAt.Time.UTC().Truncate(time.Millisecond), ETag: `"` + emptyMD5 + `"`, StorageClass: "STANDARD"})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// folderPaths maps folder IDs to their key prefixes, such as "a/b/" ("" for the top level).
+// Folders whose parents are missing, or that are their own ancestors, are left out.
+func folderPaths(folders []models.Folder) map[string]string {
+	byID := make(map[string]models.Folder, len(folders))
+	for _, folder := range folders {
+		byID[folder.FolderID] = folder
+	}
+	paths := map[string]string{"": ""}
+	var pathOf func(id string, depth int) (string, bool)
+	pathOf = func(id string, depth int) (string, bool) {
+		if path, ok := paths[id]; ok {
+			return path, true
+		}
+		folder, ok := byID[id]
+		if !ok || depth > len(folders) {
+			return "", false
+		}
+		parent, ok := pathOf(folderParent(folder), depth+1)
+		if !ok {
+			return "", false
+		}
+		paths[id] = parent + folder.Name + "/"
+		return paths[id], true
+	}
+	for _, folder := range folders {
+		pathOf(folder.FolderID, 0)
+	}
+	return paths
+}
+
+// responseOverrides are the query parameters of GetObject that set response headers, which
+// presigned download links use. Objects are always served as attachments that browsers may not
+// sniff, so a link cannot render a stored file as a page of the vault's origin: a
+// response-content-disposition override is only honoured if it is an attachment too.
+var responseOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+// getObject answers GetObject and HeadObject, with ranges and conditional requests.
+func (g *s3Gateway) getObject(c *gin.Context, fs *davFS, name string, folder bool) error {
+	info, err := fs.resolve(name)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir() != folder) {
+		return s3.ErrNoSuchKey
+	}
+	if err != nil {
+		return err
+	}
+
+	header := c.Writer.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("X-Content-Type-Options", "nosniff")
+	if folder {
+		header.Set("ETag", `"`+emptyMD5+`"`)
+		header.Set("Content-Type", "application/octet-stream")
+		http.ServeContent(c.Writer, c.Request, "", info.ModTime(), strings.NewReader(""))
+		return nil
+	}
+	contentType, _ := info.ContentType(c.Request.Context())
+	header.Set("ETag", objectETag(info.file))
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	for param, name := range responseOverrides {
+		value := c.Query(param)
+		if value == "" || (name == "Content-Disposition" && !isAttachment(value)) {
+			continue
+		}
+		header.Set(name, value)
+	}
+
+	reader := &davReader{fs: fs, ctx: c.Request.Context(), info: info}
+	defer reader.Close()
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime(), reader)
+	if fs.failure != nil {
+		// The status was sent already; all that is left is to cut the body short
+		logger(c).Error("Error serving S3 object", "file_id", info.file.FileID, "error", fs.failure)
+	}
+	return nil
+}
+
+// isAttachment reports whether a Content-Disposition value has browsers download the response.
+func isAttachment(disposition string) bool {
+	kind, _, err := mime.ParseMediaType(disposition)
+	return err == nil && kind == "attachment"
+}
+
+// putObject answers PutObject. A key ending in "/" creates the folders it names.
+func (g *s3Gateway) putObject(c *gin.Context, fs *davFS, name string, folder bool) error {
+	ctx := c.Request.Context()
+	if folder {
+		if err := mkdirAll(ctx, fs, splitPath(name)); err != nil {
+			return err
+		}
+		c.Header("ETag", `"`+emptyMD5+`"`)
+		c.Status(http.StatusOK)
+		return nil
+	}
+
+	auth := c.MustGet("s3Auth").(*s3.Auth)
+	digest, err := contentMD5(c)
+	if err != nil {
+		return err
+	}
+	length := auth.DecodedLength(c.Request)
+	body := auth.Body(c.Request)
+	fs.declaredType = objectContentType(c.GetHeader("Content-Type"))
+	info, err := storeObject(ctx, fs, name, func(w io.Writer) error {
+		hash := md5.New()
+		n, err := io.Copy(io.MultiWriter(w, hash), body)
+		switch {
+		case err != nil:
+			return bodyError(err)
+		case length >= 0 && n != length:
+			return s3.ErrIncompleteBody
+		case digest != nil && !bytes.Equal(hash.Sum(nil), digest):
+			return s3.ErrBadDigest
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Header("ETag", objectETag(info.file))
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// objectContentType returns the type a client declared for an object, which is checked
+// against the content like the type of any other upload. AWS SDKs send binary/octet-stream
+// when the caller sets none; like application/octet-stream it leaves the type to be detected.
+func objectContentType(header string) string {
+	if mediaType, _, err := mime.ParseMediaType(header); err == nil && mediaType == "binary/octet-stream" {
+		return ""
+	}
+	return header
+}
+
+// contentMD5 decodes the Content-MD5 header, if the client sent one.
+func contentMD5(c *gin.Context) ([]byte, error) {
+	value := c.GetHeader("Content-MD5")
+	if value == "" {
+		return nil, nil
+	}
+	digest, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(digest) != md5.Size {
+		return nil, s3.ErrInvalidDigest
+	}
+	return digest, nil
+}
+
+// bodyError reports a failure to read a request body: a signature mismatch as such, anything
+// else as the client not sending what it announced.
+func bodyError(err error) error {
+	var s3Err *s3.Error
+	if errors.As(err, &s3Err) {
+		return s3Err
+	}
+	return s3.ErrIncompleteBody
+}
+
+// storeObject stores what fill writes as the object at name, creating the folders on the way.
+// If fill fails nothing is stored.
+func storeObject(ctx context.Context, fs *davFS, name string, fill func(io.Writer) error) (*davInfo, error) {
+	segments := splitPath(name)
+	if err := mkdirAll(ctx, fs, segments[:len(segments)-1]); err != nil {
+		return nil, err
+	}
+	file, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0)
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return nil, s3.ErrInvalidArgument.WithMessage("A folder has this key; add a name after it")
+	}
+	if err != nil {
+		return nil, err
+	}
+	writer := file.(*davWriter)
+	if err := fill(writer); err != nil {
+		writer.abort()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return writer.info, nil
+}
+
+// mkdirAll creates the folders segments lead to that do not exist yet.
+func mkdirAll(ctx context.Context, fs *davFS, segments []string) error {
+	for i := range segments {
+		if _, err := fs.resolveDir(segments[:i+1]); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		err := fs.Mkdir(ctx, "/"+strings.Join(segments[:i+1], "/"), 0)
+		if errors.Is(err, os.ErrExist) {
+			return s3.ErrInvalidArgument.WithMessage("An object has the name of a folder in this key: " + strings.Join(segments[:i+1], "/"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObject answers DeleteObject. Every file of the key's name in its folder is deleted,
+// so no older one takes its place. A folder key only deletes the folder if it is empty, as
+// deleting a marker object leaves the objects under it in S3. Missing keys are not an error.
+func (g *s3Gateway) deleteObject(c *gin.Context, fs *davFS, name string, folder bool) error {
+	ctx := c.Request.Context()
+	info, err := fs.resolve(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.Status(http.StatusNoContent)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fs.changed()
+	switch {
+	case folder && info.folder != nil:
+		files, err := fs.files(info.folder.FolderID, "")
+		if err != nil {
+			return err
+		}
+		empty := len(files) == 0
+		for _, child := range fs.folders {
+			if folderParent(child) == info.folder.FolderID {
+				empty = false
+			}
+		}
+		if empty {
+			if err := fs.removeFolder(ctx, *info.folder); err != nil {
+				return err
+			}
+		}
+	case !folder && info.file != nil:
+		files, err := fs.files(info.parentID, info.name)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := fs.removeFile(ctx, file, info.parentID); err != nil {
+				return err
+			}
+		}
+	}
+	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// RespondS3 writes err as an S3 error document and aborts the request. Errors from the rest
+// of the vault are translated; their internal causes are logged but never sent.
+func RespondS3(c *gin.Context, err error) {
+	s3Err := s3Error(err)
+	if s3Err.Status >= http.StatusInternalServerError {
+		logger(c).Error("S3 request failed", "error", err)
+	}
+	s3.WriteError(c.Writer, c.Request, s3Err, logging.RequestID(c.Request.Context()))
+	c.Abort()
+}
+
+// s3Error translates err into the S3 error clients understand.
+func s3Error(err error) *s3.Error {
+	var s3Err *s3.Error
+	if errors.As(err, &s3Err) {
+		return s3Err
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return s3.ErrNoSuchKey
+	}
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case apierror.CodeQuotaExceeded:
+			return s3.ErrQuotaExceeded
+		case apierror.CodeNotFound:
+			return s3.ErrNoSuchKey
+		case apierror.CodeInvalidRequest, apierror.CodeMIMEMismatch:
+			return s3.ErrInvalidRequest.WithMessage(apiErr.Message)
+		}
+	}
+	return s3.ErrInternal
+}
+
+// hexMD5 returns the hex MD5 of what r yields.
+func hexMD5(r io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
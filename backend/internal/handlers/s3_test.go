@@ -0,0 +1,21 @@
+package handlers
+
+import "testing"
+
+func TestIsAttachment(t *testing.T) {
+	cases := map[string]bool{
+		"attachment":                        true,
+		`attachment; filename="report.pdf"`: true,
+		"ATTACHMENT; filename=a.txt":        true,
+		"inline":                            false,
+		`inline; filename="page.html"`:      false,
+		"":                                  false,
+		"attachment; filename":              false,
+		"attachmentx":                       false,
+	}
+	for disposition, want := range cases {
+		if got := isAttachment(disposition); got != want {
+			t.Errorf("isAttachment(%q) = %v, want %v", disposition, got, want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/s3"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartExpiry is how long the parts of an unfinished multipart upload are kept.
+const multipartExpiry = 7 * 24 * time.Hour
+
+// maxPartNumber is the highest part number S3 accepts.
+const maxPartNumber = 10000
+
+// maxCompleteBody bounds the part list of CompleteMultipartUpload, ample for 10000 parts.
+const maxCompleteBody = 2 << 20
+
+// uploadIDPattern matches upload IDs, which name directories and so must be nothing else.
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// multipartUpload is the meta.json of an upload in progress. Its parts wait beside it on the
+// server's local disk until the upload completes: they are not content yet, which the blob
+// store's garbage collector would delete.
+type multipartUpload struct {
+	UserID      string    `json:"user_id"`
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type,omitempty"` // Declared when the upload started
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// partPath returns where part number n of the upload in dir is kept.
+func partPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%05d", n))
+}
+
+// createMultipartUpload answers CreateMultipartUpload, clearing out abandoned uploads first.
+func (g *s3Gateway) createMultipartUpload(c *gin.Context, fs *davFS, key string, folder bool) error {
+	if folder {
+		return s3.ErrInvalidArgument.WithMessage("Folders cannot be uploaded in parts")
+	}
+	g.removeExpiredUploads(c)
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(random)
+	dir := filepath.Join(g.parts, uploadID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	meta, err := json.Marshal(multipartUpload{
+		UserID:      fs.user.UserID,
+		Key:         key,
+		ContentType: objectContentType(c.GetHeader("Content-Type")),
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "meta.json"), meta, 0o600)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	s3.WriteXML(c.Writer, http.StatusOK, &s3.InitiateMultipartUploadResult{Bucket: s3Bucket, Key: key, UploadID: uploadID})
+	return nil
+}
+
+// removeExpiredUploads deletes the parts of uploads started more than multipartExpiry ago.
+func (g *s3Gateway) removeExpiredUploads(c *gin.Context) {
+	entries, err := os.ReadDir(g.parts)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(g.parts, entry.Name())
+		var meta multipartUpload
+		data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+		if err == nil {
+			err = json.Unmarshal(data, &meta)
+		}
+		if err != nil {
+			// Left behind by a failed start; give it the same time before removing it
+			info, statErr := entry.Info()
+			if statErr != nil {
+				continue
+			}
+			meta.CreatedAt = info.ModTime()
+		}
+		if time.Since(meta.CreatedAt) > multipartExpiry {
+			if err := os.RemoveAll(dir); err != nil {
+				logger(c).Error("Error removing expired multipart upload", "upload_id", entry.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// upload returns the directory and metadata of the caller's upload uploadID for key.
+func (g *s3Gateway) upload(fs *davFS, key, uploadID string) (string, *multipartUpload, error) {
+	if !uploadIDPattern.MatchString(uploadID) {
+		return "", nil, s3.ErrNoSuchUpload
+	}
+	dir := filepath.Join(g.parts, uploadID)
+	data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, s3.ErrNoSuchUpload
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var meta multipartUpload
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", nil, err
+	}
+	if meta.UserID != fs.user.UserID || meta.Key != key {
+		return "", nil, s3.ErrNoSuchUpload
+	}
+	return dir, &meta, nil
+}
+
+// openUploadBytes returns how much of the server's disk the unfinished multipart uploads of
+// userID take up, parts still arriving included, leaving out the files in skip.
+func (g *s3Gateway) openUploadBytes(userID string, skip ...string) (int64, error) {
+	entries, err := os.ReadDir(g.parts)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		dir := filepath.Join(g.parts, entry.Name())
+		var meta multipartUpload
+		data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+		if err != nil || json.Unmarshal(data, &meta) != nil || meta.UserID != userID {
+			continue
+		}
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue // Completed or aborted meanwhile
+		}
+	files:
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			if file.Name() == "meta.json" {
+				continue
+			}
+			for _, skipped := range skip {
+				if path == skipped {
+					continue files
+				}
+			}
+			if info, err := file.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+	return total, nil
+}
+
+// uploadPart answers UploadPart. The parts of all the caller's unfinished uploads count
+// against the quota, which is checked against the part's declared length before anything is
+// written to disk, so neither one upload nor many at once can hold more than the caller may
+// store. The check is repeated once the part has arrived, for parts received concurrently.
+func (g *s3Gateway) uploadPart(c *gin.Context, fs *davFS, key, uploadID string) error {
+	n, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		return s3.ErrInvalidArgument.WithMessage(fmt.Sprintf("Part number must be an integer between 1 and %d", maxPartNumber))
+	}
+	dir, _, err := g.upload(fs, key, uploadID)
+	if err != nil {
+		return err
+	}
+	digest, err := contentMD5(c)
+	if err != nil {
+		return err
+	}
+
+	auth := c.MustGet("s3Auth").(*s3.Auth)
+	length := auth.DecodedLength(c.Request)
+	if length < 0 {
+		return s3.ErrMissingContentLength
+	}
+	// A part uploaded again replaces the earlier one
+	open, err := g.openUploadBytes(fs.user.UserID, partPath(dir, n))
+	if err != nil {
+		return err
+	}
+	if _, err := checkQuota(fs.clients, fs.user.UserID, open+length); err != nil {
+		return err
+	}
+
+	incoming, err := os.CreateTemp(dir, "incoming-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(incoming.Name())
+	hash := md5.New()
+	// Never write more than was declared, and checked against the quota
+	size, err := io.Copy(io.MultiWriter(incoming, hash), io.LimitReader(auth.Body(c.Request), length+1))
+	if closeErr := incoming.Close(); err == nil && closeErr != nil {
+		return closeErr
+	}
+	switch {
+	case err != nil:
+		return bodyError(err)
+	case size != length:
+		return s3.ErrIncompleteBody
+	case digest != nil && !bytes.Equal(hash.Sum(nil), digest):
+		return s3.ErrBadDigest
+	}
+
+	open, err = g.openUploadBytes(fs.user.UserID, partPath(dir, n), incoming.Name())
+	if err != nil {
+		return err
+	}
+	if _, err := checkQuota(fs.clients, fs.user.UserID, open+size); err != nil {
+		return err
+	}
+	if err := os.Rename(incoming.Name(), partPath(dir, n)); err != nil {
+		return err
+	}
+
+	c.Header("ETag", `"`+hex.EncodeToString(hash.Sum(nil))+`"`)
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// listPartFiles returns the parts received for the upload in dir by part number.
+func listPartFiles(dir string) (map[int]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	parts := make(map[int]os.FileInfo)
+	for _, entry := range entries {
+		number, ok := strings.CutPrefix(entry.Name(), "part-")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		parts[n] = info
+	}
+	return parts, nil
+}
+
+// completeMultipartUpload answers CompleteMultipartUpload: the listed parts are joined, in
+// order, and stored like a PutObject of the whole. The object's ETag is the MD5 of its
+// content, as for objects put whole, rather than S3's digest of the part digests. The parts
+// are kept until the object is stored, so a failed completion can be retried.
+func (g *s3Gateway) completeMultipartUpload(c *gin.Context, fs *davFS, key, name, uploadID string) error {
+	dir, meta, err := g.upload(fs, key, uploadID)
+	if err != nil {
+		return err
+	}
+	fs.declaredType = meta.ContentType
+	auth := c.MustGet("s3Auth").(*s3.Auth)
+	body, err := io.ReadAll(io.LimitReader(auth.Body(c.Request), maxCompleteBody))
+	if err != nil {
+		return bodyError(err)
+	}
+	var request s3.CompleteMultipartUpload
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		return s3.ErrMalformedXML
+	}
+	for i, part := range request.Parts {
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			return s3.ErrInvalidPartOrder
+		}
+	}
+
+	info, err := storeObject(c.Request.Context(), fs, name, func(w io.Writer) error {
+		for _, part := range request.Parts {
+			file, err := os.Open(partPath(dir, part.PartNumber))
+			if errors.Is(err, os.ErrNotExist) {
+				return s3.ErrInvalidPart
+			}
+			if err != nil {
+				return err
+			}
+			hash := md5.New()
+			_, err = io.Copy(io.MultiWriter(w, hash), file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+			if hex.EncodeToString(hash.Sum(nil)) != strings.Trim(part.ETag, `"`) {
+				return s3.ErrInvalidPart
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		logger(c).Error("Error removing parts of a completed multipart upload", "upload_id", uploadID, "error", err)
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	location := url.URL{Scheme: scheme, Host: c.Request.Host, Path: g.prefix + "/" + s3Bucket + "/" + key}
+	s3.WriteXML(c.Writer, http.StatusOK, &s3.CompleteMultipartUploadResult{
+		Location: location.String(),
+		Bucket:   s3Bucket,
+		Key:      key,
+		ETag:     objectETag(info.file),
+	})
+	return nil
+}
+
+// abortMultipartUpload answers AbortMultipartUpload by deleting the parts.
+func (g *s3Gateway) abortMultipartUpload(c *gin.Context, fs *davFS, key, uploadID string) error {
+	dir, _, err := g.upload(fs, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// listParts answers ListParts, all parts in one response.
+func (g *s3Gateway) listParts(c *gin.Context, fs *davFS, key, uploadID string) error {
+	dir, _, err := g.upload(fs, key, uploadID)
+	if err != nil {
+		return err
+	}
+	files, err := listPartFiles(dir)
+	if err != nil {
+		return err
+	}
+	result := &s3.ListPartsResult{Bucket: s3Bucket, Key: key, UploadID: uploadID}
+	for n, info := range files {
+		file, err := os.Open(partPath(dir, n))
+		if err != nil {
+			return err
+		}
+		etag, err := hexMD5(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		result.Parts = append(result.Parts, s3.Part{PartNumber: n, LastModified: info.ModTime().UTC().Truncate(time.Millisecond), ETag: `"` + etag + `"`, Size: info.Size()})
+	}
+	sort.Slice(result.Parts, func(i, j int) bool { return result.Parts[i].PartNumber < result.Parts[j].PartNumber })
+
+	s3.WriteXML(c.Writer, http.StatusOK, result)
+	return nil
+}
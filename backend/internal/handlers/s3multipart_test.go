@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUpload creates an upload directory under g.parts owned by userID holding files.
+func writeUpload(t *testing.T, g *s3Gateway, uploadID, userID string, files map[string]int) string {
+	t.Helper()
+	dir := filepath.Join(g.parts, uploadID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := json.Marshal(multipartUpload{UserID: userID, Key: "bucket/key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), meta, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("x", size)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestOpenUploadBytesCountsEveryUploadOfTheUser(t *testing.T) {
+	g := &s3Gateway{parts: t.TempDir()}
+	first := writeUpload(t, g, "upload-1", "alice", map[string]int{"part-00001": 10, "part-00002": 20})
+	writeUpload(t, g, "upload-2", "alice", map[string]int{"part-00001": 30, "incoming-123": 5})
+	writeUpload(t, g, "upload-3", "bob", map[string]int{"part-00001": 1000})
+
+	total, err := g.openUploadBytes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 65 {
+		t.Errorf("open bytes = %d, want 65 across both of alice's uploads", total)
+	}
+
+	total, err = g.openUploadBytes("alice", partPath(first, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 45 {
+		t.Errorf("open bytes skipping a part = %d, want 45", total)
+	}
+}
+
+func TestOpenUploadBytesWithoutPartsDirectory(t *testing.T) {
+	g := &s3Gateway{parts: filepath.Join(t.TempDir(), "missing")}
+	total, err := g.openUploadBytes("alice")
+	if err != nil || total != 0 {
+		t.Errorf("openUploadBytes = %d, %v; want 0, nil", total, err)
+	}
+}
+
+func TestObjectContentType(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"binary/octet-stream":       "",
+		"image/png":                 "image/png",
+		"text/plain; charset=utf-8": "text/plain; charset=utf-8",
+		"application/octet-stream":  "application/octet-stream",
+	}
+	for header, want := range cases {
+		if got := objectContentType(header); got != want {
+			t.Errorf("objectContentType(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
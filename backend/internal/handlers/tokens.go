@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/logging"
+	"file-vault/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// personalTokenPrefix starts every personal token, so leaked tokens are easy to recognise.
+const personalTokenPrefix = "fvt_"
+
+// tokenUseInterval limits how often authenticating with a token rewrites its last_used_at.
+const tokenUseInterval = time.Hour
+
+// ErrInvalidToken is returned by AuthenticateToken when the username and token do not match
+// a live personal token.
+var ErrInvalidToken = errors.New("invalid personal token")
+
+// hashToken returns the hex SHA-256 under which a personal token is stored. Tokens are 256
+// random bits, so a fast unsalted hash is enough to keep the table useless if it leaks.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePersonalToken issues a personal access token for the authenticated user. The token is
+// returned once; only its hash is kept.
+func CreatePersonalToken(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var payload struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			apierror.Respond(c, apierror.InvalidBody(err))
+			return
+		}
+		name := strings.TrimSpace(payload.Name)
+		if name == "" || len(name) > 100 {
+			apierror.Respond(c, apierror.BadRequest("name must be between 1 and 100 characters"))
+			return
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to generate token", err))
+			return
+		}
+		token := personalTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+		var created []models.PersonalToken
+		_, err := clients.Postgrest.From("personal_tokens").Insert(map[string]interface{}{
+			"user_id":    c.GetString("userID"),
+			"name":       name,
+			"token_hash": hashToken(token),
+		}, false, "", "representation", "").ExecuteTo(&created)
+		if err != nil || len(created) == 0 {
+			apierror.Respond(c, apierror.Internal("Failed to create token", err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"token_id":   created[0].TokenID,
+			"name":       created[0].Name,
+			"created_at": created[0].CreatedAt,
+			"token":      token,
+		})
+	}
+}
+
+// ListPersonalTokens lists the authenticated user's personal tokens, without the tokens themselves.
+func ListPersonalTokens(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var tokens []models.PersonalToken
+		_, err := clients.Postgrest.From("personal_tokens").Select("token_id,user_id,name,created_at,last_used_at", "", false).
+			Eq("user_id", c.GetString("userID")).
+			Order("created_at", nil).
+			ExecuteTo(&tokens)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to list tokens", err))
+			return
+		}
+		if tokens == nil {
+			tokens = []models.PersonalToken{}
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// RevokePersonalToken deletes one of the authenticated user's personal tokens.
+func RevokePersonalToken(clients *database.AppClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		var deleted []models.PersonalToken
+		_, err := clients.Postgrest.From("personal_tokens").Delete("", "").
+			Eq("token_id", c.Param("id")).
+			Eq("user_id", c.GetString("userID")).
+			ExecuteTo(&deleted)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to revoke token", err))
+			return
+		}
+		if len(deleted) == 0 {
+			apierror.Respond(c, apierror.NotFound("Token not found"))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// AuthenticateToken returns the user a personal token belongs to. username must be that
+// user's username or email, as clients that only speak HTTP Basic send both.
+func AuthenticateToken(ctx context.Context, clients *database.AppClients, username, token string) (models.User, error) {
+	if !strings.HasPrefix(token, personalTokenPrefix) {
+		return models.User{}, ErrInvalidToken
+	}
+	var stored models.PersonalToken
+	_, err := clients.Postgrest.From("personal_tokens").Select("*", "", false).Single().Eq("token_hash", hashToken(token)).ExecuteTo(&stored)
+	if err != nil {
+		return models.User{}, ErrInvalidToken
+	}
+
+	var user models.User
+	_, err = clients.Postgrest.From("users").Select("*", "", false).Single().Eq("user_id", stored.UserID).ExecuteTo(&user)
+	if err != nil {
+		return models.User{}, ErrInvalidToken
+	}
+	if username != user.Username && !strings.EqualFold(username, user.Email) {
+		return models.User{}, ErrInvalidToken
+	}
+
+	now := time.Now().UTC()
+	if stored.LastUsedAt == nil || now.Sub(stored.LastUsedAt.Time) > tokenUseInterval {
+		_, _, err := clients.Postgrest.From("personal_tokens").Update(map[string]interface{}{"last_used_at": now}, "", "").Eq("token_id", stored.TokenID).Execute()
+		if err != nil {
+			logging.FromContext(ctx).Error("Error recording token use", "token_id", stored.TokenID, "error", err)
+		}
+	}
+	return user, nil
+}
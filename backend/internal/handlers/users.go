@@ -2,23 +2,24 @@ package handlers
 
 import (
 	"encoding/json" // Import encoding/json
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/config"
 	"file-vault/backend/internal/database"
 	"file-vault/backend/internal/email"
 	"file-vault/backend/internal/models"
 	"fmt" // Import fmt for error handling
-	"log"
 	"math/rand"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/supabase-community/postgrest-go" // Import postgrest-go
 	"golang.org/x/crypto/bcrypt"
 )
 
 // RegisterUser handles the registration of a new user
-func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
+func RegisterUser(clients *database.AppClients, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := clients.WithContext(c.Request.Context()).Postgrest
 		var newUser struct {
 			Username    string `json:"username" binding:"required"`
 			Email       string `json:"email" binding:"required"`
@@ -30,7 +31,7 @@ func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
 		}
 
 		if err := c.ShouldBindJSON(&newUser); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, apierror.InvalidBody(err))
 			return
 		}
 
@@ -41,34 +42,34 @@ func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
 		}
 		respBody, _, err := db.From("users").Select("first_name,email_verified", "exact", false).Filter("email", "eq", newUser.Email).Execute()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check for existing user: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to check for existing user", err))
 			return
 		}
 		if err := json.Unmarshal(respBody, &existingUsers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to unmarshal existing user data: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to unmarshal existing user data", err))
 			return
 		}
 
 		if len(existingUsers) > 0 {
 			if existingUsers[0].EmailVerified {
-				c.JSON(http.StatusConflict, gin.H{"error": "A verified user with this email already exists."})
+				apierror.Respond(c, apierror.New(http.StatusConflict, apierror.CodeAlreadyExists, "A verified user with this email already exists."))
 				return
 			}
 
 			// User exists but is not verified, resend OTP
 			otp := fmt.Sprintf("%06d", rand.Intn(1000000))
-			otpExpiresAt := time.Now().Add(15 * time.Minute)
+			otpExpiresAt := time.Now().Add(cfg.OTP.Lifetime)
 			updateData := map[string]interface{}{
 				"otp":            otp,
 				"otp_expires_at": otpExpiresAt,
 			}
 			_, _, updateErr := db.From("users").Update(updateData, "", "").Filter("email", "eq", newUser.Email).Execute()
 			if updateErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update OTP for existing user: %v", updateErr)})
+				apierror.Respond(c, apierror.Internal("Failed to update OTP for existing user", updateErr))
 				return
 			}
-			if err := email.SendOTP(existingUsers[0].FirstName, newUser.Email, otp); err != nil {
-				log.Printf("Failed to resend OTP to %s: %v", newUser.Email, err)
+			if err := email.SendOTP(c.Request.Context(), cfg.SMTP, cfg.OTP.Lifetime, existingUsers[0].FirstName, newUser.Email, otp); err != nil {
+				logger(c).Error("Failed to resend OTP", "error", err)
 			}
 			c.JSON(http.StatusOK, gin.H{"message": "User already exists. A new verification OTP has been sent to your email."})
 			return
@@ -77,7 +78,7 @@ func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
 		// User does not exist, proceed with creation
 		hashedPassword, hashErr := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
 		if hashErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			apierror.Respond(c, apierror.Internal("Failed to hash password", hashErr))
 			return
 		}
 
@@ -98,7 +99,7 @@ func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
 
 		// Generate OTP
 		otp := fmt.Sprintf("%06d", rand.Intn(1000000))
-		otpExpiresAt := time.Now().Add(15 * time.Minute)
+		otpExpiresAt := time.Now().Add(cfg.OTP.Lifetime)
 
 		user["otp"] = otp
 		user["otp_expires_at"] = otpExpiresAt
@@ -107,13 +108,13 @@ func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
 		// The first return value is []byte (response body), second is count (int64), third is error
 		_, _, insertDBErr := db.From("users").Insert(user, false, "", "", "").Execute() // Use a new variable for the DB error
 		if insertDBErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create user: %v", insertDBErr)})
+			apierror.Respond(c, apierror.Internal("Failed to create user", insertDBErr))
 			return
 		}
 
 		// Send OTP via email
-		if err := email.SendOTP(newUser.FirstName, newUser.Email, otp); err != nil {
-			log.Printf("Failed to send OTP to %s: %v", newUser.Email, err)
+		if err := email.SendOTP(c.Request.Context(), cfg.SMTP, cfg.OTP.Lifetime, newUser.FirstName, newUser.Email, otp); err != nil {
+			logger(c).Error("Failed to send OTP", "error", err)
 			// Note: In a real app, you might want to handle this more gracefully
 		}
 
@@ -122,15 +123,16 @@ func RegisterUser(db *postgrest.Client) gin.HandlerFunc {
 }
 
 // LoginUser handles user login and token generation
-func LoginUser(db *postgrest.Client) gin.HandlerFunc {
+func LoginUser(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := clients.WithContext(c.Request.Context()).Postgrest
 		var credentials struct {
 			Email    string `json:"email" binding:"required"`
 			Password string `json:"password" binding:"required"`
 		}
 
 		if err := c.ShouldBindJSON(&credentials); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, apierror.InvalidBody(err))
 			return
 		}
 
@@ -149,22 +151,22 @@ func LoginUser(db *postgrest.Client) gin.HandlerFunc {
 		// The first return value is []byte (response body), second is count (int64), third is error
 		respBody, _, err := db.From("users").Select("user_id,username,email,password_hash,email_verified,first_name,last_name,is_admin", "exact", false).Filter("email", "eq", credentials.Email).Execute() // Correctly assign all three return values
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to retrieve user: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to retrieve user", err))
 			return
 		}
 
 		// Unmarshal the response body into the users slice
 		if len(respBody) == 0 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid credentials"))
 			return
 		}
 		if err := json.Unmarshal(respBody, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to unmarshal user data: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to unmarshal user data", err))
 			return
 		}
 
 		if len(users) == 0 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid credentials"))
 			return
 		}
 
@@ -173,12 +175,12 @@ func LoginUser(db *postgrest.Client) gin.HandlerFunc {
 
 		bcryptErr := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(credentials.Password))
 		if bcryptErr != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid credentials"))
 			return
 		}
 
 		if !user.EmailVerified {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Please verify your email before logging in."})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeEmailNotVerified, "Please verify your email before logging in."))
 			return
 		}
 
@@ -197,15 +199,16 @@ func LoginUser(db *postgrest.Client) gin.HandlerFunc {
 }
 
 // VerifyOTP handles the verification of a user's email using an OTP
-func VerifyOTP(db *postgrest.Client) gin.HandlerFunc {
+func VerifyOTP(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := clients.WithContext(c.Request.Context()).Postgrest
 		var payload struct {
 			Email string `json:"email" binding:"required"`
 			OTP   string `json:"otp" binding:"required"`
 		}
 
 		if err := c.ShouldBindJSON(&payload); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, apierror.InvalidBody(err))
 			return
 		}
 
@@ -217,29 +220,29 @@ func VerifyOTP(db *postgrest.Client) gin.HandlerFunc {
 
 		respBody, _, err := db.From("users").Select("otp,otp_expires_at,email_verified", "exact", false).Filter("email", "eq", payload.Email).Execute()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to retrieve user: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to retrieve user", err))
 			return
 		}
 
 		if err := json.Unmarshal(respBody, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to unmarshal user data: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to unmarshal user data", err))
 			return
 		}
 
 		if len(users) == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Respond(c, apierror.NotFound("User not found"))
 			return
 		}
 
 		user := users[0]
 
 		if user.EmailVerified {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified."})
+			apierror.Respond(c, apierror.New(http.StatusBadRequest, apierror.CodeAlreadyVerified, "Email is already verified."))
 			return
 		}
 
 		if user.OTP != payload.OTP {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid OTP"})
+			apierror.Respond(c, apierror.New(http.StatusBadRequest, apierror.CodeInvalidOTP, "Invalid OTP"))
 			return
 		}
 
@@ -251,13 +254,13 @@ func VerifyOTP(db *postgrest.Client) gin.HandlerFunc {
 			// Try parsing without fractional seconds as a fallback
 			otpExpiresAt, parseErr = time.Parse("2006-01-02T15:04:05", user.OTPExpiresAt)
 			if parseErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse expiration time: %v", parseErr)})
+				apierror.Respond(c, apierror.Internal("Failed to parse expiration time", parseErr))
 				return
 			}
 		}
 
 		if time.Now().After(otpExpiresAt) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "OTP has expired"})
+			apierror.Respond(c, apierror.New(http.StatusBadRequest, apierror.CodeOTPExpired, "OTP has expired"))
 			return
 		}
 
@@ -270,7 +273,7 @@ func VerifyOTP(db *postgrest.Client) gin.HandlerFunc {
 
 		_, _, updateErr := db.From("users").Update(updateData, "", "").Filter("email", "eq", payload.Email).Execute()
 		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update user: %v", updateErr)})
+			apierror.Respond(c, apierror.Internal("Failed to update user", updateErr))
 			return
 		}
 
@@ -279,14 +282,15 @@ func VerifyOTP(db *postgrest.Client) gin.HandlerFunc {
 }
 
 // ResendOTP handles resending a new OTP to the user's email
-func ResendOTP(db *postgrest.Client) gin.HandlerFunc {
+func ResendOTP(clients *database.AppClients, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := clients.WithContext(c.Request.Context()).Postgrest
 		var payload struct {
 			Email string `json:"email" binding:"required"`
 		}
 
 		if err := c.ShouldBindJSON(&payload); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, apierror.InvalidBody(err))
 			return
 		}
 
@@ -297,22 +301,22 @@ func ResendOTP(db *postgrest.Client) gin.HandlerFunc {
 		}
 		respBody, _, err := db.From("users").Select("first_name,email_verified", "exact", false).Filter("email", "eq", payload.Email).Execute()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check user verification status: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to check user verification status", err))
 			return
 		}
 		if err := json.Unmarshal(respBody, &existingUsers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to unmarshal user data: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to unmarshal user data", err))
 			return
 		}
 
 		if len(existingUsers) > 0 && existingUsers[0].EmailVerified {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified."})
+			apierror.Respond(c, apierror.New(http.StatusBadRequest, apierror.CodeAlreadyVerified, "Email is already verified."))
 			return
 		}
 
 		// Generate new OTP
 		otp := fmt.Sprintf("%06d", rand.Intn(1000000))
-		otpExpiresAt := time.Now().Add(15 * time.Minute)
+		otpExpiresAt := time.Now().Add(cfg.OTP.Lifetime)
 
 		updateData := map[string]interface{}{
 			"otp":            otp,
@@ -321,13 +325,13 @@ func ResendOTP(db *postgrest.Client) gin.HandlerFunc {
 
 		_, _, updateErr := db.From("users").Update(updateData, "", "").Filter("email", "eq", payload.Email).Execute()
 		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update OTP: %v", updateErr)})
+			apierror.Respond(c, apierror.Internal("Failed to update OTP", updateErr))
 			return
 		}
 
 		// Send new OTP via email
-		if err := email.SendOTP(existingUsers[0].FirstName, payload.Email, otp); err != nil {
-			log.Printf("Failed to resend OTP to %s: %v", payload.Email, err)
+		if err := email.SendOTP(c.Request.Context(), cfg.SMTP, cfg.OTP.Lifetime, existingUsers[0].FirstName, payload.Email, otp); err != nil {
+			logger(c).Error("Failed to resend OTP", "error", err)
 			// Note: In a real app, you might want to handle this more gracefully
 		}
 
@@ -339,13 +343,13 @@ func GetUserQuota(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "User not found in context"))
 			return
 		}
 
 		userModel, ok := user.(models.User)
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user model in context"})
+			apierror.Respond(c, apierror.Internal("Invalid user model in context", nil))
 			return
 		}
 
@@ -359,25 +363,26 @@ func GetUserQuota(clients *database.AppClients) gin.HandlerFunc {
 // UpdatePassword handles changing a user's password
 func UpdatePassword(clients *database.AppClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
 		var payload struct {
 			CurrentPassword string `json:"current_password" binding:"required"`
 			NewPassword     string `json:"new_password" binding:"required"`
 		}
 
 		if err := c.ShouldBindJSON(&payload); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, apierror.InvalidBody(err))
 			return
 		}
 
 		user, exists := c.Get("user")
 		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "User not found in context"))
 			return
 		}
 
 		userModel, ok := user.(models.User)
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user model in context"})
+			apierror.Respond(c, apierror.Internal("Invalid user model in context", nil))
 			return
 		}
 
@@ -387,16 +392,16 @@ func UpdatePassword(clients *database.AppClients) gin.HandlerFunc {
 		}
 		respBody, _, err := clients.Postgrest.From("users").Select("password_hash", "exact", false).Filter("user_id", "eq", userModel.UserID).Execute()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to retrieve user password hash: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to retrieve user password hash", err))
 			return
 		}
 		if err := json.Unmarshal(respBody, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to unmarshal user password hash data: %v", err)})
+			apierror.Respond(c, apierror.Internal("Failed to unmarshal user password hash data", err))
 			return
 		}
 
 		if len(users) == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Respond(c, apierror.NotFound("User not found"))
 			return
 		}
 
@@ -405,14 +410,14 @@ func UpdatePassword(clients *database.AppClients) gin.HandlerFunc {
 		// Verify the current password
 		bcryptErr := bcrypt.CompareHashAndPassword([]byte(storedPasswordHash), []byte(payload.CurrentPassword))
 		if bcryptErr != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid current password"})
+			apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid current password"))
 			return
 		}
 
 		// Hash the new password
 		hashedNewPassword, hashErr := bcrypt.GenerateFromPassword([]byte(payload.NewPassword), bcrypt.DefaultCost)
 		if hashErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash new password"})
+			apierror.Respond(c, apierror.Internal("Failed to hash new password", hashErr))
 			return
 		}
 
@@ -423,7 +428,7 @@ func UpdatePassword(clients *database.AppClients) gin.HandlerFunc {
 
 		_, _, updateErr := clients.Postgrest.From("users").Update(updateData, "", "").Filter("user_id", "eq", userModel.UserID).Execute()
 		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update password: %v", updateErr)})
+			apierror.Respond(c, apierror.Internal("Failed to update password", updateErr))
 			return
 		}
 
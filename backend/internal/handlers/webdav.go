@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVMethods are the HTTP methods the WebDAV endpoint is routed for.
+var WebDAVMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete,
+	"MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK", "PROPFIND", "PROPPATCH",
+}
+
+// WebDAV serves the authenticated user's folders and files to WebDAV clients, such as the
+// network drives of OS file managers, under prefix. It must run after TokenAuthMiddleware.
+func WebDAV(clients *database.AppClients, prefix string) gin.HandlerFunc {
+	uploads := newUploadStore(clients)
+	content := clients.ContentStore()
+	// One lock table serves every user, each through their own view of it. Locks live in this process.
+	locks := webdav.NewMemLS()
+	return func(c *gin.Context) {
+		clients := clients.WithContext(c.Request.Context())
+		user := c.MustGet("user").(models.User)
+
+		fs := &davFS{clients: clients, user: user, uploads: uploads, content: content}
+		if c.Request.Method == http.MethodPut {
+			fs.declaredType = c.GetHeader("Content-Type")
+			fs.body = &davBody{ReadCloser: c.Request.Body, length: c.Request.ContentLength}
+			c.Request.Body = fs.body
+		}
+		handler := &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fs,
+			LockSystem: &userLocks{locks: locks, userID: user.UserID},
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					logger(c).Debug("WebDAV request failed", "method", r.Method, "error", err)
+				}
+			},
+		}
+		w := &davResponse{ResponseWriter: c.Writer, fs: fs}
+		handler.ServeHTTP(w, c.Request)
+		if w.failed {
+			apierror.Respond(c, davError(fs.failure))
+		}
+	}
+}
+
+// userLocks is one user's view of the shared lock table. Each user's paths are their own, so
+// names are placed under the user's ID; and since the table hands out sequential tokens,
+// tokens carry the user's ID too and are refused when presented by anyone else.
+type userLocks struct {
+	locks  webdav.LockSystem
+	userID string
+}
+
+func (l *userLocks) name(name string) string {
+	if name == "" {
+		return "" // Confirm is called with an empty second name for single-resource requests
+	}
+	return path.Join("/", l.userID, name)
+}
+
+func (l *userLocks) token(token string) string {
+	return l.userID + ":" + token
+}
+
+// own returns the table's token behind a token handed to this user, or "" for anyone else's.
+func (l *userLocks) own(token string) string {
+	inner, ok := strings.CutPrefix(token, l.userID+":")
+	if !ok {
+		return ""
+	}
+	return inner
+}
+
+func (l *userLocks) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	scoped := make([]webdav.Condition, len(conditions))
+	for i, condition := range conditions {
+		if condition.Token != "" {
+			// Another user's token becomes one the table never issued, which matches no lock
+			if condition.Token = l.own(condition.Token); condition.Token == "" {
+				condition.Token = "-"
+			}
+		}
+		scoped[i] = condition
+	}
+	return l.locks.Confirm(now, l.name(name0), l.name(name1), scoped...)
+}
+
+func (l *userLocks) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = l.name(details.Root)
+	token, err := l.locks.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+	return l.token(token), nil
+}
+
+func (l *userLocks) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	inner := l.own(token)
+	if inner == "" {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	details, err := l.locks.Refresh(now, inner, duration)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	details.Root = "/" + strings.TrimPrefix(strings.TrimPrefix(details.Root, "/"+l.userID), "/")
+	return details, nil
+}
+
+func (l *userLocks) Unlock(now time.Time, token string) error {
+	inner := l.own(token)
+	if inner == "" {
+		return webdav.ErrNoSuchLock
+	}
+	return l.locks.Unlock(now, inner)
+}
+
+// davResponse holds back the plain-text error webdav writes when the file system failed with
+// an API error, so the client gets that error's status and problem document instead.
+type davResponse struct {
+	gin.ResponseWriter
+	fs     *davFS
+	failed bool
+}
+
+func (w *davResponse) WriteHeader(status int) {
+	if status >= http.StatusBadRequest && w.fs.failure != nil {
+		w.failed = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *davResponse) Write(b []byte) (int, error) {
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// davError gives errors the statuses WebDAV clients know: file managers report 507 as a full
+// disk and 415 as a file the server will not take.
+func davError(err *apierror.Error) *apierror.Error {
+	mapped := *err
+	switch err.Code {
+	case apierror.CodeQuotaExceeded:
+		mapped.Status = http.StatusInsufficientStorage
+	case apierror.CodeMIMEMismatch:
+		mapped.Status = http.StatusUnsupportedMediaType
+	}
+	return &mapped
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestUserLocksShareOneTable(t *testing.T) {
+	now := time.Now()
+	shared := webdav.NewMemLS()
+	alice := &userLocks{locks: shared, userID: "alice"}
+	bob := &userLocks{locks: shared, userID: "bob"}
+	details := webdav.LockDetails{Root: "/reports/q1.pdf", Duration: time.Minute, ZeroDepth: true}
+
+	aliceToken, err := alice.Create(now, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same path, different user: the paths name different files
+	bobToken, err := bob.Create(now, details)
+	if err != nil {
+		t.Fatalf("bob's lock conflicts with alice's: %v", err)
+	}
+	if _, err := alice.Create(now, details); !errors.Is(err, webdav.ErrLocked) {
+		t.Fatalf("second lock on alice's file = %v, want ErrLocked", err)
+	}
+
+	// Each user's lock only answers to that user's token
+	if _, err := bob.Confirm(now, "/reports/q1.pdf", "", webdav.Condition{Token: aliceToken}); !errors.Is(err, webdav.ErrConfirmationFailed) {
+		t.Fatalf("bob confirmed with alice's token: %v", err)
+	}
+	if _, err := bob.Refresh(now, aliceToken, time.Minute); !errors.Is(err, webdav.ErrNoSuchLock) {
+		t.Fatalf("bob refreshed alice's lock: %v", err)
+	}
+	if err := bob.Unlock(now, aliceToken); !errors.Is(err, webdav.ErrNoSuchLock) {
+		t.Fatalf("bob unlocked alice's lock: %v", err)
+	}
+	release, err := alice.Confirm(now, "/reports/q1.pdf", "", webdav.Condition{Token: aliceToken})
+	if err != nil {
+		t.Fatalf("alice could not use her lock: %v", err)
+	}
+	release()
+
+	refreshed, err := alice.Refresh(now, aliceToken, 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Root != "/reports/q1.pdf" {
+		t.Fatalf("refreshed lock reports root %q", refreshed.Root)
+	}
+
+	for user, token := range map[*userLocks]string{alice: aliceToken, bob: bobToken} {
+		if err := user.Unlock(now, token); err != nil {
+			t.Fatalf("%s unlock: %v", user.userID, err)
+		}
+	}
+	if _, err := alice.Create(now, details); err != nil {
+		t.Fatal(err)
+	}
+}
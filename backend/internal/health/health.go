@@ -0,0 +1,136 @@
+// Package health probes the server's dependencies for readiness checks and diagnostics.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dependency states reported by a check.
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusDisabled = "disabled"
+)
+
+// ErrDisabled is returned by a probe whose dependency is not configured. It is reported but
+// does not make the server unready.
+var ErrDisabled = errors.New("not configured")
+
+// Check is one dependency probe.
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) error
+	// Optional checks are reported but do not fail readiness: the API still serves
+	// everything else while they are down.
+	Optional bool
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Optional  bool    `json:"optional,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check. Ready is false when a required dependency is down or
+// the server is draining.
+type Report struct {
+	Ready     bool      `json:"ready"`
+	Draining  bool      `json:"draining,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Checker runs the checks concurrently, each bounded by a timeout. Reports are reused for
+// cacheFor so frequent probes from several orchestrator replicas do not hammer the
+// dependencies.
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	cacheFor time.Duration
+
+	mu       sync.Mutex // Held while probing, so concurrent requests share one run
+	last     *Report
+	draining atomic.Bool
+}
+
+// NewChecker returns a Checker over checks.
+func NewChecker(timeout, cacheFor time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout, cacheFor: cacheFor}
+}
+
+// SetDraining marks the server as shutting down, so readiness fails and load balancers stop
+// sending new requests while in-flight ones finish.
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// Check returns the latest report, probing the dependencies again if it is older than cacheFor.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil || time.Since(c.last.CheckedAt) >= c.cacheFor {
+		// The report outlives this request, so a client hanging up must not fail the probes
+		report := c.run(context.WithoutCancel(ctx))
+		c.last = &report
+	}
+	report := *c.last
+	report.Draining = c.draining.Load()
+	report.Ready = report.Ready && !report.Draining
+	return report
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = probe(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Ready: true, CheckedAt: time.Now(), Checks: results}
+	for _, result := range results {
+		if result.Status == StatusDown && !result.Optional {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// probe runs one check, giving up when ctx expires even if the probe itself ignores it.
+func probe(ctx context.Context, check Check) Result {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Probe(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{Name: check.Name, Status: StatusUp, Optional: check.Optional, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	switch {
+	case errors.Is(err, ErrDisabled):
+		result.Status = StatusDisabled
+		result.LatencyMS = 0
+	case err != nil:
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+
+	"file-vault/backend/internal/config"
+	"file-vault/backend/internal/database"
+	"file-vault/backend/internal/replication"
+	"file-vault/backend/internal/storage"
+)
+
+// canaryPrefix is where blob store probes write their canary objects. The probe deletes its
+// canary right away; blobs gc removes any left behind by a crash.
+const canaryPrefix = "health/"
+
+// Dependencies returns the checks of everything the server needs: the metadata store, the
+// blob store new uploads go to, the cold store and the mail server.
+func Dependencies(clients *database.AppClients, smtpConfig config.SMTPConfig) []Check {
+	content := clients.ContentStore()
+	checks := []Check{
+		{Name: "metadata_store", Probe: func(ctx context.Context) error { return clients.Ping() }},
+		{Name: "blob_store", Probe: canary(content.StoreFor(storage.ClassHot))},
+	}
+	if clients.Tiering != nil {
+		checks = append(checks, Check{Name: "cold_store", Probe: canary(clients.Tiering.Cold)})
+	}
+	// Without mail only registration and OTP emails fail, so keep serving
+	checks = append(checks, Check{Name: "smtp", Probe: SMTP(smtpConfig), Optional: true})
+	return checks
+}
+
+// canary probes store with a write, read and delete of a small random object.
+func canary(store storage.BlobStore) func(ctx context.Context) error {
+	// Replicating canaries would only queue work that never commits; probe the primary
+	if replicated, ok := store.(*replication.Store); ok {
+		store = replicated.Primary()
+	}
+	return func(ctx context.Context) error {
+		payload := make([]byte, 16)
+		if _, err := rand.Read(payload); err != nil {
+			return err
+		}
+		key := canaryPrefix + hex.EncodeToString(payload[:8])
+
+		if err := store.Put(ctx, key, bytes.NewReader(payload)); err != nil {
+			return fmt.Errorf("write canary: %w", err)
+		}
+		written := true
+		defer func() {
+			if written {
+				store.Delete(context.WithoutCancel(ctx), key)
+			}
+		}()
+
+		r, err := store.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("read canary: %w", err)
+		}
+		defer r.Close()
+		got, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read canary: %w", err)
+		}
+		if !bytes.Equal(got, payload) {
+			return fmt.Errorf("canary read back %d bytes that differ from those written", len(got))
+		}
+		written = false
+		if err := store.Delete(ctx, key); err != nil {
+			return fmt.Errorf("delete canary: %w", err)
+		}
+		return nil
+	}
+}
+
+// SMTP probes that the mail server accepts connections and greets. It does not log in, so
+// probing does not count against the account's login limits.
+func SMTP(cfg config.SMTPConfig) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if cfg.Host == "" {
+			return ErrDisabled
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		client, err := smtp.NewClient(conn, cfg.Host)
+		if err != nil {
+			return fmt.Errorf("greeting: %w", err)
+		}
+		return client.Quit()
+	}
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build describes the running binary.
+type Build struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // Built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+}
+
+// Version is the release version, set at build time with
+// -ldflags "-X file-vault/backend/internal/health.Version=v1.2.3".
+var Version = ""
+
+// BuildInfo reports the version and the VCS revision the binary was built from.
+func BuildInfo() Build {
+	build := Build{Version: Version, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	if build.Version == "" {
+		build.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
@@ -0,0 +1,207 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+	"golang.org/x/time/rate"
+)
+
+// KindBackendMigration copies every hot blob to another storage backend and switches its row over.
+const KindBackendMigration = "backend_migration"
+
+// KindBackendCleanup deletes the copies a backend migration left on the backends it moved content off.
+const KindBackendCleanup = "backend_cleanup"
+
+// BackendMigrationParams are the params of backend migration and cleanup jobs.
+type BackendMigrationParams struct {
+	// Target is the name of the backend in STORAGE_BACKENDS content moves to.
+	Target string `json:"target"`
+	// BytesPerSecond caps the copy rate of a migration; zero means unlimited. A throttled
+	// batch can take far longer than leaseTimeout: the run's heartbeat keeps the lease, and
+	// losing it cancels the copy in progress.
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty"`
+}
+
+// maxCopyBurst bounds how many bytes a rate-limited copy moves at once.
+const maxCopyBurst = 1 << 20
+
+// BackendMigration returns the Factory for backend migration jobs. Each blob is copied byte
+// for byte, read back through decryption and checked against hash_sha256, and only then is
+// the row pointed at the target. The source copy stays until a cleanup job removes it, so
+// downloads keep working whichever backend a row names while the migration runs.
+func BackendMigration(db *postgrest.Client, content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		params, err := decodeBackendParams(job, content)
+		if err != nil {
+			return nil, err
+		}
+		target, _ := content.Backend(params.Target)
+		var limiter *rate.Limiter
+		if params.BytesPerSecond > 0 {
+			limiter = rate.NewLimiter(rate.Limit(params.BytesPerSecond), int(min(params.BytesPerSecond, maxCopyBurst)))
+		}
+
+		return func(ctx context.Context, fc *models.FileContent) error {
+			if fc.StorageClass == storage.ClassCold || fc.StorageBackend == params.Target {
+				return nil
+			}
+			src, err := content.StoreOf(fc).Get(ctx, fc.StoragePath)
+			if err != nil {
+				return fmt.Errorf("read blob %s: %w", fc.StoragePath, err)
+			}
+			var r io.Reader = src
+			if limiter != nil {
+				r = &throttledReader{ctx: ctx, r: src, limiter: limiter}
+			}
+			err = target.Put(ctx, fc.StoragePath, r)
+			src.Close()
+			if err != nil {
+				return fmt.Errorf("write blob %s to %s: %w", fc.StoragePath, params.Target, err)
+			}
+
+			moved := *fc
+			moved.StorageBackend = params.Target
+			if err := content.Verify(ctx, &moved); err != nil {
+				discardCopy(ctx, target, params.Target, fc.StoragePath)
+				return fmt.Errorf("verify copy on %s: %w", params.Target, err)
+			}
+
+			var updated []models.FileContent
+			_, err = db.From("file_contents").
+				Update(map[string]interface{}{"storage_backend": params.Target}, "representation", "").
+				Eq("content_id", fc.ContentID).
+				Eq("storage_path", fc.StoragePath).
+				Eq("storage_class", storage.ClassHot).
+				Eq("storage_backend", fc.StorageBackend).
+				ExecuteTo(&updated)
+			if err != nil || len(updated) == 0 {
+				discardCopy(ctx, target, params.Target, fc.StoragePath)
+				if err == nil {
+					err = errors.New("content changed while its blob was being copied")
+				}
+				return err
+			}
+			fc.StorageBackend = params.Target
+			return nil
+		}, nil
+	}
+}
+
+// BackendCleanup returns the Factory for backend cleanup jobs. It deletes every blob already
+// on the target from the other hot backends, and refuses to start while any hot content still
+// lives elsewhere, so it can only remove copies nothing reads any more.
+func BackendCleanup(db *postgrest.Client, content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		params, err := decodeBackendParams(job, content)
+		if err != nil {
+			return nil, err
+		}
+		remaining, err := NotOnBackend(db, params.Target)
+		if err != nil {
+			return nil, err
+		}
+		if remaining > 0 {
+			return nil, fmt.Errorf("%d blobs are not on %s yet; finish the migration first", remaining, params.Target)
+		}
+
+		target, _ := content.Backend(params.Target)
+		var sources []storage.BlobStore
+		for _, name := range append([]string{""}, content.BackendNames()...) {
+			if store, _ := content.Backend(name); name != params.Target && store != target {
+				sources = append(sources, store)
+			}
+		}
+
+		return func(ctx context.Context, fc *models.FileContent) error {
+			if fc.StorageClass == storage.ClassCold || fc.StorageBackend != params.Target {
+				return nil
+			}
+			var firstErr error
+			for _, source := range sources {
+				if err := source.Delete(ctx, fc.StoragePath); err != nil && !errors.Is(err, storage.ErrNotFound) && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr
+		}, nil
+	}
+}
+
+// NotOnBackend counts the hot content stored on a backend other than name.
+func NotOnBackend(db *postgrest.Client, name string) (int64, error) {
+	_, count, err := db.From("file_contents").Select("content_id", "exact", true).
+		Eq("storage_class", storage.ClassHot).
+		Neq("storage_backend", name).
+		Execute()
+	if err != nil {
+		return 0, fmt.Errorf("count content left to migrate: %w", err)
+	}
+	return count, nil
+}
+
+// BackendUsage counts the hot content stored on each backend; the default store is counted
+// under the empty name.
+func BackendUsage(db *postgrest.Client, names []string) (map[string]int64, error) {
+	usage := make(map[string]int64, len(names)+1)
+	for _, name := range append([]string{""}, names...) {
+		_, count, err := db.From("file_contents").Select("content_id", "exact", true).
+			Eq("storage_class", storage.ClassHot).
+			Eq("storage_backend", name).
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("count content on backend %q: %w", name, err)
+		}
+		usage[name] = count
+	}
+	return usage, nil
+}
+
+func decodeBackendParams(job *models.Job, content *storage.ContentStore) (BackendMigrationParams, error) {
+	var params BackendMigrationParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return params, fmt.Errorf("decode backend migration params: %w", err)
+	}
+	if params.Target == "" {
+		return params, errors.New("backend migration needs a target backend")
+	}
+	if _, err := content.Backend(params.Target); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// discardCopy removes a copy that did not become the row's blob.
+func discardCopy(ctx context.Context, store storage.BlobStore, backend, key string) {
+	if err := store.Delete(ctx, key); err != nil {
+		slog.Error("Error removing discarded blob copy", "backend", backend, "key", key, "error", err)
+	}
+}
+
+// throttledReader limits how fast a blob copy reads its source.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
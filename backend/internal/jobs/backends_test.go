@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestThrottledReaderStopsWhenRunIsCancelled(t *testing.T) {
+	// At 1 KiB/s the 64 KiB blob would take a minute to copy
+	limiter := rate.NewLimiter(1024, 1024)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(50*time.Millisecond, func() { cancel(ErrLeaseLost) })
+
+	start := time.Now()
+	r := &throttledReader{ctx: ctx, r: bytes.NewReader(make([]byte, 64<<10)), limiter: limiter}
+	_, err := io.Copy(io.Discard, r)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("copy = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("copy kept going for %v after the lease was lost", elapsed)
+	}
+}
+
+func TestThrottledReaderLimitsRate(t *testing.T) {
+	limiter := rate.NewLimiter(8<<10, 1024)
+	limiter.AllowN(time.Now(), 1024) // Start with an empty bucket
+
+	start := time.Now()
+	r := &throttledReader{ctx: context.Background(), r: bytes.NewReader(make([]byte, 2<<10)), limiter: limiter}
+	n, err := io.Copy(io.Discard, r)
+	if err != nil || n != 2<<10 {
+		t.Fatalf("copy = %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("2 KiB at 8 KiB/s took %v, want about 250ms", elapsed)
+	}
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"context"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// KindBlobLayout moves blobs stored under random keys to content-addressed keys.
+const KindBlobLayout = "blob_layout"
+
+// KindScrub reads every blob back and checks it against its content hash.
+const KindScrub = "scrub"
+
+// BlobLayout returns the Factory for blob layout migration jobs. Blobs are copied byte for
+// byte, so the migration needs no keys and runs while the server keeps serving: downloads
+// read whichever path the row holds, and a row that changes mid-copy keeps its own blob.
+func BlobLayout(db *postgrest.Client, content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		return func(ctx context.Context, fc *models.FileContent) error {
+			if _, ok := storage.ParseContentKey(fc.StoragePath); ok {
+				return nil
+			}
+			oldPath := fc.StoragePath
+			if err := content.Relocate(ctx, fc); err != nil {
+				return err
+			}
+			return swapBlob(ctx, db, content, fc, oldPath, map[string]interface{}{"storage_path": fc.StoragePath})
+		}, nil
+	}
+}
+
+// Scrub returns the Factory for scrub jobs. Every blob that is missing, corrupt or stored
+// under a key for different content is counted as a failure, with the latest in last_error.
+func Scrub(content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		return func(ctx context.Context, fc *models.FileContent) error {
+			return content.Verify(ctx, fc)
+		}, nil
+	}
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// GCReport summarises a garbage collection pass over the blob store.
+type GCReport struct {
+	Scanned    int // Blobs listed
+	Referenced int // Blobs a file_contents row points at
+	Recent     int // Unreferenced blobs kept because they are younger than the grace period
+	Superseded int // Unreferenced content-addressed blobs whose content is stored elsewhere
+	Unknown    int // Unreferenced blobs of content no row records (failed uploads, legacy keys)
+	Deleted    int
+	Bytes      int64 // Size of the deleted (or, in a dry run, deletable) blobs
+}
+
+// CollectGarbage deletes blobs in the store new content of storage class is written to that
+// no file_contents row stored there references. Blobs younger than grace are
+// kept, since uploads and blob copies write the blob before the row points at it. It refuses
+// to run against an empty file_contents table, which more likely means lost metadata than a
+// store full of garbage; content-addressed keys let those blobs be identified and recovered.
+func CollectGarbage(ctx context.Context, db *postgrest.Client, content *storage.ContentStore, class string, grace time.Duration, dryRun bool) (*GCReport, error) {
+	blobs := content.StoreFor(class)
+	lister, ok := blobs.(storage.Lister)
+	if !ok {
+		return nil, errors.New("blob store cannot list its blobs")
+	}
+
+	referenced := make(map[string]bool)
+	hashes := make(map[string]bool)
+	cursor := ""
+	for {
+		var batch []models.FileContent
+		query := db.From("file_contents").Select("content_id,hash_sha256,storage_path,storage_class,storage_backend", "", false).
+			Order("content_id", &postgrest.OrderOpts{Ascending: true}).
+			Limit(batchSize, "")
+		if cursor != "" {
+			query = query.Gt("content_id", cursor)
+		}
+		if _, err := query.ExecuteTo(&batch); err != nil {
+			return nil, fmt.Errorf("fetch file_contents after %q: %w", cursor, err)
+		}
+		for i, fc := range batch {
+			if content.StoreOf(&batch[i]) == blobs {
+				referenced[fc.StoragePath] = true
+			}
+			hashes[fc.HashSHA256] = true
+			cursor = fc.ContentID
+		}
+		if len(batch) < batchSize {
+			break
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, errors.New("file_contents is empty; refusing to delete every blob")
+	}
+
+	report := &GCReport{}
+	cutoff := time.Now().Add(-grace)
+	err := lister.List(ctx, func(blob storage.BlobInfo) error {
+		report.Scanned++
+		if referenced[blob.Key] {
+			report.Referenced++
+			return nil
+		}
+		if blob.CreatedAt.After(cutoff) {
+			report.Recent++
+			return nil
+		}
+		if hash, ok := storage.ParseContentKey(blob.Key); ok && hashes[hash] {
+			report.Superseded++
+		} else {
+			report.Unknown++
+		}
+
+		report.Bytes += blob.Size
+		if dryRun {
+			return nil
+		}
+		if err := blobs.Delete(ctx, blob.Key); err != nil {
+			slog.Error("Error deleting orphaned blob", "key", blob.Key, "error", err)
+			return nil
+		}
+		report.Deleted++
+		return nil
+	})
+	return report, err
+}
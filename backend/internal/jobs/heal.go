@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/replication"
+	"file-vault/backend/internal/storage"
+)
+
+// KindHeal rebuilds the lost or corrupt shards of every blob on an erasure-coded store.
+const KindHeal = "heal"
+
+// Heal returns the Factory for heal jobs. Content on a store that cannot heal itself is skipped.
+func Heal(content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		if !CanHeal(content) {
+			return nil, errors.New("no erasure-coded blob store is configured")
+		}
+		return func(ctx context.Context, fc *models.FileContent) error {
+			healer, ok := healerFor(content.StoreOf(fc))
+			if !ok {
+				return nil
+			}
+			n, err := healer.Heal(ctx, fc.StoragePath)
+			if n > 0 {
+				slog.Info("Rebuilt shards", "content_id", fc.ContentID, "shards", n)
+			}
+			return err
+		}, nil
+	}
+}
+
+// CanHeal reports whether any store of content can heal its blobs.
+func CanHeal(content *storage.ContentStore) bool {
+	stores := []storage.BlobStore{content.Blobs(), content.StoreFor(storage.ClassCold)}
+	for _, name := range content.BackendNames() {
+		store, _ := content.Backend(name)
+		stores = append(stores, store)
+	}
+	for _, store := range stores {
+		if _, ok := healerFor(store); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// healerFor returns the Healer behind store, looking through replication to its primary.
+func healerFor(store storage.BlobStore) (storage.Healer, bool) {
+	if replicated, ok := store.(*replication.Store); ok {
+		store = replicated.Primary()
+	}
+	healer, ok := store.(storage.Healer)
+	return healer, ok
+}
@@ -0,0 +1,180 @@
+package jobs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"file-vault/backend/internal/keys"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// KindKeyRotation re-wraps (and optionally re-encrypts) every blob under the current master key.
+const KindKeyRotation = "key_rotation"
+
+// KeyRotationParams are the params of a key rotation job.
+type KeyRotationParams struct {
+	TargetKeyID string `json:"target_key_id"`
+	// Reencrypt copies every blob under a fresh data key instead of only re-wrapping the existing one.
+	Reencrypt bool `json:"reencrypt"`
+}
+
+// KeyRotation returns the Factory for key rotation jobs.
+func KeyRotation(db *postgrest.Client, content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		var params KeyRotationParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return nil, fmt.Errorf("decode key rotation params: %w", err)
+		}
+		if current := content.CurrentKeyID(); params.TargetKeyID != current {
+			return nil, fmt.Errorf("job targets master key %q but the current key is %q", params.TargetKeyID, current)
+		}
+
+		if params.Reencrypt {
+			return func(ctx context.Context, fc *models.FileContent) error {
+				return reencryptContent(ctx, db, content, fc)
+			}, nil
+		}
+		return func(ctx context.Context, fc *models.FileContent) error {
+			return rewrapContent(ctx, db, content, fc)
+		}, nil
+	}
+}
+
+// rewrapContent re-wraps fc's data key. The update only applies if the row still carries
+// the key it was read with, so a concurrent rotation cannot be overwritten.
+func rewrapContent(ctx context.Context, db *postgrest.Client, content *storage.ContentStore, fc *models.FileContent) error {
+	oldKeyID := fc.KeyID
+	changed, err := content.Rewrap(ctx, fc)
+	if err != nil || !changed {
+		return err
+	}
+	_, _, err = db.From("file_contents").
+		Update(map[string]interface{}{"key_id": fc.KeyID, "wrapped_key": fc.WrappedKey}, "", "").
+		Eq("content_id", fc.ContentID).
+		Eq("key_id", oldKeyID).
+		Execute()
+	return err
+}
+
+// reencryptContent writes a freshly encrypted copy of fc's blob and points the row at it.
+func reencryptContent(ctx context.Context, db *postgrest.Client, content *storage.ContentStore, fc *models.FileContent) error {
+	oldPath := fc.StoragePath
+	if err := content.Reencrypt(ctx, fc, storage.ContentKey(fc.HashSHA256)); err != nil {
+		return err
+	}
+	return swapBlob(ctx, db, content, fc, oldPath, map[string]interface{}{
+		"storage_path": fc.StoragePath,
+		"stored_size":  fc.StoredSize,
+		"encryption":   fc.Encryption,
+		"key_id":       fc.KeyID,
+		"wrapped_key":  fc.WrappedKey,
+	})
+}
+
+// swapBlob points fc's row at the copy just written to fc.StoragePath and removes the old
+// blob. The update only applies if the row still references oldPath; if it changed in the
+// meantime (for example the content was deleted) the copy is discarded instead.
+func swapBlob(ctx context.Context, db *postgrest.Client, content *storage.ContentStore, fc *models.FileContent, oldPath string, fields map[string]interface{}) error {
+	var updated []models.FileContent
+	_, err := db.From("file_contents").
+		Update(fields, "representation", "").
+		Eq("content_id", fc.ContentID).
+		Eq("storage_path", oldPath).
+		ExecuteTo(&updated)
+	if err != nil || len(updated) == 0 {
+		if removeErr := content.StoreOf(fc).Delete(ctx, fc.StoragePath); removeErr != nil {
+			slog.Error("Error removing discarded blob copy", "key", fc.StoragePath, "error", removeErr)
+		}
+		if err == nil {
+			err = fmt.Errorf("content changed while its blob was being copied")
+		}
+		return err
+	}
+
+	if err := content.StoreOf(fc).Delete(ctx, oldPath); err != nil {
+		// The row already points at the new blob; the old one is only an orphan
+		slog.Error("Error removing old blob", "key", oldPath, "error", err)
+	}
+	return nil
+}
+
+// KeyUsage counts the file_contents rows whose data key is wrapped under each master key.
+// Unencrypted blobs are counted under the empty key ID.
+func KeyUsage(db *postgrest.Client, keyIDs []string) (map[string]int64, error) {
+	usage := make(map[string]int64, len(keyIDs)+1)
+	for _, id := range keyIDs {
+		_, count, err := db.From("file_contents").Select("content_id", "exact", true).Eq("key_id", id).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("count blobs under key %q: %w", id, err)
+		}
+		usage[id] = count
+	}
+	_, count, err := db.From("file_contents").Select("content_id", "exact", true).Is("encryption", "null").Execute()
+	if err != nil {
+		return nil, fmt.Errorf("count unencrypted blobs: %w", err)
+	}
+	usage[""] = count
+	return usage, nil
+}
+
+// AccessKeyUsage counts the S3 access keys whose secret is wrapped under each master key.
+func AccessKeyUsage(db *postgrest.Client, keyIDs []string) (map[string]int64, error) {
+	usage := make(map[string]int64, len(keyIDs))
+	for _, id := range keyIDs {
+		_, count, err := db.From("s3_access_keys").Select("access_key_id", "exact", true).Eq("key_id", id).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("count access keys under key %q: %w", id, err)
+		}
+		usage[id] = count
+	}
+	return usage, nil
+}
+
+// RewrapAccessKeys re-wraps the secret of every S3 access key not yet under the current master
+// key and returns how many it changed. There are few access keys, so this runs inline when a
+// rotation starts rather than as part of the blob sweep.
+func RewrapAccessKeys(ctx context.Context, db *postgrest.Client, provider keys.Provider) (int, error) {
+	current := provider.CurrentKeyID()
+	var stale []struct {
+		AccessKeyID string `json:"access_key_id"`
+		SecretKey   string `json:"secret_key"`
+		KeyID       string `json:"key_id"`
+	}
+	_, err := db.From("s3_access_keys").Select("access_key_id,secret_key,key_id", "", false).Neq("key_id", current).ExecuteTo(&stale)
+	if err != nil {
+		return 0, fmt.Errorf("list access keys to re-wrap: %w", err)
+	}
+
+	changed := 0
+	for _, key := range stale {
+		wrapped, err := base64.StdEncoding.DecodeString(key.SecretKey)
+		if err != nil {
+			return changed, fmt.Errorf("decode secret of access key %s: %w", key.AccessKeyID, err)
+		}
+		secret, err := provider.UnwrapKey(ctx, key.KeyID, wrapped)
+		if err != nil {
+			return changed, fmt.Errorf("unwrap secret of access key %s: %w", key.AccessKeyID, err)
+		}
+		keyID, rewrapped, err := provider.WrapKey(ctx, secret)
+		if err != nil {
+			return changed, err
+		}
+		// Only if nobody re-wrapped it in the meantime, e.g. on first use after the restart
+		_, _, err = db.From("s3_access_keys").
+			Update(map[string]interface{}{"key_id": keyID, "secret_key": base64.StdEncoding.EncodeToString(rewrapped)}, "", "").
+			Eq("access_key_id", key.AccessKeyID).
+			Eq("key_id", key.KeyID).
+			Execute()
+		if err != nil {
+			return changed, fmt.Errorf("store re-wrapped access key %s: %w", key.AccessKeyID, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
@@ -0,0 +1,441 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"file-vault/backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Job statuses stored in jobs.status.
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	// batchSize is the number of file_contents rows processed between checkpoints.
+	batchSize = 100
+	// leaseTimeout is how long a running job may go without a heartbeat before
+	// another process is allowed to take it over.
+	leaseTimeout = 2 * time.Minute
+)
+
+// heartbeatInterval is how often a running job renews its lease, independently of its
+// checkpoints: a single batch of large or throttled blobs can take longer than leaseTimeout.
+var heartbeatInterval = leaseTimeout / 3
+
+var (
+	// ErrAlreadyRunning is returned when starting a job while another of the same kind is running.
+	ErrAlreadyRunning = errors.New("a job of this kind is already running")
+	// ErrLeaseLost is returned when a job was cancelled or taken over by another process mid-run.
+	ErrLeaseLost = errors.New("job was cancelled or claimed by another process")
+)
+
+// SweepFunc processes a single file_contents row. An error marks the row as failed;
+// the sweep records it and carries on with the next row.
+type SweepFunc func(ctx context.Context, fc *models.FileContent) error
+
+// Factory prepares the per-row function for a job, decoding its params.
+// It is called every time the job starts or resumes.
+type Factory func(job *models.Job) (SweepFunc, error)
+
+// Manager runs resumable sweeps over file_contents and persists their progress in the 'jobs' table.
+// Progress is checkpointed after every batch, so a job interrupted by a restart continues
+// from its cursor instead of starting over.
+type Manager struct {
+	db        *postgrest.Client
+	owner     string
+	factories map[string]Factory
+
+	// OnCheckpoint, when set, is called with a snapshot of the job after every checkpoint.
+	OnCheckpoint func(job models.Job)
+
+	mu       sync.Mutex
+	cancel   map[string]context.CancelFunc
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewManager creates a Manager storing job state through db.
+func NewManager(db *postgrest.Client) *Manager {
+	host, _ := os.Hostname()
+	return &Manager{
+		db:        db,
+		owner:     host + ":" + strconv.Itoa(os.Getpid()) + ":" + uuid.New().String()[:8],
+		factories: make(map[string]Factory),
+		cancel:    make(map[string]context.CancelFunc),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Owner identifies this process in the lease_owner column of the jobs it runs.
+func (m *Manager) Owner() string {
+	return m.owner
+}
+
+// Running returns the IDs of the jobs this process is running in the background.
+func (m *Manager) Running() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.cancel))
+	for id := range m.cancel {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Register makes a job kind available to Start and Resume.
+func (m *Manager) Register(kind string, factory Factory) {
+	m.factories[kind] = factory
+}
+
+// Create records a new running job owned by this process without starting it.
+func (m *Manager) Create(kind string, params interface{}) (*models.Job, error) {
+	if _, ok := m.factories[kind]; !ok {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+
+	var running []models.Job
+	_, err := m.db.From("jobs").Select("job_id", "", false).Eq("kind", kind).Eq("status", StatusRunning).ExecuteTo(&running)
+	if err != nil {
+		return nil, fmt.Errorf("check running jobs: %w", err)
+	}
+	if len(running) > 0 {
+		return nil, fmt.Errorf("%w (%s)", ErrAlreadyRunning, running[0].JobID)
+	}
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	_, total, err := m.db.From("file_contents").Select("content_id", "exact", true).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("count file contents: %w", err)
+	}
+
+	now := models.CustomTime{Time: time.Now().UTC()}
+	job := &models.Job{
+		JobID:       uuid.New().String(),
+		Kind:        kind,
+		Status:      StatusRunning,
+		Params:      rawParams,
+		Total:       total,
+		LeaseOwner:  m.owner,
+		HeartbeatAt: &now,
+		CreatedAt:   now,
+	}
+	if _, _, err := m.db.From("jobs").Insert(job, false, "", "", "").Execute(); err != nil {
+		return nil, fmt.Errorf("create job: %w", err)
+	}
+	return job, nil
+}
+
+// Start creates a job and runs it in the background.
+func (m *Manager) Start(kind string, params interface{}) (*models.Job, error) {
+	job, err := m.Create(kind, params)
+	if err != nil {
+		return nil, err
+	}
+	m.runInBackground(job)
+	return job, nil
+}
+
+// Resume takes over every running job whose owner stopped heartbeating (for example
+// because the process restarted) and continues it in the background.
+func (m *Manager) Resume() error {
+	stale, err := m.Stale()
+	if err != nil {
+		return err
+	}
+	for i := range stale {
+		job := &stale[i]
+		if err := m.Claim(job); err != nil {
+			slog.Error("Could not resume job", "job_id", job.JobID, "error", err)
+			continue
+		}
+		slog.Info("Resuming job", "kind", job.Kind, "job_id", job.JobID, "processed", job.Processed, "total", job.Total)
+		m.runInBackground(job)
+	}
+	return nil
+}
+
+// Stale returns running jobs whose lease has expired.
+func (m *Manager) Stale() ([]models.Job, error) {
+	cutoff := time.Now().UTC().Add(-leaseTimeout).Format(time.RFC3339Nano)
+	var stale []models.Job
+	_, err := m.db.From("jobs").Select("*", "", false).Eq("status", StatusRunning).Lt("heartbeat_at", cutoff).ExecuteTo(&stale)
+	if err != nil {
+		return nil, fmt.Errorf("list stale jobs: %w", err)
+	}
+	return stale, nil
+}
+
+// Claim takes the lease on a stale job. It fails if another process claimed it first.
+func (m *Manager) Claim(job *models.Job) error {
+	now := time.Now().UTC()
+	cutoff := now.Add(-leaseTimeout).Format(time.RFC3339Nano)
+	var claimed []models.Job
+	_, err := m.db.From("jobs").
+		Update(map[string]interface{}{"lease_owner": m.owner, "heartbeat_at": now}, "representation", "").
+		Eq("job_id", job.JobID).
+		Eq("status", StatusRunning).
+		Lt("heartbeat_at", cutoff).
+		ExecuteTo(&claimed)
+	if err != nil {
+		return err
+	}
+	if len(claimed) == 0 {
+		return ErrLeaseLost
+	}
+	*job = claimed[0]
+	return nil
+}
+
+// Run executes job in the calling goroutine until it completes, fails to checkpoint,
+// loses its lease, or ctx is cancelled. A cancelled run leaves the job running so it can
+// be resumed.
+func (m *Manager) Run(ctx context.Context, job *models.Job) error {
+	factory, ok := m.factories[job.Kind]
+	if !ok {
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+	sweep, err := factory(job)
+	if err != nil {
+		m.finish(job, StatusCancelled, err.Error())
+		return err
+	}
+
+	// The lease is renewed on a timer for as long as the job runs, and losing it stops the
+	// row in progress rather than waiting for the end of the batch
+	ctx, cancel := context.WithCancelCause(ctx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		m.heartbeat(ctx, job.JobID, cancel)
+	}()
+	defer func() {
+		cancel(nil)
+		<-heartbeatDone
+	}()
+
+	for {
+		var batch []models.FileContent
+		query := m.db.From("file_contents").Select("*", "", false).
+			Order("content_id", &postgrest.OrderOpts{Ascending: true}).
+			Limit(batchSize, "")
+		if job.Cursor != "" {
+			query = query.Gt("content_id", job.Cursor)
+		}
+		if _, err := query.ExecuteTo(&batch); err != nil {
+			return fmt.Errorf("fetch batch after %q: %w", job.Cursor, err)
+		}
+
+		for i := range batch {
+			if ctx.Err() != nil {
+				break
+			}
+			fc := &batch[i]
+			if err := sweep(ctx, fc); err != nil {
+				job.Failed++
+				job.LastError = fmt.Sprintf("content %s: %v", fc.ContentID, err)
+				slog.Warn("Job item failed", "kind", job.Kind, "job_id", job.JobID, "error", job.LastError)
+			}
+			job.Processed++
+			job.Cursor = fc.ContentID
+		}
+
+		if err := m.checkpoint(job); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if len(batch) < batchSize {
+			return m.finish(job, StatusCompleted, job.LastError)
+		}
+	}
+}
+
+// Get returns a job by ID.
+func (m *Manager) Get(jobID string) (*models.Job, error) {
+	var job models.Job
+	_, err := m.db.From("jobs").Select("*", "", false).Single().Eq("job_id", jobID).ExecuteTo(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns the most recent jobs, optionally restricted to one kind.
+func (m *Manager) List(kind string, limit int) ([]models.Job, error) {
+	query := m.db.From("jobs").Select("*", "", false).Order("created_at", &postgrest.OrderOpts{Ascending: false}).Limit(limit, "")
+	if kind != "" {
+		query = query.Eq("kind", kind)
+	}
+	var jobs []models.Job
+	if _, err := query.ExecuteTo(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Cancel marks a running job as cancelled. Whichever process runs it stops at its next checkpoint.
+func (m *Manager) Cancel(jobID string) error {
+	m.mu.Lock()
+	if cancel, ok := m.cancel[jobID]; ok {
+		cancel()
+	}
+	m.mu.Unlock()
+
+	now := time.Now().UTC()
+	_, _, err := m.db.From("jobs").
+		Update(map[string]interface{}{"status": StatusCancelled, "finished_at": now}, "", "").
+		Eq("job_id", jobID).
+		Eq("status", StatusRunning).
+		Execute()
+	return err
+}
+
+// Every starts a job of kind with params every interval until Shutdown, skipping a tick
+// while the previous run (here or in another process) is still going.
+func (m *Manager) Every(kind string, params interface{}, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				if _, err := m.Start(kind, params); err != nil && !errors.Is(err, ErrAlreadyRunning) {
+					slog.Error("Error starting scheduled job", "kind", kind, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Shutdown stops scheduling, stops background jobs at their next row and waits for them to checkpoint.
+func (m *Manager) Shutdown() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.mu.Lock()
+	for _, cancel := range m.cancel {
+		cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+func (m *Manager) runInBackground(job *models.Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.cancel[job.JobID] = cancel
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			m.mu.Lock()
+			delete(m.cancel, job.JobID)
+			m.mu.Unlock()
+			cancel()
+		}()
+		if err := m.Run(ctx, job); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Job stopped", "kind", job.Kind, "job_id", job.JobID, "error", err)
+		}
+	}()
+}
+
+// heartbeat renews the lease on jobID every heartbeatInterval until ctx is done. It cancels
+// the run with ErrLeaseLost once the job was cancelled or claimed elsewhere; failing to reach
+// the database is only logged, as the lease outlives a few missed heartbeats.
+func (m *Manager) heartbeat(ctx context.Context, jobID string, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var renewed []models.Job
+		_, err := m.db.From("jobs").
+			Update(map[string]interface{}{"heartbeat_at": time.Now().UTC()}, "representation", "").
+			Eq("job_id", jobID).
+			Eq("status", StatusRunning).
+			Eq("lease_owner", m.owner).
+			ExecuteTo(&renewed)
+		if err != nil {
+			slog.Warn("Error renewing job lease", "job_id", jobID, "error", err)
+			continue
+		}
+		if len(renewed) == 0 {
+			slog.Warn("Job lease lost; stopping", "job_id", jobID)
+			cancel(ErrLeaseLost)
+			return
+		}
+	}
+}
+
+// checkpoint persists progress and renews the lease. It fails with ErrLeaseLost when the
+// job was cancelled or claimed elsewhere, which stops the run.
+func (m *Manager) checkpoint(job *models.Job) error {
+	now := time.Now().UTC()
+	var updated []models.Job
+	_, err := m.db.From("jobs").
+		Update(map[string]interface{}{
+			"cursor":       job.Cursor,
+			"processed":    job.Processed,
+			"failed":       job.Failed,
+			"last_error":   job.LastError,
+			"heartbeat_at": now,
+		}, "representation", "").
+		Eq("job_id", job.JobID).
+		Eq("status", StatusRunning).
+		Eq("lease_owner", m.owner).
+		ExecuteTo(&updated)
+	if err != nil {
+		return fmt.Errorf("checkpoint job %s: %w", job.JobID, err)
+	}
+	if len(updated) == 0 {
+		return ErrLeaseLost
+	}
+	job.HeartbeatAt = &models.CustomTime{Time: now}
+	if m.OnCheckpoint != nil {
+		m.OnCheckpoint(*job)
+	}
+	return nil
+}
+
+func (m *Manager) finish(job *models.Job, status, lastError string) error {
+	now := time.Now().UTC()
+	_, _, err := m.db.From("jobs").
+		Update(map[string]interface{}{"status": status, "last_error": lastError, "finished_at": now}, "", "").
+		Eq("job_id", job.JobID).
+		Eq("lease_owner", m.owner).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("finish job %s: %w", job.JobID, err)
+	}
+	job.Status = status
+	job.LastError = lastError
+	job.FinishedAt = &models.CustomTime{Time: now}
+	slog.Info("Job finished", "kind", job.Kind, "job_id", job.JobID, "status", status, "processed", job.Processed, "failed", job.Failed)
+	return nil
+}
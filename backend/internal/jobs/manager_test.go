@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"file-vault/backend/internal/models"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// fakeJobsDB serves one file_contents row and answers job updates, counting the lease
+// renewals that are not checkpoints. While lost is set, updates match no row.
+type fakeJobsDB struct {
+	heartbeats atomic.Int32
+	lost       atomic.Bool
+}
+
+func (f *fakeJobsDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/file_contents"):
+		if strings.Contains(r.URL.RawQuery, "content_id=gt.") {
+			w.Write([]byte(`[]`))
+			return
+		}
+		json.NewEncoder(w).Encode([]models.FileContent{{ContentID: "c1"}})
+	case r.Method == http.MethodPatch && strings.HasSuffix(r.URL.Path, "/jobs"):
+		var update map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&update)
+		if _, ok := update["cursor"]; !ok && len(update) == 1 {
+			f.heartbeats.Add(1)
+		}
+		if f.lost.Load() {
+			w.Write([]byte(`[]`))
+			return
+		}
+		json.NewEncoder(w).Encode([]models.Job{{JobID: "j1", Status: StatusRunning}})
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+// runSlowJob runs a job whose only row takes sweep to process.
+func runSlowJob(t *testing.T, db *fakeJobsDB, sweep SweepFunc) error {
+	t.Helper()
+	previous := heartbeatInterval
+	heartbeatInterval = 10 * time.Millisecond
+	t.Cleanup(func() { heartbeatInterval = previous })
+
+	rest := httptest.NewServer(db)
+	t.Cleanup(rest.Close)
+	m := NewManager(postgrest.NewClient(rest.URL, "", nil))
+	m.Register("slow", func(*models.Job) (SweepFunc, error) { return sweep, nil })
+	return m.Run(context.Background(), &models.Job{JobID: "j1", Kind: "slow", Status: StatusRunning})
+}
+
+func TestRunRenewsLeaseDuringSlowBatch(t *testing.T) {
+	db := &fakeJobsDB{}
+	err := runSlowJob(t, db, func(ctx context.Context, fc *models.FileContent) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if n := db.heartbeats.Load(); n < 2 {
+		t.Fatalf("lease renewed %d times during the batch, want several", n)
+	}
+}
+
+func TestRunStopsWhenLeaseIsLost(t *testing.T) {
+	db := &fakeJobsDB{}
+	db.lost.Store(true)
+	stopped := make(chan struct{})
+	err := runSlowJob(t, db, func(ctx context.Context, fc *models.FileContent) error {
+		select {
+		case <-ctx.Done():
+			close(stopped)
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("Run = %v, want ErrLeaseLost", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("the row in progress was not cancelled when the lease was lost")
+	}
+}
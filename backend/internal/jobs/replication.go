@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/replication"
+	"file-vault/backend/internal/storage"
+)
+
+// KindReplicaRepair copies every blob to the replicas that are missing it or hold a stale copy.
+const KindReplicaRepair = "replica_repair"
+
+// ReplicaRepair returns the Factory for replica repair jobs. blobs must be the replicated
+// store; without replicas configured there is nothing to repair.
+func ReplicaRepair(blobs storage.BlobStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		replicated, ok := blobs.(*replication.Store)
+		if !ok {
+			return nil, errors.New("no replicas are configured")
+		}
+		return func(ctx context.Context, fc *models.FileContent) error {
+			if fc.StorageClass == storage.ClassCold || fc.StorageBackend != "" {
+				return nil // only the hot class on the default store is replicated
+			}
+			return replicated.Sync(ctx, fc)
+		}, nil
+	}
+}
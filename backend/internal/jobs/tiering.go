@@ -0,0 +1,117 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// KindTiering moves content that has not been read recently to the cold storage class.
+const KindTiering = "tiering"
+
+// TieringParams are the params of a tiering job.
+type TieringParams struct {
+	// ColdAfterHours is how long content may go unread before it is moved to cold storage.
+	ColdAfterHours int64 `json:"cold_after_hours"`
+}
+
+// NewTieringParams returns the tiering job params for the configured cold storage policy.
+func NewTieringParams(tiering *storage.Tiering) TieringParams {
+	return TieringParams{ColdAfterHours: int64(tiering.ColdAfter / time.Hour)}
+}
+
+// Tiering returns the Factory for tiering jobs.
+func Tiering(db *postgrest.Client, content *storage.ContentStore) Factory {
+	return func(job *models.Job) (SweepFunc, error) {
+		var params TieringParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return nil, fmt.Errorf("decode tiering params: %w", err)
+		}
+		if params.ColdAfterHours <= 0 {
+			return nil, fmt.Errorf("tiering job needs a positive cold_after_hours")
+		}
+		cutoff := time.Now().Add(-time.Duration(params.ColdAfterHours) * time.Hour)
+
+		return func(ctx context.Context, fc *models.FileContent) error {
+			if fc.StorageClass == storage.ClassCold {
+				return nil
+			}
+			lastUsed := fc.CreatedAt.Time
+			if fc.LastAccessedAt != nil && fc.LastAccessedAt.After(lastUsed) {
+				lastUsed = fc.LastAccessedAt.Time
+			}
+			if lastUsed.After(cutoff) {
+				return nil
+			}
+			return MoveToClass(ctx, db, content, fc, storage.ClassCold)
+		}, nil
+	}
+}
+
+// MoveToClass copies fc's blob into the store of class and switches the row over, then removes
+// the original. Content moving to the hot class lands on the active backend. The switch only
+// applies if the row still has the class and path it was read with; otherwise the copy is discarded.
+func MoveToClass(ctx context.Context, db *postgrest.Client, content *storage.ContentStore, fc *models.FileContent, class string) error {
+	oldClass := fc.StorageClass
+	if oldClass == "" {
+		oldClass = storage.ClassHot
+	}
+	if oldClass == class {
+		return nil
+	}
+	source := content.StoreOf(fc)
+	if err := content.CopyToClass(ctx, fc, class); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{"storage_class": class}
+	backend := fc.StorageBackend
+	if class == storage.ClassHot {
+		backend = content.ActiveBackend()
+		fields["storage_backend"] = backend
+	}
+	var updated []models.FileContent
+	_, err := db.From("file_contents").
+		Update(fields, "representation", "").
+		Eq("content_id", fc.ContentID).
+		Eq("storage_path", fc.StoragePath).
+		Eq("storage_class", oldClass).
+		ExecuteTo(&updated)
+	if err == nil && len(updated) == 0 && movedBy(db, fc, class) {
+		// A concurrent move (e.g. two downloads rehydrating the same content) got there first
+		fc.StorageClass = class
+		fc.StorageBackend = backend
+		return nil
+	}
+	if err != nil || len(updated) == 0 {
+		if removeErr := content.StoreFor(class).Delete(ctx, fc.StoragePath); removeErr != nil {
+			slog.Error("Error removing discarded blob copy", "class", class, "key", fc.StoragePath, "error", removeErr)
+		}
+		if err == nil {
+			err = fmt.Errorf("content changed while moving it to %s storage", class)
+		}
+		return err
+	}
+
+	if err := source.Delete(ctx, fc.StoragePath); err != nil {
+		// The row already points at the new class; the old copy is only an orphan
+		slog.Error("Error removing blob copy", "class", oldClass, "key", fc.StoragePath, "error", err)
+	}
+	fc.StorageClass = class
+	fc.StorageBackend = backend
+	return nil
+}
+
+// movedBy reports whether fc's row already has class for the same blob.
+func movedBy(db *postgrest.Client, fc *models.FileContent, class string) bool {
+	var current models.FileContent
+	_, err := db.From("file_contents").Select("storage_path,storage_class", "", false).Single().Eq("content_id", fc.ContentID).ExecuteTo(&current)
+	return err == nil && current.StorageClass == class && current.StoragePath == fc.StoragePath
+}
@@ -0,0 +1,107 @@
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AddKeyVersion generates a new master key, appends it to the key file at path and makes it
+// current. If the file does not exist yet it is created, seeded with the keys of seed (which
+// may be nil) so blobs wrapped under them stay readable. It returns the new key ID.
+func AddKeyVersion(path string, seed *LocalProvider) (string, error) {
+	kf, err := readKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		kf = &keyFile{Keys: map[string]string{}}
+		if seed != nil {
+			for id, key := range seed.keys {
+				kf.Keys[id] = base64.StdEncoding.EncodeToString(key)
+			}
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	id := nextKeyID(kf.Keys)
+	kf.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	kf.Current = id
+	return id, writeKeyFile(path, kf)
+}
+
+// RetireKeyVersion removes a master key from the key file at path. The caller is responsible
+// for checking that no data key is still wrapped under it; the current key cannot be retired.
+func RetireKeyVersion(path, id string) error {
+	kf, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	if id == kf.Current {
+		return fmt.Errorf("master key %q is current and cannot be retired", id)
+	}
+	if _, ok := kf.Keys[id]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	delete(kf.Keys, id)
+	return writeKeyFile(path, kf)
+}
+
+func readKeyFile(path string) (*keyFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("parse master key file: %w", err)
+	}
+	if kf.Keys == nil {
+		kf.Keys = map[string]string{}
+	}
+	return &kf, nil
+}
+
+// writeKeyFile replaces the key file atomically so a crash never leaves it half written.
+func writeKeyFile(path string, kf *keyFile) error {
+	raw, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".master-keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// nextKeyID returns "v<n+1>" where n is the highest numbered "v<n>" ID in use.
+func nextKeyID(existing map[string]string) string {
+	highest := 0
+	for id := range existing {
+		if n, err := strconv.Atoi(strings.TrimPrefix(id, "v")); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return "v" + strconv.Itoa(highest+1)
+}
@@ -0,0 +1,147 @@
+package keys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// masterKeySize is the required length of a master key (AES-256).
+const masterKeySize = 32
+
+// LocalProvider wraps data keys with master keys held in process memory.
+// Keys come from the configured key file or single master key; see Load.
+type LocalProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewLocalProvider creates a LocalProvider that wraps new data keys with masterKeys[current].
+func NewLocalProvider(current string, masterKeys map[string][]byte) (*LocalProvider, error) {
+	if _, ok := masterKeys[current]; !ok {
+		return nil, fmt.Errorf("current master key %q is not in the key set", current)
+	}
+	for id, key := range masterKeys {
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes, got %d", id, masterKeySize, len(key))
+		}
+	}
+	return &LocalProvider{current: current, keys: masterKeys}, nil
+}
+
+// keyFile is the on-disk format read from MASTER_KEY_FILE.
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // key ID -> base64-encoded 32-byte key
+}
+
+// Load loads master keys from the key file at path, or failing that the single base64-encoded
+// 32-byte key encoded (versioned as id, or "v1").
+func Load(path, encoded, id string) (*LocalProvider, error) {
+	if path != "" {
+		return FromFile(path)
+	}
+	if encoded == "" {
+		return nil, errors.New("a master key or master key file must be configured to encrypt blobs at rest")
+	}
+	return FromKey(id, encoded)
+}
+
+// FromKey creates a provider holding a single base64-encoded master key. An empty id defaults to "v1".
+func FromKey(id, encoded string) (*LocalProvider, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("MASTER_KEY is not valid base64: %w", err)
+	}
+	if id == "" {
+		id = "v1"
+	}
+	return NewLocalProvider(id, map[string][]byte{id: key})
+}
+
+// FromFile loads a key set written as {"current": "v2", "keys": {"v1": "<base64>", "v2": "<base64>"}}.
+func FromFile(path string) (*LocalProvider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read master key file: %w", err)
+	}
+	var kf keyFile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("parse master key file: %w", err)
+	}
+
+	masterKeys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q is not valid base64: %w", id, err)
+		}
+		masterKeys[id] = key
+	}
+	return NewLocalProvider(kf.Current, masterKeys)
+}
+
+// CurrentKeyID returns the version new data keys are wrapped with.
+func (p *LocalProvider) CurrentKeyID() string {
+	return p.current
+}
+
+// KeyIDs returns every master key version the provider holds, sorted.
+func (p *LocalProvider) KeyIDs() []string {
+	ids := make([]string, 0, len(p.keys))
+	for id := range p.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// WrapKey seals dataKey with the current master key using AES-256-GCM.
+// The wrapped form is nonce || ciphertext, with the key ID bound as additional data.
+func (p *LocalProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	aead, err := p.aead(p.current)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return p.current, aead.Seal(nonce, nonce, dataKey, []byte(p.current)), nil
+}
+
+// UnwrapKey opens a data key wrapped by WrapKey under the master key keyID.
+func (p *LocalProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := p.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	nonce, ciphertext := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key with %q: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+func (p *LocalProvider) aead(keyID string) (cipher.AEAD, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
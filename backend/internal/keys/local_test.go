@@ -0,0 +1,159 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func masterKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	ctx := context.Background()
+	v1, v2 := masterKey(t), masterKey(t)
+	p, err := NewLocalProvider("v2", map[string][]byte{"v1": v1, "v2": v2, "alias": v2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, keyID, wrapped, err := GenerateDataKey(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "v2" || len(dataKey) != DataKeySize {
+		t.Fatalf("wrapped under %q with a %d-byte data key", keyID, len(dataKey))
+	}
+
+	cases := []struct {
+		name    string
+		keyID   string
+		wrapped []byte
+		wantErr error
+	}{
+		{"right key", "v2", wrapped, nil},
+		{"older key", "v1", wrapped, errAny},
+		// Same key bytes under another ID: the ID is bound as additional data
+		{"other key ID for the same key", "alias", wrapped, errAny},
+		{"unknown key ID", "v9", wrapped, ErrUnknownKey},
+		{"flipped byte", "v2", flipped(wrapped, len(wrapped)-1), errAny},
+		{"truncated", "v2", wrapped[:8], errAny},
+		{"empty", "v2", nil, errAny},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := p.UnwrapKey(ctx, tc.keyID, tc.wrapped)
+			switch {
+			case tc.wantErr == nil && err != nil:
+				t.Fatalf("UnwrapKey: %v", err)
+			case tc.wantErr == nil && !bytes.Equal(got, dataKey):
+				t.Fatal("unwrapped another data key")
+			case tc.wantErr == errAny && err == nil:
+				t.Fatal("UnwrapKey succeeded")
+			case tc.wantErr != nil && tc.wantErr != errAny && !errors.Is(err, tc.wantErr):
+				t.Fatalf("UnwrapKey = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// errAny stands for any error in test tables.
+var errAny = errors.New("any error")
+
+func flipped(b []byte, at int) []byte {
+	changed := bytes.Clone(b)
+	changed[at] ^= 1
+	return changed
+}
+
+func TestNewLocalProviderRejects(t *testing.T) {
+	cases := map[string]struct {
+		current string
+		keys    map[string][]byte
+	}{
+		"current key missing": {"v2", map[string][]byte{"v1": masterKey(t)}},
+		"short key":           {"v1", map[string][]byte{"v1": make([]byte, 16)}},
+		"short older key":     {"v2", map[string][]byte{"v1": make([]byte, 31), "v2": masterKey(t)}},
+	}
+	for name, tc := range cases {
+		if _, err := NewLocalProvider(tc.current, tc.keys); err == nil {
+			t.Errorf("%s: NewLocalProvider succeeded", name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(masterKey(t))
+	p, err := Load("", encoded, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CurrentKeyID() != "v1" {
+		t.Fatalf("single key is %q, want v1", p.CurrentKeyID())
+	}
+	if _, err := Load("", "", ""); err == nil {
+		t.Fatal("Load without any key succeeded")
+	}
+	if _, err := Load("", "not base64!", ""); err == nil {
+		t.Fatal("Load of a malformed key succeeded")
+	}
+}
+
+func TestKeyFileVersions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "master-keys.json")
+	seed, err := FromKey("v1", base64.StdEncoding.EncodeToString(masterKey(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, wrapped, err := GenerateDataKey(ctx, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := AddKeyVersion(path, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "v2" {
+		t.Fatalf("new key is %q, want v2", id)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file mode: %v, %v", info, err)
+	}
+	p, err := Load(path, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CurrentKeyID() != "v2" {
+		t.Fatalf("current key %q, want v2", p.CurrentKeyID())
+	}
+	// Data keys wrapped under the seeded key stay readable
+	if _, err := p.UnwrapKey(ctx, "v1", wrapped); err != nil {
+		t.Fatalf("unwrap with carried-over key: %v", err)
+	}
+
+	if err := RetireKeyVersion(path, "v2"); err == nil {
+		t.Fatal("retired the current key")
+	}
+	if err := RetireKeyVersion(path, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	p, err = FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.UnwrapKey(ctx, "v1", wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unwrap with retired key = %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,39 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+)
+
+// DataKeySize is the length of the per-content AES-256 data keys.
+const DataKeySize = 32
+
+// ErrUnknownKey is returned when a wrapped data key references a master key the provider does not hold.
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Provider wraps and unwraps data keys with master keys it never reveals.
+// It mirrors the shape of a cloud KMS so an external key service can be plugged in later.
+type Provider interface {
+	// CurrentKeyID returns the version of the master key new data keys are wrapped with.
+	CurrentKeyID() string
+	// KeyIDs returns every master key version the provider can unwrap with.
+	KeyIDs() []string
+	// WrapKey encrypts dataKey with the current master key.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key previously wrapped with the master key keyID.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// GenerateDataKey creates a fresh random data key and returns it together with its wrapped form.
+func GenerateDataKey(ctx context.Context, p Provider) (dataKey []byte, keyID string, wrapped []byte, err error) {
+	dataKey = make([]byte, DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, err
+	}
+	keyID, wrapped, err = p.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return dataKey, keyID, wrapped, nil
+}
@@ -0,0 +1,69 @@
+// Package logging builds the server's structured logger and carries a request-scoped logger,
+// tagged with the request and trace IDs, through request contexts.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+
+	"file-vault/backend/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header a request ID is read from and echoed in.
+const RequestIDHeader = "X-Request-ID"
+
+// New returns a logger writing to w at the level and in the format of cfg, which must have
+// passed validation. Secrets and tokens are redacted from every line.
+func New(cfg config.LogConfig, w io.Writer) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level))
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger ctx carries, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID matches request IDs accepted from clients and proxies. Anything else is
+// replaced, so IDs cannot inject text into log lines.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// NewRequestID returns incoming if it is a usable request ID, or a new random one.
+func NewRequestID(incoming string) string {
+	if validRequestID.MatchString(incoming) {
+		return incoming
+	}
+	return uuid.NewString()
+}
@@ -0,0 +1,142 @@
+// Package metrics defines the Prometheus metrics the server exports on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "filevault"
+
+// Outcomes of a call to a dependency.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+var (
+	// HTTPRequests counts requests by method, route template and status code.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes request latency by method and route template.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to handle HTTP requests, by method and route. Uploads and downloads include the transfer.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"method", "route"})
+
+	// UploadedBytes counts the logical bytes of uploaded files, including deduplicated ones.
+	UploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes of files uploaded, before deduplication and compression.",
+	})
+
+	// DownloadedBytes counts the logical bytes of files served to clients.
+	DownloadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes of files downloaded, including public share downloads.",
+	})
+
+	// DedupLookups counts uploads by whether their content was already stored.
+	DedupLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dedup_lookups_total",
+		Help:      "Uploads checked against stored content, by result (hit or miss).",
+	}, []string{"result"})
+
+	// DedupSavedBytes counts the bytes not stored again because the content already was.
+	DedupSavedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dedup_saved_bytes_total",
+		Help:      "Bytes of uploads that referenced existing content instead of being stored again.",
+	})
+
+	// QuotaRejections counts uploads refused because they would exceed the owner's quota.
+	QuotaRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejections_total",
+		Help:      "Uploads rejected because they would exceed the owner's storage quota.",
+	})
+
+	// RateLimited counts requests answered with 429 by the per-user rate limiter.
+	RateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected with 429 Too Many Requests by the per-user rate limiter.",
+	})
+
+	// BlobStoreDuration observes blob store calls by store, operation and outcome.
+	BlobStoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "blob_store_duration_seconds",
+		Help:      "Time of blob store calls, by store, operation and outcome. Get measures opening the blob, not reading it.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"store", "op", "outcome"})
+
+	// PostgrestDuration observes PostgREST requests by HTTP method, table and outcome.
+	PostgrestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "postgrest_request_duration_seconds",
+		Help:      "Time of PostgREST requests, by HTTP method, table and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "table", "outcome"})
+
+	// EmailsSent counts outgoing emails by result (sent or failed).
+	EmailsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Emails sent, by result (sent or failed).",
+	}, []string{"result"})
+)
+
+// Outcome returns the outcome label for a call that returned err.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeOK
+}
+
+// ObserveRequest records one handled HTTP request.
+func ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	HTTPRequestDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// PostgrestTransport wraps parent (nil means http.DefaultTransport) to time every PostgREST
+// request. Responses with a 4xx or 5xx status count as errors.
+func PostgrestTransport(parent http.RoundTripper) http.RoundTripper {
+	if parent == nil {
+		parent = http.DefaultTransport
+	}
+	return postgrestTransport{parent: parent}
+}
+
+type postgrestTransport struct {
+	parent http.RoundTripper
+}
+
+func (t postgrestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.parent.RoundTrip(req)
+	outcome := Outcome(err)
+	if err == nil && resp.StatusCode >= 400 {
+		outcome = OutcomeError
+	}
+	// Paths end in the table, e.g. /rest/v1/file_contents
+	table := path.Base(req.URL.Path)
+	PostgrestDuration.WithLabelValues(req.Method, table, outcome).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -54,19 +54,56 @@ func (ct CustomTime) MarshalJSON() ([]byte, error) {
 
 // FileContent represents the metadata of a unique file blob in the 'file_contents' table
 type FileContent struct {
-	ContentID      string     `json:"content_id,omitempty"`
-	HashSHA256     string     `json:"hash_sha256,-"` // Hide from JSON output
-	Size           int64      `json:"size"`
-	MimeType       string     `json:"mime_type"`
-	StoragePath    string     `json:"storage_path,-"`    // Hide from JSON output
-	ReferenceCount int        `json:"reference_count,-"` // Hide from JSON output
-	CreatedAt      CustomTime `json:"created_at,omitempty"`
+	ContentID        string      `json:"content_id,omitempty"`
+	HashSHA256       string      `json:"hash_sha256,-"`      // Hide from JSON output
+	HashMD5          string      `json:"hash_md5,omitempty"` // ETag of the S3 gateway; empty on content stored before it
+	Size             int64       `json:"size"`
+	MimeType         string      `json:"mime_type"`
+	StoragePath      string      `json:"storage_path,-"`              // Hide from JSON output
+	ReferenceCount   int         `json:"reference_count,-"`           // Hide from JSON output
+	Codec            string      `json:"codec,omitempty"`             // Compression applied at rest ("none" or "zstd")
+	StoredSize       int64       `json:"stored_size,omitempty"`       // Physical size of the stored blob
+	Encryption       string      `json:"encryption,omitempty"`        // At-rest encryption scheme, empty for plaintext blobs
+	KeyID            string      `json:"key_id,omitempty"`            // Master key version the data key is wrapped with
+	WrappedKey       string      `json:"wrapped_key,omitempty"`       // Base64 data key, encrypted under KeyID
+	ClientEncryption string      `json:"client_encryption,omitempty"` // End-to-end mode; the server stores such content opaque
+	DedupScope       string      `json:"dedup_scope,omitempty"`       // Only uploads with the same scope key share this content
+	StorageClass     string      `json:"storage_class,omitempty"`     // "hot" or "cold"; empty on rows from before tiering means hot
+	StorageBackend   string      `json:"storage_backend,omitempty"`   // Named backend holding hot content; empty is the default store
+	LastAccessedAt   *CustomTime `json:"last_accessed_at,omitempty"`  // Last download, which keeps content in the hot class
+	CreatedAt        CustomTime  `json:"created_at,omitempty"`
+}
+
+// PhysicalSize returns the number of bytes the blob occupies in storage.
+// Rows written before compression was introduced have no stored_size and are stored verbatim.
+func (fc FileContent) PhysicalSize() int64 {
+	if fc.StoredSize > 0 {
+		return fc.StoredSize
+	}
+	return fc.Size
+}
+
+// Client-side encryption modes recorded in file_contents.client_encryption.
+const (
+	// ClientEncryptionE2E content is encrypted under a random per-file key.
+	ClientEncryptionE2E = "e2e"
+	// ClientEncryptionConvergent content is encrypted under a key derived from the plaintext,
+	// so identical files produce identical ciphertext and still deduplicate across users.
+	ClientEncryptionConvergent = "e2e-convergent"
+)
+
+// IsClientEncryption reports whether mode is a supported client-side encryption mode.
+func IsClientEncryption(mode string) bool {
+	return mode == ClientEncryptionE2E || mode == ClientEncryptionConvergent
 }
 
 // FileContentSummary is a leaner version of FileContent for display purposes.
 type FileContentSummary struct {
-	Size     int64  `json:"size"`
-	MimeType string `json:"mime_type"`
+	Size             int64  `json:"size"`
+	MimeType         string `json:"mime_type"`
+	ClientEncryption string `json:"client_encryption,omitempty"`
+	HashSHA256       string `json:"hash_sha256,omitempty"` // Only on the owner's own listings
+	HashMD5          string `json:"hash_md5,omitempty"`
 }
 
 // UserFile represents a logical file uploaded by a user in the 'files' table
@@ -140,3 +177,38 @@ type PubliclySharedFileResponse struct {
 	DownloadCount int                      `json:"download_count"`
 	File          PubliclySharedFileDetail `json:"files"` // Nested file details
 }
+
+// FileKey is a file key wrapped for one recipient in the 'file_keys' table. Only clients can
+// unwrap it; sharing an end-to-end encrypted file means adding a row for the recipient.
+type FileKey struct {
+	FileID      string     `json:"file_id"`
+	RecipientID string     `json:"recipient_id"`
+	WrappedKey  string     `json:"wrapped_key"`
+	CreatedAt   CustomTime `json:"created_at,omitempty"`
+}
+
+// BlobReplica records the state of one content blob's copy on a secondary blob store in the 'blob_replicas' table.
+type BlobReplica struct {
+	ContentID   string     `json:"content_id"`
+	Replica     string     `json:"replica"`      // Name of the replica in REPLICA_STORES
+	StoragePath string     `json:"storage_path"` // Key the copy was written under
+	Status      string     `json:"status"`       // "ok" or "failed"
+	LastError   string     `json:"last_error,omitempty"`
+	UpdatedAt   CustomTime `json:"updated_at"`
+}
+
+// Folder is a folder of a user's files in the 'folders' table. Files are placed in folders
+// through FileFolderMapping rows; a file without one is at the top level.
+type Folder struct {
+	FolderID  string     `json:"folder_id,omitempty"`
+	OwnerID   string     `json:"owner_id"`
+	ParentID  *string    `json:"parent_id"` // Nil for a top-level folder
+	Name      string     `json:"name"`
+	CreatedAt CustomTime `json:"created_at,omitempty"`
+}
+
+// FileFolderMapping places a file in a folder in the 'file_folder_mapping' table.
+type FileFolderMapping struct {
+	FileID   string `json:"file_id"`
+	FolderID string `json:"folder_id"`
+}
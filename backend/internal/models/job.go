@@ -0,0 +1,20 @@
+package models
+
+import "encoding/json"
+
+// Job represents a resumable background job in the 'jobs' table.
+type Job struct {
+	JobID       string          `json:"job_id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	Cursor      string          `json:"cursor,omitempty"` // Last content_id processed
+	Total       int64           `json:"total"`
+	Processed   int64           `json:"processed"`
+	Failed      int64           `json:"failed"`
+	LastError   string          `json:"last_error,omitempty"`
+	LeaseOwner  string          `json:"lease_owner,omitempty"` // Process currently running the job
+	HeartbeatAt *CustomTime     `json:"heartbeat_at,omitempty"`
+	CreatedAt   CustomTime      `json:"created_at,omitempty"`
+	FinishedAt  *CustomTime     `json:"finished_at,omitempty"`
+}
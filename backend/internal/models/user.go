@@ -4,22 +4,45 @@ import "time"
 
 // User represents a user account in the 'users' table.
 type User struct {
-	UserID        string      `json:"user_id,omitempty"`
-	Username      string      `json:"username"`
-	Email         string      `json:"email"`
-	PasswordHash  string      `json:"-"` // Never expose this field
-	IsAdmin       bool        `json:"is_admin"`
-	RateLimit     int         `json:"rate_limit,omitempty"`
-	StorageQuota  int64       `json:"storage_quota,omitempty"`
-	CreatedAt     CustomTime  `json:"created_at,omitempty"`
-	FirstName     string      `json:"first_name,omitempty"`
-	LastName      string      `json:"last_name,omitempty"`
-	DateOfBirth   *CustomTime `json:"date_of_birth,omitempty"`
-	PhoneNumber   string      `json:"phone_number,omitempty"`
-	LastLogin     *time.Time  `json:"last_login,omitempty"`
-	EmailVerified bool        `json:"email_verified"`
-	PhoneVerified bool        `json:"phone_verified"`
-	Status        string      `json:"status,omitempty"`
-	OTP           string      `json:"-"`
-	OTPExpiresAt  *time.Time  `json:"-"`
+	UserID         string      `json:"user_id,omitempty"`
+	Username       string      `json:"username"`
+	Email          string      `json:"email"`
+	PasswordHash   string      `json:"-"` // Never expose this field
+	IsAdmin        bool        `json:"is_admin"`
+	RateLimit      int         `json:"rate_limit,omitempty"`
+	StorageQuota   int64       `json:"storage_quota,omitempty"`
+	CreatedAt      CustomTime  `json:"created_at,omitempty"`
+	FirstName      string      `json:"first_name,omitempty"`
+	LastName       string      `json:"last_name,omitempty"`
+	DateOfBirth    *CustomTime `json:"date_of_birth,omitempty"`
+	PhoneNumber    string      `json:"phone_number,omitempty"`
+	LastLogin      *time.Time  `json:"last_login,omitempty"`
+	EmailVerified  bool        `json:"email_verified"`
+	PhoneVerified  bool        `json:"phone_verified"`
+	Status         string      `json:"status,omitempty"`
+	PublicKey      string      `json:"public_key,omitempty"`      // X25519 key for end-to-end encrypted sharing
+	OrganizationID string      `json:"organization_id,omitempty"` // Dedup boundary when DEDUP_SCOPE=organization
+	OTP            string      `json:"-"`
+	OTPExpiresAt   *time.Time  `json:"-"`
+}
+
+// PersonalToken is a named access token of a user in the 'personal_tokens' table, used where
+// clients can only send a username and password, such as WebDAV. Only its hash is stored.
+type PersonalToken struct {
+	TokenID    string      `json:"token_id,omitempty"`
+	UserID     string      `json:"user_id"`
+	Name       string      `json:"name"`
+	TokenHash  string      `json:"-"`
+	CreatedAt  CustomTime  `json:"created_at,omitempty"`
+	LastUsedAt *CustomTime `json:"last_used_at,omitempty"`
+}
+
+// AccessKey is an S3 access key of a user in the 's3_access_keys' table. Its secret is stored
+// wrapped under a master key and never leaves the server after the key is created.
+type AccessKey struct {
+	AccessKeyID string      `json:"access_key_id"`
+	UserID      string      `json:"user_id"`
+	Name        string      `json:"name"`
+	CreatedAt   CustomTime  `json:"created_at,omitempty"`
+	LastUsedAt  *CustomTime `json:"last_used_at,omitempty"`
 }
@@ -0,0 +1,82 @@
+package openapi
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+// CheckRoutes compares the routes registered on the router with the operations in doc. Every
+// route must be documented with the same path parameters, and every documented operation
+// must be routed. Catch-all routes, which serve files such as the docs UI, are not part of
+// the contract. The api package's tests and the server's startup both fail when they differ,
+// so a handler added, moved or removed without updating the document is caught by go test.
+func CheckRoutes(doc *openapi3.T, routes gin.RoutesInfo) error {
+	var drift []string
+	routed := make(map[string]bool)
+	for _, route := range routes {
+		if strings.Contains(route.Path, "*") {
+			continue
+		}
+		path := templatePath(route.Path)
+		routed[route.Method+" "+path] = true
+
+		pathItem := doc.Paths.Value(path)
+		if pathItem == nil || pathItem.GetOperation(route.Method) == nil {
+			drift = append(drift, route.Method+" "+path+" is routed but not documented")
+			continue
+		}
+		documented := make(map[string]bool)
+		for _, params := range []openapi3.Parameters{pathItem.Parameters, pathItem.GetOperation(route.Method).Parameters} {
+			for _, ref := range params {
+				if ref.Value != nil && ref.Value.In == openapi3.ParameterInPath {
+					documented[ref.Value.Name] = true
+				}
+			}
+		}
+		for _, name := range pathParams(route.Path) {
+			if !documented[name] {
+				drift = append(drift, route.Method+" "+path+" does not document path parameter "+name)
+			}
+		}
+	}
+
+	for path, pathItem := range doc.Paths.Map() {
+		for method := range pathItem.Operations() {
+			if !routed[method+" "+path] {
+				drift = append(drift, method+" "+path+" is documented but not routed")
+			}
+		}
+	}
+
+	if len(drift) == 0 {
+		return nil
+	}
+	sort.Strings(drift)
+	return errors.New("openapi: routes and document differ:\n  " + strings.Join(drift, "\n  "))
+}
+
+// templatePath turns a gin route path such as /files/:id into the OpenAPI form /files/{id}.
+func templatePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathParams returns the names of the parameters in a gin route path.
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			names = append(names, segment[1:])
+		}
+	}
+	return names
+}
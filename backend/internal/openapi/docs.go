@@ -0,0 +1,39 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// initializer replaces the Swagger UI's default, which loads the petstore example, with one
+// loading this server's document. The relative URL keeps working behind a path prefix.
+const initializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "../openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// Docs serves the Swagger UI for the document served at /openapi.json. It must be routed at
+// a path ending in /*filepath.
+func Docs() gin.HandlerFunc {
+	files := http.FS(swaggerFiles.FS)
+	return func(c *gin.Context) {
+		switch file := strings.TrimPrefix(c.Param("filepath"), "/"); file {
+		case "swagger-initializer.js":
+			c.Data(http.StatusOK, "text/javascript; charset=utf-8", []byte(initializer))
+		case "":
+			c.FileFromFS("/", files)
+		default:
+			c.FileFromFS(file, files)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package openapi holds the OpenAPI 3 document describing the HTTP API. It serves the
+// document and a docs UI, validates requests against it, and checks that the router and the
+// document list the same operations.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+// uuidPattern is the format of every ID the API takes.
+const uuidPattern = `^[0-9a-fA-F]{8}-([0-9a-fA-F]{4}-){3}[0-9a-fA-F]{12}$`
+
+func init() {
+	openapi3.DefineStringFormatValidator("uuid", openapi3.NewRegexpFormatValidator(uuidPattern))
+}
+
+var (
+	loadOnce sync.Once
+	spec     *openapi3.T
+)
+
+// Spec returns the parsed document. It panics if the embedded document is invalid, which is
+// a programming error caught on the first run.
+func Spec() *openapi3.T {
+	loadOnce.Do(func() {
+		doc, err := openapi3.NewLoader().LoadFromData(document)
+		if err != nil {
+			panic("openapi: loading document: " + err.Error())
+		}
+		if err := doc.Validate(context.Background()); err != nil {
+			panic("openapi: invalid document: " + err.Error())
+		}
+		spec = doc
+	})
+	return spec
+}
+
+// Handler serves doc as JSON.
+func Handler(doc *openapi3.T) gin.HandlerFunc {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		panic("openapi: encoding document: " + err.Error())
+	}
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
@@ -0,0 +1,280 @@
+// Package replication copies committed blobs to secondary blob stores and fails reads over
+// to them when the primary store errors.
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/storage"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Replica states recorded in blob_replicas.status.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+const (
+	// queueSize bounds the blobs waiting to be replicated; beyond it new blobs are left to the repair job.
+	queueSize = 1024
+	// workers is the number of concurrent replication copies.
+	workers = 2
+	// commitRetries and commitDelay bound how long a worker waits for the row of a new blob
+	// to be committed; blobs whose upload failed never get one.
+	commitRetries = 5
+	commitDelay   = 2 * time.Second
+)
+
+// Replica is a named secondary blob store. The name is what blob_replicas rows refer to.
+type Replica struct {
+	Name  string
+	Store storage.BlobStore
+}
+
+// ParseReplicas parses a comma-separated list of name=url pairs, opening each URL with storage.OpenURL.
+func ParseReplicas(spec string) ([]Replica, error) {
+	stores, err := storage.ParseNamedStores(spec)
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]Replica, len(stores))
+	for i, store := range stores {
+		replicas[i] = Replica{Name: store.Name, Store: store.Store}
+	}
+	return replicas, nil
+}
+
+// Store is a BlobStore that writes to a primary store and asynchronously copies every
+// committed blob to its replicas. Reads fail over to the replicas when the primary errors,
+// and deletes apply to every copy.
+type Store struct {
+	db       *postgrest.Client
+	primary  storage.BlobStore
+	replicas []Replica
+	queue    chan string
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex // Guards sends on queue against Close closing it
+	closed bool
+}
+
+// NewStore wraps primary and starts the replication workers.
+func NewStore(db *postgrest.Client, primary storage.BlobStore, replicas []Replica) *Store {
+	s := &Store{
+		db:       db,
+		primary:  primary,
+		replicas: replicas,
+		queue:    make(chan string, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+// Replicas returns the configured replicas.
+func (s *Store) Replicas() []Replica {
+	return s.replicas
+}
+
+// Primary returns the store every blob is written to first.
+func (s *Store) Primary() storage.BlobStore {
+	return s.primary
+}
+
+// Close stops accepting blobs and waits for queued copies to finish. Blobs still queued
+// afterwards are picked up by the repair job. Blobs stored after Close, e.g. by requests
+// still running when a graceful shutdown times out, are left to the repair job too.
+func (s *Store) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Put writes to the primary store and queues the blob for replication.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := s.primary.Put(ctx, key, r); err != nil {
+		return err
+	}
+	s.enqueue(key)
+	return nil
+}
+
+// enqueue queues key for the replication workers without blocking.
+func (s *Store) enqueue(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		slog.Warn("Replication stopped; the next repair will copy the blob", "key", key)
+		return
+	}
+	select {
+	case s.queue <- key:
+	default:
+		slog.Warn("Replication queue full; the next repair will copy the blob", "key", key)
+	}
+}
+
+// Get reads from the primary store, falling back to each replica in turn if it fails.
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.primary.Get(ctx, key)
+	if err == nil {
+		return r, nil
+	}
+	for _, replica := range s.replicas {
+		r, replicaErr := replica.Store.Get(ctx, key)
+		if replicaErr == nil {
+			slog.Warn("Primary store failed; served blob from replica", "key", key, "replica", replica.Name, "error", err)
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+// Delete removes the blob from the primary and every replica. Replica failures are only
+// logged: a leftover replica copy is harmless and is never served for another blob.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	err := s.primary.Delete(ctx, key)
+	for _, replica := range s.replicas {
+		if replicaErr := replica.Store.Delete(ctx, key); replicaErr != nil {
+			slog.Error("Error deleting blob from replica", "key", key, "replica", replica.Name, "error", replicaErr)
+		}
+	}
+	return err
+}
+
+// List lists the primary store.
+func (s *Store) List(ctx context.Context, fn func(storage.BlobInfo) error) error {
+	lister, ok := s.primary.(storage.Lister)
+	if !ok {
+		return fmt.Errorf("primary blob store cannot list its blobs")
+	}
+	return lister.List(ctx, fn)
+}
+
+// work replicates queued blobs once their file_contents row is committed.
+func (s *Store) work() {
+	defer s.wg.Done()
+	for key := range s.queue {
+		fc, err := s.waitForCommit(key)
+		if err != nil {
+			slog.Warn("Not replicating blob", "key", key, "error", err)
+			continue
+		}
+		if err := s.Sync(context.Background(), fc); err != nil {
+			slog.Error("Error replicating content", "content_id", fc.ContentID, "error", err)
+		}
+	}
+}
+
+// waitForCommit returns the row that references key, waiting for the upload or copy that
+// wrote the blob to commit it.
+func (s *Store) waitForCommit(key string) (*models.FileContent, error) {
+	for attempt := 0; attempt < commitRetries; attempt++ {
+		var rows []models.FileContent
+		if _, err := s.db.From("file_contents").Select("*", "", false).Eq("storage_path", key).ExecuteTo(&rows); err != nil {
+			return nil, err
+		}
+		if len(rows) > 0 {
+			return &rows[0], nil
+		}
+		time.Sleep(commitDelay)
+	}
+	return nil, fmt.Errorf("no file_contents row references it")
+}
+
+// Sync brings every replica of fc up to date, copying the blob to replicas that are missing
+// it, failed earlier, or hold an older path, and records the outcome in blob_replicas.
+func (s *Store) Sync(ctx context.Context, fc *models.FileContent) error {
+	var states []models.BlobReplica
+	if _, err := s.db.From("blob_replicas").Select("*", "", false).Eq("content_id", fc.ContentID).ExecuteTo(&states); err != nil {
+		return fmt.Errorf("fetch replica state: %w", err)
+	}
+	current := make(map[string]models.BlobReplica, len(states))
+	for _, state := range states {
+		current[state.Replica] = state
+	}
+
+	var firstErr error
+	for _, replica := range s.replicas {
+		state, known := current[replica.Name]
+		if known && state.Status == StatusOK && state.StoragePath == fc.StoragePath {
+			continue
+		}
+
+		copyErr := s.copyTo(ctx, replica, fc.StoragePath)
+		if copyErr == nil && known && state.StoragePath != "" && state.StoragePath != fc.StoragePath {
+			// The content moved (migration or re-encryption); drop the stale copy
+			if err := replica.Store.Delete(ctx, state.StoragePath); err != nil {
+				slog.Error("Error removing stale blob from replica", "key", state.StoragePath, "replica", replica.Name, "error", err)
+			}
+		}
+
+		record := models.BlobReplica{
+			ContentID:   fc.ContentID,
+			Replica:     replica.Name,
+			StoragePath: fc.StoragePath,
+			Status:      StatusOK,
+			UpdatedAt:   models.CustomTime{Time: time.Now().UTC()},
+		}
+		if copyErr != nil {
+			record.Status = StatusFailed
+			record.LastError = copyErr.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("replica %s: %w", replica.Name, copyErr)
+			}
+		}
+		if _, _, err := s.db.From("blob_replicas").Insert(record, true, "content_id,replica", "", "").Execute(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("record replica state: %w", err)
+		}
+	}
+	return firstErr
+}
+
+// copyTo copies the primary's blob under key to replica byte for byte.
+func (s *Store) copyTo(ctx context.Context, replica Replica, key string) error {
+	src, err := s.primary.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("read primary: %w", err)
+	}
+	defer src.Close()
+	return replica.Store.Put(ctx, key, src)
+}
+
+// ReplicaStatus counts the blobs each replica holds an up-to-date copy of and those whose
+// last copy failed. Blobs with neither are still pending.
+type ReplicaStatus struct {
+	Name   string `json:"name"`
+	OK     int64  `json:"ok"`
+	Failed int64  `json:"failed"`
+}
+
+// Status reports replica state counts for each configured replica.
+func (s *Store) Status() ([]ReplicaStatus, error) {
+	statuses := make([]ReplicaStatus, 0, len(s.replicas))
+	for _, replica := range s.replicas {
+		status := ReplicaStatus{Name: replica.Name}
+		var err error
+		if _, status.OK, err = s.db.From("blob_replicas").Select("content_id", "exact", true).Eq("replica", replica.Name).Eq("status", StatusOK).Execute(); err != nil {
+			return nil, err
+		}
+		if _, status.Failed, err = s.db.From("blob_replicas").Select("content_id", "exact", true).Eq("replica", replica.Name).Eq("status", StatusFailed).Execute(); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,33 @@
+package replication
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/storage"
+)
+
+func TestPutAfterCloseDoesNotPanic(t *testing.T) {
+	primary, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(nil, primary, nil)
+	s.Close()
+	s.Close()
+
+	// A request outliving a timed-out shutdown still stores its blob; only replication is skipped
+	if err := s.Put(context.Background(), "late", strings.NewReader("payload")); err != nil {
+		t.Fatalf("Put after Close: %v", err)
+	}
+	r, err := s.Get(context.Background(), "late")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer r.Close()
+	if got, _ := io.ReadAll(r); string(got) != "payload" {
+		t.Fatalf("Get returned %q", got)
+	}
+}
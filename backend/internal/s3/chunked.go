@@ -0,0 +1,187 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkHeader bounds the line before each chunk, so a client cannot make the server buffer
+// an endless header.
+const maxChunkHeader = 4096
+
+// emptySHA256 is the hex SHA-256 of no bytes, part of every chunk's string to sign.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// chunkedReader decodes an aws-chunked body: chunks of "hex-size[;chunk-signature=sig]\r\n",
+// the data and "\r\n", ending with a zero-size chunk and, for the trailer variants, trailing
+// headers. When signed, each chunk's signature chains from the previous one, starting at the
+// request's own signature; trailers are read and ignored.
+type chunkedReader struct {
+	r         *bufio.Reader
+	auth      *Auth
+	signed    bool
+	previous  string
+	remaining int64 // Unread bytes of the current chunk
+	hash      hashState
+	signature string
+	done      bool
+	err       error
+}
+
+type hashState interface {
+	io.Writer
+	Sum([]byte) []byte
+	Reset()
+}
+
+func newChunkedReader(body io.Reader, auth *Auth, signed bool) *chunkedReader {
+	return &chunkedReader{
+		r:        bufio.NewReader(body),
+		auth:     auth,
+		signed:   signed,
+		previous: auth.signature,
+		hash:     sha256.New(),
+	}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = ErrIncompleteBody
+	}
+	if err == nil && c.remaining == 0 {
+		err = c.endChunk()
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk reads a chunk header. A zero-size chunk is verified at once and ends the body.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if errors.Is(err, io.EOF) {
+		return ErrIncompleteBody
+	}
+	if err != nil {
+		return err
+	}
+	sizeText, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+	if err != nil || size < 0 {
+		return ErrIncompleteBody.WithMessage("Malformed aws-chunked body")
+	}
+	c.signature = ""
+	if c.signed {
+		name, value, _ := strings.Cut(extension, "=")
+		if name != "chunk-signature" || value == "" {
+			return ErrSignatureDoesNotMatch.WithMessage("A chunk of the body is not signed")
+		}
+		c.signature = value
+	}
+	c.hash.Reset()
+	c.remaining = size
+	if size > 0 {
+		return nil
+	}
+
+	c.done = true
+	if err := c.verify(); err != nil {
+		return err
+	}
+	// Trailing headers, if any, end with an empty line; a plain body may just end
+	for {
+		line, err := c.readLine()
+		if errors.Is(err, io.EOF) || line == "" && err == nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// endChunk consumes the CRLF after a chunk's data and checks its signature.
+func (c *chunkedReader) endChunk() error {
+	line, err := c.readLine()
+	if errors.Is(err, io.EOF) {
+		return ErrIncompleteBody
+	}
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return ErrIncompleteBody.WithMessage("Malformed aws-chunked body")
+	}
+	return c.verify()
+}
+
+// verify checks the signature of the chunk just read.
+func (c *chunkedReader) verify() error {
+	if !c.signed {
+		return nil
+	}
+	stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" +
+		c.auth.date.Format(timeFormat) + "\n" +
+		c.auth.scope + "\n" +
+		c.previous + "\n" +
+		emptySHA256 + "\n" +
+		hex.EncodeToString(c.hash.Sum(nil))
+	expected := hex.EncodeToString(hmacSHA256(c.auth.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(c.signature)) {
+		return ErrSignatureDoesNotMatch.WithMessage("The signature of a chunk of the body does not match")
+	}
+	c.previous = c.signature
+	return nil
+}
+
+// readLine reads a CRLF-terminated line without the terminator. A line cut off by the end
+// of the body is an incomplete body, not a shorter line.
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		part, err := c.r.ReadSlice('\n')
+		line = append(line, part...)
+		if len(line) > maxChunkHeader {
+			return "", ErrIncompleteBody.WithMessage("Malformed aws-chunked body")
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) == 0 {
+				return "", io.EOF
+			}
+			return "", ErrIncompleteBody
+		}
+		line = line[:len(line)-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		return string(line), nil
+	}
+}
@@ -0,0 +1,119 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The streaming PUT of the AWS Signature Version 4 documentation: 66560 bytes of 'a' sent as
+// a 64 KiB chunk, a 1 KiB chunk and the final empty chunk, each signed.
+const (
+	streamingSeedSignature = "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9"
+	streamingSignedHeaders = "content-encoding;content-length;host;x-amz-content-sha256;x-amz-date;x-amz-decoded-content-length;x-amz-storage-class"
+)
+
+var streamingChunks = []struct {
+	size      int
+	signature string
+}{
+	{65536, "ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648"},
+	{1024, "0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497"},
+	{0, "b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9"},
+}
+
+// streamingChunk encodes one chunk of the example body.
+func streamingChunk(size int, signature string) string {
+	return strconv.FormatInt(int64(size), 16) + ";chunk-signature=" + signature + "\r\n" +
+		strings.Repeat("a", size) + "\r\n"
+}
+
+func streamingBody() string {
+	var b strings.Builder
+	for _, chunk := range streamingChunks {
+		b.WriteString(streamingChunk(chunk.size, chunk.signature))
+	}
+	return b.String()
+}
+
+// streamingAuth verifies the example's seed request and returns its Auth.
+func streamingAuth(t *testing.T) (*Auth, *http.Request) {
+	t.Helper()
+	at(t, exampleDate)
+	body := streamingBody()
+	r := httptest.NewRequest(http.MethodPut, "http://s3.amazonaws.com/examplebucket/chunkObject.txt", strings.NewReader(body))
+	r.Header.Set("X-Amz-Date", exampleDate)
+	r.Header.Set("X-Amz-Storage-Class", "REDUCED_REDUNDANCY")
+	r.Header.Set("X-Amz-Content-Sha256", streamingPayload)
+	r.Header.Set("Content-Encoding", "aws-chunked")
+	r.Header.Set("X-Amz-Decoded-Content-Length", "66560")
+	r.Header.Set("Authorization", authorization(streamingSignedHeaders, streamingSeedSignature))
+	if r.ContentLength != 66824 {
+		t.Fatalf("example body is %d bytes, want 66824", r.ContentLength)
+	}
+
+	auth, err := Verify(r, exampleLookup)
+	if err != nil {
+		t.Fatalf("Verify seed request: %v", err)
+	}
+	return auth, r
+}
+
+func TestChunkedKnownAnswer(t *testing.T) {
+	auth, r := streamingAuth(t)
+	if n := auth.DecodedLength(r); n != 66560 {
+		t.Fatalf("DecodedLength = %d", n)
+	}
+	got, err := io.ReadAll(auth.Body(r))
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if !bytes.Equal(got, bytes.Repeat([]byte("a"), 66560)) {
+		t.Fatalf("decoded %d bytes, not the example payload", len(got))
+	}
+}
+
+func TestChunkedRejections(t *testing.T) {
+	auth, _ := streamingAuth(t)
+	first := streamingChunk(streamingChunks[0].size, streamingChunks[0].signature)
+	second := streamingChunk(streamingChunks[1].size, streamingChunks[1].signature)
+	final := streamingChunk(0, streamingChunks[2].signature)
+	badSignature := streamingChunk(1024, strings.Repeat("0", 64))
+	changedData := strings.Replace(second, "aaaa", "aaab", 1)
+
+	cases := []struct {
+		name string
+		body string
+		want *Error
+	}{
+		{"bad chunk signature", first + badSignature + final, ErrSignatureDoesNotMatch},
+		{"changed chunk data", first + changedData + final, ErrSignatureDoesNotMatch},
+		{"chunks swapped", second + first + final, ErrSignatureDoesNotMatch},
+		{"unsigned chunk", first + "400\r\n" + strings.Repeat("a", 1024) + "\r\n" + final, ErrSignatureDoesNotMatch},
+		{"final chunk missing", first + second, ErrIncompleteBody},
+		{"final chunk cut off", first + second + final[:len(final)/2], ErrIncompleteBody},
+		{"data cut off", first + second[:len(second)/2], ErrIncompleteBody},
+		{"malformed size", "zz;chunk-signature=" + strings.Repeat("0", 64) + "\r\n", ErrIncompleteBody},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := io.ReadAll(newChunkedReader(strings.NewReader(tc.body), auth, true))
+			if !hasCode(err, tc.want) {
+				t.Fatalf("read = %v, want %s", err, tc.want.Code)
+			}
+		})
+	}
+}
+
+func TestChunkedUnsignedTrailer(t *testing.T) {
+	auth, _ := streamingAuth(t)
+	body := "5\r\nhello\r\n0\r\nx-amz-checksum-crc32:NhCmhg==\r\n\r\n"
+	got, err := io.ReadAll(newChunkedReader(strings.NewReader(body), auth, false))
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("read = %q, %v", got, err)
+	}
+}
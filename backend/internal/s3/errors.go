@@ -0,0 +1,76 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// Error is an error in the form S3 clients expect: a code they branch on, an HTTP status and
+// a message.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithMessage returns a copy of e with another message.
+func (e *Error) WithMessage(message string) *Error {
+	copied := *e
+	copied.Message = message
+	return &copied
+}
+
+// Errors returned by the gateway, with the codes and statuses S3 uses for them.
+var (
+	ErrAccessDenied                = &Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	ErrAuthorizationMalformed      = &Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed"}
+	ErrAuthorizationQueryMalformed = &Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "The presigned URL parameters are malformed"}
+	ErrBadDigest                   = &Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what was received"}
+	ErrContentSHA256Mismatch       = &Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	ErrIncompleteBody              = &Error{http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header"}
+	ErrInternal                    = &Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+	ErrInvalidAccessKeyID          = &Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist in our records"}
+	ErrInvalidArgument             = &Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	ErrInvalidDigest               = &Error{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified is not valid"}
+	ErrInvalidPart                 = &Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found or did not match"}
+	ErrInvalidPartOrder            = &Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	ErrInvalidRequest              = &Error{http.StatusBadRequest, "InvalidRequest", "Invalid request"}
+	ErrMalformedXML                = &Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate"}
+	ErrMissingContentLength        = &Error{http.StatusLengthRequired, "MissingContentLength", "You must provide the Content-Length HTTP header"}
+	ErrMethodNotAllowed            = &Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	ErrNoSuchBucket                = &Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	ErrNoSuchKey                   = &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	ErrNoSuchUpload                = &Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	ErrNotImplemented              = &Error{http.StatusNotImplemented, "NotImplemented", "A header or operation you provided implies functionality that is not implemented"}
+	ErrQuotaExceeded               = &Error{http.StatusForbidden, "QuotaExceeded", "The upload would exceed your storage quota"}
+	ErrRequestTimeTooSkewed        = &Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	ErrSignatureDoesNotMatch       = &Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	ErrSignatureVersion            = &Error{http.StatusBadRequest, "InvalidRequest", "Only AWS Signature Version 4 is supported"}
+)
+
+// errorDocument is the body of an S3 error response.
+type errorDocument struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty"`
+}
+
+// WriteError writes err as an S3 error document naming the request's path as the resource.
+// HEAD responses carry no body, so clients only see the status.
+func WriteError(w http.ResponseWriter, r *http.Request, err *Error, requestID string) {
+	w.Header().Set("Content-Type", "application/xml")
+	if requestID != "" {
+		w.Header().Set("X-Amz-Request-Id", requestID)
+	}
+	w.WriteHeader(err.Status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	writeXML(w, errorDocument{Code: err.Code, Message: err.Message, Resource: r.URL.Path, RequestID: requestID})
+}
@@ -0,0 +1,336 @@
+// Package s3 implements the protocol side of the S3-compatible gateway: AWS Signature Version 4
+// request authentication, aws-chunked request bodies, and the XML documents S3 clients exchange.
+// Mapping buckets and objects onto the vault is left to the handlers.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	// timeFormat is the ISO 8601 basic format of X-Amz-Date.
+	timeFormat = "20060102T150405Z"
+	// maxSkew is how far a signed request's date may be from the server's clock.
+	maxSkew = 15 * time.Minute
+	// maxPresignExpiry is the longest validity a presigned URL may ask for: seven days.
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// Payload hashes a client can sign instead of the hex SHA-256 of the body.
+const (
+	unsignedPayload        = "UNSIGNED-PAYLOAD"
+	streamingPayload       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrail  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// now is the clock signed request dates are checked against.
+var now = time.Now
+
+// ErrUnknownAccessKey is returned by a SecretLookup for an access key ID it does not know.
+var ErrUnknownAccessKey = errors.New("unknown access key")
+
+// SecretLookup returns the secret access key of an access key ID.
+type SecretLookup func(accessKeyID string) (string, error)
+
+// Auth is a request whose Signature Version 4 signature has been verified.
+type Auth struct {
+	AccessKeyID string
+	// PayloadHash is the x-amz-content-sha256 the request was signed with
+	PayloadHash string
+
+	date       time.Time
+	scope      string
+	signingKey []byte
+	signature  string
+}
+
+// Verify checks the Signature Version 4 signature of r, sent in the Authorization header or,
+// for presigned URLs, in the query string. Errors are *Error values to send to the client.
+func Verify(r *http.Request, lookup SecretLookup) (*Auth, error) {
+	if r.URL.Query().Get("X-Amz-Algorithm") != "" {
+		return verifyPresigned(r, lookup)
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrAccessDenied.WithMessage("Anonymous access is not allowed")
+	}
+	if !strings.HasPrefix(header, algorithm+" ") {
+		return nil, ErrSignatureVersion
+	}
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(header, algorithm+" "), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[key] = value
+	}
+	credential, signedHeaders, signature := fields["Credential"], fields["SignedHeaders"], fields["Signature"]
+	if credential == "" || signedHeaders == "" || signature == "" {
+		return nil, ErrAuthorizationMalformed
+	}
+	// A signature that does not cover the host and date could be replayed elsewhere or later
+	headers := strings.Split(signedHeaders, ";")
+	if name := unsignedHeader(headers, "host", "x-amz-date"); name != "" {
+		return nil, ErrAuthorizationMalformed.WithMessage("SignedHeaders must include " + name)
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	date, err := time.Parse(timeFormat, amzDate)
+	if err != nil {
+		return nil, ErrAccessDenied.WithMessage("X-Amz-Date is missing or malformed")
+	}
+	if skew := now().Sub(date); skew > maxSkew || skew < -maxSkew {
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return nil, ErrInvalidRequest.WithMessage("Missing required header for this request: x-amz-content-sha256")
+	}
+	auth, err := authenticate(r, lookup, credential, date, payloadHash)
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	canonical := canonicalRequest(r, query, headers, payloadHash)
+	if err := auth.check(amzDate, canonical, signature); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// verifyPresigned checks a request whose signature is in the query string.
+func verifyPresigned(r *http.Request, lookup SecretLookup) (*Auth, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != algorithm {
+		return nil, ErrSignatureVersion
+	}
+	amzDate := query.Get("X-Amz-Date")
+	date, err := time.Parse(timeFormat, amzDate)
+	if err != nil {
+		return nil, ErrAuthorizationQueryMalformed
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return nil, ErrAuthorizationQueryMalformed
+	}
+	if now().After(date.Add(time.Duration(expires) * time.Second)) {
+		return nil, ErrAccessDenied.WithMessage("Request has expired")
+	}
+	if date.After(now().Add(maxSkew)) {
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	signedHeaders, signature := query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature")
+	if signedHeaders == "" || signature == "" {
+		return nil, ErrAuthorizationQueryMalformed
+	}
+	// The date is in the signed query string; the host must be signed too
+	headers := strings.Split(signedHeaders, ";")
+	if name := unsignedHeader(headers, "host"); name != "" {
+		return nil, ErrAuthorizationQueryMalformed.WithMessage("X-Amz-SignedHeaders must include " + name)
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
+	auth, err := authenticate(r, lookup, query.Get("X-Amz-Credential"), date, payloadHash)
+	if err != nil {
+		return nil, err
+	}
+	query.Del("X-Amz-Signature")
+	canonical := canonicalRequest(r, query, headers, payloadHash)
+	if err := auth.check(amzDate, canonical, signature); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// unsignedHeader returns the first of required that signedHeaders lacks, or "".
+func unsignedHeader(signedHeaders []string, required ...string) string {
+	for _, name := range required {
+		found := false
+		for _, signed := range signedHeaders {
+			if signed == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return name
+		}
+	}
+	return ""
+}
+
+// authenticate parses a credential scope, AKID/date/region/s3/aws4_request, and derives the
+// signing key of its access key.
+func authenticate(r *http.Request, lookup SecretLookup, credential string, date time.Time, payloadHash string) (*Auth, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return nil, ErrAuthorizationMalformed
+	}
+	if parts[1] != date.Format("20060102") {
+		return nil, ErrAuthorizationMalformed.WithMessage("The credential date does not match X-Amz-Date")
+	}
+	secret, err := lookup(parts[0])
+	if errors.Is(err, ErrUnknownAccessKey) {
+		return nil, ErrInvalidAccessKeyID
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte("AWS4" + secret)
+	for _, part := range parts[1:] {
+		key = hmacSHA256(key, part)
+	}
+	return &Auth{
+		AccessKeyID: parts[0],
+		PayloadHash: payloadHash,
+		date:        date,
+		scope:       strings.Join(parts[1:], "/"),
+		signingKey:  key,
+	}, nil
+}
+
+// check compares signature with the one computed over the canonical request.
+func (a *Auth) check(amzDate, canonical, signature string) error {
+	stringToSign := algorithm + "\n" + amzDate + "\n" + a.scope + "\n" + sha256Hex([]byte(canonical))
+	expected := hex.EncodeToString(hmacSHA256(a.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureDoesNotMatch
+	}
+	a.signature = signature
+	return nil
+}
+
+// canonicalRequest builds the canonical form of r the signature covers.
+func canonicalRequest(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(encode(r.URL.Path, false) + "\n")
+
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, encode(key, true)+"="+encode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	b.WriteString(strings.Join(pairs, "&") + "\n")
+
+	for _, name := range signedHeaders {
+		b.WriteString(name + ":" + headerValue(r, name) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+// headerValue returns the canonical value of a signed header: its values with runs of
+// spaces collapsed, joined by commas. net/http keeps Host and Content-Length out of r.Header.
+func headerValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.ContentLength < 0 {
+			return ""
+		}
+		return strconv.FormatInt(r.ContentLength, 10)
+	}
+	values := r.Header.Values(name)
+	canonical := make([]string, len(values))
+	for i, value := range values {
+		canonical[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(canonical, ",")
+}
+
+// encode percent-encodes s the way Signature Version 4 does: every byte but the unreserved
+// characters, and the slash too unless it separates path segments.
+func encode(s string, slash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !slash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// Body returns the request body as the client meant it: aws-chunked bodies are decoded and
+// their chunk signatures checked, and a body signed with its SHA-256 fails on the last read
+// if it does not match.
+func (a *Auth) Body(r *http.Request) io.Reader {
+	switch a.PayloadHash {
+	case unsignedPayload:
+		return r.Body
+	case streamingPayload, streamingPayloadTrail:
+		return newChunkedReader(r.Body, a, true)
+	case streamingUnsignedTrail:
+		return newChunkedReader(r.Body, a, false)
+	}
+	return &hashingReader{r: r.Body, hash: sha256.New(), want: a.PayloadHash}
+}
+
+// DecodedLength returns the length of the body as Body returns it, or -1 if unknown.
+func (a *Auth) DecodedLength(r *http.Request) int64 {
+	if strings.HasPrefix(a.PayloadHash, "STREAMING-") {
+		n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	return r.ContentLength
+}
+
+// hashingReader fails at the end of a body whose SHA-256 is not the signed one.
+type hashingReader struct {
+	r    io.Reader
+	hash interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+	want string
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.want {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
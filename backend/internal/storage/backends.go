@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"file-vault/backend/internal/models"
+)
+
+// NamedStore is a blob store configured under a name.
+type NamedStore struct {
+	Name  string
+	Store BlobStore
+}
+
+// ParseNamedStores parses a comma-separated list of name=url pairs, opening each URL with OpenURL.
+func ParseNamedStores(spec string) ([]NamedStore, error) {
+	var stores []NamedStore
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rawURL, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("blob store %q must be written as name=url", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("blob store %q is configured twice", name)
+		}
+		seen[name] = true
+		store, err := OpenURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("blob store %s: %w", name, err)
+		}
+		stores = append(stores, NamedStore{Name: name, Store: store})
+	}
+	return stores, nil
+}
+
+// Backends configures named hot blob stores besides the default one (the Supabase bucket or
+// BLOB_STORE). Each file_contents row records the backend holding its blob in storage_backend,
+// so content can be moved between backends one blob at a time while it stays downloadable.
+type Backends struct {
+	Stores map[string]BlobStore
+	// Active is the backend new blobs are written to; empty means the default store.
+	Active string
+}
+
+// OpenBackends opens the backends in spec (name=url pairs) with active as the one new blobs go to.
+// It returns nil when spec lists none.
+func OpenBackends(spec, active string) (*Backends, error) {
+	named, err := ParseNamedStores(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(named) == 0 {
+		if active != "" {
+			return nil, fmt.Errorf("active backend %q is not a configured storage backend", active)
+		}
+		return nil, nil
+	}
+	backends := &Backends{Stores: make(map[string]BlobStore, len(named)), Active: active}
+	for _, n := range named {
+		backends.Stores[n.Name] = n.Store
+	}
+	if _, ok := backends.Stores[active]; active != "" && !ok {
+		return nil, fmt.Errorf("active backend %q is not a configured storage backend", active)
+	}
+	return backends, nil
+}
+
+// SetBackends makes the named backends available to rows that reference them and switches
+// new blobs to the active one.
+func (s *ContentStore) SetBackends(backends *Backends) {
+	s.backends = backends.Stores
+	s.active = backends.Active
+}
+
+// ActiveBackend returns the name of the backend new blobs are written to, empty for the default store.
+func (s *ContentStore) ActiveBackend() string {
+	return s.active
+}
+
+// BackendNames returns the configured named backends in sorted order.
+func (s *ContentStore) BackendNames() []string {
+	names := make([]string, 0, len(s.backends))
+	for name := range s.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Backend returns the hot store called name; the empty name is the default store.
+func (s *ContentStore) Backend(name string) (BlobStore, error) {
+	if name == "" {
+		return s.blobs, nil
+	}
+	store, ok := s.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q is not configured", name)
+	}
+	return store, nil
+}
+
+// StoreOf returns the blob store holding fc's blob: the cold store for cold content,
+// otherwise the backend the row names.
+func (s *ContentStore) StoreOf(fc *models.FileContent) BlobStore {
+	if fc.StorageClass == ClassCold && s.cold != nil {
+		return s.cold
+	}
+	store, err := s.Backend(fc.StorageBackend)
+	if err != nil {
+		return unavailableStore{err: err}
+	}
+	return store
+}
+
+// unavailableStore stands in for a backend that rows reference but the configuration lacks,
+// so reading such content fails with a clear error instead of hitting the wrong store.
+type unavailableStore struct {
+	err error
+}
+
+func (u unavailableStore) Put(ctx context.Context, key string, r io.Reader) error { return u.err }
+
+func (u unavailableStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, u.err
+}
+
+func (u unavailableStore) Delete(ctx context.Context, key string) error { return u.err }
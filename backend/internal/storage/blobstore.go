@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by a BlobStore when the requested key does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore is the minimal interface the upload and download handlers need from a blob backend.
+type BlobStore interface {
+	// Put stores the contents of r under key.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the blob stored under key. The caller must close the returned reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key.
+	Delete(ctx context.Context, key string) error
+}
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Key       string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Lister is implemented by blob stores that can enumerate their blobs, which garbage collection needs.
+type Lister interface {
+	// List calls fn for every stored blob, stopping at the first error fn returns.
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// Healer is implemented by blob stores that keep redundant pieces of every blob themselves
+// and can rebuild the pieces a blob has lost.
+type Healer interface {
+	// Heal restores the redundancy of the blob stored under key and returns how many pieces it rewrote.
+	Heal(ctx context.Context, key string) (int, error)
+}
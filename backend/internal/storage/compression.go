@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs recorded in file_contents.codec.
+const (
+	CodecNone = "none"
+	CodecZstd = "zstd"
+)
+
+// minCompressionSavings is the fraction of the logical size a compressed blob must save
+// before it is stored compressed. Anything less is not worth the CPU on every download.
+const minCompressionSavings = 0.05
+
+// compress returns a zstd encoding of src when it saves at least minCompressionSavings,
+// otherwise it returns src unchanged with CodecNone. src is rewound before returning.
+// The encoding is spooled to a temp file so memory stays bounded for large blobs;
+// the caller must call the returned cleanup once it is done with the reader.
+func compress(src io.ReadSeeker, size int64) (io.Reader, string, int64, func(), error) {
+	noop := func() {}
+	spool, err := os.CreateTemp("", "file-vault-zstd-*")
+	if err != nil {
+		return nil, "", 0, noop, fmt.Errorf("compress blob: %w", err)
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	enc, err := zstd.NewWriter(spool, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		cleanup()
+		return nil, "", 0, noop, err
+	}
+	if _, err := io.Copy(enc, src); err != nil {
+		enc.Close()
+		cleanup()
+		return nil, "", 0, noop, fmt.Errorf("compress blob: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		cleanup()
+		return nil, "", 0, noop, fmt.Errorf("compress blob: %w", err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, "", 0, noop, err
+	}
+
+	compressed, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		cleanup()
+		return nil, "", 0, noop, err
+	}
+	if float64(compressed) >= float64(size)*(1-minCompressionSavings) {
+		cleanup()
+		return src, CodecNone, size, noop, nil
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, "", 0, noop, err
+	}
+	return spool, CodecZstd, compressed, cleanup, nil
+}
+
+// decompress wraps r so that reading it yields the logical bytes of a blob stored with codec.
+func decompress(r io.ReadCloser, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case "", CodecNone:
+		return r, nil
+	case CodecZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return &zstdReadCloser{dec: dec, src: r}, nil
+	default:
+		r.Close()
+		return nil, fmt.Errorf("unknown blob codec %q", codec)
+	}
+}
+
+// zstdReadCloser releases both the decoder and the underlying blob reader on Close.
+type zstdReadCloser struct {
+	dec *zstd.Decoder
+	src io.ReadCloser
+}
+
+func (z *zstdReadCloser) Read(p []byte) (int, error) {
+	return z.dec.Read(p)
+}
+
+func (z *zstdReadCloser) Close() error {
+	z.dec.Close()
+	return z.src.Close()
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	logical := []byte(strings.Repeat("quarterly report, page after page of it\n", 4096))
+	src := bytes.NewReader(logical)
+
+	r, codec, size, cleanup, err := compress(src, int64(len(logical)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec != CodecZstd || size >= int64(len(logical)) {
+		t.Fatalf("codec %q, %d of %d bytes", codec, size, len(logical))
+	}
+	spool := r.(*os.File).Name()
+	stored, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(stored)) != size {
+		t.Fatalf("read %d stored bytes, reported %d", len(stored), size)
+	}
+	cleanup()
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Fatalf("spool file left behind: %v", err)
+	}
+
+	// The caller still owns src and may upload it again if the write is retried
+	if src.Len() != len(logical) {
+		t.Fatalf("src not rewound, %d bytes unread", src.Len())
+	}
+
+	dec, err := decompress(io.NopCloser(bytes.NewReader(stored)), codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, logical) {
+		t.Fatal("decompressed blob differs")
+	}
+}
+
+func TestCompressSkipsIncompressible(t *testing.T) {
+	logical := make([]byte, 256*1024)
+	if _, err := rand.Read(logical); err != nil {
+		t.Fatal(err)
+	}
+	src := bytes.NewReader(logical)
+
+	r, codec, size, cleanup, err := compress(src, int64(len(logical)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if codec != CodecNone || size != int64(len(logical)) || r != io.Reader(src) {
+		t.Fatalf("codec %q with %d bytes, want the source unchanged", codec, size)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, logical) {
+		t.Fatal("source not rewound to its start")
+	}
+}
+
+func TestDecompressCodecs(t *testing.T) {
+	for _, codec := range []string{"", CodecNone} {
+		r, err := decompress(io.NopCloser(strings.NewReader("plain")), codec)
+		if err != nil {
+			t.Fatalf("codec %q: %v", codec, err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != "plain" {
+			t.Fatalf("codec %q read %q", codec, got)
+		}
+	}
+	if _, err := decompress(io.NopCloser(strings.NewReader("x")), "brotli"); err == nil {
+		t.Fatal("unknown codec accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"file-vault/backend/internal/keys"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/stream"
+)
+
+// EncryptionAES256GCMChunked is recorded in file_contents.encryption for blobs encrypted at rest.
+const EncryptionAES256GCMChunked = "aes-256-gcm-chunked"
+
+// ContentStore applies the at-rest transforms (compression, then envelope encryption) on top of a BlobStore.
+// Handlers only ever see logical bytes; the transforms are recorded on the file_contents row.
+type ContentStore struct {
+	blobs       BlobStore // Default store of the hot storage class
+	cold        BlobStore
+	backends    map[string]BlobStore // Named hot stores rows can reference in storage_backend
+	active      string               // Backend new blobs are written to, empty for blobs
+	keyProvider keys.Provider
+}
+
+// NewContentStore creates a ContentStore writing to blobs. Each new blob is encrypted with a fresh
+// data key wrapped by keyProvider; a nil keyProvider stores blobs unencrypted.
+func NewContentStore(blobs BlobStore, keyProvider keys.Provider) *ContentStore {
+	return &ContentStore{blobs: blobs, keyProvider: keyProvider}
+}
+
+// Blobs returns the default blob store of the hot storage class.
+func (s *ContentStore) Blobs() BlobStore {
+	return s.blobs
+}
+
+// Save writes src to fc.StoragePath and records how it was stored on fc.
+// fc.Size must already hold the logical size of src.
+func (s *ContentStore) Save(ctx context.Context, fc *models.FileContent, src io.ReadSeeker) error {
+	// End-to-end encrypted content is ciphertext already; compressing it would only waste CPU
+	var body io.Reader = src
+	codec, storedSize := CodecNone, fc.Size
+	if fc.ClientEncryption == "" {
+		var cleanup func()
+		var err error
+		if body, codec, storedSize, cleanup, err = compress(src, fc.Size); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	var encryption, keyID, wrappedKey string
+	if s.keyProvider != nil {
+		dataKey, id, wrapped, err := keys.GenerateDataKey(ctx, s.keyProvider)
+		if err != nil {
+			return fmt.Errorf("generate data key: %w", err)
+		}
+		encrypted := encryptReader(body, dataKey)
+		defer encrypted.Close()
+		body = encrypted
+		storedSize = stream.EncryptedSize(storedSize)
+		encryption, keyID, wrappedKey = EncryptionAES256GCMChunked, id, base64.StdEncoding.EncodeToString(wrapped)
+	}
+
+	if err := s.StoreFor(ClassHot).Put(ctx, fc.StoragePath, body); err != nil {
+		return err
+	}
+	fc.Codec = codec
+	fc.StoredSize = storedSize
+	fc.Encryption = encryption
+	fc.KeyID = keyID
+	fc.WrappedKey = wrappedKey
+	fc.StorageClass = ClassHot
+	fc.StorageBackend = s.active
+	return nil
+}
+
+// Open returns a reader over the logical bytes of fc.
+func (s *ContentStore) Open(ctx context.Context, fc *models.FileContent) (io.ReadCloser, error) {
+	r, err := s.StoreOf(fc).Get(ctx, fc.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if fc.Encryption != "" {
+		dataKey, err := s.dataKey(ctx, fc)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		decrypted, err := stream.NewReader(r, dataKey)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r = decrypted
+	}
+	return decompress(r, fc.Codec)
+}
+
+// Delete removes the blob behind fc.
+func (s *ContentStore) Delete(ctx context.Context, fc *models.FileContent) error {
+	return s.StoreOf(fc).Delete(ctx, fc.StoragePath)
+}
+
+// dataKey unwraps the data key recorded on fc.
+func (s *ContentStore) dataKey(ctx context.Context, fc *models.FileContent) ([]byte, error) {
+	if fc.Encryption != EncryptionAES256GCMChunked {
+		return nil, fmt.Errorf("unsupported blob encryption %q", fc.Encryption)
+	}
+	if s.keyProvider == nil {
+		return nil, fmt.Errorf("blob %s is encrypted but no key provider is configured", fc.ContentID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(fc.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode wrapped data key: %w", err)
+	}
+	return s.keyProvider.UnwrapKey(ctx, fc.KeyID, wrapped)
+}
+
+// encryptReader returns a reader yielding the encrypted form of src. Closing it
+// before it is drained stops the background encryption.
+func encryptReader(src io.Reader, dataKey []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := stream.NewWriter(pw, dataKey)
+		if err == nil {
+			_, err = io.Copy(w, src)
+			if err == nil {
+				err = w.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,732 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+const (
+	shardMagic      = "SFVE"
+	shardVersion    = 1
+	shardHeaderSize = 32
+	// shardBlockSize is how much of each shard one stripe holds; a stripe carries data*shardBlockSize bytes of the blob.
+	shardBlockSize = 64 << 10
+	// maxShardBlockSize rejects headers that would make a read allocate unreasonable buffers.
+	maxShardBlockSize = 16 << 20
+	// blockTrailer is the CRC-32C stored after every block, which tells a corrupt block from a good one.
+	blockTrailer = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErasureStore is a BlobStore for deployments without object storage. It splits every blob
+// into data shards plus Reed-Solomon parity shards and keeps each shard in a different
+// directory, ideally on its own disk. Reads survive up to parity missing or corrupt shards,
+// and Heal rewrites the shards a blob has lost.
+//
+// A shard file starts with a header carrying the blob size and a generation chosen per write,
+// so shards left behind by an earlier write of the same key are never mixed into a read.
+// The header is followed by one block per stripe, each with a CRC-32C trailer.
+type ErasureStore struct {
+	dirs   []string
+	data   int
+	parity int
+	enc    reedsolomon.Encoder
+
+	// healing holds the keys a degraded read is healing in the background.
+	healing sync.Map
+}
+
+// NewErasureStore creates an ErasureStore over dirs, writing data+parity shards per blob.
+// Shard i of a blob lives in dirs[i] when there are exactly data+parity directories; with
+// more, each blob's shards start at a directory picked from its key, spreading the load.
+// The directory list must keep its order across restarts.
+func NewErasureStore(dirs []string, data, parity int) (*ErasureStore, error) {
+	if data < 1 || parity < 1 {
+		return nil, fmt.Errorf("erasure coding needs at least one data and one parity shard, got %d+%d", data, parity)
+	}
+	if data+parity > 255 {
+		return nil, fmt.Errorf("erasure coding supports at most 255 shards, got %d", data+parity)
+	}
+	if len(dirs) < data+parity {
+		return nil, fmt.Errorf("%d+%d shards need at least %d directories, got %d", data, parity, data+parity, len(dirs))
+	}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		clean := filepath.Clean(dir)
+		if seen[clean] {
+			return nil, fmt.Errorf("directory %s is listed twice", dir)
+		}
+		seen[clean] = true
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	enc, err := reedsolomon.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	return &ErasureStore{dirs: dirs, data: data, parity: parity, enc: enc}, nil
+}
+
+// shardPaths returns the file of every shard of key, rejecting keys that would escape the directories.
+func (s *ErasureStore) shardPaths(key string) ([]string, error) {
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+	total := s.data + s.parity
+	start := 0
+	if len(s.dirs) > total {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		start = int(h.Sum32() % uint32(len(s.dirs)))
+	}
+	paths := make([]string, total)
+	for i := range paths {
+		paths[i] = filepath.Join(s.dirs[(start+i)%len(s.dirs)], filepath.FromSlash(key))
+	}
+	return paths, nil
+}
+
+// Put encodes r stripe by stripe into temporary shard files and renames them into place.
+// A shard whose directory fails to write is dropped as long as data+1 shards succeed, so a
+// single failed disk does not stop uploads; Heal restores the missing shard later.
+func (s *ErasureStore) Put(ctx context.Context, key string, r io.Reader) error {
+	paths, err := s.shardPaths(key)
+	if err != nil {
+		return err
+	}
+	var gen [8]byte
+	if _, err := rand.Read(gen[:]); err != nil {
+		return err
+	}
+	hdr := shardHeader{data: s.data, parity: s.parity, blockSize: shardBlockSize, generation: binary.BigEndian.Uint64(gen[:])}
+
+	writers := make([]*shardWriter, len(paths))
+	for i, path := range paths {
+		writers[i] = createShard(path)
+	}
+	defer func() {
+		for _, w := range writers {
+			w.abort()
+		}
+	}()
+
+	shards := make([][]byte, len(paths))
+	for i := range shards {
+		shards[i] = make([]byte, shardBlockSize)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, eof, err := fillStripe(r, shards[:s.data])
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		hdr.size += n
+		if err := s.enc.Encode(shards); err != nil {
+			return err
+		}
+		for i, w := range writers {
+			w.writeBlock(shards[i])
+		}
+		if eof {
+			break
+		}
+	}
+
+	for i, w := range writers {
+		h := hdr
+		h.index = i
+		w.commit(h)
+	}
+	written, firstErr := 0, error(nil)
+	for _, w := range writers {
+		if w.err == nil {
+			written++
+		} else if firstErr == nil {
+			firstErr = w.err
+		}
+	}
+	if written < s.writeQuorum() {
+		return fmt.Errorf("blob %s: only %d of %d shards written: %w", key, written, len(paths), firstErr)
+	}
+	if written < len(paths) {
+		slog.Warn("Erasure store: blob written with missing shards; heal will rebuild them", "key", key, "written", written, "shards", len(paths), "error", firstErr)
+	}
+	return nil
+}
+
+// writeQuorum is the number of shards a Put must write: enough to survive losing one more.
+func (s *ErasureStore) writeQuorum() int {
+	return s.data + 1
+}
+
+// fillStripe reads the next stripe of r into shards, zero-padding the last one.
+func fillStripe(r io.Reader, shards [][]byte) (n int64, eof bool, err error) {
+	for _, shard := range shards {
+		if eof {
+			clear(shard)
+			continue
+		}
+		m, err := io.ReadFull(r, shard)
+		n += int64(m)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			clear(shard[m:])
+			eof = true
+			continue
+		}
+		if err != nil {
+			return n, false, err
+		}
+	}
+	return n, eof, nil
+}
+
+// Get reconstructs the blob from any data shards' worth of readable shards. A read that had
+// to reconstruct starts healing the blob in the background once it is closed.
+func (s *ErasureStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	set, err := s.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return &erasureReader{store: s, key: key, set: set, remaining: set.hdr.size}, nil
+}
+
+// Delete removes every shard of key. Deleting a missing blob is not an error.
+func (s *ErasureStore) Delete(ctx context.Context, key string) error {
+	paths, err := s.shardPaths(key)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// List reports every key with at least one shard in any directory, skipping temporary files
+// of writes in progress. CreatedAt is the modification time of the oldest shard.
+func (s *ErasureStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	blobs := make(map[string]BlobInfo)
+	for _, dir := range s.dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path != dir && errors.Is(err, fs.ErrNotExist) {
+					return nil // removed while walking
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+				return nil
+			}
+			hdr, err := readShardHeader(path)
+			if err != nil {
+				return nil // not a shard, or too damaged to say which blob it belongs to
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			blob, ok := blobs[key]
+			if !ok || info.ModTime().Before(blob.CreatedAt) {
+				blobs[key] = BlobInfo{Key: key, Size: hdr.size, CreatedAt: info.ModTime()}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, blob := range blobs {
+		if err := fn(blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Heal rewrites the shards of key that are missing, left over from another write or hold a
+// corrupt block, and returns how many it rewrote. Healthy blobs are only read.
+func (s *ErasureStore) Heal(ctx context.Context, key string) (int, error) {
+	// First pass: read every stripe to find the damaged shards
+	set, err := s.open(key)
+	if err != nil {
+		return 0, err
+	}
+	shards := set.buffers()
+	for stripe := int64(0); stripe < set.stripes(); stripe++ {
+		if err := ctx.Err(); err != nil {
+			set.close()
+			return 0, err
+		}
+		if err := set.readStripe(shards); err != nil {
+			set.close()
+			return 0, err
+		}
+	}
+	set.close()
+	damaged := set.damagedShards()
+	if len(damaged) == 0 {
+		return 0, nil
+	}
+
+	// Second pass: rebuild the damaged shards from the rest
+	set, err = s.open(key)
+	if err != nil {
+		return 0, err
+	}
+	defer set.close()
+	paths, err := s.shardPaths(key)
+	if err != nil {
+		return 0, err
+	}
+	writers := make(map[int]*shardWriter, len(damaged))
+	for _, i := range damaged {
+		writers[i] = createShard(paths[i])
+	}
+	defer func() {
+		for _, w := range writers {
+			w.abort()
+		}
+	}()
+
+	shards = set.buffers()
+	for stripe := int64(0); stripe < set.stripes(); stripe++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if err := set.readStripe(shards); err != nil {
+			return 0, err
+		}
+		for i := range writers {
+			shards[i] = shards[i][:0]
+		}
+		if err := s.enc.Reconstruct(shards); err != nil {
+			return 0, err
+		}
+		for i, w := range writers {
+			w.writeBlock(shards[i])
+		}
+	}
+
+	healed := 0
+	for i, w := range writers {
+		h := set.hdr
+		h.index = i
+		w.commit(h)
+		if w.err != nil {
+			slog.Error("Erasure store: could not rebuild shard", "key", key, "shard", i, "error", w.err)
+			continue
+		}
+		healed++
+	}
+	if healed < len(writers) {
+		return healed, fmt.Errorf("blob %s: rebuilt %d of %d damaged shards", key, healed, len(writers))
+	}
+	return healed, nil
+}
+
+// healInBackground heals key after a degraded read, at most once at a time per key.
+func (s *ErasureStore) healInBackground(key string) {
+	if _, busy := s.healing.LoadOrStore(key, true); busy {
+		return
+	}
+	go func() {
+		defer s.healing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		if n, err := s.Heal(ctx, key); err != nil {
+			slog.Error("Erasure store: error healing blob", "key", key, "error", err)
+		} else if n > 0 {
+			slog.Info("Erasure store: rebuilt shards", "key", key, "shards", n)
+		}
+	}()
+}
+
+// shardHeader is the fixed-size header at the start of every shard file.
+type shardHeader struct {
+	data       int
+	parity     int
+	index      int
+	blockSize  int
+	size       int64
+	generation uint64
+}
+
+func (h shardHeader) marshal() []byte {
+	b := make([]byte, shardHeaderSize)
+	copy(b, shardMagic)
+	b[4] = shardVersion
+	b[5] = byte(h.data)
+	b[6] = byte(h.parity)
+	b[7] = byte(h.index)
+	binary.BigEndian.PutUint32(b[8:], uint32(h.blockSize))
+	binary.BigEndian.PutUint64(b[12:], uint64(h.size))
+	binary.BigEndian.PutUint64(b[20:], h.generation)
+	binary.BigEndian.PutUint32(b[28:], crc32.Checksum(b[:28], castagnoli))
+	return b
+}
+
+func parseShardHeader(b []byte) (shardHeader, error) {
+	if len(b) != shardHeaderSize || string(b[:4]) != shardMagic {
+		return shardHeader{}, errors.New("not a shard file")
+	}
+	if b[4] != shardVersion {
+		return shardHeader{}, fmt.Errorf("unsupported shard version %d", b[4])
+	}
+	if crc32.Checksum(b[:28], castagnoli) != binary.BigEndian.Uint32(b[28:]) {
+		return shardHeader{}, errors.New("shard header checksum mismatch")
+	}
+	h := shardHeader{
+		data:       int(b[5]),
+		parity:     int(b[6]),
+		index:      int(b[7]),
+		blockSize:  int(binary.BigEndian.Uint32(b[8:])),
+		size:       int64(binary.BigEndian.Uint64(b[12:])),
+		generation: binary.BigEndian.Uint64(b[20:]),
+	}
+	if h.blockSize <= 0 || h.blockSize > maxShardBlockSize || h.size < 0 {
+		return shardHeader{}, errors.New("shard header out of range")
+	}
+	return h, nil
+}
+
+func readShardHeader(path string) (shardHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return shardHeader{}, err
+	}
+	defer f.Close()
+	b := make([]byte, shardHeaderSize)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return shardHeader{}, err
+	}
+	return parseShardHeader(b)
+}
+
+// shardWriter writes one shard to a temporary file. After its first error it ignores further
+// writes and removes the file; the error is kept in err.
+type shardWriter struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	err  error
+}
+
+func createShard(path string) *shardWriter {
+	sw := &shardWriter{path: path}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		sw.err = err
+		return sw
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		sw.err = err
+		return sw
+	}
+	sw.f = f
+	sw.w = bufio.NewWriterSize(f, shardBlockSize+blockTrailer)
+	// Leave room for the header, which needs the final size
+	if _, err := sw.w.Write(make([]byte, shardHeaderSize)); err != nil {
+		sw.fail(err)
+	}
+	return sw
+}
+
+func (w *shardWriter) writeBlock(block []byte) {
+	if w.err != nil {
+		return
+	}
+	var trailer [blockTrailer]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.Checksum(block, castagnoli))
+	_, err := w.w.Write(block)
+	if err == nil {
+		_, err = w.w.Write(trailer[:])
+	}
+	if err != nil {
+		w.fail(err)
+	}
+}
+
+// commit fills in the header, syncs the file and renames it into place.
+func (w *shardWriter) commit(h shardHeader) {
+	if w.err != nil {
+		return
+	}
+	err := w.w.Flush()
+	if err == nil {
+		_, err = w.f.WriteAt(h.marshal(), 0)
+	}
+	if err == nil {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	name := w.f.Name()
+	closeErr := w.f.Close()
+	w.f = nil
+	if closeErr == nil {
+		closeErr = os.Rename(name, w.path)
+	}
+	if closeErr != nil {
+		os.Remove(name)
+		w.err = closeErr
+	}
+}
+
+func (w *shardWriter) fail(err error) {
+	w.err = err
+	w.abort()
+}
+
+// abort removes the temporary file unless it was committed.
+func (w *shardWriter) abort() {
+	if w.f == nil {
+		return
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+	w.f = nil
+}
+
+// shardSet is one blob opened for reading: the shard files of its newest readable generation,
+// positioned at the first stripe.
+type shardSet struct {
+	enc     reedsolomon.Encoder
+	data    int
+	hdr     shardHeader
+	readers []*bufio.Reader // nil for shards that are missing or stopped being readable
+	files   []*os.File
+	damaged []bool // shards that must be rewritten by Heal
+	blocks  [][]byte
+}
+
+// open opens the shards of key, choosing the generation most shards agree on.
+func (s *ErasureStore) open(key string) (*shardSet, error) {
+	paths, err := s.shardPaths(key)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*os.File, len(paths))
+	headers := make([]shardHeader, len(paths))
+	found := 0
+	votes := make(map[uint64]int)
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				slog.Warn("Erasure store: cannot open shard", "key", key, "shard", i, "error", err)
+			}
+			continue
+		}
+		found++
+		b := make([]byte, shardHeaderSize)
+		hdr, err := shardHeader{}, error(nil)
+		if _, err = io.ReadFull(f, b); err == nil {
+			hdr, err = parseShardHeader(b)
+		}
+		if err == nil && (hdr.index != i || hdr.data != s.data || hdr.parity != s.parity) {
+			err = errors.New("shard belongs to a different layout")
+		}
+		if err != nil {
+			slog.Warn("Erasure store: ignoring shard", "key", key, "shard", i, "error", err)
+			f.Close()
+			continue
+		}
+		files[i] = f
+		headers[i] = hdr
+		votes[hdr.generation]++
+	}
+	if found == 0 {
+		return nil, ErrNotFound
+	}
+
+	var gen uint64
+	best := 0
+	for g, n := range votes {
+		if n > best {
+			gen, best = g, n
+		}
+	}
+	set := &shardSet{
+		enc:     s.enc,
+		data:    s.data,
+		readers: make([]*bufio.Reader, len(paths)),
+		files:   files,
+		damaged: make([]bool, len(paths)),
+		blocks:  make([][]byte, len(paths)),
+	}
+	for i, f := range files {
+		if f != nil && headers[i].generation != gen {
+			f.Close()
+			files[i] = nil
+		}
+		if files[i] == nil {
+			set.damaged[i] = true
+			continue
+		}
+		set.hdr = headers[i]
+		set.readers[i] = bufio.NewReaderSize(files[i], headers[i].blockSize+blockTrailer)
+	}
+	if best < s.data {
+		set.close()
+		return nil, fmt.Errorf("blob %s: only %d of %d shards readable, need %d", key, best, len(paths), s.data)
+	}
+	return set, nil
+}
+
+// stripes returns the number of stripes the blob was encoded into.
+func (set *shardSet) stripes() int64 {
+	stripe := int64(set.data * set.hdr.blockSize)
+	return (set.hdr.size + stripe - 1) / stripe
+}
+
+// buffers allocates the per-shard block buffers readStripe fills.
+func (set *shardSet) buffers() [][]byte {
+	shards := make([][]byte, len(set.readers))
+	for i := range shards {
+		set.blocks[i] = make([]byte, set.hdr.blockSize+blockTrailer)
+	}
+	return shards
+}
+
+// readStripe reads the next block of every shard into shards, leaving a zero-length slice
+// for blocks that are missing or fail their checksum, and reconstructs the data shards.
+// A shard that fails to read is dropped for the rest of the blob.
+func (set *shardSet) readStripe(shards [][]byte) error {
+	live := 0
+	for i, r := range set.readers {
+		block := set.blocks[i]
+		shards[i] = block[:0]
+		if r == nil {
+			continue
+		}
+		if _, err := io.ReadFull(r, block); err != nil {
+			set.readers[i] = nil
+			set.files[i].Close()
+			set.files[i] = nil
+			set.damaged[i] = true
+			continue
+		}
+		data := block[:set.hdr.blockSize]
+		if crc32.Checksum(data, castagnoli) != binary.BigEndian.Uint32(block[set.hdr.blockSize:]) {
+			set.damaged[i] = true
+			continue
+		}
+		shards[i] = data
+		live++
+	}
+	if live < set.data {
+		return fmt.Errorf("only %d of %d shards of this stripe are intact: %w", live, len(shards), reedsolomon.ErrTooFewShards)
+	}
+	return set.enc.ReconstructData(shards)
+}
+
+// damagedShards returns the indexes of the shards found missing or damaged so far.
+func (set *shardSet) damagedShards() []int {
+	var damaged []int
+	for i, d := range set.damaged {
+		if d {
+			damaged = append(damaged, i)
+		}
+	}
+	return damaged
+}
+
+func (set *shardSet) close() {
+	for i, f := range set.files {
+		if f != nil {
+			f.Close()
+			set.files[i] = nil
+		}
+	}
+}
+
+// erasureReader streams a blob out of its shard set one stripe at a time.
+type erasureReader struct {
+	store     *ErasureStore
+	key       string
+	set       *shardSet
+	shards    [][]byte
+	stripe    []byte
+	pending   []byte
+	remaining int64
+}
+
+func (r *erasureReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next decodes the next stripe into pending.
+func (r *erasureReader) next() error {
+	if r.shards == nil {
+		r.shards = r.set.buffers()
+		r.stripe = make([]byte, r.set.data*r.set.hdr.blockSize)
+	}
+	if err := r.set.readStripe(r.shards); err != nil {
+		return fmt.Errorf("blob %s: %w", r.key, err)
+	}
+	n := 0
+	for _, shard := range r.shards[:r.set.data] {
+		n += copy(r.stripe[n:], shard)
+	}
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+	}
+	r.remaining -= int64(n)
+	r.pending = r.stripe[:n]
+	return nil
+}
+
+// Close releases the shard files and heals the blob if the read found damage.
+func (r *erasureReader) Close() error {
+	r.set.close()
+	if len(r.set.damagedShards()) > 0 {
+		r.store.healInBackground(r.key)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testData   = 4
+	testParity = 2
+)
+
+// newTestErasureStore returns a store over one fresh directory per shard.
+func newTestErasureStore(t *testing.T) (*ErasureStore, []string) {
+	t.Helper()
+	root := t.TempDir()
+	dirs := make([]string, testData+testParity)
+	for i := range dirs {
+		dirs[i] = filepath.Join(root, "disk"+string(rune('0'+i)))
+	}
+	s, err := NewErasureStore(dirs, testData, testParity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dirs
+}
+
+// putRandom stores a blob spanning several stripes, with a partial last one, under key.
+func putRandom(t *testing.T, s *ErasureStore, key string) []byte {
+	t.Helper()
+	blob := make([]byte, 3*testData*shardBlockSize+12345)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), key, bytes.NewReader(blob)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	return blob
+}
+
+func readAll(s *ErasureStore, key string) ([]byte, error) {
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// waitForHeal waits for background heals started by degraded reads, so they do not race the
+// test's own changes to the shard directories.
+func waitForHeal(t *testing.T, s *ErasureStore) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		busy := false
+		s.healing.Range(func(any, any) bool { busy = true; return false })
+		if !busy {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background heal did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestErasureStoreSurvivesLostShardsAndHeals(t *testing.T) {
+	s, dirs := newTestErasureStore(t)
+	const key = "ab/cd/blob"
+	blob := putRandom(t, s, key)
+
+	// Lose as many disks as there are parity shards
+	for _, dir := range dirs[:testParity] {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("degraded Get: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("degraded read: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatal("degraded read returned different bytes")
+	}
+
+	healed, err := s.Heal(context.Background(), key)
+	r.Close()
+	waitForHeal(t, s)
+	if err != nil {
+		t.Fatalf("Heal: %v", err)
+	}
+	if healed != testParity {
+		t.Fatalf("Heal rebuilt %d shards, want %d", healed, testParity)
+	}
+	for _, dir := range dirs[:testParity] {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(key))); err != nil {
+			t.Fatalf("shard in %s was not rebuilt: %v", dir, err)
+		}
+	}
+	if healed, err := s.Heal(context.Background(), key); err != nil || healed != 0 {
+		t.Fatalf("Heal of a healthy blob = %d, %v; want 0, nil", healed, err)
+	}
+
+	// The rebuilt shards must be correct: lose the other parity-count disks and read again
+	for _, dir := range dirs[len(dirs)-testParity:] {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err = readAll(s, key)
+	waitForHeal(t, s)
+	if err != nil {
+		t.Fatalf("read from rebuilt shards: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatal("rebuilt shards returned different bytes")
+	}
+}
+
+func TestErasureStoreRejectsTooFewShards(t *testing.T) {
+	s, dirs := newTestErasureStore(t)
+	const key = "ab/cd/blob"
+	putRandom(t, s, key)
+
+	for _, dir := range dirs[:testParity+1] {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := readAll(s, key); err == nil {
+		t.Fatalf("read with %d of %d shards returned %d bytes and no error", testData-1, testData+testParity, len(got))
+	}
+	if _, err := s.Heal(context.Background(), key); err == nil {
+		t.Fatal("Heal with too few shards succeeded")
+	}
+}
+
+func TestErasureStoreRejectsTooManyCorruptBlocks(t *testing.T) {
+	s, dirs := newTestErasureStore(t)
+	const key = "ab/cd/blob"
+	putRandom(t, s, key)
+
+	// Flip a byte in the first block of parity+1 shards; the headers stay intact
+	for _, dir := range dirs[:testParity+1] {
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, shardHeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		b[0] ^= 0xff
+		if _, err := f.WriteAt(b, shardHeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	got, err := readAll(s, key)
+	waitForHeal(t, s)
+	if err == nil {
+		t.Fatalf("read with %d corrupt shards returned %d bytes and no error", testParity+1, len(got))
+	}
+}
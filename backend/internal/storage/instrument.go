@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"file-vault/backend/internal/metrics"
+	"file-vault/backend/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Instrument wraps store so the latency and outcome of its calls are exported as metrics
+// labelled name, and calls made while handling a request are traced. The wrapper keeps the Lister and Healer capabilities of store, so garbage
+// collection and healing still work through it.
+func Instrument(name string, store BlobStore) BlobStore {
+	measured := &measuredStore{name: name, store: store}
+	lister, canList := store.(Lister)
+	healer, canHeal := store.(Healer)
+	switch {
+	case canList && canHeal:
+		return struct {
+			*measuredStore
+			Lister
+			Healer
+		}{measured, lister, healer}
+	case canList:
+		return struct {
+			*measuredStore
+			Lister
+		}{measured, lister}
+	case canHeal:
+		return struct {
+			*measuredStore
+			Healer
+		}{measured, healer}
+	default:
+		return measured
+	}
+}
+
+type measuredStore struct {
+	name  string
+	store BlobStore
+}
+
+// start begins measuring op on key. The returned function records the outcome.
+func (s *measuredStore) start(ctx context.Context, op, key string) (context.Context, func(error)) {
+	started := time.Now()
+	ctx, span := tracing.Start(ctx, "blobstore "+op,
+		attribute.String("blob.store", s.name),
+		attribute.String("blob.key", key),
+	)
+	return ctx, func(err error) {
+		metrics.BlobStoreDuration.WithLabelValues(s.name, op, metrics.Outcome(err)).Observe(time.Since(started).Seconds())
+		tracing.End(span, err)
+	}
+}
+
+func (s *measuredStore) Put(ctx context.Context, key string, r io.Reader) error {
+	ctx, done := s.start(ctx, "put", key)
+	err := s.store.Put(ctx, key, r)
+	done(err)
+	return err
+}
+
+func (s *measuredStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, done := s.start(ctx, "get", key)
+	r, err := s.store.Get(ctx, key)
+	// A missing blob is an answer, not a failing store
+	if errors.Is(err, ErrNotFound) {
+		done(nil)
+	} else {
+		done(err)
+	}
+	return r, err
+}
+
+func (s *measuredStore) Delete(ctx context.Context, key string) error {
+	ctx, done := s.start(ctx, "delete", key)
+	err := s.store.Delete(ctx, key)
+	done(err)
+	return err
+}
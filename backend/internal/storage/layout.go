@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"file-vault/backend/internal/models"
+)
+
+// contentKeySuffixLen is the number of random bytes appended to a content-addressed key.
+const contentKeySuffixLen = 8
+
+// ContentKey returns a new blob key for content with the given hex SHA-256, sharded two
+// levels deep by its leading digits: "ab/cd/abcd…<hash>.<suffix>". The hash in the key lets the
+// blob store alone identify and verify every blob. The random suffix keeps keys unique, since
+// the same content may be stored once per dedup scope and re-encryption writes a new copy
+// before the old one is removed.
+func ContentKey(hash string) string {
+	suffix := make([]byte, contentKeySuffixLen)
+	rand.Read(suffix) // crypto/rand.Read never returns an error
+	return hash[0:2] + "/" + hash[2:4] + "/" + hash + "." + hex.EncodeToString(suffix)
+}
+
+// ParseContentKey returns the content hash encoded in a key made by ContentKey. ok is false
+// for the random keys blobs were stored under before the content-addressed layout.
+func ParseContentKey(key string) (hash string, ok bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return "", false
+	}
+	hash, _, found := strings.Cut(parts[2], ".")
+	if !found || len(hash) != sha256.Size*2 || strings.ToLower(hash) != hash {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	if parts[0] != hash[0:2] || parts[1] != hash[2:4] {
+		return "", false
+	}
+	return hash, true
+}
+
+// Relocate copies fc's blob byte for byte to a content-addressed key and sets fc.StoragePath
+// to it. The old blob is left in place for the caller to remove once the row is updated.
+func (s *ContentStore) Relocate(ctx context.Context, fc *models.FileContent) error {
+	src, err := s.StoreOf(fc).Get(ctx, fc.StoragePath)
+	if err != nil {
+		return fmt.Errorf("read blob %s: %w", fc.StoragePath, err)
+	}
+	defer src.Close()
+
+	newPath := ContentKey(fc.HashSHA256)
+	if err := s.StoreOf(fc).Put(ctx, newPath, src); err != nil {
+		return fmt.Errorf("write blob %s: %w", newPath, err)
+	}
+	fc.StoragePath = newPath
+	return nil
+}
+
+// Verify reads fc's blob back through decryption and decompression and checks that it still
+// hashes to the recorded content hash and size, and to the hash in its key when it has one.
+func (s *ContentStore) Verify(ctx context.Context, fc *models.FileContent) error {
+	if keyHash, ok := ParseContentKey(fc.StoragePath); ok && keyHash != fc.HashSHA256 {
+		return fmt.Errorf("blob key %s does not match content hash %s", fc.StoragePath, fc.HashSHA256)
+	}
+
+	r, err := s.Open(ctx, fc)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return fmt.Errorf("read blob %s: %w", fc.StoragePath, err)
+	}
+	if n != fc.Size {
+		return fmt.Errorf("blob %s holds %d bytes, expected %d", fc.StoragePath, n, fc.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != fc.HashSHA256 {
+		return fmt.Errorf("blob %s hashes to %s, expected %s", fc.StoragePath, sum, fc.HashSHA256)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"file-vault/backend/internal/keys"
+	"file-vault/backend/internal/models"
+)
+
+func TestContentKey(t *testing.T) {
+	hash := "abcd" + strings.Repeat("0", sha256.Size*2-4)
+	key := ContentKey(hash)
+	if !strings.HasPrefix(key, "ab/cd/"+hash+".") {
+		t.Fatalf("ContentKey = %q", key)
+	}
+	if other := ContentKey(hash); other == key {
+		t.Fatal("two keys for the same content collide")
+	}
+	if got, ok := ParseContentKey(key); !ok || got != hash {
+		t.Fatalf("ParseContentKey(%q) = %q, %v", key, got, ok)
+	}
+
+	for _, key := range []string{
+		"3f2a9c1e-legacy-random-key",
+		"ab/cd/" + hash,                            // no suffix
+		"ab/ce/" + hash + ".0011",                  // shard does not match the hash
+		"ab/cd/" + strings.ToUpper(hash) + ".0011", // not canonical hex
+		"ab/cd/" + hash[:62] + ".0011",             // short hash
+		"ab/cd/" + hash[:62] + "zz.0011",           // not hex
+		"x/ab/cd/" + hash + ".0011",                // too deep
+	} {
+		if _, ok := ParseContentKey(key); ok {
+			t.Errorf("ParseContentKey(%q) accepted", key)
+		}
+	}
+}
+
+func TestRelocateAndVerify(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := keys.FromKey("v1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewContentStore(blobs, provider)
+
+	logical := []byte(strings.Repeat("sharded content-addressed blob\n", 1000))
+	sum := sha256.Sum256(logical)
+	fc := &models.FileContent{ContentID: "c1", HashSHA256: hex.EncodeToString(sum[:]), Size: int64(len(logical)), StoragePath: "legacy-key"}
+	if err := store.Save(ctx, fc, bytes.NewReader(logical)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Relocate(ctx, fc); err != nil {
+		t.Fatal(err)
+	}
+	if hash, ok := ParseContentKey(fc.StoragePath); !ok || hash != fc.HashSHA256 {
+		t.Fatalf("relocated to %q", fc.StoragePath)
+	}
+	// The copy keeps its encryption and codec, so it verifies under the same row
+	if err := store.Verify(ctx, fc); err != nil {
+		t.Fatalf("Verify after Relocate: %v", err)
+	}
+	if _, err := blobs.Get(ctx, "legacy-key"); err != nil {
+		t.Fatalf("old blob removed by Relocate: %v", err)
+	}
+
+	wrongSize := *fc
+	wrongSize.Size++
+	if err := store.Verify(ctx, &wrongSize); err == nil {
+		t.Fatal("Verify accepted a size mismatch")
+	}
+	wrongHash := *fc
+	wrongHash.HashSHA256 = strings.Repeat("0", 64)
+	if err := store.Verify(ctx, &wrongHash); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Verify of a key naming other content = %v", err)
+	}
+	legacy := wrongHash
+	legacy.StoragePath = "legacy-key"
+	if err := store.Verify(ctx, &legacy); err == nil || !strings.Contains(err.Error(), "hashes to") {
+		t.Fatalf("Verify of content with another hash = %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LocalStore is a BlobStore keeping each blob as a file under a root directory.
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a LocalStore rooted at dir, creating the directory if needed.
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: dir}, nil
+}
+
+// path maps a key to its file, rejecting keys that would escape the root.
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes r to a temporary file and renames it into place, so readers never see a partial blob.
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the file stored under key.
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the file stored under key. Deleting a missing blob is not an error.
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the root directory, skipping temporary files of writes in progress.
+func (s *LocalStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	return filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: filepath.ToSlash(rel), Size: info.Size(), CreatedAt: info.ModTime()})
+	})
+}
+
+// OpenURL opens the blob store described by rawURL. Supported schemes:
+//
+//	file:///path/to/dir                         a LocalStore rooted at the directory
+//	erasure:///d1,/d2,/d3?data=2&parity=1       an ErasureStore over the directories; parity
+//	                                            defaults to 1 and data to the remaining directories
+func OpenURL(rawURL string) (BlobStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse blob store URL %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("blob store URL %q has no path", rawURL)
+		}
+		return NewLocalStore(u.Path)
+	case "erasure":
+		return openErasureURL(u)
+	}
+	return nil, fmt.Errorf("unsupported blob store URL scheme %q", u.Scheme)
+}
+
+func openErasureURL(u *url.URL) (BlobStore, error) {
+	var dirs []string
+	for _, dir := range strings.Split(u.Path, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("erasure blob store URL %q lists no directories", u.Redacted())
+	}
+	query := u.Query()
+	parity, err := urlInt(query, "parity", 1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := urlInt(query, "data", len(dirs)-parity)
+	if err != nil {
+		return nil, err
+	}
+	return NewErasureStore(dirs, data, parity)
+}
+
+func urlInt(query url.Values, name string, fallback int) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("blob store URL parameter %s=%q is not a number", name, raw)
+	}
+	return n, nil
+}
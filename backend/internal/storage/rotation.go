@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"file-vault/backend/internal/keys"
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/stream"
+)
+
+// CurrentKeyID returns the master key version new data keys are wrapped with,
+// or "" when encryption is disabled.
+func (s *ContentStore) CurrentKeyID() string {
+	if s.keyProvider == nil {
+		return ""
+	}
+	return s.keyProvider.CurrentKeyID()
+}
+
+// Rewrap re-wraps fc's data key under the current master key. The blob itself is untouched.
+// It reports false when fc is unencrypted or already wrapped under the current key.
+func (s *ContentStore) Rewrap(ctx context.Context, fc *models.FileContent) (bool, error) {
+	if fc.Encryption == "" || fc.KeyID == s.CurrentKeyID() {
+		return false, nil
+	}
+	dataKey, err := s.dataKey(ctx, fc)
+	if err != nil {
+		return false, err
+	}
+	keyID, wrapped, err := s.keyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return false, fmt.Errorf("wrap data key: %w", err)
+	}
+	fc.KeyID = keyID
+	fc.WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+	return true, nil
+}
+
+// Reencrypt copies fc's blob to newPath under a fresh data key wrapped by the current master key,
+// then updates fc to describe the copy. Legacy plaintext blobs are encrypted on the way.
+// The old blob is left in place for the caller to remove once the metadata points at the copy.
+func (s *ContentStore) Reencrypt(ctx context.Context, fc *models.FileContent, newPath string) error {
+	if s.keyProvider == nil {
+		return fmt.Errorf("no key provider is configured")
+	}
+
+	r, err := s.StoreOf(fc).Get(ctx, fc.StoragePath)
+	if err != nil {
+		return err
+	}
+	if fc.Encryption != "" {
+		dataKey, err := s.dataKey(ctx, fc)
+		if err != nil {
+			r.Close()
+			return err
+		}
+		decrypted, err := stream.NewReader(r, dataKey)
+		if err != nil {
+			r.Close()
+			return err
+		}
+		r = decrypted
+	}
+	defer r.Close()
+
+	dataKey, keyID, wrapped, err := keys.GenerateDataKey(ctx, s.keyProvider)
+	if err != nil {
+		return fmt.Errorf("generate data key: %w", err)
+	}
+	// The decrypted stream is still compressed with fc.Codec; count it to size the new blob
+	counted := &countingReader{r: r}
+	encrypted := encryptReader(counted, dataKey)
+	defer encrypted.Close()
+	if err := s.StoreOf(fc).Put(ctx, newPath, encrypted); err != nil {
+		return err
+	}
+
+	fc.StoragePath = newPath
+	fc.StoredSize = stream.EncryptedSize(counted.n)
+	fc.Encryption = EncryptionAES256GCMChunked
+	fc.KeyID = keyID
+	fc.WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
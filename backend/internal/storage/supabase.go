@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	storage_go "github.com/supabase-community/storage-go"
+)
+
+// uploadsPrefix is the folder inside the bucket that holds every content blob.
+const uploadsPrefix = "uploads/"
+
+// listPageSize is the number of entries requested per Storage list call.
+const listPageSize = 1000
+
+// SupabaseStore is a BlobStore backed by a Supabase Storage bucket.
+type SupabaseStore struct {
+	client *storage_go.Client
+	bucket string
+}
+
+// NewSupabaseStore creates a SupabaseStore for the given bucket.
+func NewSupabaseStore(client *storage_go.Client, bucket string) *SupabaseStore {
+	return &SupabaseStore{client: client, bucket: bucket}
+}
+
+// Put uploads r to the bucket under the uploads folder.
+func (s *SupabaseStore) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.client.UploadFile(s.bucket, uploadsPrefix+key, r)
+	return err
+}
+
+// Get downloads the blob stored under key.
+func (s *SupabaseStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, err := s.client.DownloadFile(s.bucket, uploadsPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Delete removes the blob stored under key.
+func (s *SupabaseStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.RemoveFile(s.bucket, []string{uploadsPrefix + key})
+	return err
+}
+
+// List walks the uploads folder, descending into the shard folders of content-addressed keys.
+func (s *SupabaseStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	return s.listFolder(ctx, "", fn)
+}
+
+func (s *SupabaseStore) listFolder(ctx context.Context, folder string, fn func(BlobInfo) error) error {
+	for offset := 0; ; offset += listPageSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := s.client.ListFiles(s.bucket, strings.TrimSuffix(uploadsPrefix+folder, "/"), storage_go.FileSearchOptions{
+			Limit:  listPageSize,
+			Offset: offset,
+		})
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key := folder + entry.Name
+			if entry.Id == "" {
+				// Folders have no object ID
+				if err := s.listFolder(ctx, key+"/", fn); err != nil {
+					return err
+				}
+				continue
+			}
+			info := BlobInfo{Key: key}
+			info.CreatedAt, _ = time.Parse(time.RFC3339, entry.CreatedAt)
+			if metadata, ok := entry.Metadata.(map[string]interface{}); ok {
+				if size, ok := metadata["size"].(float64); ok {
+					info.Size = int64(size)
+				}
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if len(entries) < listPageSize {
+			return nil
+		}
+	}
+}
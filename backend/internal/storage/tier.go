@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"file-vault/backend/internal/models"
+)
+
+// Storage classes recorded in file_contents.storage_class.
+const (
+	ClassHot  = "hot"
+	ClassCold = "cold"
+)
+
+// Tiering configures the cold storage class.
+type Tiering struct {
+	// Cold holds content that has not been read for ColdAfter.
+	Cold      BlobStore
+	ColdAfter time.Duration
+	// Rehydrate moves cold content back to the hot class when it is downloaded.
+	Rehydrate bool
+}
+
+// OpenTiering opens the cold store at coldURL (a URL for OpenURL) and moves content to it after
+// coldAfterDays without a read. It returns nil when coldURL is empty, leaving all content in the hot class.
+func OpenTiering(coldURL string, coldAfterDays int, rehydrate bool) (*Tiering, error) {
+	if coldURL == "" {
+		return nil, nil
+	}
+	if coldAfterDays <= 0 {
+		return nil, fmt.Errorf("content must stay hot for a positive number of days, got %d", coldAfterDays)
+	}
+	cold, err := OpenURL(coldURL)
+	if err != nil {
+		return nil, fmt.Errorf("cold store: %w", err)
+	}
+
+	return &Tiering{
+		Cold:      cold,
+		ColdAfter: time.Duration(coldAfterDays) * 24 * time.Hour,
+		Rehydrate: rehydrate,
+	}, nil
+}
+
+// SetColdStore makes content of the cold storage class live in cold.
+func (s *ContentStore) SetColdStore(cold BlobStore) {
+	s.cold = cold
+}
+
+// StoreFor returns the blob store new content of the given storage class is written to:
+// the cold store, or for the hot class the active backend.
+func (s *ContentStore) StoreFor(class string) BlobStore {
+	if class == ClassCold && s.cold != nil {
+		return s.cold
+	}
+	return s.StoreOf(&models.FileContent{StorageBackend: s.active})
+}
+
+// CopyToClass copies fc's blob byte for byte into the store of class, under the same key.
+// The caller switches the row over (to the active backend when moving to the hot class)
+// and removes the original.
+func (s *ContentStore) CopyToClass(ctx context.Context, fc *models.FileContent, class string) error {
+	if class == ClassCold && s.cold == nil {
+		return fmt.Errorf("no cold store is configured")
+	}
+	src, err := s.StoreOf(fc).Get(ctx, fc.StoragePath)
+	if err != nil {
+		return fmt.Errorf("read blob %s: %w", fc.StoragePath, err)
+	}
+	defer src.Close()
+	return s.StoreFor(class).Put(ctx, fc.StoragePath, src)
+}
@@ -0,0 +1,243 @@
+// Package stream implements chunked AES-256-GCM encryption of byte streams, so blobs of any
+// size can be encrypted and decrypted without holding them in memory.
+package stream
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream layout: a 16-byte header followed by sealed chunks.
+//
+//	header: magic "SFVE" | version (1 byte) | chunk size (uint32) | nonce prefix (7 bytes)
+//	chunk:  AES-256-GCM(plaintext chunk), nonce = prefix | chunk index (uint32) | final flag (1 byte)
+//
+// Every chunk but the last holds exactly chunkSize plaintext bytes. The final flag in the
+// nonce makes truncation at a chunk boundary detectable, and the header is bound as
+// additional data so it cannot be altered.
+const (
+	encMagic        = "SFVE"
+	encVersion      = 1
+	encHeaderSize   = 16
+	encPrefixSize   = 7
+	encChunkSize    = 64 * 1024
+	encTagSize      = 16
+	encMaxChunkSize = 16 * 1024 * 1024
+)
+
+// NoncePrefixSize is the length of the per-stream nonce prefix stored in the header.
+const NoncePrefixSize = encPrefixSize
+
+// EncryptedSize returns the size of the encrypted stream for plainSize bytes of plaintext.
+func EncryptedSize(plainSize int64) int64 {
+	chunks := (plainSize + encChunkSize - 1) / encChunkSize
+	if chunks == 0 {
+		chunks = 1 // an empty stream still carries one sealed (empty) final chunk
+	}
+	return encHeaderSize + plainSize + chunks*encTagSize
+}
+
+// encryptWriter seals everything written to it and writes the ciphertext to dst.
+type encryptWriter struct {
+	dst    io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint32
+	err    error
+}
+
+// NewWriter writes the stream header to dst and returns a writer that seals with key.
+// Close must be called to flush the final chunk.
+func NewWriter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	prefix := make([]byte, NoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return NewWriterWithPrefix(dst, key, prefix)
+}
+
+// NewWriterWithPrefix is NewWriter with a caller-chosen nonce prefix. The output is then a
+// deterministic function of key and plaintext, which convergent encryption relies on. The
+// prefix must never be reused with the same key for different plaintext.
+func NewWriterWithPrefix(dst io.Writer, key, prefix []byte) (io.WriteCloser, error) {
+	if len(prefix) != NoncePrefixSize {
+		return nil, fmt.Errorf("nonce prefix must be %d bytes", NoncePrefixSize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	header[4] = encVersion
+	binary.BigEndian.PutUint32(header[5:9], encChunkSize)
+	copy(header[9:], prefix)
+	if _, err := dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		dst:    dst,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the last chunk is always known
+		if len(w.buf) == encChunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return written, w.err
+			}
+		}
+		n := copy(w.buf[len(w.buf):encChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the buffered bytes as the final chunk.
+func (w *encryptWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	if w.err == nil {
+		w.err = errors.New("encrypt writer is closed")
+		return nil
+	}
+	return w.err
+}
+
+func (w *encryptWriter) seal(final bool) error {
+	nonce := chunkNonce(w.header, w.index, final)
+	sealed := w.aead.Seal(nil, nonce, w.buf, w.header)
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.dst.Write(sealed)
+	return err
+}
+
+// decryptReader yields the plaintext of a stream written by encryptWriter.
+type decryptReader struct {
+	src       io.ReadCloser
+	br        *bufio.Reader
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	chunk     []byte
+	plain     []byte
+	index     uint32
+	done      bool
+}
+
+// NewReader reads and validates the stream header from src and returns a reader over the plaintext.
+// Closing the reader closes src.
+func NewReader(src io.ReadCloser, key []byte) (io.ReadCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("read encrypted stream header: %w", err)
+	}
+	if string(header[:4]) != encMagic || header[4] != encVersion {
+		return nil, errors.New("stream is not in a supported encrypted format")
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[5:9]))
+	if chunkSize <= 0 || chunkSize > encMaxChunkSize {
+		return nil, fmt.Errorf("encrypted stream has invalid chunk size %d", chunkSize)
+	}
+
+	return &decryptReader{
+		src:       src,
+		br:        bufio.NewReaderSize(src, chunkSize+encTagSize+1),
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		chunk:     make([]byte, chunkSize+encTagSize),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and opens the following chunk.
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.br, r.chunk)
+	final := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		final = true
+	case err == io.EOF:
+		return errors.New("encrypted stream is truncated")
+	case err != nil:
+		return err
+	default:
+		// A full-size chunk is the last one only if nothing follows it
+		if _, peekErr := r.br.Peek(1); peekErr == io.EOF {
+			final = true
+		}
+	}
+
+	nonce := chunkNonce(r.header, r.index, final)
+	plain, openErr := r.aead.Open(r.chunk[:0], nonce, r.chunk[:n], r.header)
+	if openErr != nil {
+		return fmt.Errorf("decrypt chunk %d: %w", r.index, openErr)
+	}
+	r.index++
+	r.plain = plain
+	r.done = final
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(header []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[9:9+encPrefixSize])
+	binary.BigEndian.PutUint32(nonce[encPrefixSize:], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,154 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(&out, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd-sized writes, so chunk boundaries fall inside them
+	for rest := plain; len(rest) > 0; {
+		n := min(len(rest), 1000)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	r, err := NewReader(io.NopCloser(bytes.NewReader(sealed)), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(t)
+	sizes := map[string]int{
+		"empty":                0,
+		"one byte":             1,
+		"just under a chunk":   encChunkSize - 1,
+		"exactly one chunk":    encChunkSize,
+		"just over a chunk":    encChunkSize + 1,
+		"exactly three chunks": 3 * encChunkSize,
+		"several chunks":       3*encChunkSize + 5,
+	}
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			plain := make([]byte, size)
+			rand.Read(plain)
+			sealed := encrypt(t, key, plain)
+			if int64(len(sealed)) != EncryptedSize(int64(size)) {
+				t.Fatalf("sealed %d bytes, EncryptedSize says %d", len(sealed), EncryptedSize(int64(size)))
+			}
+			got, err := decrypt(key, sealed)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("round trip changed the data")
+			}
+		})
+	}
+}
+
+func TestTamperingIsDetected(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 3*encChunkSize)
+	rand.Read(plain)
+	sealed := encrypt(t, key, plain)
+	sealedChunk := encChunkSize + encTagSize
+	chunk := func(i int) []byte {
+		start := encHeaderSize + i*sealedChunk
+		return sealed[start : start+sealedChunk]
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	flip := func(at int) []byte {
+		changed := bytes.Clone(sealed)
+		changed[at] ^= 1
+		return changed
+	}
+	header := sealed[:encHeaderSize]
+
+	cases := []struct {
+		name   string
+		key    []byte
+		sealed []byte
+	}{
+		{"truncated at a chunk boundary", key, join(header, chunk(0), chunk(1))},
+		{"only the first chunk", key, join(header, chunk(0))},
+		{"no chunks", key, header},
+		{"truncated header", key, sealed[:encHeaderSize-1]},
+		{"truncated inside a chunk", key, sealed[:len(sealed)-1]},
+		{"flipped ciphertext byte", key, flip(encHeaderSize + sealedChunk + 10)},
+		{"flipped tag byte", key, flip(len(sealed) - 1)},
+		{"flipped nonce prefix", key, flip(10)},
+		{"swapped chunks", key, join(header, chunk(1), chunk(0), chunk(2))},
+		{"repeated chunk", key, join(header, chunk(0), chunk(0), chunk(2))},
+		{"appended chunk", key, join(sealed, chunk(2))},
+		{"wrong key", testKey(t), sealed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, err := decrypt(tc.key, tc.sealed); err == nil {
+				t.Fatalf("decrypted %d bytes of a tampered stream", len(got))
+			}
+		})
+	}
+}
+
+func TestEmptyStreamTruncatedIsDetected(t *testing.T) {
+	key := testKey(t)
+	sealed := encrypt(t, key, nil)
+	if _, err := decrypt(key, sealed[:encHeaderSize]); err == nil {
+		t.Fatal("a stream without its final chunk decrypted as empty")
+	}
+}
+
+func TestWithPrefixIsDeterministic(t *testing.T) {
+	key := testKey(t)
+	prefix := bytes.Repeat([]byte{7}, NoncePrefixSize)
+	seal := func(plain []byte) []byte {
+		var out bytes.Buffer
+		w, err := NewWriterWithPrefix(&out, key, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	plain := []byte("the same content under the same key")
+	if !bytes.Equal(seal(plain), seal(plain)) {
+		t.Fatal("equal plaintext sealed differently with a fixed prefix")
+	}
+	if _, err := NewWriterWithPrefix(io.Discard, key, prefix[1:]); err == nil {
+		t.Fatal("a short nonce prefix was accepted")
+	}
+}
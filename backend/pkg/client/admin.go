@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"file-vault/backend/internal/jobs"
+	"file-vault/backend/internal/models"
+)
+
+// The admin methods require the caller to be an admin.
+
+// UserConfig changes a user's limits. Nil fields are left as they are.
+type UserConfig struct {
+	RateLimit    *int   `json:"rate_limit,omitempty"`    // Requests per second
+	StorageQuota *int64 `json:"storage_quota,omitempty"` // Bytes
+}
+
+// TierUsage is the content held in one storage class.
+type TierUsage struct {
+	Blobs         int   `json:"blobs"`
+	LogicalBytes  int64 `json:"logical_bytes"`
+	PhysicalBytes int64 `json:"physical_bytes"`
+}
+
+// AdminStats is the storage used by all users.
+type AdminStats struct {
+	TotalBlobs              int                  `json:"total_blobs"`
+	CompressedBlobs         int                  `json:"compressed_blobs"`
+	ReferencedBytes         int64                `json:"referenced_bytes"`
+	LogicalBytes            int64                `json:"logical_bytes"`
+	PhysicalBytes           int64                `json:"physical_bytes"`
+	CompressionSavingsBytes int64                `json:"compression_savings_bytes"`
+	CompressionRatio        string               `json:"compression_ratio"` // e.g. "1.85"
+	Tiers                   map[string]TierUsage `json:"tiers"`             // By storage class, "hot" or "cold"
+}
+
+// KeyVersion is one master key version and the blobs it still wraps.
+type KeyVersion struct {
+	KeyID     string `json:"key_id"`
+	Current   bool   `json:"current"`
+	Blobs     int64  `json:"blobs"`
+	Retirable bool   `json:"retirable"`
+}
+
+// MasterKeys is the state of the server's master keys.
+type MasterKeys struct {
+	CurrentKeyID     string       `json:"current_key_id"`
+	Versions         []KeyVersion `json:"versions"`
+	UnencryptedBlobs int64        `json:"unencrypted_blobs"`
+}
+
+// ReplicaUsage is how many blobs one replica holds up to date and how many failed to copy.
+type ReplicaUsage struct {
+	Name   string `json:"name"`
+	OK     int64  `json:"ok"`
+	Failed int64  `json:"failed"`
+}
+
+// Replicas is the state of the blob replicas. It is empty when replication is off.
+type Replicas struct {
+	TotalBlobs int64          `json:"total_blobs"`
+	Replicas   []ReplicaUsage `json:"replicas"`
+}
+
+// Backend is a storage backend and the hot content it holds. The default store has no name.
+type Backend struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	Blobs   int64  `json:"blobs"`
+}
+
+// Backends is the configured storage backends and the one new uploads go to.
+type Backends struct {
+	Active   string    `json:"active"`
+	Backends []Backend `json:"backends"`
+}
+
+// AdminFiles lists every file of every user.
+func (c *Client) AdminFiles(ctx context.Context) ([]models.FileSearchResult, error) {
+	var files []models.FileSearchResult
+	if err := c.admin(ctx, http.MethodGet, "files", nil, nil, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// UpdateUserConfig changes the limits of userID.
+func (c *Client) UpdateUserConfig(ctx context.Context, userID string, config UserConfig) error {
+	body := struct {
+		UserID string `json:"user_id"`
+		UserConfig
+	}{userID, config}
+	return c.admin(ctx, http.MethodPost, "config", nil, body, nil)
+}
+
+// AdminStats returns the storage used by all users.
+func (c *Client) AdminStats(ctx context.Context) (*AdminStats, error) {
+	var stats AdminStats
+	if err := c.admin(ctx, http.MethodGet, "stats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// MasterKeys returns the master key versions and how many blobs each wraps.
+func (c *Client) MasterKeys(ctx context.Context) (*MasterKeys, error) {
+	var keys MasterKeys
+	if err := c.admin(ctx, http.MethodGet, "keys", nil, nil, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// Replicas returns the state of the blob replicas.
+func (c *Client) Replicas(ctx context.Context) (*Replicas, error) {
+	var replicas Replicas
+	if err := c.admin(ctx, http.MethodGet, "replicas", nil, nil, &replicas); err != nil {
+		return nil, err
+	}
+	return &replicas, nil
+}
+
+// Backends returns the configured storage backends.
+func (c *Client) Backends(ctx context.Context) (*Backends, error) {
+	var backends Backends
+	if err := c.admin(ctx, http.MethodGet, "storage/backends", nil, nil, &backends); err != nil {
+		return nil, err
+	}
+	return &backends, nil
+}
+
+// Diagnostics returns the build, runtime, redacted configuration, dependency health and
+// jobs of the server that handles the request.
+func (c *Client) Diagnostics(ctx context.Context) (map[string]interface{}, error) {
+	var diagnostics map[string]interface{}
+	if err := c.admin(ctx, http.MethodGet, "diagnostics", nil, nil, &diagnostics); err != nil {
+		return nil, err
+	}
+	return diagnostics, nil
+}
+
+// StartKeyRotation starts re-wrapping every data key under the current master key, and
+// re-encrypting blobs with fresh data keys if reencrypt is set.
+func (c *Client) StartKeyRotation(ctx context.Context, reencrypt bool) (*models.Job, error) {
+	return c.startJob(ctx, "keys/rotate", map[string]bool{"reencrypt": reencrypt})
+}
+
+// StartBlobMigration starts moving blobs to content-addressed keys.
+func (c *Client) StartBlobMigration(ctx context.Context) (*models.Job, error) {
+	return c.startJob(ctx, "blobs/migrate", nil)
+}
+
+// StartScrub starts verifying every blob against its content hash.
+func (c *Client) StartScrub(ctx context.Context) (*models.Job, error) {
+	return c.startJob(ctx, "blobs/scrub", nil)
+}
+
+// StartHeal starts rebuilding missing or corrupt shards of erasure-coded blobs.
+func (c *Client) StartHeal(ctx context.Context) (*models.Job, error) {
+	return c.startJob(ctx, "blobs/heal", nil)
+}
+
+// StartTiering starts moving content nobody has read recently to cold storage.
+func (c *Client) StartTiering(ctx context.Context) (*models.Job, error) {
+	return c.startJob(ctx, "tiering/run", nil)
+}
+
+// StartReplicaRepair starts copying blobs to the replicas missing them.
+func (c *Client) StartReplicaRepair(ctx context.Context) (*models.Job, error) {
+	return c.startJob(ctx, "replicas/repair", nil)
+}
+
+// StartStorageMigration starts copying hot content to the target backend, at most
+// bytesPerSecond if that is positive.
+func (c *Client) StartStorageMigration(ctx context.Context, target string, bytesPerSecond int64) (*models.Job, error) {
+	return c.startJob(ctx, "storage/migrate", storageMigration{target, bytesPerSecond})
+}
+
+// StartStorageCleanup starts removing content already migrated to target from the other
+// backends. It is refused with CONFLICT while any hot content lives elsewhere.
+func (c *Client) StartStorageCleanup(ctx context.Context, target string, bytesPerSecond int64) (*models.Job, error) {
+	return c.startJob(ctx, "storage/cleanup", storageMigration{target, bytesPerSecond})
+}
+
+type storageMigration struct {
+	Target         string `json:"target"`
+	BytesPerSecond int64  `json:"bytes_per_second,omitempty"`
+}
+
+// Jobs lists recent background jobs, newest first. kind may be empty for every kind, and
+// limit 0 for the server's default.
+func (c *Client) Jobs(ctx context.Context, kind string, limit int) ([]models.Job, error) {
+	query := url.Values{}
+	if kind != "" {
+		query.Set("kind", kind)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var list []models.Job
+	if err := c.admin(ctx, http.MethodGet, "jobs", query, nil, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Job returns a background job's status and progress.
+func (c *Client) Job(ctx context.Context, jobID string) (*models.Job, error) {
+	var job models.Job
+	if err := c.admin(ctx, http.MethodGet, "jobs/"+url.PathEscape(jobID), nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelJob stops a running job at its next checkpoint.
+func (c *Client) CancelJob(ctx context.Context, jobID string) error {
+	return c.admin(ctx, http.MethodPost, "jobs/"+url.PathEscape(jobID)+"/cancel", nil, nil, nil)
+}
+
+// WaitJob polls jobID every interval until it stops running, and returns it as it finished.
+func (c *Client) WaitJob(ctx context.Context, jobID string, interval time.Duration) (*models.Job, error) {
+	for {
+		job, err := c.Job(ctx, jobID)
+		if err != nil || job.Status != jobs.StatusRunning {
+			return job, err
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) startJob(ctx context.Context, path string, body interface{}) (*models.Job, error) {
+	var job models.Job
+	if err := c.admin(ctx, http.MethodPost, path, nil, body, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// admin calls the admin endpoint at path, which takes the caller's ID as a query parameter.
+func (c *Client) admin(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("user_id", c.UserID)
+	return c.doJSON(ctx, method, apiPrefix+"/admin/"+path, query, in, out)
+}
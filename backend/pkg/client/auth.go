@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Registration is a new user account.
+type Registration struct {
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	FirstName   string `json:"first_name,omitempty"`
+	LastName    string `json:"last_name,omitempty"`
+	DateOfBirth string `json:"date_of_birth,omitempty"` // YYYY-MM-DD
+	PhoneNumber string `json:"phone_number,omitempty"`
+}
+
+// Session describes the user a Login authenticated.
+type Session struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	IsAdmin   bool   `json:"is_admin"`
+}
+
+// Quota is the caller's rate limit and storage quota.
+type Quota struct {
+	RateLimit    int   `json:"rate_limit"`    // Requests per second, 0 for the server default
+	StorageQuota int64 `json:"storage_quota"` // Bytes
+}
+
+// Register creates an unverified account and has the vault email a one-time password for
+// VerifyOTP. Registering an email that is still unverified sends a new one.
+func (c *Client) Register(ctx context.Context, r Registration) error {
+	return c.doJSON(ctx, http.MethodPost, apiPath("register"), nil, r, nil)
+}
+
+// VerifyOTP verifies email with the one-time password sent to it.
+func (c *Client) VerifyOTP(ctx context.Context, email, otp string) error {
+	return c.doJSON(ctx, http.MethodPost, apiPath("verify-otp"), nil, map[string]string{"email": email, "otp": otp}, nil)
+}
+
+// ResendOTP sends a new one-time password to an unverified email.
+func (c *Client) ResendOTP(ctx context.Context, email string) error {
+	return c.doJSON(ctx, http.MethodPost, apiPath("resend-otp"), nil, map[string]string{"email": email}, nil)
+}
+
+// Login checks a user's credentials. It does not change the client; set UserID to the
+// session's to make requests as the user.
+func (c *Client) Login(ctx context.Context, email, password string) (*Session, error) {
+	var session Session
+	body := map[string]string{"email": email, "password": password}
+	if err := c.doJSON(ctx, http.MethodPost, apiPath("login"), nil, body, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Quota returns the caller's rate limit and storage quota.
+func (c *Client) Quota(ctx context.Context) (*Quota, error) {
+	var quota Quota
+	if err := c.doJSON(ctx, http.MethodGet, apiPath("user", "quota"), nil, nil, &quota); err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// ChangePassword replaces the caller's password.
+func (c *Client) ChangePassword(ctx context.Context, current, replacement string) error {
+	body := map[string]string{"current_password": current, "new_password": replacement}
+	return c.doJSON(ctx, http.MethodPost, apiPath("user", "password"), nil, body, nil)
+}
@@ -0,0 +1,156 @@
+// Package client is a Go client for the vault API. It covers authentication, streamed uploads
+// and resumable downloads, paginated listing and search, sharing and the admin endpoints.
+//
+// Requests rejected by the rate limiter are retried with backoff, as are server errors and
+// network failures of requests that are safe to repeat. Errors the API reports are returned
+// as *Error, carrying the stable code of the problem document.
+//
+// A Client needs only the server's root URL, so it works as well against an httptest.Server
+// running api.SetupRoutes as against a deployed server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiPrefix is the path of the versioned API under the server root.
+const apiPrefix = "/api/v1"
+
+// Client calls the vault API as one user. Its fields must not change while requests are in
+// flight; use a Client per user.
+type Client struct {
+	// BaseURL is the server root, e.g. "http://localhost:8080".
+	BaseURL string
+	// UserID identifies the caller to the vault, as returned by Login.
+	UserID string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Retry controls retries of rate-limited and failed requests. The zero value retries with
+	// the defaults of RetryPolicy.
+	Retry RetryPolicy
+}
+
+// request is one API call. body, if set, is called for every attempt and must return the
+// same content each time; replayable is false for bodies that can only be read once, which
+// are never retried.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        func() (io.Reader, error)
+	contentType string
+	replayable  bool
+}
+
+// newRequest builds the HTTP request for one attempt of r.
+func (c *Client) newRequest(ctx context.Context, r request) (*http.Request, error) {
+	target := strings.TrimRight(c.BaseURL, "/") + r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+	var body io.Reader
+	if r.body != nil {
+		var err error
+		if body, err = r.body(); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if c.UserID != "" {
+		req.Header.Set("X-User-ID", c.UserID)
+	}
+	return req, nil
+}
+
+// do sends r, retrying as the policy allows, and returns the response to the first attempt
+// that succeeds. Error statuses are returned as *Error.
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	policy := c.Retry.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		req, err := c.newRequest(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+
+		var retryAfter string
+		if err == nil {
+			retryAfter = resp.Header.Get("Retry-After")
+			err = readError(resp)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= policy.MaxAttempts || !r.replayable || !retryable(r.method, err) {
+			return nil, err
+		}
+		if waitErr := sleep(ctx, policy.delay(attempt, retryAfter)); waitErr != nil {
+			return nil, waitErr
+		}
+	}
+}
+
+// doJSON sends in, if not nil, as the JSON body of a request and decodes the response into
+// out, if not nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	r := request{method: method, path: path, query: query, replayable: true}
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		r.body = func() (io.Reader, error) { return bytes.NewReader(raw), nil }
+		r.contentType = "application/json"
+	}
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// userQuery returns the query naming the caller in key, for endpoints that take the user ID
+// as a parameter rather than from the X-User-ID header.
+func (c *Client) userQuery(key string) url.Values {
+	return url.Values{key: {c.UserID}}
+}
+
+// apiPath returns the path of an API endpoint, escaping the IDs joined into it.
+func apiPath(segments ...string) string {
+	var b strings.Builder
+	b.WriteString(apiPrefix)
+	for _, segment := range segments {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(segment))
+	}
+	return b.String()
+}
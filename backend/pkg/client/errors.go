@@ -0,0 +1,79 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"file-vault/backend/internal/apierror"
+)
+
+// maxErrorBody caps how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// Error is an error the API reported: the problem document of a 4xx or 5xx response.
+type Error struct {
+	StatusCode int
+	// Code is the stable machine-readable code; branch on this rather than on Detail.
+	Code apierror.Code
+	// Detail is the human-readable explanation.
+	Detail string
+	// RequestID matches the server's log lines for the request.
+	RequestID string
+	// Extensions holds the problem's extra members, e.g. storage_quota on QUOTA_EXCEEDED.
+	Extensions map[string]interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("vault returned %d: %s", e.StatusCode, e.Detail)
+	}
+	return fmt.Sprintf("vault returned %d %s: %s", e.StatusCode, e.Code, e.Detail)
+}
+
+// IsCode reports whether err is an API error with code.
+func IsCode(err error, code apierror.Code) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// problemMembers are the standard members of a problem document, which are not extensions.
+var problemMembers = map[string]bool{
+	"type": true, "title": true, "status": true, "detail": true, "instance": true,
+	"code": true, "request_id": true, "error": true,
+}
+
+// readError reads and closes the body of an error response. Responses that are not problem
+// documents, such as a proxy's error page, get the status text as their detail.
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode, Detail: http.StatusText(resp.StatusCode)}
+
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&members); err != nil {
+		return apiErr
+	}
+	var detail string
+	if json.Unmarshal(members["detail"], &detail) == nil && detail != "" {
+		apiErr.Detail = detail
+	} else if json.Unmarshal(members["error"], &detail) == nil && detail != "" {
+		apiErr.Detail = detail
+	}
+	json.Unmarshal(members["code"], &apiErr.Code)
+	json.Unmarshal(members["request_id"], &apiErr.RequestID)
+	for key, raw := range members {
+		if problemMembers[key] {
+			continue
+		}
+		var value interface{}
+		if json.Unmarshal(raw, &value) == nil {
+			if apiErr.Extensions == nil {
+				apiErr.Extensions = make(map[string]interface{})
+			}
+			apiErr.Extensions[key] = value
+		}
+	}
+	return apiErr
+}
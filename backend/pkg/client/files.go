@@ -0,0 +1,417 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+	"file-vault/backend/internal/dedup"
+	"file-vault/backend/internal/models"
+)
+
+// sniffLen is how much of a file the vault inspects to detect its type.
+const sniffLen = 512
+
+// defaultPageSize is the page size SearchAll uses when the query sets none.
+const defaultPageSize = 100
+
+// Upload stores the contents of src as filename and returns the new file. The body is
+// streamed, so large files are never held in memory.
+//
+// When src is an io.ReadSeeker it is read from its current position. Its hash is computed
+// first and, if the vault already holds the same content for the caller, ownership is
+// proven by answering a challenge instead of sending the bytes. A seekable src is also
+// rewound to retry a rate-limited upload; other readers are sent once.
+func (c *Client) Upload(ctx context.Context, filename string, src io.Reader) (*models.UserFile, error) {
+	seeker, _ := src.(io.ReadSeeker)
+	var start int64
+	if seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		file, err := c.uploadByProof(ctx, filename, seeker, start)
+		if file != nil || err != nil {
+			return file, err
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	// The vault checks the declared type against the one it detects, so detect it the same way
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	attempt := 0
+	body := func() (io.Reader, error) {
+		content := io.MultiReader(bytes.NewReader(head), src)
+		if attempt++; attempt > 1 {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			content = seeker
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeUploadForm(pw, boundary, filename, contentType, content))
+		}()
+		return pr, nil
+	}
+
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        apiPath("upload"),
+		query:       c.userQuery("owner_id"),
+		body:        body,
+		contentType: "multipart/form-data; boundary=" + boundary,
+		replayable:  seeker != nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var file models.UserFile
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// writeUploadForm writes the multipart body of an upload to w.
+func writeUploadForm(w io.Writer, boundary, filename, contentType string, content io.Reader) error {
+	form := multipart.NewWriter(w)
+	if err := form.SetBoundary(boundary); err != nil {
+		return err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// uploadByProof creates filename from content the vault already holds, proving ownership of
+// src instead of sending it. It returns a nil file and error when the content must be uploaded.
+func (c *Client) uploadByProof(ctx context.Context, filename string, src io.ReadSeeker, start int64) (*models.UserFile, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var check struct {
+		Exists    bool             `json:"exists"`
+		Challenge *dedup.Challenge `json:"challenge"`
+	}
+	body := map[string]interface{}{"hash_sha256": hex.EncodeToString(hash.Sum(nil)), "size": size, "filename": filename}
+	if err := c.doJSON(ctx, http.MethodPost, apiPath("upload", "check"), c.userQuery("owner_id"), body, &check); err != nil {
+		return nil, err
+	}
+	if !check.Exists || check.Challenge == nil {
+		return nil, nil
+	}
+
+	if _, err := src.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	proofs, err := dedup.Prove(src, check.Challenge.Nonce, check.Challenge.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	var file models.UserFile
+	proof := map[string]interface{}{"challenge_id": check.Challenge.ID, "proofs": proofs}
+	err = c.doJSON(ctx, http.MethodPost, apiPath("upload", "prove"), c.userQuery("owner_id"), proof, &file)
+	if IsCode(err, apierror.CodeContentGone) || IsCode(err, apierror.CodeNotFound) {
+		// Deleted or expired since the check; fall back to uploading
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Download is an open download of a file's contents.
+type Download struct {
+	io.ReadCloser
+	Filename    string
+	ContentType string
+	// Size is the size of the whole file; Offset is where the body starts in it.
+	Size   int64
+	Offset int64
+	// ClientEncryption is the end-to-end encryption mode of the content, if any. Such
+	// content is ciphertext; pkg/e2e decrypts it.
+	ClientEncryption string
+}
+
+// Open starts downloading fileID from offset. The caller must close the download.
+func (c *Client) Open(ctx context.Context, fileID string, offset int64) (*Download, error) {
+	return c.open(ctx, apiPath("files", fileID), offset)
+}
+
+// Download writes the contents of fileID from offset to dst and returns the number of
+// bytes written. A download that breaks off is resumed where it stopped, as the retry policy
+// allows; to continue a partial copy from an earlier run, pass its size as offset.
+func (c *Client) Download(ctx context.Context, fileID string, offset int64, dst io.Writer) (int64, error) {
+	return c.download(ctx, apiPath("files", fileID), offset, dst)
+}
+
+func (c *Client) open(ctx context.Context, path string, offset int64) (*Download, error) {
+	r := request{method: http.MethodGet, path: path, replayable: true}
+	if offset > 0 {
+		r.header = http.Header{"Range": {"bytes=" + strconv.FormatInt(offset, 10) + "-"}}
+	}
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Download{
+		ReadCloser:       resp.Body,
+		Filename:         attachmentFilename(resp.Header.Get("Content-Disposition")),
+		ContentType:      resp.Header.Get("Content-Type"),
+		Size:             resp.ContentLength,
+		ClientEncryption: resp.Header.Get("X-Client-Encryption"),
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		var end int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &d.Offset, &end, &d.Size); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("invalid Content-Range %q: %w", resp.Header.Get("Content-Range"), err)
+		}
+	} else if offset > 0 {
+		// The whole file was sent; skip to the offset here
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		d.Offset = offset
+	}
+	return d, nil
+}
+
+// download copies the contents at path from offset to dst, reopening the download at the
+// first missing byte when the connection breaks.
+func (c *Client) download(ctx context.Context, path string, offset int64, dst io.Writer) (int64, error) {
+	policy := c.Retry.withDefaults()
+	out := &trackingWriter{w: dst}
+	for attempt := 1; ; attempt++ {
+		d, err := c.open(ctx, path, offset+out.n)
+		if err != nil {
+			if out.n == 0 && isComplete(err, offset) {
+				return 0, nil
+			}
+			return out.n, err
+		}
+		_, err = io.Copy(out, d)
+		d.Close()
+		if err == nil || out.err != nil || ctx.Err() != nil || attempt >= policy.MaxAttempts {
+			return out.n, err
+		}
+		if err := sleep(ctx, policy.delay(attempt, "")); err != nil {
+			return out.n, err
+		}
+	}
+}
+
+// isComplete reports whether err rejects a range starting at the end of the file, meaning a
+// partial copy of offset bytes was already complete.
+func isComplete(err error, offset int64) bool {
+	var apiErr *Error
+	if offset == 0 || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return false
+	}
+	size, ok := apiErr.Extensions["size"].(float64)
+	return ok && int64(size) == offset
+}
+
+// trackingWriter counts the bytes written to w and keeps its error, telling a failing
+// destination apart from a broken download.
+type trackingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.n += int64(n)
+	if err != nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// attachmentFilename returns the filename of a Content-Disposition header, which the vault
+// writes unquoted.
+func attachmentFilename(disposition string) string {
+	_, name, found := strings.Cut(disposition, "filename=")
+	if !found {
+		return ""
+	}
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		return unquoted
+	}
+	return name
+}
+
+// Page selects part of a listing, which is ordered newest first. The zero value selects
+// every file.
+type Page struct {
+	Limit  int // At most 1000
+	Offset int
+}
+
+func (p Page) apply(query url.Values) {
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Offset > 0 {
+		query.Set("offset", strconv.Itoa(p.Offset))
+	}
+}
+
+// SearchQuery filters the caller's files. Zero fields do not filter.
+type SearchQuery struct {
+	Filename string // Substring of the filename
+	MimeType string
+	MinSize  int64
+	MaxSize  int64
+	Since    time.Time // Uploaded at or after
+	Until    time.Time // Uploaded at or before
+	Page
+}
+
+func (q SearchQuery) values() url.Values {
+	query := url.Values{}
+	if q.Filename != "" {
+		query.Set("filename", q.Filename)
+	}
+	if q.MimeType != "" {
+		query.Set("mime_type", q.MimeType)
+	}
+	if q.MinSize > 0 {
+		query.Set("min_size", strconv.FormatInt(q.MinSize, 10))
+	}
+	if q.MaxSize > 0 {
+		query.Set("max_size", strconv.FormatInt(q.MaxSize, 10))
+	}
+	if !q.Since.IsZero() {
+		query.Set("start_date", q.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		query.Set("end_date", q.Until.UTC().Format(time.RFC3339Nano))
+	}
+	q.Page.apply(query)
+	return query
+}
+
+// ListFiles returns a page of the caller's files.
+func (c *Client) ListFiles(ctx context.Context, page Page) ([]models.FileSearchResult, error) {
+	query := c.userQuery("owner_id")
+	page.apply(query)
+	var files []models.FileSearchResult
+	if err := c.doJSON(ctx, http.MethodGet, apiPath("files"), query, nil, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Search returns a page of the caller's files matching q.
+func (c *Client) Search(ctx context.Context, q SearchQuery) ([]models.FileSearchResult, error) {
+	query := q.values()
+	query.Set("owner_id", c.UserID)
+	var files []models.FileSearchResult
+	if err := c.doJSON(ctx, http.MethodGet, apiPath("search"), query, nil, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// SearchAll iterates over every file matching q from q.Offset on, fetching pages of q.Limit
+// files, or 100 if unset, as the loop consumes them. Iteration stops at the first error,
+// which is yielded with a zero file.
+func (c *Client) SearchAll(ctx context.Context, q SearchQuery) iter.Seq2[models.FileSearchResult, error] {
+	if q.Limit <= 0 {
+		q.Limit = defaultPageSize
+	}
+	return func(yield func(models.FileSearchResult, error) bool) {
+		for {
+			files, err := c.Search(ctx, q)
+			if err != nil {
+				yield(models.FileSearchResult{}, err)
+				return
+			}
+			for _, file := range files {
+				if !yield(file, nil) {
+					return
+				}
+			}
+			if len(files) < q.Limit {
+				return
+			}
+			q.Offset += len(files)
+		}
+	}
+}
+
+// Delete deletes fileID, which the caller must own.
+func (c *Client) Delete(ctx context.Context, fileID string) error {
+	return c.doJSON(ctx, http.MethodDelete, apiPath("files", fileID), c.userQuery("user_id"), nil, nil)
+}
+
+// Rename renames fileID, which the caller must own, to filename. Unlike Upload, which stores
+// a file under a prefixed name when the name is taken, it fails with ALREADY_EXISTS.
+func (c *Client) Rename(ctx context.Context, fileID, filename string) (*models.UserFile, error) {
+	var file models.UserFile
+	body := map[string]string{"filename": filename}
+	if err := c.doJSON(ctx, http.MethodPatch, apiPath("user", "files", fileID), nil, body, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Stats is the caller's storage usage with and without deduplication.
+type Stats struct {
+	StorageQuota      int64  `json:"storage_quota"`
+	DeduplicatedUsage int64  `json:"total_storage_used_deduplicated"`
+	OriginalUsage     int64  `json:"original_storage_usage"`
+	SavingsBytes      int64  `json:"storage_savings_bytes"`
+	SavingsPercentage string `json:"storage_savings_percentage"` // e.g. "12.50%"
+}
+
+// Stats returns the caller's storage usage.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.doJSON(ctx, http.MethodGet, apiPath("stats"), c.userQuery("user_id"), nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"file-vault/backend/internal/models"
+)
+
+func TestDownloadResumesWithRangeAfterDroppedConnection(t *testing.T) {
+	content := make([]byte, 256<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	const cut = 100 << 10
+
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/files/file-1" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		first := len(ranges) == 1
+		mu.Unlock()
+		if first {
+			// Send part of the body, then drop the connection
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:cut])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err != nil {
+			t.Errorf("resumed without a byte range: %q", r.Header.Get("Range"))
+			start = 0
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start:])
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+	var got bytes.Buffer
+	n, err := c.Download(context.Background(), "file-1", 0, &got)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n != int64(len(content)) || !bytes.Equal(got.Bytes(), content) {
+		t.Fatalf("downloaded %d bytes that differ from the content", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != fmt.Sprintf("bytes=%d-", cut) {
+		t.Fatalf("Range headers = %q, want none then bytes=%d-", ranges, cut)
+	}
+}
+
+func TestSearchAllWalksEveryPage(t *testing.T) {
+	const total = 7
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if got := r.URL.Query().Get("owner_id"); got != "alice" {
+			t.Errorf("owner_id = %q", got)
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page := []map[string]string{}
+		for i := offset; i < total && i < offset+limit; i++ {
+			page = append(page, map[string]string{"file_id": fmt.Sprintf("file-%d", i), "filename": "report.pdf"})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+	var files []models.FileSearchResult
+	for file, err := range c.SearchAll(context.Background(), SearchQuery{Filename: "report", Page: Page{Limit: 3}}) {
+		if err != nil {
+			t.Fatalf("SearchAll: %v", err)
+		}
+		files = append(files, file)
+	}
+	if len(files) != total {
+		t.Fatalf("got %d files, want %d", len(files), total)
+	}
+	for i, file := range files {
+		if want := fmt.Sprintf("file-%d", i); file.FileID != want {
+			t.Fatalf("file %d is %s, want %s", i, file.FileID, want)
+		}
+	}
+	// Pages of 3, 3 and 1; the short page ends the walk
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("made %d requests, want 3", got)
+	}
+}
+
+func TestSearchAllStopsAtError(t *testing.T) {
+	srv, _ := failingServer(t, 100, http.StatusBadRequest, nil)
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+	var errs int
+	for _, err := range c.SearchAll(context.Background(), SearchQuery{}) {
+		if err == nil {
+			t.Fatal("yielded a file from a failing search")
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Fatalf("yielded %d errors, want 1", errs)
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Zero fields take the defaults.
+//
+// Requests the rate limiter rejected with 429 never reached a handler and are always retried.
+// Server errors and network failures are retried only for GET, HEAD, PUT and DELETE, which
+// are safe to repeat; a failed upload or job start is returned rather than risk doing it twice.
+// Uploads from a reader that cannot be rewound are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries including the first, 4 by default. 1 disables retries.
+	MaxAttempts int
+	// MinBackoff is the longest wait before the first retry, 250ms by default. Each later
+	// retry may wait up to twice as long as the one before, with random jitter.
+	MinBackoff time.Duration
+	// MaxBackoff caps the wait between attempts, 10s by default. A longer Retry-After from
+	// the server is honoured up to this cap.
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 4
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = 250 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	return p
+}
+
+// delay returns how long to wait after the given failed attempt: exponential backoff with
+// full jitter, or the server's Retry-After if that is longer.
+func (p RetryPolicy) delay(attempt int, retryAfter string) time.Duration {
+	ceiling := p.MinBackoff << min(attempt-1, 16)
+	if ceiling <= 0 || ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	wait := rand.N(ceiling) + 1
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		wait = max(wait, time.Duration(seconds)*time.Second)
+	} else if at, err := http.ParseTime(retryAfter); err == nil {
+		wait = max(wait, time.Until(at))
+	}
+	return min(wait, p.MaxBackoff)
+}
+
+// retryable reports whether a request with method that failed with err may be sent again.
+func retryable(method string, err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// The request may or may not have reached the server
+		return idempotent(method)
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"file-vault/backend/internal/apierror"
+)
+
+// fastRetry keeps retries in tests from waiting on the default backoff.
+var fastRetry = RetryPolicy{MaxAttempts: 4, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// failingServer answers the first failures requests with status and the rest with an empty
+// JSON object, counting every request.
+func failingServer(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.Header().Set("Content-Type", apierror.ContentType)
+			w.WriteHeader(status)
+			w.Write([]byte(`{"code":"` + string(apierror.CodeInternal) + `","detail":"try again"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetriesRateLimitedAndServerErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		srv, calls := failingServer(t, 2, status, nil)
+		c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+		if _, err := c.Quota(context.Background()); err != nil {
+			t.Fatalf("%d: Quota after retries: %v", status, err)
+		}
+		if got := calls.Load(); got != 3 {
+			t.Fatalf("%d: made %d requests, want 3", status, got)
+		}
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	srv, calls := failingServer(t, 100, http.StatusBadGateway, nil)
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+	_, err := c.Quota(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err = %v, want the 502", err)
+	}
+	if got := calls.Load(); got != int32(fastRetry.MaxAttempts) {
+		t.Fatalf("made %d requests, want %d", got, fastRetry.MaxAttempts)
+	}
+}
+
+func TestDoesNotRetryNonIdempotentServerErrors(t *testing.T) {
+	srv, calls := failingServer(t, 1, http.StatusInternalServerError, nil)
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+	if _, err := c.ToggleShare(context.Background(), "file-1"); !IsCode(err, apierror.CodeInternal) {
+		t.Fatalf("err = %v, want the 500", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("POST was sent %d times, want once", got)
+	}
+}
+
+func TestRetriesRateLimitedNonIdempotentRequests(t *testing.T) {
+	// A 429 never reached a handler, so even a POST is safe to repeat
+	srv, calls := failingServer(t, 1, http.StatusTooManyRequests, nil)
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: fastRetry}
+	if _, err := c.ToggleShare(context.Background(), "file-1"); err != nil {
+		t.Fatalf("ToggleShare: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("made %d requests, want 2", got)
+	}
+}
+
+func TestHonoursRetryAfter(t *testing.T) {
+	srv, calls := failingServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	c := &Client{BaseURL: srv.URL, UserID: "alice", Retry: RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Second}}
+	start := time.Now()
+	if _, err := c.Quota(context.Background()); err != nil {
+		t.Fatalf("Quota: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %s, before Retry-After elapsed", elapsed)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("made %d requests, want 2", got)
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	p := RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Second}.withDefaults()
+	if d := p.delay(1, "3600"); d != 2*time.Second {
+		t.Fatalf("delay with a long Retry-After = %s, want the 2s cap", d)
+	}
+	if d := p.delay(1, "1"); d < time.Second {
+		t.Fatalf("delay with Retry-After 1 = %s, want at least 1s", d)
+	}
+}
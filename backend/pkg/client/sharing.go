@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"file-vault/backend/internal/models"
+)
+
+// ShareState is whether a file is publicly shared, and the token of its share link.
+type ShareState struct {
+	ShareToken string `json:"share_token"`
+	IsPublic   bool   `json:"is_public"`
+}
+
+// PublicShare describes a publicly shared file to anyone holding its share token.
+type PublicShare struct {
+	FileID        string            `json:"file_id"`
+	Filename      string            `json:"filename"`
+	MimeType      string            `json:"mime_type"`
+	Size          int64             `json:"size"`
+	DownloadCount int               `json:"download_count"`
+	OwnerUsername string            `json:"owner_username"`
+	CreatedAt     models.CustomTime `json:"created_at"`
+}
+
+// PublicShareListing is one entry of the list of every publicly shared file.
+type PublicShareListing struct {
+	ShareID       string            `json:"share_id"`
+	FileID        string            `json:"file_id"`
+	Filename      string            `json:"filename"`
+	OwnerUsername string            `json:"owner_username"`
+	MimeType      string            `json:"mime_type"`
+	Size          int64             `json:"size"`
+	DownloadCount int               `json:"download_count"`
+	ShareToken    string            `json:"share_token"`
+	CreatedAt     models.CustomTime `json:"created_at"`
+}
+
+// ToggleShare makes fileID public, or private again if it is public. Every call issues a new
+// share token, so earlier links stop working.
+func (c *Client) ToggleShare(ctx context.Context, fileID string) (*ShareState, error) {
+	var state ShareState
+	if err := c.doJSON(ctx, http.MethodPost, apiPath("user", "files", fileID, "share"), nil, nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// PublicShare returns the details of the file shared as token. No user ID is needed.
+func (c *Client) PublicShare(ctx context.Context, token string) (*PublicShare, error) {
+	var share PublicShare
+	if err := c.doJSON(ctx, http.MethodGet, "/share/"+url.PathEscape(token), nil, nil, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// OpenShare starts downloading the file shared as token from offset. The caller must close
+// the download.
+func (c *Client) OpenShare(ctx context.Context, token string, offset int64) (*Download, error) {
+	return c.open(ctx, "/share/"+url.PathEscape(token)+"/download", offset)
+}
+
+// DownloadShare writes the file shared as token from offset to dst, resuming as Download does.
+func (c *Client) DownloadShare(ctx context.Context, token string, offset int64, dst io.Writer) (int64, error) {
+	return c.download(ctx, "/share/"+url.PathEscape(token)+"/download", offset, dst)
+}
+
+// PublicShares lists every publicly shared file.
+func (c *Client) PublicShares(ctx context.Context) ([]PublicShareListing, error) {
+	var shares []PublicShareListing
+	if err := c.doJSON(ctx, http.MethodGet, apiPath("user", "shared-publicly"), nil, nil, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// SharedWithMe lists the end-to-end encrypted files other users shared with the caller. Use
+// pkg/e2e to download and decrypt them.
+func (c *Client) SharedWithMe(ctx context.Context) ([]models.FileSearchResult, error) {
+	var files []models.FileSearchResult
+	if err := c.doJSON(ctx, http.MethodGet, apiPath("user", "shared-with-me"), nil, nil, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
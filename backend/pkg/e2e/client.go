@@ -0,0 +1,213 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+
+	"file-vault/backend/internal/models"
+)
+
+// Client uploads, downloads and shares end-to-end encrypted files. All cryptography happens
+// here; the vault only receives ciphertext and wrapped file keys.
+type Client struct {
+	// BaseURL is the API root, e.g. "http://localhost:8080/api/v1".
+	BaseURL string
+	// UserID identifies the caller to the vault.
+	UserID string
+	// Identity holds the caller's key pair.
+	Identity *Identity
+	// ConvergenceSecret scopes ModeConvergent deduplication to users sharing the same secret.
+	ConvergenceSecret []byte
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// APIError is returned when the vault answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("vault returned %d: %s", e.StatusCode, e.Message)
+}
+
+// PublishPublicKey registers the identity's public key so other users can share files with the caller.
+func (c *Client) PublishPublicKey(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodPut, "/user/public-key", map[string]string{"public_key": c.Identity.PublicKey()}, nil)
+}
+
+// Upload encrypts src and uploads it as filename. src is read twice in ModeConvergent, once to
+// derive the key and once to encrypt, so it must be seekable.
+func (c *Client) Upload(ctx context.Context, filename string, src io.ReadSeeker, mode string) (*models.UserFile, error) {
+	var fileKey []byte
+	var err error
+	if mode == ModeConvergent {
+		if fileKey, err = ConvergentKey(src, c.ConvergenceSecret); err != nil {
+			return nil, err
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if fileKey, err = NewFileKey(); err != nil {
+		return nil, err
+	}
+	wrapped, err := WrapKey(fileKey, c.Identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream the multipart body so large files are never held in memory
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		err := writeUploadForm(form, filename, src, fileKey, mode, wrapped)
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/upload?owner_id="+url.QueryEscape(c.UserID), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	var file models.UserFile
+	if err := c.do(req, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func writeUploadForm(form *multipart.Writer, filename string, src io.Reader, fileKey []byte, mode, wrapped string) error {
+	if err := form.WriteField("e2e", mode); err != nil {
+		return err
+	}
+	if err := form.WriteField("wrapped_key", wrapped); err != nil {
+		return err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", "application/octet-stream")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	return Encrypt(part, src, fileKey, mode)
+}
+
+// Download fetches a file shared with (or owned by) the caller and writes its plaintext to dst.
+func (c *Client) Download(ctx context.Context, fileID string, dst io.Writer) error {
+	fileKey, err := c.fileKey(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/files/"+url.PathEscape(fileID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	plaintext, err := NewDecryptReader(resp.Body, fileKey)
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	defer plaintext.Close()
+	_, err = io.Copy(dst, plaintext)
+	return err
+}
+
+// Share re-wraps the file key of fileID for recipientID, giving them read access.
+func (c *Client) Share(ctx context.Context, fileID, recipientID string) error {
+	var recipient struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/user/public-keys/"+url.PathEscape(recipientID), nil, &recipient); err != nil {
+		return err
+	}
+	fileKey, err := c.fileKey(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	wrapped, err := WrapKey(fileKey, recipient.PublicKey)
+	if err != nil {
+		return err
+	}
+	body := map[string]string{"recipient_id": recipientID, "wrapped_key": wrapped}
+	return c.doJSON(ctx, http.MethodPost, "/user/files/"+url.PathEscape(fileID)+"/keys", body, nil)
+}
+
+// fileKey fetches and unwraps the caller's copy of a file key.
+func (c *Client) fileKey(ctx context.Context, fileID string) ([]byte, error) {
+	var fileKey models.FileKey
+	if err := c.doJSON(ctx, http.MethodGet, "/user/files/"+url.PathEscape(fileID)+"/key", nil, &fileKey); err != nil {
+		return nil, err
+	}
+	return c.Identity.UnwrapKey(fileKey.WrappedKey)
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs req as the caller and turns error statuses into an APIError.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-User-ID", c.UserID)
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+	return resp, nil
+}
@@ -0,0 +1,66 @@
+package e2e
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+
+	"file-vault/backend/internal/models"
+	"file-vault/backend/internal/stream"
+)
+
+// Modes an end-to-end encrypted file can be uploaded with.
+const (
+	// ModeRandom encrypts every upload under a fresh random key. Nothing about the plaintext
+	// leaks, but identical files never deduplicate.
+	ModeRandom = models.ClientEncryptionE2E
+	// ModeConvergent derives the key from the plaintext, so identical files encrypt to identical
+	// ciphertext and deduplicate across users. Anyone holding a candidate plaintext can confirm
+	// that it is stored; set a ConvergenceSecret to limit this to the people who share it.
+	ModeConvergent = models.ClientEncryptionConvergent
+)
+
+const (
+	convergentKeyLabel   = "sfv-convergent-key-v1"
+	convergentNonceLabel = "sfv-convergent-nonce-v1"
+)
+
+// ConvergentKey derives the file key for src in ModeConvergent. Only users with the same
+// secret (which may be empty) derive the same key, and so deduplicate against each other.
+func ConvergentKey(src io.Reader, secret []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, append([]byte(convergentKeyLabel), secret...))
+	if _, err := io.Copy(mac, src); err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
+
+// Encrypt writes the ciphertext of src under fileKey to dst. In ModeConvergent the nonce prefix
+// is derived from the key, making the ciphertext a deterministic function of the plaintext.
+func Encrypt(dst io.Writer, src io.Reader, fileKey []byte, mode string) error {
+	var w io.WriteCloser
+	var err error
+	if mode == ModeConvergent {
+		prefix := sha256.Sum256(append([]byte(convergentNonceLabel), fileKey...))
+		w, err = stream.NewWriterWithPrefix(dst, fileKey, prefix[:stream.NoncePrefixSize])
+	} else {
+		w, err = stream.NewWriter(dst, fileKey)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// NewDecryptReader returns a reader over the plaintext of ciphertext encrypted by Encrypt.
+func NewDecryptReader(ciphertext io.ReadCloser, fileKey []byte) (io.ReadCloser, error) {
+	return stream.NewReader(ciphertext, fileKey)
+}
+
+// EncryptedSize returns the ciphertext size for plainSize bytes of plaintext.
+func EncryptedSize(plainSize int64) int64 {
+	return stream.EncryptedSize(plainSize)
+}
@@ -0,0 +1,113 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+// encrypt returns the ciphertext of plaintext under the key mode would use for it.
+func encrypt(t *testing.T, plaintext, secret []byte, mode string) ([]byte, []byte) {
+	t.Helper()
+	var fileKey []byte
+	var err error
+	if mode == ModeConvergent {
+		fileKey, err = ConvergentKey(bytes.NewReader(plaintext), secret)
+	} else {
+		fileKey, err = NewFileKey()
+	}
+	if err != nil {
+		t.Fatalf("file key: %v", err)
+	}
+	var ciphertext bytes.Buffer
+	if err := Encrypt(&ciphertext, bytes.NewReader(plaintext), fileKey, mode); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	return ciphertext.Bytes(), fileKey
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	// Span several chunks, including a partial final one
+	plaintext := make([]byte, 3*64*1024+123)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{ModeRandom, ModeConvergent} {
+		t.Run(mode, func(t *testing.T) {
+			ciphertext, fileKey := encrypt(t, plaintext, nil, mode)
+			if got, want := int64(len(ciphertext)), EncryptedSize(int64(len(plaintext))); got != want {
+				t.Fatalf("ciphertext is %d bytes, EncryptedSize says %d", got, want)
+			}
+			if bytes.Contains(ciphertext, plaintext[:64]) {
+				t.Fatal("ciphertext contains plaintext")
+			}
+
+			r, err := NewDecryptReader(io.NopCloser(bytes.NewReader(ciphertext)), fileKey)
+			if err != nil {
+				t.Fatalf("NewDecryptReader: %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Fatal("decrypted bytes differ from plaintext")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsWrongKey(t *testing.T) {
+	ciphertext, _ := encrypt(t, []byte("attack at dawn"), nil, ModeRandom)
+	otherKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewDecryptReader(io.NopCloser(bytes.NewReader(ciphertext)), otherKey)
+	if err == nil {
+		defer r.Close()
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Fatal("decrypting with the wrong key succeeded")
+	}
+}
+
+func TestConvergentEncryptionIsDeterministic(t *testing.T) {
+	plaintext := []byte("the same quarterly report uploaded by two users")
+	first, _ := encrypt(t, plaintext, nil, ModeConvergent)
+	second, _ := encrypt(t, plaintext, nil, ModeConvergent)
+	if !bytes.Equal(first, second) {
+		t.Fatal("identical plaintext produced different convergent ciphertext")
+	}
+	if sha256.Sum256(first) != sha256.Sum256(second) {
+		t.Fatal("identical plaintext produced different ciphertext hashes")
+	}
+
+	different, _ := encrypt(t, []byte("a different quarterly report, same length!!!!!!"), nil, ModeConvergent)
+	if bytes.Equal(first, different) || sha256.Sum256(first) == sha256.Sum256(different) {
+		t.Fatal("different plaintext produced the same convergent ciphertext")
+	}
+}
+
+func TestConvergenceSecretScopesDedup(t *testing.T) {
+	plaintext := []byte("shared only within a team")
+	team, _ := encrypt(t, plaintext, []byte("team secret"), ModeConvergent)
+	public, _ := encrypt(t, plaintext, nil, ModeConvergent)
+	if bytes.Equal(team, public) {
+		t.Fatal("convergence secret did not change the ciphertext")
+	}
+}
+
+func TestRandomModeDoesNotDeduplicate(t *testing.T) {
+	plaintext := []byte("nothing about this should leak")
+	first, _ := encrypt(t, plaintext, nil, ModeRandom)
+	second, _ := encrypt(t, plaintext, nil, ModeRandom)
+	if bytes.Equal(first, second) {
+		t.Fatal("random mode produced identical ciphertext for identical plaintext")
+	}
+}
@@ -0,0 +1,147 @@
+// Package e2e implements the client side of end-to-end encrypted files: the server only ever
+// sees ciphertext and file keys wrapped for individual users.
+//
+// Each file is encrypted with its own 32-byte file key using the same chunked AES-256-GCM format
+// the server uses at rest. The file key is wrapped for every user allowed to read the file with
+// an ECIES-style construction over X25519 (ephemeral key agreement, HKDF-SHA256, AES-256-GCM).
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// FileKeySize is the length of a file key.
+const FileKeySize = 32
+
+// wrapInfo is the HKDF info prefix for key wrapping; both public keys are appended to it.
+const wrapInfo = "sfv-e2e-wrap-v1"
+
+// Identity is a user's X25519 key pair. The private half never leaves the client.
+type Identity struct {
+	private *ecdh.PrivateKey
+}
+
+// NewIdentity generates a new key pair.
+func NewIdentity() (*Identity, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{private: private}, nil
+}
+
+// ParseIdentity restores an identity from the output of Identity.Marshal.
+func ParseIdentity(encoded string) (*Identity, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode identity: %w", err)
+	}
+	private, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{private: private}, nil
+}
+
+// Marshal returns the base64-encoded private key. Store it as carefully as a password.
+func (id *Identity) Marshal() string {
+	return base64.StdEncoding.EncodeToString(id.private.Bytes())
+}
+
+// PublicKey returns the base64-encoded public key to register with the server.
+func (id *Identity) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(id.private.PublicKey().Bytes())
+}
+
+// NewFileKey returns a random file key.
+func NewFileKey() ([]byte, error) {
+	key := make([]byte, FileKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey encrypts fileKey so that only the holder of recipientPublicKey can recover it.
+// The result is base64(ephemeral public key || AES-256-GCM(fileKey)).
+func WrapKey(fileKey []byte, recipientPublicKey string) (string, error) {
+	rawPublic, err := base64.StdEncoding.DecodeString(recipientPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("decode recipient public key: %w", err)
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(rawPublic)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	aead, err := wrapAEAD(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+
+	// The wrapping key is unique per ephemeral key, so a fixed nonce is safe
+	nonce := make([]byte, aead.NonceSize())
+	wrapped := aead.Seal(ephemeral.PublicKey().Bytes(), nonce, fileKey, nil)
+	return base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// UnwrapKey recovers a file key wrapped for this identity.
+func (id *Identity) UnwrapKey(wrapped string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("decode wrapped key: %w", err)
+	}
+	if len(raw) < 32 {
+		return nil, errors.New("wrapped key is truncated")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw[:32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := id.private.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := wrapAEAD(shared, ephemeral, id.private.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	fileKey, err := aead.Open(nil, nonce, raw[32:], nil)
+	if err != nil {
+		return nil, errors.New("file key was not wrapped for this identity")
+	}
+	return fileKey, nil
+}
+
+// wrapAEAD derives the AEAD protecting a wrapped key from an X25519 shared secret.
+// Both public keys are bound into the derivation.
+func wrapAEAD(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	info := wrapInfo + string(ephemeral.Bytes()) + string(recipient.Bytes())
+	kek, err := hkdf.Key(sha256.New, shared, nil, info, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,101 @@
+package e2e
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapUnwrapKey(t *testing.T) {
+	recipient, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := WrapKey(fileKey, recipient.PublicKey())
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	got, err := recipient.UnwrapKey(wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if !bytes.Equal(got, fileKey) {
+		t.Fatal("unwrapped key differs from the original")
+	}
+}
+
+func TestUnwrapKeyRejectsOtherIdentity(t *testing.T) {
+	recipient, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := WrapKey(fileKey, recipient.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.UnwrapKey(wrapped); err == nil {
+		t.Fatal("a key wrapped for one identity was unwrapped by another")
+	}
+}
+
+func TestShareRewrapsForRecipient(t *testing.T) {
+	owner, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner recovers their copy and re-wraps it, as Client.Share does
+	ownerCopy, err := WrapKey(fileKey, owner.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := owner.UnwrapKey(ownerCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := WrapKey(unwrapped, recipient.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := recipient.UnwrapKey(shared)
+	if err != nil {
+		t.Fatalf("recipient UnwrapKey: %v", err)
+	}
+	if !bytes.Equal(got, fileKey) {
+		t.Fatal("recipient recovered a different file key")
+	}
+}
+
+func TestIdentityMarshalRoundTrip(t *testing.T) {
+	id, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ParseIdentity(id.Marshal())
+	if err != nil {
+		t.Fatalf("ParseIdentity: %v", err)
+	}
+	if restored.PublicKey() != id.PublicKey() {
+		t.Fatal("restored identity has a different public key")
+	}
+}